@@ -0,0 +1,224 @@
+package builder
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os/exec"
+	"sort"
+	"strings"
+)
+
+// AnalysisPolicy configures the static analysis gate that runs
+// go vet, and optionally staticcheck, over the plugin packages in
+// the build environment before compiling.
+type AnalysisPolicy struct {
+	// Fail makes any finding fail the build;
+	// otherwise findings are only logged.
+	Fail bool `json:"fail,omitempty"`
+
+	// Staticcheck also runs staticcheck, if it is installed.
+	Staticcheck bool `json:"staticcheck,omitempty"`
+
+	// StaticcheckCommand is the staticcheck
+	// executable. Default: staticcheck
+	StaticcheckCommand string `json:"staticcheck_command,omitempty"`
+}
+
+// Finding is a problem reported by go vet or staticcheck.
+type Finding struct {
+	// The tool that reported it, "vet" or "staticcheck",
+	// and its analyzer or check, e.g. "printf" or "SA4006".
+	Tool  string `json:"tool"`
+	Check string `json:"check,omitempty"`
+
+	Package  string `json:"package,omitempty"`
+	Position string `json:"position,omitempty"`
+	Message  string `json:"message"`
+}
+
+func (f Finding) String() string {
+	return fmt.Sprintf("%s: %s (%s %s)", f.Position, f.Message, f.Tool, f.Check)
+}
+
+// AnalysisError is returned when the analysis
+// policy fails the build.
+type AnalysisError struct {
+	Findings []Finding
+}
+
+func (e *AnalysisError) Error() string {
+	lines := make([]string, 0, len(e.Findings))
+	for _, f := range e.Findings {
+		lines = append(lines, f.String())
+	}
+	return fmt.Sprintf("static analysis reported %d finding(s): %s", len(lines), strings.Join(lines, "; "))
+}
+
+// analyze runs go vet, and staticcheck if the policy asks for it,
+// over the plugin packages in env with the given build tags, logs
+// every finding, and records them in env. It returns an
+// AnalysisError if the policy requires the build to fail.
+func (env *environment) analyze(ctx context.Context, policy AnalysisPolicy, plugins []Dependency, tags []string) error {
+	if env.plan != nil || len(plugins) == 0 {
+		return nil
+	}
+	packages := make([]string, 0, len(plugins))
+	for _, p := range plugins {
+		packages = append(packages, p.PackagePath)
+	}
+	env.log.Printf("[INFO] Analyzing plugin packages")
+
+	findings, err := env.vet(ctx, packages, tags)
+	if err != nil {
+		return err
+	}
+	if policy.Staticcheck {
+		found, err := env.staticcheck(ctx, policy.StaticcheckCommand, packages, tags)
+		if err != nil {
+			return err
+		}
+		findings = append(findings, found...)
+	}
+
+	for _, f := range findings {
+		env.log.Printf("[WARNING] Analysis: %s", f)
+	}
+	env.findings = findings
+	if policy.Fail && len(findings) > 0 {
+		return &AnalysisError{Findings: findings}
+	}
+	return nil
+}
+
+// vet runs `go vet` over packages and returns its findings.
+func (env environment) vet(ctx context.Context, packages, tags []string) ([]Finding, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := env.newCommand(ctx, GetGo(), "vet", "-json")
+	if len(tags) > 0 {
+		cmd.Args = append(cmd.Args, "-tags", strings.Join(tags, ","))
+	}
+	cmd.Args = append(cmd.Args, packages...)
+	// with -json, findings do not fail the command; they are
+	// written to stderr, or to stdout by newer go commands
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := env.runCommand(ctx, cmd); err != nil {
+		return nil, fmt.Errorf("running go vet: %v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return parseVet(io.MultiReader(&stdout, &stderr))
+}
+
+// parseVet reads the output of `go vet -json`, which is a JSON
+// object per package, keyed by package path and then analyzer,
+// among other lines such as comments naming the package, which
+// start with neither a brace nor an indentation.
+func parseVet(r io.Reader) ([]Finding, error) {
+	var stripped bytes.Buffer
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		if line := scanner.Text(); strings.HasPrefix(line, "{") || strings.HasPrefix(line, "}") ||
+			strings.HasPrefix(line, "\t") || strings.HasPrefix(line, " ") {
+			stripped.WriteString(line)
+			stripped.WriteByte('\n')
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	var findings []Finding
+	dec := json.NewDecoder(&stripped)
+	for {
+		var report map[string]map[string]json.RawMessage
+		if err := dec.Decode(&report); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("decoding go vet output: %v", err)
+		}
+		for pkg, analyzers := range report {
+			for analyzer, raw := range analyzers {
+				var diagnostics []struct {
+					Posn    string `json:"posn"`
+					Message string `json:"message"`
+				}
+				if err := json.Unmarshal(raw, &diagnostics); err != nil {
+					// an analyzer that failed reports {"error": ...}
+					var failed struct{ Error string }
+					if json.Unmarshal(raw, &failed) == nil && failed.Error != "" {
+						return nil, fmt.Errorf("go vet %s in %s: %s", analyzer, pkg, failed.Error)
+					}
+					return nil, fmt.Errorf("decoding go vet output: %v", err)
+				}
+				for _, d := range diagnostics {
+					findings = append(findings, Finding{Tool: "vet", Check: analyzer, Package: pkg, Position: d.Posn, Message: d.Message})
+				}
+			}
+		}
+	}
+	sortFindings(findings)
+	return findings, nil
+}
+
+// staticcheck runs staticcheck over packages and returns its
+// findings. If it is not installed, it is skipped with a warning.
+func (env environment) staticcheck(ctx context.Context, command string, packages, tags []string) ([]Finding, error) {
+	if command == "" {
+		command = "staticcheck"
+	}
+	if env.executor == nil {
+		if _, err := exec.LookPath(command); err != nil {
+			env.log.Printf("[WARNING] Skipping staticcheck: %v", err)
+			return nil, nil
+		}
+	}
+
+	var out bytes.Buffer
+	cmd := env.newCommand(ctx, command, "-f", "json")
+	if len(tags) > 0 {
+		cmd.Args = append(cmd.Args, "-tags", strings.Join(tags, ","))
+	}
+	cmd.Args = append(cmd.Args, packages...)
+	cmd.Stdout = &out
+	// staticcheck fails if it reports anything,
+	// so only fail if it reported nothing
+	runErr := env.runCommand(ctx, cmd)
+	if isCanceled(runErr) {
+		return nil, runErr
+	}
+
+	var findings []Finding
+	dec := json.NewDecoder(&out)
+	for {
+		var problem struct {
+			Code     string `json:"code"`
+			Location struct {
+				File   string `json:"file"`
+				Line   int    `json:"line"`
+				Column int    `json:"column"`
+			} `json:"location"`
+			Message string `json:"message"`
+		}
+		if err := dec.Decode(&problem); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("decoding %s output: %v", command, err)
+		}
+		loc := problem.Location
+		findings = append(findings, Finding{Tool: "staticcheck", Check: problem.Code,
+			Position: fmt.Sprintf("%s:%d:%d", loc.File, loc.Line, loc.Column), Message: problem.Message})
+	}
+	if runErr != nil && len(findings) == 0 {
+		return nil, fmt.Errorf("running %s: %v", command, runErr)
+	}
+	sortFindings(findings)
+	return findings, nil
+}
+
+// sortFindings sorts findings by position.
+func sortFindings(findings []Finding) {
+	sort.SliceStable(findings, func(i, j int) bool { return findings[i].Position < findings[j].Position })
+}
@@ -0,0 +1,254 @@
+package builder
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ArtifactCache keeps the binaries built by Build, keyed by a hash of
+// everything that determines their contents: the modules and their
+// versions, the flags, the target platform, and the Go toolchain. A
+// build whose key is already in the cache copies the binary from
+// there instead of building it.
+//
+// Only builds whose inputs are fixed are cached, so builds with
+// version queries (including an empty version, which means the latest
+// one), local replacements, hooks, an SBOM, or a provenance
+// attestation are always run.
+type ArtifactCache struct {
+	// Dir is the directory of the cache. It is created if needed
+	// and may be shared by concurrent builds.
+	Dir string `json:"dir,omitempty"`
+
+	// MaxSize, if positive, is the total size in bytes that the
+	// cached binaries may take up; the least recently used are
+	// evicted beyond that.
+	MaxSize int64 `json:"max_size,omitempty"`
+
+	// MaxAge, if positive, evicts binaries that have not been
+	// used for that long.
+	MaxAge time.Duration `json:"max_age,omitempty"`
+}
+
+// Files of a cache entry, which is a directory named by the key.
+const (
+	artifactBinary = "binary"
+	artifactResult = "result.json"
+)
+
+// artifactKey returns the cache key of the build of b, or the reason
+// why it cannot be cached. b must have its platform defaults set.
+func (b Builder) artifactKey(ctx context.Context) (string, string, error) {
+	if reason := b.uncacheableReason(); reason != "" {
+		return "", reason, nil
+	}
+
+	// only the settings that affect the binary make up the key
+	keyed := b
+	keyed.TimeoutGet, keyed.TimeoutBuild, keyed.Timeouts = 0, 0, Timeouts{}
+	keyed.SkipCleanup, keyed.CleanupPolicy, keyed.CleanupMaxAge, keyed.WorkDir = false, "", 0, ""
+	keyed.GracePeriod, keyed.FetchRetries, keyed.FetchRetryBackoff, keyed.FetchConcurrency = 0, 0, 0, 0
+	keyed.GoModCache, keyed.Credentials, keyed.ArtifactCache, keyed.GoProxies = "", nil, nil, nil
+	keyed.Checkpoint = ""
+	keyed.Env = make(map[string]string, len(b.Env))
+	for k, v := range b.Env {
+		switch k {
+		case "GOPATH", "GOCACHE", "GOMODCACHE", "GOMAXPROCS":
+			// locations and resources, as set by Pool
+		default:
+			keyed.Env[k] = v
+		}
+	}
+	config, err := json.Marshal(keyed)
+	if err != nil {
+		return "", "", err
+	}
+
+	h := sha256.New()
+	h.Write(config)
+	inputs := []string{b.PGOProfile}
+	if res := b.WindowsResources; res != nil {
+		inputs = append(inputs, res.Icon, res.Manifest)
+	}
+	for _, file := range inputs {
+		if file == "" {
+			continue
+		}
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return "", "", err
+		}
+		h.Write(data)
+	}
+
+	// the toolchain and the settings of the go command that
+	// come from the process environment matter as well
+	cmd := exec.CommandContext(ctx, GetGo(), "env", "-json",
+		"GOVERSION", "GOFLAGS", "GOEXPERIMENT", "CC", "CXX",
+		"CGO_CFLAGS", "CGO_CPPFLAGS", "CGO_CXXFLAGS", "CGO_LDFLAGS")
+	cmd.Env = processEnv(b.SandboxEnv)
+	for _, kv := range b.goEnv() {
+		cmd.Env = setEnv(cmd.Env, kv)
+	}
+	cmd.Env = b.cgoEnv(cmd.Env)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	goEnv, err := cmd.Output()
+	if err != nil {
+		return "", "", fmt.Errorf("reading go env: %v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	h.Write(goEnv)
+
+	return hex.EncodeToString(h.Sum(nil)), "", nil
+}
+
+// uncacheableReason returns why the build of b
+// cannot be cached, or "" if it can.
+func (b Builder) uncacheableReason() string {
+	if !isConcreteVersion(b.CaddyVersion) {
+		return fmt.Sprintf("version %q is not a concrete version", b.CaddyVersion)
+	}
+	for _, p := range b.Plugins {
+		if !isConcreteVersion(p.Version) {
+			return fmt.Sprintf("version %q of %s is not a concrete version", p.Version, p.PackagePath)
+		}
+	}
+	for _, r := range b.Replacements {
+		if r.New.IsLocal() {
+			return fmt.Sprintf("%s is replaced by a local directory", r.Old)
+		}
+		if !isConcreteVersion(r.New.Version()) {
+			return fmt.Sprintf("replacement %s has no concrete version", r.New)
+		}
+	}
+	switch {
+	case len(b.Workspace) > 0:
+		return "workspace modules are local directories"
+	case len(b.Hooks.PostTidy) > 0 || len(b.Hooks.PreBuild) > 0 || len(b.Hooks.PostBuild) > 0:
+		return "hooks are configured"
+	case b.SBOMFormat != "":
+		return "an SBOM is requested"
+	case b.Provenance != nil:
+		return "a provenance attestation is requested"
+	case b.WasmExec:
+		return "wasm_exec.js is copied next to the binary"
+	case b.Debug && b.DebugDir != "":
+		return "the build environment is copied for debugging"
+	case b.BuildMode.writesHeader():
+		return fmt.Sprintf("build mode %s may write a header file", b.BuildMode)
+	case len(b.Signers) > 0:
+		return "the binary is signed"
+	case len(b.Embeds) > 0:
+		return "local files are embedded"
+	case b.MainTemplateFS != nil:
+		return "the main template is read from a file system"
+	case b.Container == nil && b.Executor != nil:
+		return "the commands run on an executor"
+	case b.SkipBuild:
+		return "the build is skipped"
+	}
+	return ""
+}
+
+// load copies the binary cached under key to absOutputFile and
+// returns its result, or nil if key is not in the cache.
+func (c ArtifactCache) load(key, absOutputFile string) (*BuildResult, error) {
+	entry := filepath.Join(c.Dir, key)
+	data, err := os.ReadFile(filepath.Join(entry, artifactResult))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var result BuildResult
+	if err := json.Unmarshal(data, &result); err != nil {
+		return nil, fmt.Errorf("reading cached result %s: %v", entry, err)
+	}
+	if err := copyFile(filepath.Join(entry, artifactBinary), absOutputFile, 0755); err != nil {
+		return nil, err
+	}
+	// the modification time of an entry is when it was last used
+	now := time.Now()
+	_ = os.Chtimes(entry, now, now)
+	result.OutputFile = absOutputFile
+	result.Cached = true
+	return &result, nil
+}
+
+// store adds the binary described by result to the cache under key
+// and then evicts entries according to the limits of the cache.
+func (c ArtifactCache) store(key string, result *BuildResult) error {
+	if err := os.MkdirAll(c.Dir, 0755); err != nil {
+		return err
+	}
+	// the entry is assembled under a different name, so that
+	// concurrent builds never see an incomplete entry
+	tmp, err := os.MkdirTemp(c.Dir, ".tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+	if err := copyFile(result.OutputFile, filepath.Join(tmp, artifactBinary), 0755); err != nil {
+		return err
+	}
+	cached := *result
+	cached.OutputFile = ""
+	data, err := json.Marshal(cached)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(tmp, artifactResult), data, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, filepath.Join(c.Dir, key)); err != nil {
+		if _, statErr := os.Stat(filepath.Join(c.Dir, key)); statErr != nil {
+			return err
+		}
+		// a concurrent build stored the same binary first
+	}
+	_, err = c.Evict()
+	return err
+}
+
+// Evict removes the cached binaries that exceed the limits of the
+// cache, MaxAge and MaxSize, and returns the paths of the removed
+// entries. Build calls it after adding a binary to the cache.
+func (c ArtifactCache) Evict() ([]string, error) {
+	_, removed, err := trimCache(context.Background(), c.Dir, c.limits(), artifactEntries)
+	return removed, err
+}
+
+// limits returns the limits of c as those of CacheGC.
+func (c ArtifactCache) limits() CacheLimits {
+	return CacheLimits{MaxSize: c.MaxSize, MaxAge: c.MaxAge}
+}
+
+// copyFile copies the file src to dst, which is
+// created with perm if it does not exist yet.
+func copyFile(src, dst string, perm os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,222 @@
+package builder
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"golang.org/x/mod/module"
+	"golang.org/x/mod/zip"
+)
+
+// testLogger logs the messages of a build to t.
+type testLogger struct{ t *testing.T }
+
+func (l testLogger) Printf(format string, v ...interface{}) {
+	l.t.Helper()
+	l.t.Logf(format, v...)
+}
+
+// recordingLogger records the messages of a build.
+type recordingLogger struct {
+	mu       sync.Mutex
+	messages []string
+}
+
+func (l *recordingLogger) Printf(format string, v ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.messages = append(l.messages, fmt.Sprintf(format, v...))
+}
+
+// Messages returns the messages that contain substr.
+func (l *recordingLogger) Messages(substr string) []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var matches []string
+	for _, msg := range l.messages {
+		if strings.Contains(msg, substr) {
+			matches = append(matches, msg)
+		}
+	}
+	return matches
+}
+
+// writeFiles writes files, keyed by their slash-separated
+// paths relative to dir, creating directories as needed.
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// testBaseModule writes a checkout of a minimal base module, with
+// the main package that the generated main.go calls, to a new
+// directory and returns its path.
+func testBaseModule(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"go.mod":           "module " + defaultBaseModule + "\n\ngo 1.19\n",
+		"goaway.go":        "package goaway\n\nfunc RegisterModule(interface{}) {}\n",
+		"server/server.go": "package server\n\nimport \"fmt\"\n\nvar Version string\n\nfunc Main() { fmt.Println(\"goaway\", Version) }\n",
+	})
+	return dir
+}
+
+// testBuilder returns a Builder that builds the base module checked
+// out in baseDir without network access: the module proxy is off, so
+// any module that is not in baseDir or the module cache fails the
+// build. Its build environments are created in a new directory.
+func testBuilder(t *testing.T, baseDir string) Builder {
+	t.Helper()
+	if testing.Short() {
+		t.Skip("builds a binary")
+	}
+	if _, err := exec.LookPath(GetGo()); err != nil {
+		t.Skipf("go command not found: %v", err)
+	}
+	return Builder{
+		Replacements: []Replace{NewReplace(defaultBaseModule, baseDir)},
+		GoProxy:      "off",
+		Env:          map[string]string{"GOFLAGS": "-mod=mod", "GOSUMDB": "off"},
+		WorkDir:      t.TempDir(),
+		Logger:       testLogger{t},
+		Stdout:       io.Discard,
+		Stderr:       io.Discard,
+	}
+}
+
+// buildEnvFolders returns the names of the build
+// environments that remain in dir.
+func buildEnvFolders(t *testing.T, dir string) []string {
+	t.Helper()
+	matches, err := filepath.Glob(filepath.Join(dir, tempFolderPrefix+"*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return matches
+}
+
+// testProxy is a module proxy that serves one version of each of its
+// modules and records the paths of the requests it receives.
+type testProxy struct {
+	*httptest.Server
+
+	version string
+	modules map[string]string // module path to the source of its package
+	hang    bool              // zip requests wait until they are canceled
+
+	mu       sync.Mutex
+	requests []string
+}
+
+// newTestProxy starts a proxy that serves version of each module,
+// which consists of a package with the given source, and stops it
+// when the test ends.
+func newTestProxy(t *testing.T, version string, modules map[string]string) *testProxy {
+	t.Helper()
+	return startTestProxy(t, &testProxy{version: version, modules: modules})
+}
+
+// newHangingTestProxy is like newTestProxy, but the downloads
+// of the modules never finish until they are canceled.
+func newHangingTestProxy(t *testing.T, version string, modules map[string]string) *testProxy {
+	t.Helper()
+	return startTestProxy(t, &testProxy{version: version, modules: modules, hang: true})
+}
+
+func startTestProxy(t *testing.T, p *testProxy) *testProxy {
+	p.Server = httptest.NewServer(http.HandlerFunc(p.serve))
+	t.Cleanup(p.Close)
+	return p
+}
+
+// Requests returns the paths of the requests so far.
+func (p *testProxy) Requests() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]string(nil), p.requests...)
+}
+
+func (p *testProxy) serve(w http.ResponseWriter, r *http.Request) {
+	p.mu.Lock()
+	p.requests = append(p.requests, r.URL.Path)
+	p.mu.Unlock()
+
+	modulePath, file, ok := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/@v/")
+	source, known := p.modules[modulePath]
+	if !ok || !known {
+		http.NotFound(w, r)
+		return
+	}
+	switch file {
+	case "list":
+		fmt.Fprintln(w, p.version)
+	case p.version + ".info":
+		fmt.Fprintf(w, `{"Version":%q,"Time":"2024-01-01T00:00:00Z"}`, p.version)
+	case p.version + ".mod":
+		fmt.Fprintf(w, "module %s\n\ngo 1.19\n", modulePath)
+	case p.version + ".zip":
+		if p.hang {
+			<-r.Context().Done()
+			return
+		}
+		data, err := moduleZip(modulePath, p.version, source)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		_, _ = w.Write(data)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// moduleZip returns the zip file of modulePath at version,
+// with a go.mod file and a package with the given source.
+func moduleZip(modulePath, version, source string) ([]byte, error) {
+	dir, err := os.MkdirTemp("", "module")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	files := map[string]string{
+		"go.mod":    fmt.Sprintf("module %s\n\ngo 1.19\n", modulePath),
+		"plugin.go": source,
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			return nil, err
+		}
+	}
+	var buf bytes.Buffer
+	err = zip.CreateFromDir(&buf, module.Version{Path: modulePath, Version: version}, dir)
+	return buf.Bytes(), err
+}
+
+// testModCache returns a new, empty module cache for a build,
+// so that its modules are downloaded from the module proxy,
+// which is removed when the test ends.
+func testModCache(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	// the go command makes the modules read-only
+	t.Cleanup(func() { _ = removeReadOnly(dir) })
+	return dir
+}
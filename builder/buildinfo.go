@@ -0,0 +1,99 @@
+package builder
+
+import (
+	"debug/buildinfo"
+	"fmt"
+	"os"
+	"runtime/debug"
+)
+
+// BuildInfo describes the modules embedded in a binary
+// produced by the Go toolchain.
+type BuildInfo struct {
+	// The version of Go that built the binary.
+	GoVersion string `json:"go_version,omitempty"`
+
+	// The package path of the main package.
+	Path string `json:"path,omitempty"`
+
+	// The main module of the binary.
+	Main Dependency `json:"main,omitempty"`
+
+	// The modules the binary was built with.
+	Dependencies []Dependency `json:"dependencies,omitempty"`
+
+	// The replacements that were in effect
+	// for any of the dependencies.
+	Replacements []Replace `json:"replacements,omitempty"`
+
+	// The build settings, such as GOOS and -ldflags.
+	Settings map[string]string `json:"settings,omitempty"`
+}
+
+// NoBuildInfoError is returned by ReadBuildInfo when the
+// file is not a Go binary with embedded build information.
+type NoBuildInfoError struct {
+	Path string
+	Err  error
+}
+
+func (e *NoBuildInfoError) Error() string {
+	return fmt.Sprintf("%s has no embedded Go build information: %v", e.Path, e.Err)
+}
+
+func (e *NoBuildInfoError) Unwrap() error { return e.Err }
+
+// readBuildInfo is like buildinfo.ReadFile, but
+// also reads WebAssembly modules, which it does not.
+func readBuildInfo(path string) (*debug.BuildInfo, error) {
+	bi, err := buildinfo.ReadFile(path)
+	if err != nil {
+		if wasm, wasmErr := readWasmBuildInfo(path); wasmErr == nil {
+			return wasm, nil
+		}
+		return nil, err
+	}
+	return bi, nil
+}
+
+// ReadBuildInfo reads the module information embedded in the
+// binary at path, such as one previously produced by Build.
+func ReadBuildInfo(path string) (*BuildInfo, error) {
+	if _, err := os.Stat(path); err != nil {
+		return nil, err
+	}
+	bi, err := readBuildInfo(path)
+	if err != nil {
+		return nil, &NoBuildInfoError{Path: path, Err: err}
+	}
+
+	info := &BuildInfo{
+		GoVersion: bi.GoVersion,
+		Path:      bi.Path,
+		Main: Dependency{
+			PackagePath: bi.Main.Path,
+			Version:     bi.Main.Version,
+		},
+		Settings: make(map[string]string),
+	}
+	for _, dep := range bi.Deps {
+		info.Dependencies = append(info.Dependencies, Dependency{
+			PackagePath: dep.Path,
+			Version:     dep.Version,
+		})
+		if dep.Replace != nil {
+			old := dep.Path + " " + dep.Version
+			new := dep.Replace.Path
+			// local directories are reported with a version of "(devel)"
+			if dep.Replace.Version != "" && dep.Replace.Version != "(devel)" {
+				new += " " + dep.Replace.Version
+			}
+			info.Replacements = append(info.Replacements, NewReplace(old, new))
+		}
+	}
+	for _, s := range bi.Settings {
+		info.Settings[s.Key] = s.Value
+	}
+
+	return info, nil
+}
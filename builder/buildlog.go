@@ -0,0 +1,148 @@
+package builder
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LogCapture configures capturing the log of a build: everything
+// that its go commands and hooks write to standard output and
+// standard error, along with the progress messages of the builder.
+type LogCapture struct {
+	// File, if set, is the path of the file that the log is written
+	// to, replacing any existing file, so that it is kept after the
+	// build, even if it failed. Its directory is created if needed.
+	// Otherwise the log is kept in memory and, if the build
+	// succeeds, returned in BuildResult.Log.
+	File string `json:"file,omitempty"`
+
+	// MaxBytes limits the size of the log; output beyond it is
+	// dropped, which the end of the log notes. Default: 16 MiB
+	MaxBytes int64 `json:"max_bytes,omitempty"`
+
+	// TailLines, if positive, is how many of the last lines of
+	// standard error the Stderr of a GoGetError, TidyError, or
+	// CompileError contains, up to 64 KiB, instead of the last
+	// 4 KiB.
+	TailLines int `json:"tail_lines,omitempty"`
+}
+
+const (
+	// defaultLogMaxBytes is the default of LogCapture.MaxBytes.
+	defaultLogMaxBytes = 16 << 20
+
+	// maxTailLinesSize is how many bytes of output the typed
+	// errors include at most if LogCapture.TailLines is set.
+	maxTailLinesSize = 64 << 10
+)
+
+// capturedLog is the log of a build captured for LogCapture.
+// It is safe for concurrent use.
+type capturedLog struct {
+	mu        sync.Mutex
+	w         io.Writer
+	file      *os.File
+	buf       bytes.Buffer
+	max       int64
+	written   int64
+	truncated bool
+}
+
+// newCapturedLog starts capturing a log for c.
+func newCapturedLog(c LogCapture) (*capturedLog, error) {
+	l := &capturedLog{max: c.MaxBytes}
+	if l.max <= 0 {
+		l.max = defaultLogMaxBytes
+	}
+	l.w = &l.buf
+	if c.File != "" {
+		if err := os.MkdirAll(filepath.Dir(c.File), 0755); err != nil {
+			return nil, fmt.Errorf("creating build log directory: %v", err)
+		}
+		f, err := os.Create(c.File)
+		if err != nil {
+			return nil, fmt.Errorf("creating build log: %v", err)
+		}
+		l.file, l.w = f, f
+	}
+	return l, nil
+}
+
+// Write writes p to the log, or as much of it as the size
+// limit permits. It never fails, so that the output of
+// commands is not lost because of the log.
+func (l *capturedLog) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.truncated {
+		return len(p), nil
+	}
+	chunk := p
+	if remaining := l.max - l.written; int64(len(chunk)) > remaining {
+		chunk = chunk[:remaining]
+		l.truncated = true
+	}
+	n, _ := l.w.Write(chunk)
+	l.written += int64(n)
+	if l.truncated {
+		fmt.Fprintf(l.w, "\n[log truncated at %d bytes]\n", l.max)
+	}
+	return len(p), nil
+}
+
+// String returns the log, if it is kept in memory.
+func (l *capturedLog) String() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.buf.String()
+}
+
+// close closes the log file, if any.
+func (l *capturedLog) close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file == nil {
+		return nil
+	}
+	err := l.file.Close()
+	l.file = nil
+	l.w = io.Discard
+	return err
+}
+
+// captureLogger writes the messages of a
+// Logger to a captured log as well.
+type captureLogger struct {
+	Logger
+	log *capturedLog
+}
+
+func (c captureLogger) Printf(format string, v ...interface{}) {
+	c.Logger.Printf(format, v...)
+	msg := fmt.Sprintf(format, v...)
+	if !strings.HasSuffix(msg, "\n") {
+		msg += "\n"
+	}
+	fmt.Fprintf(c.log, "%s %s", time.Now().Format("2006/01/02 15:04:05"), msg)
+}
+
+// startLogCapture captures the log of env for c: the output
+// of its commands and the messages of its Logger.
+func (env *environment) startLogCapture(c LogCapture) error {
+	l, err := newCapturedLog(c)
+	if err != nil {
+		return err
+	}
+	env.capture = l
+	env.stdout = io.MultiWriter(env.stdout, l)
+	env.stderr = io.MultiWriter(env.stderr, l)
+	env.log = captureLogger{Logger: env.log, log: l}
+	env.tailLines = c.TailLines
+	return nil
+}
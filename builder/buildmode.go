@@ -0,0 +1,102 @@
+package builder
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// BuildMode is the kind of object file that go build
+// produces, as selected with -buildmode.
+type BuildMode string
+
+const (
+	// BuildModeExe builds an executable. It is the default.
+	BuildModeExe BuildMode = "exe"
+
+	// BuildModePIE builds a position independent executable.
+	BuildModePIE BuildMode = "pie"
+
+	// BuildModeCShared builds a C shared library. If the main
+	// package exports functions with //export comments, a C
+	// header file that declares them is written next to it.
+	BuildModeCShared BuildMode = "c-shared"
+
+	// BuildModeCArchive builds a C archive, with a C
+	// header file as for BuildModeCShared.
+	BuildModeCArchive BuildMode = "c-archive"
+
+	// BuildModePlugin builds a Go plugin, which
+	// programs can load with the plugin package.
+	BuildModePlugin BuildMode = "plugin"
+)
+
+// pluginBuildModeOS lists the operating systems
+// that support BuildModePlugin.
+var pluginBuildModeOS = map[string]bool{"linux": true, "darwin": true, "freebsd": true}
+
+// checkBuildMode returns an error if mode is neither
+// empty nor a supported build mode.
+func checkBuildMode(mode BuildMode) error {
+	switch mode {
+	case "", BuildModeExe, BuildModePIE, BuildModeCShared, BuildModeCArchive, BuildModePlugin:
+		return nil
+	}
+	return fmt.Errorf("unsupported build mode %q; use %q, %q, %q, %q, or %q", mode,
+		BuildModeExe, BuildModePIE, BuildModeCShared, BuildModeCArchive, BuildModePlugin)
+}
+
+// executable reports whether mode builds an executable.
+func (mode BuildMode) executable() bool {
+	return mode == "" || mode == BuildModeExe || mode == BuildModePIE
+}
+
+// needsCgo reports whether mode requires cgo, as
+// all modes that link with C or load at run time do.
+func (mode BuildMode) needsCgo() bool {
+	return !mode.executable()
+}
+
+// writesHeader reports whether go build may write
+// a C header file next to the output in mode.
+func (mode BuildMode) writesHeader() bool {
+	return mode == BuildModeCShared || mode == BuildModeCArchive
+}
+
+// headerFile returns the path of the C header file that go build
+// may write next to output in mode, or "" if it writes none: the
+// output file with its extension replaced by .h.
+func (mode BuildMode) headerFile(output string) string {
+	if !mode.writesHeader() {
+		return ""
+	}
+	return strings.TrimSuffix(output, filepath.Ext(output)) + ".h"
+}
+
+// checkBuildModeOptions returns the problems with options
+// of b that do not work with its build mode.
+func (b Builder) checkBuildModeOptions() []ValidationProblem {
+	v := &validator{}
+	mode := b.BuildMode
+	if mode.executable() {
+		return nil
+	}
+	for _, opt := range []struct {
+		field string
+		set   bool
+	}{
+		{"smoke_test", b.SmokeTest != nil},
+		{"compress", b.Compress != nil},
+	} {
+		if opt.set {
+			v.add(opt.field, fmt.Errorf("requires an executable, which build_mode %s does not produce", mode))
+		}
+	}
+	if b.Static && mode != BuildModeCArchive {
+		v.add("static", fmt.Errorf("cannot be combined with build_mode %s, which links dynamically", mode))
+	}
+	if mode == BuildModePlugin && !pluginBuildModeOS[b.targetOS()] {
+		v.add("build_mode", fmt.Errorf("plugins are not supported on %s", b.targetOS()))
+	}
+	return v.problems
+}
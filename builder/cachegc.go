@@ -0,0 +1,364 @@
+package builder
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// CacheGCPolicy is what CacheGC trims and how much of it is kept, so
+// that build hosts stay within their disk quotas without scripts of
+// their own. Each cache is only trimmed if it has a limit.
+type CacheGCPolicy struct {
+	// GoModCache is the module cache to trim to the limits of
+	// ModCache. It defaults to the module cache of the go command
+	// (`go env GOMODCACHE`). The module cache does not record when
+	// a module was last used, so modules are aged by when they were
+	// downloaded. Their go.mod files are kept, which are small and
+	// spare builds the download of the modules whose code they no
+	// longer build; the rest is downloaded again when it is needed.
+	GoModCache string      `json:"go_mod_cache,omitempty"`
+	ModCache   CacheLimits `json:"mod_cache,omitempty"`
+
+	// GoCache is the build cache to trim to the limits of
+	// BuildCache. It defaults to the build cache of the go command
+	// (`go env GOCACHE`), which records when its entries were used.
+	GoCache    string      `json:"go_cache,omitempty"`
+	BuildCache CacheLimits `json:"build_cache,omitempty"`
+
+	// ArtifactCache, if set, is evicted according to its own
+	// MaxSize and MaxAge, as Build does after it adds a binary.
+	ArtifactCache *ArtifactCache `json:"artifact_cache,omitempty"`
+
+	// BuildEnvMaxAge, if positive, removes the build environments
+	// in WorkDir that were last modified longer ago, as CleanStale
+	// does. WorkDir defaults to the location of temporary folders.
+	WorkDir        string        `json:"work_dir,omitempty"`
+	BuildEnvMaxAge time.Duration `json:"build_env_max_age,omitempty"`
+}
+
+// CacheLimits are the limits of a cache. Beyond MaxSize, if positive,
+// the entries that were used least recently are removed, and so are
+// the entries that have not been used for MaxAge, if positive.
+type CacheLimits struct {
+	MaxSize int64         `json:"max_size,omitempty"`
+	MaxAge  time.Duration `json:"max_age,omitempty"`
+}
+
+// CacheGCReport is what CacheGC removed from each cache,
+// which is nil for the caches that were not trimmed.
+type CacheGCReport struct {
+	ModCache      *CacheGCResult `json:"mod_cache,omitempty"`
+	BuildCache    *CacheGCResult `json:"build_cache,omitempty"`
+	ArtifactCache *CacheGCResult `json:"artifact_cache,omitempty"`
+	BuildEnvs     *CacheGCResult `json:"build_envs,omitempty"`
+}
+
+// CacheGCResult is what CacheGC removed from the cache in Dir: the
+// number of entries, such as modules or binaries, and their bytes,
+// and the bytes that the remaining entries take up.
+type CacheGCResult struct {
+	Dir       string `json:"dir"`
+	Removed   int    `json:"removed"`
+	Freed     int64  `json:"freed"`
+	Remaining int64  `json:"remaining"`
+}
+
+// CacheGC trims the module cache, the build cache, the artifact
+// cache, and the build environments left behind by builds, according
+// to policy, and reports what it removed. Entries are removed even if
+// builds are using them, which then fail, so CacheGC should run while
+// no builds do, or with ages that exceed the longest build. If a cache
+// cannot be trimmed, the report of the caches trimmed before it is
+// returned with the error.
+func CacheGC(ctx context.Context, policy CacheGCPolicy) (*CacheGCReport, error) {
+	report := &CacheGCReport{}
+	if policy.BuildEnvMaxAge > 0 {
+		parentDir, err := tempParentDir(policy.WorkDir)
+		if err != nil {
+			return report, err
+		}
+		if parentDir == "" {
+			parentDir = os.TempDir()
+		}
+		report.BuildEnvs, _, err = trimCache(ctx, parentDir, CacheLimits{MaxAge: policy.BuildEnvMaxAge}, buildEnvEntries)
+		if err != nil {
+			return report, fmt.Errorf("removing stale build environments: %v", err)
+		}
+	}
+	if c := policy.ArtifactCache; c != nil && c.limits() != (CacheLimits{}) {
+		var err error
+		report.ArtifactCache, _, err = trimCache(ctx, c.Dir, c.limits(), artifactEntries)
+		if err != nil {
+			return report, fmt.Errorf("trimming artifact cache: %v", err)
+		}
+	}
+	if policy.BuildCache != (CacheLimits{}) {
+		dir, err := goEnvDir(ctx, policy.GoCache, "GOCACHE")
+		if err != nil {
+			return report, err
+		}
+		// the build cache may be disabled
+		if dir != "off" {
+			report.BuildCache, _, err = trimCache(ctx, dir, policy.BuildCache, buildCacheEntries)
+			if err != nil {
+				return report, fmt.Errorf("trimming build cache: %v", err)
+			}
+		}
+	}
+	if policy.ModCache != (CacheLimits{}) {
+		dir, err := goEnvDir(ctx, policy.GoModCache, "GOMODCACHE")
+		if err != nil {
+			return report, err
+		}
+		report.ModCache, _, err = trimCache(ctx, dir, policy.ModCache, modCacheEntries)
+		if err != nil {
+			return report, fmt.Errorf("trimming module cache: %v", err)
+		}
+	}
+	return report, nil
+}
+
+// goEnvDir returns dir, if set, or else the directory
+// that the go command has for the variable key.
+func goEnvDir(ctx context.Context, dir, key string) (string, error) {
+	if dir != "" {
+		return filepath.Abs(dir)
+	}
+	out, err := exec.CommandContext(ctx, GetGo(), "env", key).Output()
+	if err != nil {
+		return "", fmt.Errorf("locating %s: %v", key, err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// cacheEntry is a part of a cache that is removed as a whole,
+// by remove if set, or else by removing path.
+type cacheEntry struct {
+	path    string
+	size    int64
+	modTime time.Time
+	remove  func() error
+}
+
+// trimCache removes the entries of the cache in dir, which list
+// returns, that exceed limits, the least recently used first, and
+// returns the paths of the removed entries as well.
+func trimCache(ctx context.Context, dir string, limits CacheLimits, list func(dir string) ([]cacheEntry, error)) (*CacheGCResult, []string, error) {
+	entries, err := list(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	result := &CacheGCResult{Dir: dir}
+	for _, e := range entries {
+		result.Remaining += e.size
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].modTime.Before(entries[j].modTime) })
+
+	cutoff := time.Now().Add(-limits.MaxAge)
+	var removed []string
+	for _, e := range entries {
+		expired := limits.MaxAge > 0 && e.modTime.Before(cutoff)
+		oversized := limits.MaxSize > 0 && result.Remaining > limits.MaxSize
+		if !expired && !oversized {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return result, removed, err
+		}
+		remove := e.remove
+		if remove == nil {
+			remove = func() error { return os.RemoveAll(e.path) }
+		}
+		if err := remove(); err != nil {
+			return result, removed, err
+		}
+		removed = append(removed, e.path)
+		result.Removed++
+		result.Freed += e.size
+		result.Remaining -= e.size
+	}
+	return result, removed, nil
+}
+
+// buildEnvEntries returns the build environments in dir.
+func buildEnvEntries(dir string) ([]cacheEntry, error) {
+	dirEntries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var entries []cacheEntry
+	for _, d := range dirEntries {
+		if !d.IsDir() || !strings.HasPrefix(d.Name(), tempFolderPrefix) {
+			continue
+		}
+		info, err := d.Info()
+		if err != nil {
+			continue
+		}
+		e := cacheEntry{path: filepath.Join(dir, d.Name()), modTime: info.ModTime()}
+		if e.size, err = dirSize(e.path); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+// artifactEntries returns the binaries of the artifact cache in dir,
+// whose modification times are when they were last used.
+func artifactEntries(dir string) ([]cacheEntry, error) {
+	dirEntries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var entries []cacheEntry
+	for _, d := range dirEntries {
+		if !d.IsDir() || strings.HasPrefix(d.Name(), ".") {
+			continue
+		}
+		info, err := d.Info()
+		if err != nil {
+			continue
+		}
+		e := cacheEntry{path: filepath.Join(dir, d.Name()), modTime: info.ModTime()}
+		for _, name := range []string{artifactBinary, artifactResult} {
+			if fi, err := os.Stat(filepath.Join(e.path, name)); err == nil {
+				e.size += fi.Size()
+			}
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+// buildCacheEntries returns the files of the build cache in dir,
+// which are in subdirectories named by two hexadecimal digits. The
+// go command updates the modification times of the files it uses.
+func buildCacheEntries(dir string) ([]cacheEntry, error) {
+	dirEntries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var entries []cacheEntry
+	for _, d := range dirEntries {
+		if !d.IsDir() || !isHexByte(d.Name()) {
+			continue
+		}
+		sub := filepath.Join(dir, d.Name())
+		files, err := os.ReadDir(sub)
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range files {
+			if !f.Type().IsRegular() {
+				continue
+			}
+			info, err := f.Info()
+			if err != nil {
+				continue
+			}
+			entries = append(entries, cacheEntry{path: filepath.Join(sub, f.Name()), size: info.Size(), modTime: info.ModTime()})
+		}
+	}
+	return entries, nil
+}
+
+// isHexByte reports whether name is two lowercase hexadecimal digits.
+func isHexByte(name string) bool {
+	return len(name) == 2 && strings.Trim(name, "0123456789abcdef") == ""
+}
+
+// modCacheEntries returns the modules of the module cache in dir:
+// the extracted directory of each module version, named path@version,
+// with its downloaded zip file, but not its go.mod file. The directories
+// are read-only, as the go command makes them.
+func modCacheEntries(dir string) ([]cacheEntry, error) {
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		return nil, nil
+	}
+	var entries []cacheEntry
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() || p == dir {
+			return nil
+		}
+		if p == filepath.Join(dir, "cache") {
+			// the downloads, which are found by the modules
+			return filepath.SkipDir
+		}
+		if !strings.Contains(d.Name(), "@") {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		size, err := dirSize(p)
+		if err != nil {
+			return err
+		}
+		// the escaped module path and version, as in the
+		// file names of the downloads
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		i := strings.LastIndex(rel, "@")
+		download := filepath.Join(dir, "cache", "download", rel[:i], "@v", rel[i+1:])
+		files := []string{download + ".zip", download + ".ziphash"}
+		for _, file := range files {
+			if fi, err := os.Stat(file); err == nil {
+				size += fi.Size()
+			}
+		}
+		path := p
+		entries = append(entries, cacheEntry{
+			path:    path,
+			size:    size,
+			modTime: info.ModTime(),
+			remove: func() error {
+				if err := removeReadOnly(path); err != nil {
+					return err
+				}
+				for _, file := range files {
+					if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+						return err
+					}
+				}
+				return nil
+			},
+		})
+		return filepath.SkipDir
+	})
+	return entries, err
+}
+
+// removeReadOnly removes dir, whose
+// directories may be read-only.
+func removeReadOnly(dir string) error {
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err == nil && d.IsDir() {
+			err = os.Chmod(p, 0755)
+		}
+		return err
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return os.RemoveAll(dir)
+}
@@ -0,0 +1,176 @@
+package builder
+
+import (
+	"fmt"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// CgoToolchain configures the C toolchain used
+// by cgo when building for a particular target.
+type CgoToolchain struct {
+	// The C and C++ compilers, exported as CC and CXX.
+	CC  string `json:"cc,omitempty"`
+	CXX string `json:"cxx,omitempty"`
+
+	// Sysroot, if set, is passed to the compilers and
+	// the linker with --sysroot.
+	Sysroot string `json:"sysroot,omitempty"`
+
+	// Extra flags appended to CGO_CFLAGS, CGO_CXXFLAGS,
+	// and CGO_LDFLAGS, respectively.
+	CFlags   string `json:"cflags,omitempty"`
+	CXXFlags string `json:"cxxflags,omitempty"`
+	LDFlags  string `json:"ldflags,omitempty"`
+}
+
+// toolchain returns the cgo toolchain configured for the
+// target platform, falling back to the entry without an
+// ARM version if there is none for the exact target.
+func (b Builder) toolchain() (CgoToolchain, bool) {
+	target := Platform{OS: b.targetOS(), Arch: b.targetArch(), ARM: b.ARM}
+	if tc, ok := b.Toolchains[target.key()]; ok {
+		return tc, true
+	}
+	target.ARM = ""
+	tc, ok := b.Toolchains[target.key()]
+	return tc, ok
+}
+
+// targetArch returns the architecture being built
+// for, falling back to that of the host.
+func (b Builder) targetArch() string {
+	if b.Arch != "" {
+		return b.Arch
+	}
+	return runtime.GOARCH
+}
+
+// isCrossCompiling reports whether the target
+// platform differs from that of the host.
+func (b Builder) isCrossCompiling() bool {
+	return b.targetOS() != runtime.GOOS || b.targetArch() != runtime.GOARCH
+}
+
+// cgoRequirement returns what requires cgo in the build of b, or ""
+// if nothing does: a build mode that links with C, the sanitizers,
+// or BoringCrypto, which FIPS builds use with toolchains older than
+// minFIPS140GoVersion. goVersion is the version of the toolchain,
+// such as "go1.23.4", or empty if it is not known, which leaves
+// BoringCrypto out. WebAssembly targets never have cgo.
+func (b Builder) cgoRequirement(goVersion string) string {
+	if b.isWasm() {
+		return ""
+	}
+	var reasons []string
+	if b.BuildMode.needsCgo() {
+		reasons = append(reasons, "build mode "+string(b.BuildMode))
+	}
+	for _, s := range b.enabledSanitizers() {
+		reasons = append(reasons, s.field)
+	}
+	if b.FIPS && usesBoringCrypto(goVersion) {
+		reasons = append(reasons, "BoringCrypto")
+	}
+	return strings.Join(reasons, " and ")
+}
+
+// usesCgo reports whether the build of b with the toolchain of
+// goVersion, as for cgoRequirement, uses cgo: whether Cgo is set
+// or something requires it.
+func (b Builder) usesCgo(goVersion string) bool {
+	return b.Compile.Cgo && !b.isWasm() || b.cgoRequirement(goVersion) != ""
+}
+
+// enableRequiredCgo enables cgo, with a warning, if something
+// requires it in the build of b with the toolchain of goVersion.
+func (b *Builder) enableRequiredCgo(goVersion string) {
+	if reason := b.cgoRequirement(goVersion); reason != "" && !b.Compile.Cgo {
+		b.logger().Printf("[WARNING] Enabling cgo because it is required by %s", reason)
+		b.Compile.Cgo = true
+	}
+}
+
+// cgoEnv applies the cgo settings of b to env, in which cgo is
+// enabled if the build uses it, as usesCgo reports.
+func (b Builder) cgoEnv(env []string) []string {
+	b.Compile.Cgo = b.usesCgo("")
+	env = setEnv(env, fmt.Sprintf("CGO_ENABLED=%s", b.Compile.CgoEnabled()))
+	if !b.Compile.Cgo {
+		if b.Compile.CgoCFlags != "" || b.Compile.CgoLdFlags != "" {
+			b.logger().Printf("[WARNING] Ignoring cgo flags because cgo is disabled")
+		}
+		return env
+	}
+
+	cflags := []string{b.Compile.CgoCFlags}
+	cxxflags := []string{}
+	ldflags := []string{b.Compile.CgoLdFlags}
+	tc, ok := b.toolchain()
+	if ok {
+		if tc.CC != "" {
+			env = setEnv(env, "CC="+tc.CC)
+		}
+		if tc.CXX != "" {
+			env = setEnv(env, "CXX="+tc.CXX)
+		}
+		if tc.Sysroot != "" {
+			sysroot := "--sysroot=" + tc.Sysroot
+			cflags = append(cflags, sysroot)
+			cxxflags = append(cxxflags, sysroot)
+			ldflags = append(ldflags, sysroot)
+		}
+		cflags = append(cflags, tc.CFlags)
+		cxxflags = append(cxxflags, tc.CXXFlags)
+		ldflags = append(ldflags, tc.LDFlags)
+	}
+	if _, hasCC := getEnv(env, "CC"); !hasCC && b.isCrossCompiling() {
+		b.logger().Printf("[WARNING] Cross-compiling with cgo for %s/%s, but no C compiler is configured; set CC or configure a toolchain for the target",
+			b.targetOS(), b.targetArch())
+	}
+
+	for _, v := range []struct {
+		key   string
+		flags []string
+	}{
+		{"CGO_CFLAGS", cflags},
+		{"CGO_CXXFLAGS", cxxflags},
+		{"CGO_LDFLAGS", ldflags},
+	} {
+		if joined := joinNonEmpty(v.flags); joined != "" {
+			env = setEnv(env, v.key+"="+joined)
+		}
+	}
+	return env
+}
+
+// staticSettings returns env adjusted for a fully static build,
+// along with the build tags and linker flags that it requires.
+func (b Builder) staticSettings(env []string) (newEnv, tags, ldflags []string) {
+	tags = []string{"netgo", "osusergo"}
+	if !b.Compile.Cgo {
+		// without cgo, the go linker already produces static binaries
+		return env, tags, nil
+	}
+	if _, hasCC := getEnv(env, "CC"); !hasCC {
+		if musl, err := exec.LookPath("musl-gcc"); err == nil {
+			b.logger().Printf("[INFO] Using %s for a static cgo build", musl)
+			env = setEnv(env, "CC="+musl)
+		} else {
+			b.logger().Printf("[WARNING] Linking statically with cgo but without musl-gcc; parts of glibc may not work in a static binary")
+		}
+	}
+	return env, tags, []string{"-linkmode=external", "-extldflags '-static'"}
+}
+
+// joinNonEmpty joins the non-empty elements of s with spaces.
+func joinNonEmpty(s []string) string {
+	var parts []string
+	for _, v := range s {
+		if v != "" {
+			parts = append(parts, v)
+		}
+	}
+	return strings.Join(parts, " ")
+}
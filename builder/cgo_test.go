@@ -0,0 +1,39 @@
+package builder
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCgoEnv(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		b       Builder
+		want    []string
+		warning bool
+	}{
+		{"cgo disabled", Builder{}, []string{"CGO_ENABLED=0"}, false},
+		{"flags without cgo", Builder{Compile: Compile{CgoCFlags: "-O3", CgoLdFlags: "-lm"}}, []string{"CGO_ENABLED=0"}, true},
+		{"cgo enabled", Builder{Compile: Compile{Cgo: true, CgoCFlags: "-O3", CgoLdFlags: "-lm"}},
+			[]string{"CGO_ENABLED=1", "CGO_CFLAGS=-O3", "CGO_LDFLAGS=-lm"}, false},
+		{"race detector", Builder{Compile: Compile{CgoCFlags: "-O3"}, RaceDetector: true},
+			[]string{"CGO_ENABLED=1", "CGO_CFLAGS=-O3"}, false},
+		{"WebAssembly", Builder{Compile: Compile{Platform: Platform{OS: "wasip1", Arch: "wasm"}, Cgo: true, CgoCFlags: "-O3"}},
+			[]string{"CGO_ENABLED=0"}, true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			logger := &recordingLogger{}
+			b := tt.b
+			b.Logger = logger
+			// the host is the target, so no C compiler is needed
+			got := b.cgoEnv([]string{"PATH=/bin"})
+			want := append([]string{"PATH=/bin"}, tt.want...)
+			if strings.Join(got, " ") != strings.Join(want, " ") {
+				t.Errorf("cgoEnv = %q, want %q", got, want)
+			}
+			if warned := len(logger.Messages("cgo is disabled")) > 0; warned != tt.warning {
+				t.Errorf("warned about ignored cgo flags: %t, want %t", warned, tt.warning)
+			}
+		})
+	}
+}
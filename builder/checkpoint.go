@@ -0,0 +1,137 @@
+package builder
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Checkpoint is the state of a build once its modules are resolved,
+// which Build writes to Builder.Checkpoint, so that Resume can finish
+// the build after the process was interrupted without resolving the
+// modules again.
+type Checkpoint struct {
+	// The configuration of the build, including any Credentials,
+	// which is why the file is only readable by its owner.
+	// Settings that are not encoded, such as Logger, Signers,
+	// or OnEvent, are lost.
+	Builder Builder `json:"builder"`
+
+	// The absolute path of the binary to build.
+	OutputFile string `json:"output_file"`
+
+	// The phase of the build to resume at, which is
+	// "compile", the one after resolving the modules.
+	Phase string `json:"phase"`
+
+	// The folder of the build environment, which is removed
+	// on resuming if the interrupted build left it behind.
+	Dir string `json:"dir"`
+
+	// The tidied go.mod and go.sum of the build environment.
+	GoMod []byte `json:"go_mod"`
+	GoSum []byte `json:"go_sum,omitempty"`
+
+	// How version queries were resolved.
+	Resolved []ResolvedVersion `json:"resolved,omitempty"`
+
+	// When the checkpoint was written.
+	Time time.Time `json:"time"`
+}
+
+// checkpointPhase is the phase that Resume resumes builds at.
+const checkpointPhase = "compile"
+
+// writeCheckpoint writes the checkpoint of the build of b to
+// absOutputFile, whose env is tidied and checked, to b.Checkpoint.
+func (b Builder) writeCheckpoint(env *environment, absOutputFile string) error {
+	mod, err := env.resolvedModule()
+	if err != nil {
+		return err
+	}
+	cp := Checkpoint{
+		Builder:    b,
+		OutputFile: absOutputFile,
+		Phase:      checkpointPhase,
+		Dir:        env.tempFolder,
+		GoMod:      mod.GoMod,
+		GoSum:      mod.GoSum,
+		Resolved:   env.resolved,
+		Time:       time.Now().UTC(),
+	}
+	cp.Builder.Checkpoint = ""
+	data, err := json.MarshalIndent(cp, "", "\t")
+	if err != nil {
+		return err
+	}
+	// write it in full or not at all, as the
+	// process may be interrupted at any time
+	tmp := b.Checkpoint + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0600); err != nil {
+		return fmt.Errorf("writing checkpoint: %v", err)
+	}
+	if err := os.Rename(tmp, b.Checkpoint); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("writing checkpoint: %v", err)
+	}
+	b.logger().Printf("[INFO] Wrote checkpoint to %s", b.Checkpoint)
+	return nil
+}
+
+// ReadCheckpoint reads a Checkpoint written by Build from path.
+func ReadCheckpoint(path string) (*Checkpoint, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cp Checkpoint
+	if err := json.Unmarshal(data, &cp); err != nil {
+		return nil, fmt.Errorf("decoding checkpoint %s: %v", path, err)
+	}
+	if cp.Phase != checkpointPhase || len(cp.GoMod) == 0 || cp.OutputFile == "" {
+		return nil, fmt.Errorf("checkpoint %s is incomplete", path)
+	}
+	return &cp, nil
+}
+
+// Resume finishes the build of the checkpoint at checkpointPath,
+// which Build wrote before it was interrupted, by compiling the
+// module resolved then: like BuildWithModule, it only checks that
+// the module is still tidy, which needs no downloads as long as the
+// module cache is kept. The checks before compiling, such as
+// LicenseCheck or VulnCheck, passed before the checkpoint and do not
+// run again. The checkpoint is removed once the build succeeds.
+func Resume(ctx context.Context, checkpointPath string) (*BuildResult, error) {
+	cp, err := ReadCheckpoint(checkpointPath)
+	if err != nil {
+		return nil, err
+	}
+	b := cp.Builder
+	b.logger().Printf("[INFO] Resuming build of %s from checkpoint of %s", cp.OutputFile, cp.Time.Local().Format(time.RFC3339))
+
+	// a build that was interrupted did not clean up
+	if strings.HasPrefix(filepath.Base(cp.Dir), tempFolderPrefix) {
+		if _, err := os.Stat(cp.Dir); err == nil {
+			b.logger().Printf("[INFO] Removing build environment of interrupted build: %s", cp.Dir)
+			if err := os.RemoveAll(cp.Dir); err != nil {
+				b.logger().Printf("[WARNING] Removing %s: %v", cp.Dir, err)
+			}
+		}
+	}
+
+	result, err := b.BuildWithModule(ctx, cp.GoMod, cp.GoSum, cp.OutputFile)
+	if err != nil {
+		return nil, err
+	}
+	if result != nil {
+		result.Resolved = cp.Resolved
+	}
+	if err := os.Remove(checkpointPath); err != nil {
+		b.logger().Printf("[WARNING] Removing checkpoint: %v", err)
+	}
+	return result, nil
+}
@@ -0,0 +1,107 @@
+package builder
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// CleanupPolicy determines when the temporary folder
+// of a build environment is removed.
+type CleanupPolicy string
+
+const (
+	// CleanupAlways removes the folder after every build.
+	// It is the default unless SkipCleanup is set.
+	CleanupAlways CleanupPolicy = "always"
+
+	// CleanupOnSuccess removes the folder after successful
+	// builds and keeps it after failed ones for inspection.
+	CleanupOnSuccess CleanupPolicy = "on-success"
+
+	// CleanupNever keeps the folder. It is the
+	// default if SkipCleanup is set.
+	CleanupNever CleanupPolicy = "never"
+
+	// CleanupMaxAge keeps the folder, but every build first
+	// removes the folders in the work directory that are older
+	// than Builder.CleanupMaxAge, as CleanStale does.
+	CleanupMaxAge CleanupPolicy = "max-age"
+)
+
+// cleanupPolicy returns the effective cleanup policy of b.
+func (b Builder) cleanupPolicy() (CleanupPolicy, error) {
+	switch b.CleanupPolicy {
+	case "":
+		if b.SkipCleanup {
+			return CleanupNever, nil
+		}
+		return CleanupAlways, nil
+	case CleanupAlways, CleanupOnSuccess, CleanupNever:
+		return b.CleanupPolicy, nil
+	case CleanupMaxAge:
+		if b.CleanupMaxAge <= 0 {
+			return "", fmt.Errorf("cleanup policy %s requires a positive CleanupMaxAge", CleanupMaxAge)
+		}
+		return CleanupMaxAge, nil
+	}
+	return "", fmt.Errorf("unknown cleanup policy %q", b.CleanupPolicy)
+}
+
+// keepFolder returns true if the policy keeps the folder of a
+// build environment after a build that ended with err.
+func (p CleanupPolicy) keepFolder(err error) bool {
+	switch p {
+	case CleanupNever, CleanupMaxAge:
+		return true
+	case CleanupOnSuccess:
+		return err != nil
+	}
+	return false
+}
+
+// CleanStale removes the temporary folders of build environments
+// in dir that were last modified more than olderThan ago, such as
+// those left behind by crashed builds or kept by a cleanup policy,
+// and returns their paths. If dir is empty, the default location
+// of temporary folders is cleaned. Folders of builds that are still
+// running are not protected, so olderThan should exceed the longest
+// build.
+func CleanStale(dir string, olderThan time.Duration) ([]string, error) {
+	parentDir, err := tempParentDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	if parentDir == "" {
+		parentDir = os.TempDir()
+	}
+	entries, err := os.ReadDir(parentDir)
+	if err != nil {
+		return nil, err
+	}
+	cutoff := time.Now().Add(-olderThan)
+	var removed []string
+	for _, entry := range entries {
+		if !entry.IsDir() || !strings.HasPrefix(entry.Name(), tempFolderPrefix) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return removed, err
+		}
+		if info.ModTime().After(cutoff) {
+			continue
+		}
+		path := filepath.Join(parentDir, entry.Name())
+		if err := os.RemoveAll(path); err != nil {
+			return removed, err
+		}
+		removed = append(removed, path)
+	}
+	return removed, nil
+}
@@ -0,0 +1,100 @@
+package builder
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+
+	"golang.org/x/mod/semver"
+)
+
+// CompatibilityConflict describes a module in the build that
+// requires a newer version of the Caddy module than requested.
+type CompatibilityConflict struct {
+	// The module (and version) that has the requirement.
+	Module string `json:"module,omitempty"`
+
+	// The version of the Caddy module that Module requires.
+	Requires string `json:"requires,omitempty"`
+
+	// The version of the Caddy module that was requested.
+	Requested string `json:"requested,omitempty"`
+}
+
+func (c CompatibilityConflict) String() string {
+	return fmt.Sprintf("%s requires %s, but %s was requested", c.Module, c.Requires, c.Requested)
+}
+
+// CompatibilityError is returned when strict compatibility
+// checking is enabled and any conflicts were found.
+type CompatibilityError struct {
+	Conflicts []CompatibilityConflict
+}
+
+func (e *CompatibilityError) Error() string {
+	lines := make([]string, 0, len(e.Conflicts))
+	for _, c := range e.Conflicts {
+		lines = append(lines, c.String())
+	}
+	return fmt.Sprintf("%d incompatible module(s): %s", len(e.Conflicts), strings.Join(lines, "; "))
+}
+
+// checkCompatibility reports every module in the module graph
+// that requires a newer version of the Caddy module than the
+// requested one, using the output of `go mod graph`. It returns
+// no conflicts if no specific Caddy version was requested.
+func (env environment) checkCompatibility(ctx context.Context) ([]CompatibilityConflict, error) {
+	if !semver.IsValid(env.caddyVersion) {
+		return nil, nil
+	}
+
+	var out bytes.Buffer
+	cmd := env.newGoModCommand(ctx, "graph")
+	cmd.Stdout = &out
+	if err := env.runCommand(ctx, cmd); err != nil {
+		return nil, err
+	}
+
+	var conflicts []CompatibilityConflict
+	for _, line := range strings.Split(out.String(), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		from, to := fields[0], fields[1]
+		if !strings.Contains(from, "@") {
+			// requirement of the main module itself
+			continue
+		}
+		toPath, toVersion, ok := strings.Cut(to, "@")
+		if !ok || (env.caddyModulePath != toPath && !strings.HasPrefix(env.caddyModulePath, toPath+"/")) {
+			continue
+		}
+		if semver.Compare(toVersion, env.caddyVersion) > 0 {
+			conflicts = append(conflicts, CompatibilityConflict{
+				Module:    from,
+				Requires:  toVersion,
+				Requested: env.caddyVersion,
+			})
+		}
+	}
+	return conflicts, nil
+}
+
+// enforceCompatibility runs the compatibility check, logging all
+// conflicts at once, and returns an error for them if strict is set.
+func (env environment) enforceCompatibility(ctx context.Context, strict bool) error {
+	env.log.Printf("[INFO] Checking module compatibility")
+	conflicts, err := env.checkCompatibility(ctx)
+	if err != nil {
+		return err
+	}
+	for _, c := range conflicts {
+		env.log.Printf("[WARNING] Incompatible module: %s", c)
+	}
+	if strict && len(conflicts) > 0 {
+		return &CompatibilityError{Conflicts: conflicts}
+	}
+	return nil
+}
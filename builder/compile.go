@@ -3,92 +3,1003 @@ package builder
 import (
 	"context"
 	"fmt"
+	"io"
+	"io/fs"
 	"log"
 	"os"
 	"path/filepath"
 	"runtime"
 	"strings"
 	"time"
+
+	"golang.org/x/mod/module"
 )
 
 // Builder can produce a custom Caddy build with the
 // configuration it represents.
+//
+// A Builder is not modified by building with it, so the same value
+// may be used for concurrent builds; each build gets its own absolute
+// temporary folder and its own environment for the go commands. The
+// only process-wide state consulted is the environment and, for
+// relative paths such as the output file, the working directory at
+// the time the build starts. Callbacks such as OnProgress may be
+// called concurrently by concurrent builds.
 type Builder struct {
+	Compile
 	CaddyVersion string        `json:"caddy_version,omitempty"`
+	Plugins      []Dependency  `json:"plugins,omitempty"`
 	Replacements []Replace     `json:"replacements,omitempty"`
 	TimeoutGet   time.Duration `json:"timeout_get,omitempty"`
 	TimeoutBuild time.Duration `json:"timeout_build,omitempty"`
 	RaceDetector bool          `json:"race_detector,omitempty"`
 	SkipCleanup  bool          `json:"skip_cleanup,omitempty"`
 	SkipBuild    bool          `json:"skip_build,omitempty"`
-	Debug        bool          `json:"debug,omitempty"`
 	BuildFlags   string        `json:"build_flags,omitempty"`
 	ModFlags     string        `json:"mod_flags,omitempty"`
+
+	// Workspace lists the directories of local modules to build
+	// in workspace mode: instead of replace directives, a go.work
+	// file in the build environment uses them along with the main
+	// module, which suits developing several plugins together.
+	// Plugins and Caddy itself are taken from the workspace if
+	// one of its modules provides them. `go mod tidy` does not
+	// support workspaces, so the main module is not tidied.
+	Workspace []string `json:"workspace,omitempty"`
+
+	// Lock, if set, is the Lock that the Builder reproduces, as
+	// set by BuilderFromLock: the hashes of its modules are
+	// written to go.sum before any modules are fetched, so that
+	// the go command fails on any module whose content differs.
+	Lock *Lock `json:"lock,omitempty"`
+
+	// Excludes are module versions that the module graph must
+	// not use, as with exclude directives in go.mod. The
+	// PackagePath of each is a module path, and its Version may
+	// be any query that resolves to a single version.
+	Excludes []Dependency `json:"excludes,omitempty"`
+
+	// Pins are modules that are required at exactly the given
+	// versions: they are passed to every `go get`, which fails if
+	// a plugin requires a newer version, and the build fails if
+	// the tidied module graph selects any other version. The
+	// PackagePath of each is a module path.
+	Pins []Dependency `json:"pins,omitempty"`
+
+	// BuildTags are passed to go build with -tags, merged with
+	// any -tags in BuildFlags. go mod tidy always considers
+	// all build tags, so it needs no equivalent setting.
+	BuildTags []string `json:"build_tags,omitempty"`
+
+	// LDFlagsX sets string variables at link time, mapping
+	// importpath.name to its value, as with -ldflags "-X".
+	// Values are quoted as needed and merged with any
+	// -ldflags in BuildFlags.
+	LDFlagsX map[string]string `json:"ldflags_x,omitempty"`
+
+	// StripSymbols omits the symbol table and DWARF debugging
+	// information from the binary (-ldflags "-s -w").
+	StripSymbols bool `json:"strip_symbols,omitempty"`
+
+	// Debug builds the binary for debuggers such as dlv: without
+	// optimizations and inlining, with its DWARF debugging information,
+	// and without -trimpath, so that the binary has the paths of its
+	// sources on the machine that built it. It cannot be combined with
+	// StripSymbols, Reproducible, or Compress.
+	//
+	// DebugDir, if set as well, is where the build environment, which
+	// has the generated main.go and any vendored modules, is copied to
+	// after the build, replacing an earlier copy, so that the sources
+	// outlive the build environment. A dlv configuration that maps the
+	// paths of the build environment to DebugDir is written next to
+	// the binary, with the extension ".dlv.yml"; see BuildResult.
+	Debug    bool   `json:"debug,omitempty"`
+	DebugDir string `json:"debug_dir,omitempty"`
+
+	// Compress, if set, compresses the binary with UPX after
+	// it is built. Not every target is supported by UPX.
+	Compress *UPXOptions `json:"compress,omitempty"`
+
+	// MaxBinarySize, if positive, is the largest size in bytes
+	// that the binary may have, after any compression, so that
+	// plugins that bloat it are caught before it ships. A larger
+	// binary fails the build with a BinarySizeError that lists
+	// the modules with the most code and data in it, unless
+	// WarnBinarySize is set, which only logs the error.
+	MaxBinarySize  int64 `json:"max_binary_size,omitempty"`
+	WarnBinarySize bool  `json:"warn_binary_size,omitempty"`
+
+	// SizeBreakdown reports in BuildResult.Sizes how much code and
+	// data each package and module adds to the binary, so that the
+	// costs of plugins can be compared. It needs the symbol table,
+	// which StripSymbols omits.
+	SizeBreakdown bool `json:"size_breakdown,omitempty"`
+
+	// WindowsResources, if set, are embedded into binaries for
+	// windows, such as an icon and version information.
+	WindowsResources *WindowsResources `json:"windows_resources,omitempty"`
+
+	// MacOSSign, if set, signs binaries for darwin with codesign
+	// and optionally has them notarized, after the PostBuild hooks.
+	MacOSSign *MacOSSignOptions `json:"macos_sign,omitempty"`
+
+	// PGOProfile is the path of a CPU profile to use for
+	// profile-guided optimization. It is copied into the
+	// build environment as default.pgo and passed with -pgo,
+	// which requires Go 1.21 or newer.
+	PGOProfile string `json:"pgo_profile,omitempty"`
+
+	// Coverage builds a coverage-instrumented binary (-cover),
+	// which writes coverage data to the directory named by the
+	// GOCOVERDIR environment variable when it exits. Only the
+	// packages matching CoverPackages are instrumented; these
+	// default to the packages of the plugins.
+	Coverage      bool     `json:"coverage,omitempty"`
+	CoverPackages []string `json:"cover_packages,omitempty"`
+
+	// Static produces a fully statically linked binary that runs
+	// without a C library, e.g. on scratch or Alpine images. It
+	// builds with the netgo and osusergo tags and, if cgo is
+	// enabled, links externally with -static, using musl-gcc as
+	// the C compiler if it is installed and no CC is configured.
+	Static bool `json:"static,omitempty"`
+
+	// BuildMode selects what go build produces, such as a C
+	// shared library or archive for embedding the server into
+	// other programs. Build modes other than executables enable
+	// cgo, which they require. Default: BuildModeExe
+	BuildMode BuildMode `json:"build_mode,omitempty"`
+
+	// Toolchains configures the C toolchain used by cgo for each
+	// target platform, keyed by os/arch or os/arch/arm (such as
+	// linux/arm64 or linux/arm/7), e.g. for cross-compiling with
+	// cgo. Keys without an ARM version apply to all ARM versions.
+	Toolchains map[string]CgoToolchain `json:"toolchains,omitempty"`
+
+	// BaseModule is the path of the plugin-extensible module that is
+	// built at CaddyVersion, and MainPackage the import path of its
+	// package whose Main function the generated main package calls.
+	// If only one is set, it is used for both. They default to the
+	// goaway module and its server package.
+	BaseModule  string `json:"base_module,omitempty"`
+	MainPackage string `json:"main_package,omitempty"`
+
+	// MainTemplate overrides the text/template used to generate the
+	// main package; see MainTemplateData for the data it is executed
+	// with. If MainTemplateFS is set, MainTemplate is instead the
+	// path of the template file within it.
+	MainTemplate   string `json:"main_template,omitempty"`
+	MainTemplateFS fs.FS  `json:"-"`
+
+	// OutputTemplate names the binary when Build is given a
+	// directory, which is an existing one or a path ending with a
+	// separator, and the binaries of BuildAll. It is a text/template
+	// executed with an OutputName, such as
+	// "caddy_{{.Version}}_{{.OS}}_{{.Arch}}{{.Variant}}{{.Ext}}".
+	// Default: "{{.Name}}_{{.OS}}_{{.Arch}}{{.Variant}}{{.Ext}}"
+	OutputTemplate string `json:"output_template,omitempty"`
+
+	// Embeds are files and directories to embed into the binary
+	// with go:embed, such as a default configuration or web
+	// assets. The main package holds them in embeddedFiles, an
+	// fs.FS that a custom MainTemplate can use.
+	Embeds []EmbedMapping `json:"embeds,omitempty"`
+
+	// EmbedFunc is the qualified name of a function that receives
+	// the embedded files before main runs, e.g.
+	// "github.com/acme/dist.SetFiles" for a function of package
+	// dist with signature func(fs.FS). Its module must be part
+	// of the build, e.g. as a plugin.
+	EmbedFunc string `json:"embed_func,omitempty"`
+
+	// SkipExeSuffix disables appending ".exe" to the output
+	// file when building for Windows, or ".wasm" when building
+	// for WebAssembly.
+	SkipExeSuffix bool `json:"skip_exe_suffix,omitempty"`
+
+	// WasmExec copies wasm_exec.js of the Go toolchain next to
+	// the output file of builds for js/wasm, which browsers and
+	// Node.js need to run the module.
+	WasmExec bool `json:"wasm_exec,omitempty"`
+
+	// MaxDiskBytes, if positive, aborts the build when the
+	// temporary build environment grows beyond this size. The
+	// GOPATH, GOCACHE, and GOMODCACHE set in Env, such as those
+	// of the builds of a Pool, count toward it as well, but not
+	// a GoModCache, which builds share.
+	MaxDiskBytes int64 `json:"max_disk_bytes,omitempty"`
+
+	// MinFreeDiskBytes is how much free space the volumes of the
+	// build environment and of the output file must have before a
+	// build starts, which fails with a PreflightError otherwise.
+	// Default: 1 GiB; negative disables the check.
+	MinFreeDiskBytes int64 `json:"min_free_disk_bytes,omitempty"`
+
+	// MainModulePath is the module path given to the generated
+	// main module, such as one under a company domain for proxies
+	// whose policies go by module path. Default: goaway
+	MainModulePath string `json:"main_module_path,omitempty"`
+
+	// GoDirective, if set, is the language version of the go
+	// directive of the generated main module, e.g. "1.22", for
+	// plugins that need a newer one than the go command writes,
+	// which is its own version. With a newer version than that of
+	// the toolchain, the go command switches to a newer toolchain
+	// as GOTOOLCHAIN permits. Default: that of the go command
+	GoDirective string `json:"go_directive,omitempty"`
+
+	// GoExperiment, if set, is exported as GOEXPERIMENT
+	// to every go command run during the build. It is a
+	// comma-separated list of experiments such as "greenteagc"
+	// or "boringcrypto", each of which a "no" prefix disables.
+	GoExperiment string `json:"go_experiment,omitempty"`
+
+	// GoDebug sets the default GODEBUG settings of the binary,
+	// such as {"http2client": "0"} or {"default": "go1.21"}, with
+	// //go:debug directives in the generated main.go. Unlike the
+	// GODEBUG variable, they apply whenever the binary runs,
+	// though GODEBUG still overrides them. They require Go 1.21.
+	GoDebug map[string]string `json:"go_debug,omitempty"`
+
+	// Hardened builds a position independent executable with
+	// full RELRO, using the -bindnow linker flag of Go 1.22 or
+	// newer, and -trimpath; with cgo, the C code is compiled with
+	// -fstack-protector-strong and -D_FORTIFY_SOURCE=2. Measures
+	// that the target does not support are skipped, and
+	// BuildResult.Hardening reports which ones were applied.
+	// Static binaries are not position independent.
+	Hardened bool `json:"hardened,omitempty"`
+
+	// MemorySanitizer and AddressSanitizer build with -msan and
+	// -asan, like RaceDetector builds with -race, to find memory
+	// errors in C code that a plugin calls with cgo. At most one of
+	// the three may be set. Each requires cgo, which is enabled,
+	// and, when cross-compiling, a C compiler for the target from
+	// CC or Toolchains; the memory sanitizer requires clang.
+	MemorySanitizer  bool `json:"memory_sanitizer,omitempty"`
+	AddressSanitizer bool `json:"address_sanitizer,omitempty"`
+
+	// FIPS builds the binary with FIPS 140 validated crypto: the
+	// Go Cryptographic Module at GOFIPS140=v1.0.0, unless Env sets
+	// GOFIPS140, with Go 1.24 or newer, or the BoringCrypto
+	// experiment with older toolchains, which only support linux
+	// on amd64 and arm64, and require cgo. BuildResult.FIPS reports
+	// which one the binary uses.
+	FIPS bool `json:"fips,omitempty"`
+
+	// GoProxy, if set, is exported as GOPROXY to the go commands
+	// of this build only; the process environment is not changed.
+	GoProxy string `json:"go_proxy,omitempty"`
+
+	// GoProxies, if set instead of GoProxy, are the module proxies
+	// to fail over between, in order, such as proxy.golang.org,
+	// then goproxy.cn, then an internal Athens. They are probed
+	// before the build, each with its timeout, and those that do
+	// not answer are left out; the go commands fall back from one
+	// to the next on any error.
+	GoProxies []ModuleProxy `json:"go_proxies,omitempty"`
+
+	// GoNoProxy, GoPrivate, and GoNoSumDB, if set, are exported as
+	// GONOPROXY, GOPRIVATE, and GONOSUMDB in the same way. They are
+	// comma-separated lists of module path patterns that are fetched
+	// directly instead of through the proxy, are private (neither
+	// proxied nor checked against the checksum database), or are
+	// not checked against the checksum database, respectively.
+	GoNoProxy string `json:"go_no_proxy,omitempty"`
+	GoPrivate string `json:"go_private,omitempty"`
+	GoNoSumDB string `json:"go_no_sum_db,omitempty"`
+
+	// GoSumDB, if set, is exported as GOSUMDB: the checksum
+	// database to verify downloaded modules against, such as
+	// "sum.golang.org" or "sum.example.com+<key> https://sum.example.com",
+	// or "off" to verify none. GoNoSumDB exempts modules from it,
+	// as GONOSUMCHECK did for older toolchains.
+	GoSumDB string `json:"go_sum_db,omitempty"`
+
+	// GoInsecure, if set, is exported as GOINSECURE, the module
+	// path patterns that may be fetched over plain HTTP or from
+	// servers with an invalid certificate, as -insecure used to
+	// allow. They are still verified against the checksum database.
+	GoInsecure string `json:"go_insecure,omitempty"`
+
+	// VerifyChecksums fails the build with an UnverifiedModulesError,
+	// once its module graph is tidy, if any module was not verified
+	// against the checksum database: because GOSUMDB is off, because
+	// GONOSUMDB or GOPRIVATE match it, or because it is replaced by
+	// a local directory.
+	VerifyChecksums bool `json:"verify_checksums,omitempty"`
+
+	// GoFlags, if set, is exported as GOFLAGS to the go commands of
+	// this build, replacing any GOFLAGS of the process environment.
+	// Flags that the builder passes itself take precedence.
+	GoFlags string `json:"go_flags,omitempty"`
+
+	// GoModCache, if set, is the module cache directory of the build,
+	// exported as GOMODCACHE. The go command locks the module cache
+	// as needed, so any number of concurrent builds can share it.
+	GoModCache string `json:"go_mod_cache,omitempty"`
+
+	// GoVersion pins the Go toolchain used for the build, e.g.
+	// "1.22.1". If the go command on the PATH is a different
+	// version, the requested toolchain is downloaded into the
+	// module cache (through GOTOOLCHAIN) and used instead.
+	GoVersion string `json:"go_version,omitempty"`
+
+	// If OnProgress is set, the build is run with -v and the
+	// function is called with the fraction (0 to 1) of packages
+	// compiled so far, and with 1 once the build has succeeded.
+	// Packages in the build cache are not compiled, so the
+	// fraction may jump to 1.
+	OnProgress func(fraction float64) `json:"-"`
+
+	// If OnEvent is set, it is called with the progress events
+	// of the build, such as a ModuleFetched event for every
+	// module that is downloaded, in the order they happen.
+	OnEvent func(Event) `json:"-"`
+
+	// Report, if set, receives the report of every build as
+	// newline-delimited JSON, one ReportEvent per line: the phases
+	// as they start and finish, the warnings, and the results, so
+	// that tools can follow builds without parsing the log, which
+	// is written to Logger as usual.
+	Report io.Writer `json:"-"`
+
+	// DownloadMetrics runs the go commands that fetch modules with
+	// -x, which traces their requests to module proxies, and reports
+	// how long the downloads of each module took, their sizes, and
+	// how many modules were found in the module cache instead, in
+	// BuildResult.Downloads and the log. The traces are written to
+	// Stderr along with the rest of the output of the commands.
+	DownloadMetrics bool `json:"download_metrics,omitempty"`
+
+	// Instrumentation, if set, receives spans for the phases of
+	// every build and metrics such as the durations and failure
+	// reasons of builds; see the Span and Metric constants.
+	Instrumentation Instrumentation `json:"-"`
+
+	// LogCapture, if set, captures the log of every build, in a
+	// file or in BuildResult.Log, and can attach more of the end
+	// of the output of failed go commands to their errors.
+	LogCapture *LogCapture `json:"log_capture,omitempty"`
+
+	// CheckCompatibility enables a check, after dependencies are
+	// resolved and before compiling, that warns about modules that
+	// require a newer Caddy version than CaddyVersion. With
+	// StrictCompatibility, any such conflict fails the build.
+	CheckCompatibility  bool `json:"check_compatibility,omitempty"`
+	StrictCompatibility bool `json:"strict_compatibility,omitempty"`
+
+	// CheckPlugins enables a check, before compiling, that warns
+	// about plugins that would not add any modules, because their
+	// packages neither call RegisterModule nor blank-import one
+	// that does; they are usually libraries rather than plugins.
+	// With StrictPlugins, any such plugin fails the build.
+	CheckPlugins  bool `json:"check_plugins,omitempty"`
+	StrictPlugins bool `json:"strict_plugins,omitempty"`
+
+	// CheckConflicts enables a check, after tidying, that compares
+	// the module graph with the requested versions and reports
+	// modules that were downgraded, upgraded, or replaced, and
+	// requested modules that require different major versions
+	// of a module, as warnings and in BuildResult.Conflicts.
+	// With StrictConflicts, any conflict fails the build.
+	CheckConflicts  bool `json:"check_conflicts,omitempty"`
+	StrictConflicts bool `json:"strict_conflicts,omitempty"`
+
+	// Checkpoint, if set, is the path of a file that Build writes
+	// a Checkpoint to once the modules are resolved and checked, and
+	// removes once the binary is built, so that Resume can finish
+	// the build if it was interrupted, such as by a restart of the
+	// CI runner. Workspace builds cannot be resumed.
+	Checkpoint string `json:"checkpoint,omitempty"`
+
+	// Reproducible makes builds deterministic, such that two
+	// builds of the same configuration with the same Go
+	// toolchain (and, with cgo, the same C toolchain) produce
+	// byte-identical binaries. It builds with -trimpath and
+	// -buildvcs=false, clears the build ID, refuses to change
+	// the tidied module graph, and sets SOURCE_DATE_EPOCH to 0
+	// for any tool that consults it, unless already set.
+	Reproducible bool `json:"reproducible,omitempty"`
+
+	// SBOMFormat, if set, writes a Software Bill of Materials of
+	// the binary in the given format (SBOMFormatSPDX or
+	// SBOMFormatCycloneDX) to SBOMWriter or, if that is nil, to
+	// a file next to the binary, such as goaway.spdx.json.
+	SBOMFormat string    `json:"sbom_format,omitempty"`
+	SBOMWriter io.Writer `json:"-"`
+
+	// Provenance, if set, writes a SLSA provenance attestation
+	// of the binary, an in-toto statement, to a file next to it
+	// such as goaway.intoto.jsonl, or to Provenance.Writer.
+	Provenance *ProvenanceOptions `json:"provenance,omitempty"`
+
+	// SmokeTest, if set, runs the binary after the build to
+	// check that it works and registers the expected modules.
+	SmokeTest *SmokeTest `json:"smoke_test,omitempty"`
+
+	// Signers sign the binary and its SBOM and provenance files,
+	// if any, after the build, e.g. with CosignSigner, GPGSigner,
+	// or MinisignSigner. The files they write are listed in the
+	// BuildResult.
+	Signers []Signer `json:"-"`
+
+	// LicenseCheck, if set, takes an inventory of the licenses of
+	// the modules in the build before compiling, which is in
+	// BuildResult.Licenses, and fails the build if the policy
+	// denies any of them.
+	LicenseCheck *LicensePolicy `json:"license_check,omitempty"`
+
+	// VulnCheck, if set, runs govulncheck against the build
+	// environment before compiling, according to the policy.
+	VulnCheck *VulnPolicy `json:"vuln_check,omitempty"`
+
+	// Analysis, if set, runs go vet, and optionally staticcheck,
+	// over the plugin packages before compiling, according to the
+	// policy. The findings are in BuildResult.Findings.
+	Analysis *AnalysisPolicy `json:"analysis,omitempty"`
+
+	// WorkDir is the folder in which the temporary folders of
+	// build environments are created. Default: the temporary
+	// directory of the system (on macOS, the user cache directory)
+	WorkDir string `json:"work_dir,omitempty"`
+
+	// CleanupPolicy determines when the temporary folder of a
+	// build environment is removed; see the CleanupPolicy
+	// constants. CleanupMaxAge is the age after which the
+	// CleanupMaxAge policy removes folders. The default policy
+	// is CleanupAlways, or CleanupNever if SkipCleanup is set.
+	CleanupPolicy CleanupPolicy `json:"cleanup_policy,omitempty"`
+	CleanupMaxAge time.Duration `json:"cleanup_max_age,omitempty"`
+
+	// GracePeriod is how long a command of the build may take to
+	// exit after it was interrupted because the build was canceled,
+	// before it is killed along with its child processes.
+	// Default: 15s
+	GracePeriod time.Duration `json:"grace_period,omitempty"`
+
+	// Timeouts limit the phases of the build individually.
+	Timeouts Timeouts `json:"timeouts,omitempty"`
+
+	// FetchRetries is how many more times to run a go command that
+	// downloads modules, such as `go get` or `go mod tidy`, after it
+	// failed with what looks like a transient network or proxy error.
+	// The delay between attempts starts at FetchRetryBackoff
+	// (default: 1s) and doubles with every retry.
+	FetchRetries      int           `json:"fetch_retries,omitempty"`
+	FetchRetryBackoff time.Duration `json:"fetch_retry_backoff,omitempty"`
+
+	// FetchConcurrency, if greater than 1, fetches up to that many
+	// plugins at once before they are added to the go.mod of the
+	// build one at a time, which is then quick. Failures to fetch
+	// are reported together in a FetchError. TimeoutGet still
+	// limits all of them together, and Timeouts.GoGet each one.
+	// OnEvent may be called concurrently while fetching.
+	FetchConcurrency int `json:"fetch_concurrency,omitempty"`
+
+	// Vendor copies all dependencies into the vendor directory
+	// of the build environment with `go mod vendor` after tidying
+	// it, and compiles with -mod=vendor.
+	Vendor bool `json:"vendor,omitempty"`
+
+	// Offline prevents the go commands of the build from accessing
+	// the network, with GOPROXY=off and GOFLAGS=-mod=mod, so that
+	// the build fails as soon as a module is missing from the module
+	// cache rather than downloading it.
+	Offline bool `json:"offline,omitempty"`
+
+	// Env holds environment variables to set for the go commands
+	// of this build, and UnsetEnv names variables of the process
+	// environment to remove for them; neither changes the process
+	// environment. Variables in Env are set after those in UnsetEnv
+	// are removed. The other settings of the builder, such as GoProxy
+	// or the target platform, take precedence over Env.
+	Env      map[string]string `json:"env,omitempty"`
+	UnsetEnv []string          `json:"unset_env,omitempty"`
+
+	// SandboxEnv starts the environment of the go commands and
+	// hooks of the build from a minimal allowlist of the process
+	// environment instead of all of it: PATH, the home and temporary
+	// directories, and the system variables of Windows. Everything
+	// else, including GOPATH, GOFLAGS, and CC, must then be set with
+	// Env or the other settings of the builder, so that secrets of a
+	// build service cannot leak into the scripts of plugins.
+	SandboxEnv bool `json:"sandbox_env,omitempty"`
+
+	// Executor, if set, runs the commands of the build elsewhere
+	// than on the host, e.g. on a remote machine with SSHExecutor.
+	// Container, if set, runs them inside containers of an image
+	// and takes precedence over Executor.
+	Executor  Executor   `json:"-"`
+	Container *Container `json:"container,omitempty"`
+
+	// ArtifactCache, if set, caches the binaries built by Build,
+	// so that repeating a build copies the binary from the cache.
+	ArtifactCache *ArtifactCache `json:"artifact_cache,omitempty"`
+
+	// Prebuilt, if set, downloads the binary from a service that
+	// builds it on request instead of compiling it, if the service
+	// can produce the requested build. ForceLocalBuild compiles
+	// it locally even so.
+	Prebuilt        *PrebuiltSource `json:"prebuilt,omitempty"`
+	ForceLocalBuild bool            `json:"force_local_build,omitempty"`
+
+	// Credentials, if set, authenticate the build
+	// environment to private repositories.
+	Credentials *Credentials `json:"credentials,omitempty"`
+
+	// Hooks are commands to run at certain points of the build.
+	Hooks Hooks `json:"hooks,omitempty"`
+
+	// Generate runs `go generate` once the dependencies are
+	// fetched and before the module is tidied, for the packages
+	// built from source: those of the workspace modules and of
+	// local replacements. GeneratePackages, if set, limits it to
+	// the given packages, which must be in such modules, as the
+	// go command does not generate code in the module cache.
+	Generate         bool     `json:"generate,omitempty"`
+	GeneratePackages []string `json:"generate_packages,omitempty"`
+
+	// Logger receives the progress messages of the build.
+	// If nil, the standard logger of the log package is used.
+	Logger Logger `json:"-"`
+
+	// Stdout and Stderr receive the output of the go commands
+	// run during the build. If nil, the output goes to the
+	// standard output and standard error of the process.
+	Stdout io.Writer `json:"-"`
+	Stderr io.Writer `json:"-"`
+
+	// plan, if set, records the commands of the
+	// build instead of running them; see Plan.
+	plan *Plan
+}
+
+// Logger is used by the builder to report progress. Messages
+// are prefixed with their level, such as [INFO] or [WARNING].
+// A *log.Logger satisfies this interface; to discard all
+// messages, use log.New(io.Discard, "", 0).
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+// logger returns the logger configured for b.
+func (b Builder) logger() Logger {
+	if b.Logger != nil {
+		return b.Logger
+	}
+	return log.Default()
 }
 
 // Build builds Caddy at the configured version with the
 // configured plugins and plops down a binary at outputFile.
-func (b Builder) Build(ctx context.Context, outputFile string) error {
+// It returns a description of the binary, or nil if
+// SkipBuild is set.
+//
+// CaddyVersion may be a specific version, the keyword
+// "latest", or a constraint such as "~2.8", which is
+// resolved to the newest version satisfying it that the
+// module proxy lists; BuildResult reports the version used.
+func (b Builder) Build(ctx context.Context, outputFile string) (result *BuildResult, err error) {
+	start := time.Now()
 	var cancel context.CancelFunc
 	if b.TimeoutBuild > 0 {
 		ctx, cancel = context.WithTimeout(ctx, b.TimeoutBuild)
 		defer cancel()
 	}
-	if outputFile == "" {
-		return fmt.Errorf("output file path is required")
-	}
-	// the user's specified output file might be relative, and
-	// because the `go build` command is executed in a different,
-	// temporary folder, we convert the user's input to an
-	// absolute path so it goes the expected place
-	absOutputFile, err := filepath.Abs(outputFile)
+	b, report := b.startReport()
+	defer func() { report.finish([]*BuildResult{result}, err) }()
+	ctx, absOutputFile, endBuild, err := b.beginBuild(ctx, start, outputFile)
+	defer func() { endBuild(err) }()
 	if err != nil {
-		return err
+		return nil, err
 	}
-
-	// set some defaults from the environment, if applicable
-	if b.OS == "" {
-		b.OS = os.Getenv("GOOS")
+	if err := checkPlatform(ctx, b.Platform); err != nil {
+		return nil, err
 	}
-	if b.Arch == "" {
-		b.Arch = os.Getenv("GOARCH")
+	if err := prepareOutputFile(absOutputFile); err != nil {
+		return nil, err
 	}
-	if b.ARM == "" {
-		b.ARM = os.Getenv("GOARM")
+
+	if b.Prebuilt != nil {
+		result, err := b.buildPrebuilt(ctx, absOutputFile, start)
+		if err == nil && result != nil {
+			err = b.renameToVersion(result, outputFile)
+		}
+		if err != nil || result != nil {
+			return result, err
+		}
+	}
+
+	var cacheKey string
+	if b.ArtifactCache != nil {
+		key, reason, err := b.artifactKey(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if reason != "" {
+			b.logger().Printf("[INFO] Not using the artifact cache: %s", reason)
+		} else {
+			result, err := b.ArtifactCache.load(key, absOutputFile)
+			if err != nil {
+				b.logger().Printf("[WARNING] Reading the artifact cache: %v", err)
+			} else if result != nil {
+				b.logger().Printf("[INFO] Using cached binary: %s", absOutputFile)
+				b.instrumentation().Add(ctx, MetricArtifactCache, 1, Attribute{Key: "result", Value: "hit"})
+				result.Duration = time.Since(start)
+				return result, nil
+			}
+			b.instrumentation().Add(ctx, MetricArtifactCache, 1, Attribute{Key: "result", Value: "miss"})
+			cacheKey = key
+		}
 	}
 
 	// prepare the build environment
 	buildEnv, err := b.newEnvironment(ctx)
 	if err != nil {
+		return nil, err
+	}
+	defer func() { err = buildEnv.finish(ctx, err) }()
+
+	if b.SkipBuild {
+		b.logger().Printf("[INFO] Skipping build as requested")
+
+		return nil, nil
+	}
+	absOutputFile, err = b.finalOutputPath(ctx, buildEnv, outputFile, absOutputFile)
+	if err != nil {
+		return nil, err
+	}
+
+	b.logger().Printf("[INFO] Building Caddy")
+
+	if err := buildEnv.tidy(ctx); err != nil {
+		return nil, err
+	}
+
+	if err := b.checkEnvironment(ctx, buildEnv); err != nil {
+		return nil, err
+	}
+	if b.Checkpoint != "" {
+		if err := b.writeCheckpoint(buildEnv, absOutputFile); err != nil {
+			return nil, err
+		}
+	}
+
+	result, err = b.compile(ctx, buildEnv, absOutputFile, start)
+	if err != nil {
+		return nil, err
+	}
+	if b.Checkpoint != "" {
+		if err := os.Remove(b.Checkpoint); err != nil {
+			b.logger().Printf("[WARNING] Removing checkpoint: %v", err)
+		}
+	}
+	if cacheKey != "" {
+		if err := b.ArtifactCache.store(cacheKey, result); err != nil {
+			b.logger().Printf("[WARNING] Adding the binary to the artifact cache: %v", err)
+		}
+	}
+	return result, nil
+}
+
+// beginBuild runs the steps that precede the build environment of
+// every build of b: it sets the platform defaults, starts the build
+// span, selects the reachable GoProxies, and runs the preflight
+// checks. If outputFile is not empty, it returns the absolute path
+// of the binary, as outputPath does, whose volume the preflight
+// checks include. The returned function ends the span and must be
+// called with the error of the build, even if beginBuild fails.
+func (b *Builder) beginBuild(ctx context.Context, start time.Time, outputFile string) (_ context.Context, absOutputFile string, endBuild func(error), err error) {
+	b.setPlatformDefaults()
+	ctx, endBuild = b.startBuild(ctx, start)
+	if outputFile != "" {
+		absOutputFile, err = b.outputPath(outputFile)
+		if err != nil {
+			return ctx, "", endBuild, err
+		}
+	}
+	if err := b.selectProxies(ctx); err != nil {
+		return ctx, "", endBuild, err
+	}
+	if err := b.preflight(ctx, absOutputFile); err != nil {
+		return ctx, "", endBuild, err
+	}
+	return ctx, absOutputFile, endBuild, nil
+}
+
+// checkEnvironment runs the PostTidy hooks and then the
+// optional checks that gate compilation of a tidied build
+// environment.
+func (b Builder) checkEnvironment(ctx context.Context, buildEnv *environment) error {
+	if err := buildEnv.runHooks(ctx, "post-tidy", b.Hooks.PostTidy, nil); err != nil {
 		return err
 	}
-	defer buildEnv.Close()
+	if err := buildEnv.checkPins(ctx); err != nil {
+		return err
+	}
+	if b.VerifyChecksums {
+		if err := buildEnv.verifyChecksums(ctx); err != nil {
+			return err
+		}
+	}
+	if b.CheckCompatibility || b.StrictCompatibility {
+		if err := buildEnv.enforceCompatibility(ctx, b.StrictCompatibility); err != nil {
+			return err
+		}
+	}
+	if b.CheckConflicts || b.StrictConflicts {
+		if err := buildEnv.enforceConflicts(ctx, b.Plugins, b.StrictConflicts); err != nil {
+			return err
+		}
+	}
+	if b.CheckPlugins || b.StrictPlugins {
+		if err := buildEnv.enforcePlugins(ctx, b.Plugins, b.BuildTags, b.StrictPlugins); err != nil {
+			return err
+		}
+	}
+	if b.LicenseCheck != nil {
+		if err := buildEnv.checkLicenses(ctx, *b.LicenseCheck, b.BuildTags); err != nil {
+			return err
+		}
+	}
+	if b.VulnCheck != nil {
+		if err := buildEnv.checkVulnerabilities(ctx, *b.VulnCheck, b.BuildTags); err != nil {
+			return err
+		}
+	}
+	if b.Analysis != nil {
+		if err := buildEnv.analyze(ctx, *b.Analysis, b.Plugins, b.BuildTags); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// BuildWithModule is like Build, except that instead of resolving
+// dependencies it builds exactly the module described by goMod and
+// goSum. The provided files must already be tidy; if `go mod tidy`
+// would change either of them, an error is returned rather than
+// building against silently rewritten files.
+func (b Builder) BuildWithModule(ctx context.Context, goMod, goSum []byte, outputFile string) (result *BuildResult, err error) {
+	start := time.Now()
+	var cancel context.CancelFunc
+	if b.TimeoutBuild > 0 {
+		ctx, cancel = context.WithTimeout(ctx, b.TimeoutBuild)
+		defer cancel()
+	}
+	b, report := b.startReport()
+	defer func() { report.finish([]*BuildResult{result}, err) }()
+	ctx, absOutputFile, endBuild, err := b.beginBuild(ctx, start, outputFile)
+	defer func() { endBuild(err) }()
+	if err != nil {
+		return nil, err
+	}
+	if err := checkPlatform(ctx, b.Platform); err != nil {
+		return nil, err
+	}
+	if err := prepareOutputFile(absOutputFile); err != nil {
+		return nil, err
+	}
+
+	buildEnv, err := b.newModuleEnvironment(ctx, goMod, goSum)
+	if err != nil {
+		return nil, wrapError(err, func(err error) error { return &SetupError{Err: err} })
+	}
+	defer func() { err = buildEnv.finish(ctx, err) }()
 
 	if b.SkipBuild {
-		log.Printf("[INFO] Skipping build as requested")
+		b.logger().Printf("[INFO] Skipping build as requested")
+
+		return nil, nil
+	}
+	absOutputFile, err = b.finalOutputPath(ctx, buildEnv, outputFile, absOutputFile)
+	if err != nil {
+		return nil, err
+	}
+
+	b.logger().Printf("[INFO] Building Caddy from the provided module")
+
+	if err := buildEnv.verifyTidy(ctx, goMod, goSum); err != nil {
+		return nil, err
+	}
+	if err := buildEnv.runHooks(ctx, "post-tidy", b.Hooks.PostTidy, nil); err != nil {
+		return nil, err
+	}
+
+	return b.compile(ctx, buildEnv, absOutputFile, start)
+}
+
+// absOutputPath validates the user's output file and makes it
+// absolute. The user's specified output file might be relative,
+// and because the `go build` command is executed in a different,
+// temporary folder, we convert the user's input to an absolute
+// path so it goes the expected place.
+func absOutputPath(outputFile string) (string, error) {
+	if outputFile == "" {
+		return "", fmt.Errorf("output file path is required")
+	}
+	return filepath.Abs(outputFile)
+}
+
+// OutputFile returns the absolute path of the binary that Build
+// produces for outputFile; for Windows targets, this includes the
+// ".exe" suffix, and for WebAssembly the ".wasm" suffix, unless
+// SkipExeSuffix is set.
+func (b Builder) OutputFile(outputFile string) (string, error) {
+	b.setPlatformDefaults()
+	return b.outputPath(outputFile)
+}
+
+// outputPath is like absOutputPath, but names the binary by
+// OutputTemplate if outputFile is a directory, and appends the
+// ".exe" or ".wasm" suffix if the target platform requires it.
+func (b Builder) outputPath(outputFile string) (string, error) {
+	return b.outputPathAt(outputFile, b.CaddyVersion)
+}
 
-		return nil
+// outputPathAt is like outputPath, but names binaries in
+// directories after version of the base module.
+func (b Builder) outputPathAt(outputFile, version string) (string, error) {
+	absOutputFile, err := absOutputPath(outputFile)
+	if err != nil {
+		return "", err
+	}
+	if isOutputDir(outputFile, absOutputFile) {
+		name, err := b.outputName(version)
+		if err != nil {
+			return "", err
+		}
+		absOutputFile = filepath.Join(absOutputFile, name)
 	}
+	if b.targetOS() == "windows" && !b.SkipExeSuffix &&
+		!strings.HasSuffix(strings.ToLower(absOutputFile), ".exe") {
+		absOutputFile += ".exe"
+	}
+	if b.isWasm() && !b.SkipExeSuffix &&
+		!strings.HasSuffix(strings.ToLower(absOutputFile), ".wasm") {
+		absOutputFile += ".wasm"
+	}
+	return absOutputFile, nil
+}
 
+// prepareOutputFile makes sure the go command will be able to write
+// absOutputFile, creating its parent directories if necessary, so that
+// problems are reported clearly before any work is done.
+func prepareOutputFile(absOutputFile string) error {
+	if info, err := os.Stat(absOutputFile); err == nil && info.IsDir() {
+		return fmt.Errorf("output path %s is a directory; specify a file name", absOutputFile)
+	}
+	dir := filepath.Dir(absOutputFile)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("creating output directory: %v", err)
+	}
+	probe, err := os.CreateTemp(dir, ".goaway-write-check")
+	if err != nil {
+		return fmt.Errorf("output directory %s is not writable: %v", dir, err)
+	}
+	probe.Close()
+	return os.Remove(probe.Name())
+}
+
+// targetOS returns the OS being built for,
+// falling back to that of the host.
+func (b Builder) targetOS() string {
+	if b.OS != "" {
+		return b.OS
+	}
+	return runtime.GOOS
+}
+
+// setPlatformDefaults sets some defaults from the
+// environment, if applicable.
+func (b *Builder) setPlatformDefaults() {
+	if b.OS == "" {
+		b.OS = os.Getenv("GOOS")
+	}
+	if b.Arch == "" {
+		b.Arch = os.Getenv("GOARCH")
+	}
+	if b.ARM == "" {
+		b.ARM = os.Getenv("GOARM")
+	}
+	for _, v := range []struct {
+		field *string
+		key   string
+	}{
+		{&b.AMD64, "GOAMD64"},
+		{&b.ARM64, "GOARM64"},
+		{&b.I386, "GO386"},
+		{&b.MIPS, "GOMIPS"},
+		{&b.MIPS64, "GOMIPS64"},
+	} {
+		if *v.field == "" {
+			*v.field = os.Getenv(v.key)
+		}
+	}
+}
+
+// compile runs `go build` in buildEnv, writing the binary to
+// absOutputFile, and describes the result of the build that
+// began at start.
+func (b Builder) compile(ctx context.Context, buildEnv *environment, absOutputFile string, start time.Time) (*BuildResult, error) {
 	// prepare the environment for the go command; for
 	// the most part we want it to inherit our current
 	// environment, with a few customizations
-	env := os.Environ()
+	env := buildEnv.environ()
 	env = setEnv(env, "GOOS="+b.OS)
 	env = setEnv(env, "GOARCH="+b.Arch)
 	env = setEnv(env, "GOARM="+b.ARM)
-	if b.RaceDetector && !b.Compile.Cgo {
-		log.Println("[WARNING] Enabling cgo because it is required by the race detector")
-		b.Compile.Cgo = true
+	for _, kv := range b.Platform.microArchEnv() {
+		env = setEnv(env, kv)
+	}
+	if err := checkBuildMode(b.BuildMode); err != nil {
+		return nil, err
+	}
+	if problems := append(b.checkBuildModeOptions(), b.checkWasmOptions()...); len(problems) > 0 {
+		return nil, &ValidationError{Problems: problems}
+	}
+	if b.isWasm() {
+		b.wasmSettings()
+	}
+	// BoringCrypto, which requires cgo, depends on the toolchain
+	var goVersion string
+	if b.FIPS {
+		var err error
+		if goVersion, err = buildEnv.goVersion(ctx); err != nil {
+			return nil, err
+		}
+	}
+	b.enableRequiredCgo(goVersion)
+	env, err := b.sanitizerSettings(buildEnv, env)
+	if err != nil {
+		return nil, err
+	}
+	if b.FIPS {
+		if env, err = b.fipsSettings(goVersion, env); err != nil {
+			return nil, err
+		}
+	}
+	var hardenedArgs, ldflags []string
+	var hardening []HardeningMeasure
+	if b.Hardened {
+		if hardenedArgs, ldflags, hardening, err = b.hardenedSettings(ctx, buildEnv); err != nil {
+			return nil, err
+		}
+	}
+	env = b.cgoEnv(env)
+	tags := append([]string(nil), b.BuildTags...)
+	if b.Static {
+		var staticTags, staticLDFlags []string
+		env, staticTags, staticLDFlags = b.staticSettings(env)
+		tags = append(tags, staticTags...)
+		ldflags = append(ldflags, staticLDFlags...)
 	}
-	env = setEnv(env, fmt.Sprintf("CGO_ENABLED=%s", b.Compile.CgoEnabled()))
 
-	log.Println("[INFO] Building Caddy")
+	if err := checkGoExperiment(b.GoExperiment); err != nil {
+		return nil, err
+	}
+	if b.GoExperiment != "" {
+		b.logger().Printf("[INFO] Using GOEXPERIMENT=%s", b.GoExperiment)
+	}
 
-	// tidy the module to ensure go.mod and go.sum are consistent with the module prereq
-	tidyCmd := buildEnv.newGoModCommand(ctx, "tidy", "-e")
-	if err := buildEnv.runCommand(ctx, tidyCmd); err != nil {
-		return err
+	if err := checkSBOMFormat(b.SBOMFormat); err != nil {
+		return nil, err
+	}
+	if b.Compress != nil {
+		if err := b.Compress.check(); err != nil {
+			return nil, err
+		}
+	}
+	targetOS := b.OS
+	if targetOS == "" {
+		targetOS = runtime.GOOS
+	}
+	macOSSign := b.MacOSSign != nil && targetOS == "darwin"
+	if macOSSign {
+		if err := b.MacOSSign.check(buildEnv.executor != nil); err != nil {
+			return nil, err
+		}
 	}
 
 	// compile
@@ -98,19 +1009,243 @@ func (b Builder) Build(ctx context.Context, outputFile string) error {
 		cmd.Args = append(cmd.Args, "-gcflags", "all=-N -l")
 	}
 
-	if b.RaceDetector {
-		cmd.Args = append(cmd.Args, "-race")
+	cmd.Args = append(cmd.Args, b.sanitizerFlags()...)
+	if b.BuildMode != "" {
+		cmd.Args = append(cmd.Args, "-buildmode="+string(b.BuildMode))
+	}
+	cmd.Args = append(cmd.Args, hardenedArgs...)
+
+	xflags, err := linkerVariables(b.LDFlagsX)
+	if err != nil {
+		return nil, err
+	}
+	ldflags = append(ldflags, xflags...)
+	if b.StripSymbols {
+		ldflags = append(ldflags, "-s", "-w")
+	}
+	if b.Reproducible {
+		// strip everything that differs between otherwise
+		// identical builds: file system paths, VCS status,
+		// the build ID, and any change to the module graph
+		cmd.Args = append(cmd.Args, "-trimpath", "-buildvcs=false")
+		if !b.Vendor {
+			cmd.Args = append(cmd.Args, "-mod=readonly")
+		}
+		ldflags = append(ldflags, "-buildid=")
+		if _, ok := getEnv(env, "SOURCE_DATE_EPOCH"); !ok {
+			env = setEnv(env, "SOURCE_DATE_EPOCH=0")
+		}
+	}
+	if b.Vendor {
+		cmd.Args = append(cmd.Args, "-mod=vendor")
+	}
+	if b.Coverage {
+		cmd.Args = append(cmd.Args, "-cover")
+		if coverPkgs := b.coverPackages(); len(coverPkgs) > 0 {
+			cmd.Args = append(cmd.Args, "-coverpkg="+strings.Join(coverPkgs, ","))
+		}
+	}
+	if b.PGOProfile != "" {
+		profile, err := buildEnv.copyPGOProfile(b.PGOProfile)
+		if err != nil {
+			return nil, err
+		}
+		cmd.Args = append(cmd.Args, "-pgo="+profile)
+	}
+	cmd.Args = mergeLDFlags(cmd.Args, ldflags...)
+	cmd.Args = mergeTags(cmd.Args, tags...)
+	if b.Debug {
+		var trimmed bool
+		if cmd.Args, trimmed = removeBoolFlag(cmd.Args, "trimpath"); trimmed {
+			b.logger().Printf("[WARNING] Building without -trimpath, which would hide the sources from debuggers")
+		}
 	}
 	cmd.Env = env
-	cmd.Args = append(cmd.Args, "-o", absOutputFile)
-	err = buildEnv.runCommand(ctx, cmd)
+	if b.OnProgress != nil {
+		packages, err := buildEnv.listPackages(ctx, env, tags)
+		if err != nil {
+			return nil, err
+		}
+		cmd.Args = append(cmd.Args, "-v")
+		cmd.Stderr = &progressWriter{w: cmd.Stderr, packages: packages, report: b.OnProgress}
+	}
+	// executors only share the build environment with the host
+	buildOutput := absOutputFile
+	if buildEnv.executor != nil {
+		buildOutput = filepath.Join(buildEnv.tempFolder, executorOutputFolder, filepath.Base(absOutputFile))
+		if err := os.MkdirAll(filepath.Dir(buildOutput), 0755); err != nil {
+			return nil, err
+		}
+	}
+	cmd.Args = append(cmd.Args, "-o", buildOutput)
+	if b.WindowsResources != nil && targetOS == "windows" {
+		arch := b.Arch
+		if arch == "" {
+			arch = runtime.GOARCH
+		}
+		syso, err := writeWindowsResources(*b.WindowsResources, buildEnv.tempFolder, arch, buildEnv.caddyVersion)
+		if err != nil {
+			return nil, fmt.Errorf("windows resources: %v", err)
+		}
+		defer os.Remove(syso)
+	}
+	if err := buildEnv.runHooks(ctx, "pre-build", b.Hooks.PreBuild, env); err != nil {
+		return nil, err
+	}
+	compileCtx, cancel := withPhaseTimeout(ctx, buildEnv.timeouts.Compile)
+	defer cancel()
+	buildEnv.emit(CompileStarted{})
+	compileStart := time.Now()
+	compileCtx, span := buildEnv.instrumentation().StartSpan(compileCtx, SpanCompile)
+	stderr, err := buildEnv.runCommandTail(compileCtx, cmd)
+	err = phaseError(ctx, compileCtx, "compile", buildEnv.timeouts.Compile, err)
+	span.End(err)
+	buildEnv.emit(CompileFinished{Duration: time.Since(compileStart), Err: err})
 	if err != nil {
-		return err
+		return nil, wrapError(err, func(err error) error {
+			return &CompileError{ExitCode: exitCode(err), Stderr: stderr, Err: err}
+		})
+	}
+	if b.OnProgress != nil {
+		b.OnProgress(1)
+	}
+	// the symbols of a compressed binary cannot be read, so
+	// the sizes for MaxBinarySize are taken before compressing
+	var sizes *SizeReport
+	if (b.SizeBreakdown || b.MaxBinarySize > 0 && b.Compress != nil) && buildEnv.plan == nil {
+		sizes, err = buildEnv.sizeReport(ctx, buildOutput)
+		if err != nil {
+			if b.SizeBreakdown {
+				return nil, fmt.Errorf("breaking down the size of the binary: %v", err)
+			}
+			b.logger().Printf("[WARNING] Reading the sizes of modules: %v", err)
+		}
+	}
+	if b.Compress != nil {
+		if err := buildEnv.compressBinary(ctx, *b.Compress, buildOutput); err != nil {
+			return nil, err
+		}
+	}
+	if b.MaxBinarySize > 0 && buildEnv.plan == nil {
+		if err := buildEnv.checkBinarySize(ctx, buildOutput, b.MaxBinarySize, b.WarnBinarySize, sizes, b.Compress != nil); err != nil {
+			return nil, err
+		}
+	}
+	postBuildEnv := setEnv(append([]string(nil), env...), "GOAWAY_BUILDER_OUTPUT="+buildOutput)
+	if err := buildEnv.runHooks(ctx, "post-build", b.Hooks.PostBuild, postBuildEnv); err != nil {
+		return nil, err
+	}
+	if macOSSign {
+		if err := buildEnv.signMacOSBinary(ctx, *b.MacOSSign, buildOutput); err != nil {
+			return nil, err
+		}
+	}
+	if buildEnv.plan != nil {
+		// there is no binary to describe
+		return nil, nil
+	}
+	if buildOutput != absOutputFile {
+		if err := copyFile(buildOutput, absOutputFile, 0755); err != nil {
+			return nil, err
+		}
+		header := b.BuildMode.headerFile(buildOutput)
+		if _, err := os.Stat(header); header != "" && err == nil {
+			if err := copyFile(header, b.BuildMode.headerFile(absOutputFile), 0644); err != nil {
+				return nil, err
+			}
+		}
 	}
 
-	log.Printf("[INFO] Build complete: %s", outputFile)
+	b.logger().Printf("[INFO] Build complete: %s", absOutputFile)
 
-	return nil
+	result, err := b.newBuildResult(absOutputFile, start)
+	if err != nil {
+		return nil, err
+	}
+	result.CaddyVersion = buildEnv.caddyVersion
+	result.Resolved = buildEnv.resolved
+	result.Conflicts = buildEnv.conflicts
+	result.Licenses = buildEnv.licenses
+	result.Findings = buildEnv.findings
+	result.Hardening = hardening
+	if b.SizeBreakdown {
+		result.Sizes = sizes
+	}
+	if b.Debug {
+		buildEnv.checkDWARF(absOutputFile)
+		if b.DebugDir != "" {
+			result.DebugDir, result.DebugConfigFile, err = buildEnv.copyDebugSources(b.DebugDir, absOutputFile)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	if b.WasmExec {
+		result.WasmExecFile, err = buildEnv.copyWasmExec(ctx, absOutputFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if header := b.BuildMode.headerFile(absOutputFile); header != "" {
+		if _, err := os.Stat(header); err == nil {
+			result.HeaderFile = header
+		}
+	}
+	if b.LogCapture != nil {
+		if b.LogCapture.File != "" {
+			result.LogFile, err = filepath.Abs(b.LogCapture.File)
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			result.Log = buildEnv.capture.String()
+		}
+	}
+	if buildEnv.downloads != nil {
+		result.Downloads, err = buildEnv.downloadReport(ctx)
+		if err != nil {
+			return nil, err
+		}
+		b.logger().Printf("[INFO] Downloads: %s", result.Downloads.summary())
+	}
+	result.Verified, err = b.verifyBinary(buildEnv, result)
+	if err != nil {
+		return nil, err
+	}
+	if b.SmokeTest != nil {
+		if err := b.smokeTest(ctx, *b.SmokeTest, buildEnv, buildOutput); err != nil {
+			return nil, err
+		}
+	}
+	if b.SBOMFormat != "" {
+		result.SBOMFile, err = b.writeSBOM(result)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if b.Provenance != nil {
+		result.ProvenanceFile, err = b.writeProvenance(*b.Provenance, result, start)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := b.sign(ctx, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// coverPackages returns the package patterns to instrument
+// for coverage, defaulting to all packages of each plugin.
+func (b Builder) coverPackages() []string {
+	if len(b.CoverPackages) > 0 {
+		return b.CoverPackages
+	}
+	pkgs := make([]string, 0, len(b.Plugins))
+	for _, p := range b.Plugins {
+		pkgs = append(pkgs, p.PackagePath+"/...")
+	}
+	return pkgs
 }
 
 // setEnv sets an environment variable-value pair in
@@ -130,6 +1265,17 @@ func setEnv(env []string, set string) []string {
 	return append(env, set)
 }
 
+// unsetEnv removes the environment variable key from env.
+func unsetEnv(env []string, key string) []string {
+	kept := env[:0]
+	for _, kv := range env {
+		if !strings.HasPrefix(kv, key+"=") {
+			kept = append(kept, kv)
+		}
+	}
+	return kept
+}
+
 // Dependency pairs a Go module path with a version.
 type Dependency struct {
 	// The name (import path) of the Go package. If at a version > 1,
@@ -137,7 +1283,9 @@ type Dependency struct {
 	// Used with `go get`.
 	PackagePath string `json:"module_path,omitempty"`
 
-	// The version of the Go module, as used with `go get`.
+	// The version of the Go module, as used with `go get`. Branch
+	// names and commit hashes are resolved to pseudo-versions;
+	// BuildResult.Resolved reports what they were resolved to.
 	Version string `json:"version,omitempty"`
 }
 
@@ -147,18 +1295,58 @@ type ReplacementPath string
 
 // Param reformats a go.mod replace directive to be
 // compatible with the `go mod edit` command.
+//
+// Deprecated: go.mod is edited with golang.org/x/mod/modfile
+// rather than with `go mod edit`; use ModulePath and Version.
 func (r ReplacementPath) Param() string {
-	return strings.Replace(string(r), " ", "@", 1)
+	return pathAtVersion(r.split())
 }
 
 func (r ReplacementPath) String() string { return string(r) }
 
+// ModulePath returns the module path of r without any version,
+// or the directory of r if it is local.
+func (r ReplacementPath) ModulePath() string {
+	path, _ := r.split()
+	return path
+}
+
+// Version returns the version of r, which is empty if r is
+// local or a module path without a version.
+func (r ReplacementPath) Version() string {
+	_, version := r.split()
+	return version
+}
+
+// split splits r into its module path and the version given after
+// a space or an "@". Local directories have no version, even if
+// their paths contain spaces or an "@".
+func (r ReplacementPath) split() (path, version string) {
+	if r.IsLocal() {
+		return string(r), ""
+	}
+	path, version, _ = strings.Cut(strings.Replace(string(r), "@", " ", 1), " ")
+	return path, strings.TrimSpace(version)
+}
+
+// IsLocal reports whether r refers to a directory on the
+// local filesystem rather than to a module path.
+func (r ReplacementPath) IsLocal() bool {
+	s := string(r)
+	return s == "." || s == ".." ||
+		strings.HasPrefix(s, "./") || strings.HasPrefix(s, "../") ||
+		strings.HasPrefix(s, ".\\") || strings.HasPrefix(s, "..\\") ||
+		filepath.IsAbs(s)
+}
+
 // Replace represents a Go module replacement.
 type Replace struct {
 	// The import path of the module being replaced.
 	Old ReplacementPath `json:"old,omitempty"`
 
-	// The path to the replacement module.
+	// The path to the replacement module. Its version, given
+	// after a space or an "@", may also be a branch name or a
+	// commit hash, which is resolved to a pseudo-version.
 	New ReplacementPath `json:"new,omitempty"`
 }
 
@@ -171,9 +1359,44 @@ func NewReplace(old, new string) Replace {
 	}
 }
 
-// newTempFolder creates a new folder in a temporary location.
-// It is the caller's responsibility to remove the folder when finished.
-func newTempFolder() (string, error) {
+// NewForkReplace returns a replacement of the module original by
+// its fork at the repository path forkRepo, such as
+// github.com/user/caddy, at ref, which may be a branch, a tag, or a
+// commit hash. The major version suffix of original, such as "/v2",
+// is added to forkRepo if it lacks one, as the go command requires.
+func NewForkReplace(original, forkRepo, ref string) Replace {
+	fork := strings.TrimSuffix(forkRepo, "/")
+	if _, pathMajor, ok := module.SplitPathVersion(original); ok && strings.HasPrefix(pathMajor, "/") {
+		if _, forkMajor, ok := module.SplitPathVersion(fork); ok && forkMajor == "" {
+			fork += pathMajor
+		}
+	}
+	return NewReplace(original, fork+"@"+ref)
+}
+
+// newTempFolder creates a new folder in workDir or, if that
+// is empty, in a temporary location. It is the caller's
+// responsibility to remove the folder when finished.
+func newTempFolder(workDir string) (string, error) {
+	parentDir, err := tempParentDir(workDir)
+	if err != nil {
+		return "", err
+	}
+	ts := time.Now().Format(yearMonthDayHourMin)
+	return os.MkdirTemp(parentDir, fmt.Sprintf("%s%s.", tempFolderPrefix, ts))
+}
+
+// tempParentDir returns the folder in which temporary folders
+// are created, creating it if necessary. It is workDir, if set;
+// otherwise the empty string stands for the default temporary
+// directory, except on macOS.
+func tempParentDir(workDir string) (string, error) {
+	if workDir != "" {
+		if err := os.MkdirAll(workDir, 0755); err != nil {
+			return "", err
+		}
+		return workDir, nil
+	}
 	var parentDir string
 	if runtime.GOOS == "darwin" {
 		// After upgrading to macOS High Sierra, Caddy builds mysteriously
@@ -193,16 +1416,20 @@ func newTempFolder() (string, error) {
 		// I guess it doesn't matter too much.
 		// See: https://github.com/caddyserver/caddy/issues/2036
 		// and https://twitter.com/mholt6/status/978345803365273600 (thread)
-		// (using an absolute path prevents problems later when removing this
-		// folder if the CWD changes)
-		var err error
-		parentDir, err = filepath.Abs(".")
+		// We use the user's cache directory rather than the current
+		// working directory so that the location does not depend on
+		// process-wide state, which may change while builds run
+		// concurrently.
+		cacheDir, err := os.UserCacheDir()
 		if err != nil {
 			return "", err
 		}
+		parentDir = filepath.Join(cacheDir, "goaway-builder")
+		if err := os.MkdirAll(parentDir, 0755); err != nil {
+			return "", err
+		}
 	}
-	ts := time.Now().Format(yearMonthDayHourMin)
-	return os.MkdirTemp(parentDir, fmt.Sprintf("buildenv_%s.", ts))
+	return parentDir, nil
 }
 
 const (
@@ -210,5 +1437,26 @@ const (
 	// used for temporary folder paths.
 	yearMonthDayHourMin = "2006-01-02-1504"
 
-	defaultCaddyModulePath = "github.com/caddyserver/caddy"
+	// defaultGracePeriod is the default of GracePeriod.
+	defaultGracePeriod = 15 * time.Second
+
+	// tempFolderPrefix starts the name of every temporary folder.
+	tempFolderPrefix = "buildenv_"
+
+	// defaultBaseModule is the module built at CaddyVersion,
+	// and defaultMainPackage its package that provides Main.
+	defaultBaseModule  = "github.com/crackeer/goaway"
+	defaultMainPackage = "github.com/crackeer/goaway/server"
+
+	// defaultMainModulePath is the module path
+	// of the generated main module.
+	defaultMainModulePath = "goaway"
+
+	// defaultBinaryName is the base name of binaries
+	// whose file name is derived from their target.
+	defaultBinaryName = "goaway"
+
+	// placeholderVersion is required for modules that are replaced
+	// by a local directory and therefore have no real version.
+	placeholderVersion = "v0.0.0-00010101000000-000000000000"
 )
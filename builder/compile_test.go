@@ -0,0 +1,244 @@
+package builder
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// TestConcurrentBuilds runs builds of one Builder value at once,
+// which is most useful with -race.
+func TestConcurrentBuilds(t *testing.T) {
+	const n = 4
+	b := testBuilder(t, testBaseModule(t))
+	var mu sync.Mutex
+	var folders []string
+	b.OnEvent = func(e Event) {
+		if created, ok := e.(EnvCreated); ok {
+			mu.Lock()
+			folders = append(folders, created.Dir)
+			mu.Unlock()
+		}
+	}
+
+	outputDir := t.TempDir()
+	results := make([]*BuildResult, n)
+	errs := make([]error, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			output := filepath.Join(outputDir, fmt.Sprintf("goaway-%d", i))
+			results[i], errs[i] = b.Build(context.Background(), output)
+		}(i)
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			t.Fatalf("build %d: %v", i, err)
+		}
+	}
+	seen := make(map[string]bool)
+	for i, result := range results {
+		want := filepath.Join(outputDir, fmt.Sprintf("goaway-%d", i))
+		if result.OutputFile != want {
+			t.Errorf("build %d wrote %s, want %s", i, result.OutputFile, want)
+		}
+		if seen[result.OutputFile] {
+			t.Errorf("builds share the output file %s", result.OutputFile)
+		}
+		seen[result.OutputFile] = true
+		// a binary written by another build would not match
+		data, err := os.ReadFile(want)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sum := sha256.Sum256(data); hex.EncodeToString(sum[:]) != result.SHA256 {
+			t.Errorf("build %d: %s does not have the checksum of its result", i, want)
+		}
+	}
+
+	if len(folders) != n {
+		t.Fatalf("%d build environments were created, want %d: %v", len(folders), n, folders)
+	}
+	unique := make(map[string]bool)
+	for _, dir := range folders {
+		if unique[dir] {
+			t.Errorf("builds share the build environment %s", dir)
+		}
+		unique[dir] = true
+		if !filepath.IsAbs(dir) {
+			t.Errorf("build environment %s is not an absolute path", dir)
+		}
+		if _, err := os.Stat(dir); !os.IsNotExist(err) {
+			t.Errorf("build environment %s was not removed", dir)
+		}
+	}
+	if left := buildEnvFolders(t, b.WorkDir); len(left) > 0 {
+		t.Errorf("build environments remain: %v", left)
+	}
+}
+
+func TestOutputPath(t *testing.T) {
+	dir := t.TempDir()
+	existing := filepath.Join(dir, "dist")
+	if err := os.Mkdir(existing, 0755); err != nil {
+		t.Fatal(err)
+	}
+	linux := Platform{OS: "linux", Arch: "amd64"}
+	for _, tt := range []struct {
+		name     string
+		platform Platform
+		output   string
+		want     string
+	}{
+		{"file", linux, filepath.Join(dir, "goaway"), filepath.Join(dir, "goaway")},
+		{"existing directory", linux, existing, filepath.Join(existing, "goaway_linux_amd64")},
+		{"trailing separator", linux, filepath.Join(dir, "new") + string(filepath.Separator),
+			filepath.Join(dir, "new", "goaway_linux_amd64")},
+		{"windows file", Platform{OS: "windows", Arch: "amd64"}, filepath.Join(dir, "goaway"), filepath.Join(dir, "goaway.exe")},
+		{"windows directory", Platform{OS: "windows", Arch: "amd64"}, existing, filepath.Join(existing, "goaway_windows_amd64.exe")},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			b := Builder{Compile: Compile{Platform: tt.platform}}
+			got, err := b.outputPath(tt.output)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("outputPath(%q) = %s, want %s", tt.output, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPrepareOutputFile(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "dir"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "file"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		name    string
+		output  string
+		wantErr string
+	}{
+		{"new file", "goaway", ""},
+		{"existing file", "file", ""},
+		{"existing directory", "dir", "is a directory; specify a file name"},
+		{"missing parent", "dist/linux/goaway", ""},
+		{"parent is a file", "file/goaway", "creating output directory"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			output := filepath.Join(dir, filepath.FromSlash(tt.output))
+			err := prepareOutputFile(output)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("prepareOutputFile(%s) = %v, want an error containing %q", tt.output, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("prepareOutputFile(%s): %v", tt.output, err)
+			}
+			if info, err := os.Stat(filepath.Dir(output)); err != nil || !info.IsDir() {
+				t.Errorf("parent of %s is not a directory: %v", tt.output, err)
+			}
+			// the check leaves nothing behind
+			probes, _ := filepath.Glob(filepath.Join(filepath.Dir(output), ".goaway-write-check*"))
+			if len(probes) > 0 {
+				t.Errorf("write checks were left behind: %v", probes)
+			}
+		})
+	}
+}
+
+// reasonRecorder is an Instrumentation that records
+// the failure reasons of the builds it counts.
+type reasonRecorder struct {
+	mu      sync.Mutex
+	reasons []string
+}
+
+func (r *reasonRecorder) StartSpan(ctx context.Context, name string, attrs ...Attribute) (context.Context, Span) {
+	return ctx, noopSpan{}
+}
+
+func (r *reasonRecorder) Add(ctx context.Context, counter string, n int64, attrs ...Attribute) {
+	if counter != MetricBuilds {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, attr := range attrs {
+		if attr.Key == "reason" {
+			r.reasons = append(r.reasons, attr.Value)
+		}
+	}
+}
+
+func (r *reasonRecorder) Record(ctx context.Context, histogram string, value float64, attrs ...Attribute) {
+}
+
+// TestEntryPointsRunPreflight checks that every way to
+// prepare a build environment runs the preflight checks and
+// records the build, rather than only Build.
+func TestEntryPointsRunPreflight(t *testing.T) {
+	ctx := context.Background()
+	for _, tt := range []struct {
+		name string
+		run  func(b Builder) error
+	}{
+		{"Build", func(b Builder) error {
+			_, err := b.Build(ctx, filepath.Join(t.TempDir(), "goaway"))
+			return err
+		}},
+		{"BuildAll", func(b Builder) error {
+			_, err := b.BuildAll(ctx, []Platform{{OS: "linux", Arch: "amd64"}}, t.TempDir())
+			return err
+		}},
+		{"PrepareEnvironment", func(b Builder) error {
+			env, err := b.PrepareEnvironment(ctx)
+			if err == nil {
+				env.Close()
+			}
+			return err
+		}},
+		{"Resolve", func(b Builder) error {
+			_, err := b.Resolve(ctx)
+			return err
+		}},
+		{"Export", func(b Builder) error {
+			return b.Export(ctx, t.TempDir())
+		}},
+		{"Test", func(b Builder) error {
+			return b.Test(ctx, nil, nil)
+		}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &reasonRecorder{}
+			b := Builder{WorkDir: t.TempDir(), MinFreeDiskBytes: 1 << 62, Instrumentation: r, Logger: testLogger{t}}
+			var preflightErr *PreflightError
+			if err := tt.run(b); !errors.As(err, &preflightErr) {
+				t.Fatalf("%s = %v, want a PreflightError for the disk space", tt.name, err)
+			}
+			if left := buildEnvFolders(t, b.WorkDir); len(left) > 0 {
+				t.Errorf("build environments were created: %v", left)
+			}
+			if len(r.reasons) != 1 || r.reasons[0] != "preflight" {
+				t.Errorf("recorded failures %v, want one for the preflight", r.reasons)
+			}
+		})
+	}
+}
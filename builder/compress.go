@@ -0,0 +1,44 @@
+package builder
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+)
+
+// UPXOptions configures compression of the
+// built binary with UPX after compiling.
+type UPXOptions struct {
+	// Level is the compression level from 1 (fastest) to
+	// 9 (best). Zero uses the default level of UPX.
+	Level int `json:"level,omitempty"`
+
+	// Command is the UPX executable. Default: upx
+	Command string `json:"command,omitempty"`
+}
+
+// check returns an error if the options are invalid.
+func (o UPXOptions) check() error {
+	if o.Level < 0 || o.Level > 9 {
+		return fmt.Errorf("invalid UPX compression level %d: must be between 1 and 9, or 0 for the default", o.Level)
+	}
+	return nil
+}
+
+// compressBinary compresses the binary at absOutputFile in place.
+func (env environment) compressBinary(ctx context.Context, opts UPXOptions, absOutputFile string) error {
+	command := opts.Command
+	if command == "" {
+		command = "upx"
+	}
+	env.log.Printf("[INFO] Compressing %s", absOutputFile)
+	cmd := env.newCommand(ctx, command, "-q")
+	if opts.Level > 0 {
+		cmd.Args = append(cmd.Args, "-"+strconv.Itoa(opts.Level))
+	}
+	cmd.Args = append(cmd.Args, absOutputFile)
+	if err := env.runCommand(ctx, cmd); err != nil {
+		return fmt.Errorf("compressing binary: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,237 @@
+package builder
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v2"
+)
+
+// LoadConfig reads the configuration of a Builder from the file at
+// path, so that build definitions can be kept in files and shared
+// between the command line and services. The format follows from
+// the extension: .json, .yaml or .yml, or .toml. In all formats,
+// the keys are those of the JSON encoding of Builder, such as
+// "caddy_version" and "plugins", and unknown keys are errors.
+// Durations are strings such as "10m" or "1h30m", as parsed by
+// time.ParseDuration, or integers of nanoseconds.
+//
+// $VAR and ${VAR} in string values are replaced by the values of
+// the environment variables, or nothing if they are not set; $$
+// stands for a literal $. The values of main_template and
+// output_template, whose text/template variables start with $,
+// and of hooks, whose commands the shell expands when they run,
+// are kept as they are.
+//
+// YAML files are single documents. TOML dates and times are read
+// as strings.
+func LoadConfig(path string) (Builder, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Builder{}, err
+	}
+	var config interface{}
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".json":
+		err = json.Unmarshal(data, &config)
+	case ".yaml", ".yml":
+		if err = yaml.Unmarshal(data, &config); err == nil {
+			config, err = yamlToJSON(config)
+		}
+	case ".toml":
+		err = toml.Unmarshal(data, &config)
+	default:
+		return Builder{}, fmt.Errorf("unsupported configuration format %q; use .json, .yaml, .yml, or .toml", ext)
+	}
+	if err != nil {
+		return Builder{}, fmt.Errorf("parsing %s: %v", path, err)
+	}
+	if fields, ok := config.(map[string]interface{}); ok {
+		for key, value := range fields {
+			if !verbatimConfigKeys[key] {
+				fields[key] = expandConfig(value)
+			}
+		}
+	}
+	if err := parseDurations(config, reflect.TypeOf(Builder{}), ""); err != nil {
+		return Builder{}, fmt.Errorf("decoding %s: %v", path, err)
+	}
+
+	// decode through JSON, so that all formats
+	// share the struct tags of Builder
+	data, err = json.Marshal(config)
+	if err != nil {
+		return Builder{}, fmt.Errorf("parsing %s: %v", path, err)
+	}
+	var b Builder
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&b); err != nil {
+		return Builder{}, fmt.Errorf("decoding %s: %v", path, err)
+	}
+	return b, nil
+}
+
+// yamlToJSON converts the mappings of the decoded YAML
+// value v, whose keys may be of any type, to the objects
+// that encoding/json decodes into interface{}.
+func yamlToJSON(v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case []interface{}:
+		for i := range v {
+			var err error
+			if v[i], err = yamlToJSON(v[i]); err != nil {
+				return nil, err
+			}
+		}
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, value := range v {
+			key, ok := k.(string)
+			if !ok {
+				return nil, fmt.Errorf("key %v is not a string", k)
+			}
+			var err error
+			if m[key], err = yamlToJSON(value); err != nil {
+				return nil, err
+			}
+		}
+		return m, nil
+	}
+	return v, nil
+}
+
+// parseDurations replaces the strings of the decoded
+// configuration v that are durations of the fields of type t,
+// which key names, with their integers of nanoseconds.
+func parseDurations(v interface{}, t reflect.Type, key string) error {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch v := v.(type) {
+	case []interface{}:
+		if t.Kind() != reflect.Slice && t.Kind() != reflect.Array {
+			return nil
+		}
+		for i := range v {
+			if s, ok := v[i].(string); ok && t.Elem() == durationType {
+				d, err := parseDuration(key, s)
+				if err != nil {
+					return err
+				}
+				v[i] = d
+				continue
+			}
+			if err := parseDurations(v[i], t.Elem(), fmt.Sprintf("%s[%d]", key, i)); err != nil {
+				return err
+			}
+		}
+	case map[string]interface{}:
+		fields := make(map[string]reflect.Type)
+		switch t.Kind() {
+		case reflect.Struct:
+			jsonFields(t, fields)
+		case reflect.Map:
+			for k := range v {
+				fields[k] = t.Elem()
+			}
+		default:
+			return nil
+		}
+		for k, value := range v {
+			ft, ok := fields[k]
+			if !ok {
+				// an unknown key, which decoding reports
+				continue
+			}
+			name := k
+			if key != "" {
+				name = key + "." + k
+			}
+			if s, ok := value.(string); ok && ft == durationType {
+				d, err := parseDuration(name, s)
+				if err != nil {
+					return err
+				}
+				v[k] = d
+				continue
+			}
+			if err := parseDurations(value, ft, name); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// parseDuration parses the duration s of the setting key.
+func parseDuration(key, s string) (int64, error) {
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("%s: invalid duration %q; use a duration such as 90s or 1h30m", key, s)
+	}
+	return int64(d), nil
+}
+
+// jsonFields adds the types of the encoded fields of the struct
+// type t to fields, keyed by their JSON names, including those of
+// embedded structs.
+func jsonFields(t reflect.Type, fields map[string]reflect.Type) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name := strings.Split(tag, ",")[0]
+		if f.Anonymous && name == "" && f.Type.Kind() == reflect.Struct {
+			jsonFields(f.Type, fields)
+			continue
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		fields[name] = f.Type
+	}
+}
+
+// verbatimConfigKeys are the keys of the configuration
+// whose values LoadConfig does not expand.
+var verbatimConfigKeys = map[string]bool{
+	"main_template":   true,
+	"output_template": true,
+	"hooks":           true,
+}
+
+// expandConfig replaces the environment variables
+// in all strings of the decoded configuration v.
+func expandConfig(v interface{}) interface{} {
+	switch v := v.(type) {
+	case string:
+		return os.Expand(v, func(key string) string {
+			if key == "$" {
+				return "$"
+			}
+			return os.Getenv(key)
+		})
+	case []interface{}:
+		for i := range v {
+			v[i] = expandConfig(v[i])
+		}
+	case map[string]interface{}:
+		for k := range v {
+			v[k] = expandConfig(v[k])
+		}
+	}
+	return v
+}
@@ -0,0 +1,218 @@
+package builder
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v2"
+)
+
+// loadConfigString writes config to a file with the extension of
+// its format in a new directory, and loads it.
+func loadConfigString(t *testing.T, ext, config string) (Builder, error) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "goaway"+ext)
+	if err := os.WriteFile(path, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return LoadConfig(path)
+}
+
+func TestLoadConfigRoundTrip(t *testing.T) {
+	want := Builder{
+		Compile: Compile{
+			Platform: Platform{OS: "linux", Arch: "arm64"},
+			Cgo:      true,
+		},
+		CaddyVersion: "v1.2.3",
+		Plugins: []Dependency{
+			{PackagePath: "example.com/plugin", Version: "v1.0.0"},
+			{PackagePath: "example.com/other"},
+		},
+		Replacements: []Replace{NewReplace("example.com/plugin", "../plugin")},
+		TimeoutGet:   10 * time.Minute,
+		Timeouts:     Timeouts{Setup: 90 * time.Second},
+		Env:          map[string]string{"GOFLAGS": "-mod=mod"},
+	}
+	data, err := json.Marshal(want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tree interface{}
+	if err := json.Unmarshal(data, &tree); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		ext     string
+		marshal func(interface{}) ([]byte, error)
+	}{
+		{".json", json.Marshal},
+		{".yaml", yaml.Marshal},
+		{".yml", yaml.Marshal},
+		{".toml", toml.Marshal},
+	} {
+		t.Run(tt.ext, func(t *testing.T) {
+			data, err := tt.marshal(tree)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := loadConfigString(t, tt.ext, string(data))
+			if err != nil {
+				t.Fatalf("loading\n%s: %v", data, err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("loading\n%s\ngot  %+v\nwant %+v", data, got, want)
+			}
+		})
+	}
+}
+
+func TestLoadConfigFormats(t *testing.T) {
+	want := Builder{
+		CaddyVersion: "v1.2.3",
+		Plugins:      []Dependency{{PackagePath: "example.com/plugin", Version: "v1.0.0"}},
+		TimeoutGet:   10 * time.Minute,
+		TimeoutBuild: time.Minute,
+		Timeouts:     Timeouts{Setup: 90 * time.Second, Compile: time.Hour + 30*time.Minute},
+	}
+	for _, tt := range []struct {
+		name, ext, config string
+	}{
+		{"json", ".json", `{
+			"caddy_version": "v1.2.3",
+			"plugins": [{"module_path": "example.com/plugin", "version": "v1.0.0"}],
+			"timeout_get": "10m",
+			"timeout_build": 60000000000,
+			"timeouts": {"setup": "90s", "compile": "1h30m"}
+		}`},
+		{"yaml", ".yaml", `# the release build
+caddy_version: v1.2.3
+plugins:
+  - module_path: example.com/plugin
+    version: "v1.0.0"
+timeout_get: 10m
+timeout_build: 60000000000
+timeouts: {setup: 90s, compile: 1h30m}
+`},
+		{"toml", ".toml", `# the release build
+caddy_version = "v1.2.3"
+timeout_get = "10m"
+timeout_build = 60000000000
+
+[timeouts]
+setup = "90s"
+compile = "1h30m"
+
+[[plugins]]
+module_path = "example.com/plugin"
+version = "v1.0.0"
+`},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := loadConfigString(t, tt.ext, tt.config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("got  %+v\nwant %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	for _, tt := range []struct {
+		name, ext, config, wantErr string
+	}{
+		{"unknown key", ".json", `{"caddy_verison": "v1.2.3"}`, "unknown field"},
+		{"unknown key in yaml", ".yaml", "caddy_verison: v1.2.3\n", "unknown field"},
+		{"unknown key in toml", ".toml", "caddy_verison = \"v1.2.3\"\n", "unknown field"},
+		{"invalid duration", ".yaml", "timeouts:\n  setup: 10 minutes\n", `timeouts.setup: invalid duration "10 minutes"`},
+		{"invalid yaml", ".yaml", "plugins: [\n", "parsing"},
+		{"invalid toml", ".toml", "caddy_version = \n", "parsing"},
+		{"unsupported format", ".ini", "caddy_version = v1.2.3\n", "unsupported configuration format"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := loadConfigString(t, tt.ext, tt.config)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("LoadConfig = %v, want an error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLoadConfigExpandsEnv(t *testing.T) {
+	t.Setenv("GOAWAY_TEST_VERSION", "v1.2.3")
+	t.Setenv("GOAWAY_TEST_TIMEOUT", "5m")
+	t.Setenv("GOAWAY_TEST_EMPTY", "")
+	for _, tt := range []struct {
+		name, value, want string
+	}{
+		{"bare", "$GOAWAY_TEST_VERSION", "v1.2.3"},
+		{"braces", "${GOAWAY_TEST_VERSION}-rc", "v1.2.3-rc"},
+		{"unset", "x${GOAWAY_TEST_UNSET}y", "xy"},
+		{"empty", "x${GOAWAY_TEST_EMPTY}y", "xy"},
+		{"escaped", "$$GOAWAY_TEST_VERSION", "$GOAWAY_TEST_VERSION"},
+		{"none", "v1.0.0", "v1.0.0"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, format := range []struct{ ext, config string }{
+				{".json", `{"caddy_version": "` + tt.value + `", "env": {"V": "` + tt.value + `"}, "timeout_get": "${GOAWAY_TEST_TIMEOUT}"}`},
+				{".yaml", "caddy_version: '" + tt.value + "'\nenv:\n  V: '" + tt.value + "'\ntimeout_get: ${GOAWAY_TEST_TIMEOUT}\n"},
+				{".toml", "caddy_version = '" + tt.value + "'\ntimeout_get = '${GOAWAY_TEST_TIMEOUT}'\n[env]\nV = '" + tt.value + "'\n"},
+			} {
+				b, err := loadConfigString(t, format.ext, format.config)
+				if err != nil {
+					t.Fatalf("%s: %v", format.ext, err)
+				}
+				if b.CaddyVersion != tt.want || b.Env["V"] != tt.want {
+					t.Errorf("%s: %q was expanded to %q and %q, want %q", format.ext, tt.value, b.CaddyVersion, b.Env["V"], tt.want)
+				}
+				if b.TimeoutGet != 5*time.Minute {
+					t.Errorf("%s: timeout_get is %s, want the 5m of the environment", format.ext, b.TimeoutGet)
+				}
+			}
+		})
+	}
+}
+
+func TestLoadConfigKeepsTemplatesAndHooks(t *testing.T) {
+	t.Setenv("GOAWAY_TEST_VERSION", "v1.2.3")
+	const (
+		mainTemplate   = `{{ $x := .BaseModule }}package main // {{ $x }}`
+		outputTemplate = `{{ $v := .Version }}goaway_{{ $v }}`
+		hook           = `sh -c 'cp "$GOAWAY_BUILDER_OUTPUT" "$HOME/bin/"'`
+	)
+	config, err := json.Marshal(map[string]interface{}{
+		"caddy_version":   "$GOAWAY_TEST_VERSION",
+		"main_template":   mainTemplate,
+		"output_template": outputTemplate,
+		"hooks":           map[string][]string{"post_build": {hook}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := loadConfigString(t, ".json", string(config))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.CaddyVersion != "v1.2.3" {
+		t.Errorf("caddy_version is %q, want the expanded v1.2.3", b.CaddyVersion)
+	}
+	if b.MainTemplate != mainTemplate {
+		t.Errorf("main_template is %q, want it unchanged", b.MainTemplate)
+	}
+	if b.OutputTemplate != outputTemplate {
+		t.Errorf("output_template is %q, want it unchanged", b.OutputTemplate)
+	}
+	if len(b.Hooks.PostBuild) != 1 || b.Hooks.PostBuild[0] != hook {
+		t.Errorf("post_build hooks are %q, want them unchanged", b.Hooks.PostBuild)
+	}
+}
@@ -0,0 +1,222 @@
+package builder
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"golang.org/x/mod/module"
+	"golang.org/x/mod/semver"
+)
+
+// Kinds of ModuleConflict.
+const (
+	// ConflictMajorVersions: requested modules require different
+	// major versions of a module, such as v1 and v2 of a library,
+	// which are then both in the build.
+	ConflictMajorVersions = "major_versions"
+
+	// ConflictDowngraded: a requested module is selected at
+	// an older version than was requested, which happens when
+	// `go get` of another module downgrades it.
+	ConflictDowngraded = "downgraded"
+
+	// ConflictUpgraded: a requested module is selected at a
+	// newer version than was requested, because another
+	// module requires that version.
+	ConflictUpgraded = "upgraded"
+
+	// ConflictReplaced: a requested module is replaced, so that
+	// the replacement is built instead of the requested version.
+	ConflictReplaced = "replaced"
+)
+
+// ModuleConflict describes a way in which the tidied module
+// graph of a build differs from what was requested.
+type ModuleConflict struct {
+	// The kind of conflict, e.g. ConflictDowngraded.
+	Kind string `json:"kind,omitempty"`
+
+	// The module the conflict is about, with the package
+	// path of the plugin that requested it, if any.
+	Module  string `json:"module,omitempty"`
+	Package string `json:"package,omitempty"`
+
+	// The requested and the selected versions of Module. For
+	// ConflictMajorVersions, Selected lists the selected
+	// modules of each major version instead.
+	Requested string   `json:"requested,omitempty"`
+	Selected  []string `json:"selected,omitempty"`
+
+	// The modules that require the selected versions, as
+	// path@version, or the replacement for ConflictReplaced.
+	RequiredBy  []string        `json:"required_by,omitempty"`
+	Replacement ReplacementPath `json:"replacement,omitempty"`
+}
+
+func (c ModuleConflict) String() string {
+	switch c.Kind {
+	case ConflictMajorVersions:
+		return fmt.Sprintf("%s is in the build at %d major versions: %s", c.Module, len(c.Selected), strings.Join(c.Selected, ", "))
+	case ConflictReplaced:
+		return fmt.Sprintf("%s was requested at %s but is replaced by %s", c.Module, versionOrLatest(c.Requested), c.Replacement)
+	}
+	s := fmt.Sprintf("%s was requested at %s but was %s to %s", c.Module, c.Requested, c.Kind, strings.Join(c.Selected, ", "))
+	if len(c.RequiredBy) > 0 {
+		s += ", as required by " + strings.Join(c.RequiredBy, ", ")
+	}
+	return s
+}
+
+// versionOrLatest returns version, or "latest" if it is empty.
+func versionOrLatest(version string) string {
+	if version == "" {
+		return "latest"
+	}
+	return version
+}
+
+// ModuleConflictError is returned when strict conflict
+// checking is enabled and any conflicts were found.
+type ModuleConflictError struct {
+	Conflicts []ModuleConflict
+}
+
+func (e *ModuleConflictError) Error() string {
+	lines := make([]string, 0, len(e.Conflicts))
+	for _, c := range e.Conflicts {
+		lines = append(lines, c.String())
+	}
+	return fmt.Sprintf("%d module conflict(s): %s", len(e.Conflicts), strings.Join(lines, "; "))
+}
+
+// checkConflicts compares the tidied module graph of env with the
+// requested versions of the Caddy module and the plugins, using the
+// output of `go list -m all` and `go mod graph`.
+func (env environment) checkConflicts(ctx context.Context, plugins []Dependency) ([]ModuleConflict, error) {
+	if env.plan != nil {
+		// queries are not run when planning
+		return nil, nil
+	}
+	modules, err := env.listModules(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var out bytes.Buffer
+	cmd := env.newGoModCommand(ctx, "graph")
+	cmd.Stdout = &out
+	if err := env.runCommand(ctx, cmd); err != nil {
+		return nil, err
+	}
+	// requiredBy maps path@version to the modules that require it
+	requiredBy := make(map[string][]string)
+	for _, line := range strings.Split(out.String(), "\n") {
+		if fields := strings.Fields(line); len(fields) == 2 && strings.Contains(fields[0], "@") {
+			requiredBy[fields[1]] = append(requiredBy[fields[1]], fields[0])
+		}
+	}
+
+	var conflicts []ModuleConflict
+	requested := make(map[string]bool)
+	requests := append([]Dependency{{PackagePath: env.caddyModulePath, Version: env.caddyVersion}}, plugins...)
+	for _, p := range requests {
+		provider := moduleProviding(modules, p.PackagePath)
+		if provider == nil || provider.Main {
+			continue
+		}
+		if requested[provider.Path+"@"+provider.Version] {
+			// reported for another package of the module
+			continue
+		}
+		requested[provider.Path+"@"+provider.Version] = true
+		c := ModuleConflict{
+			Module:    provider.Path,
+			Requested: env.requestedVersion(p.PackagePath, p.Version),
+			Selected:  []string{provider.Version},
+		}
+		if p.PackagePath != provider.Path {
+			c.Package = p.PackagePath
+		}
+		switch {
+		case provider.Replace != nil:
+			c.Kind = ConflictReplaced
+			c.Replacement = ReplacementPath(strings.TrimSpace(provider.Replace.Path + " " + provider.Replace.Version))
+			if p.PackagePath == env.caddyModulePath {
+				// a replaced Caddy module is a common choice
+				continue
+			}
+		case c.Requested == "" || c.Requested == provider.Version:
+			continue
+		case semver.Compare(provider.Version, c.Requested) < 0:
+			c.Kind = ConflictDowngraded
+		default:
+			c.Kind = ConflictUpgraded
+			c.RequiredBy = requiredBy[provider.Path+"@"+provider.Version]
+		}
+		conflicts = append(conflicts, c)
+	}
+
+	// group the modules by their path without the major version,
+	// and report those whose major versions are required by
+	// different requested modules
+	majors := make(map[string][]string)
+	for _, m := range modules {
+		if m.Main {
+			continue
+		}
+		if prefix, _, ok := module.SplitPathVersion(m.Path); ok {
+			majors[prefix] = append(majors[prefix], m.Path+"@"+m.Version)
+		}
+	}
+	prefixes := make([]string, 0, len(majors))
+	for prefix := range majors {
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Strings(prefixes)
+	for _, prefix := range prefixes {
+		selected := majors[prefix]
+		if len(selected) < 2 {
+			continue
+		}
+		sort.Strings(selected)
+		c := ModuleConflict{Kind: ConflictMajorVersions, Module: prefix, Selected: selected}
+		forced := 0
+		for _, s := range selected {
+			var by []string
+			for _, from := range requiredBy[s] {
+				if requested[from] {
+					by = append(by, from)
+				}
+			}
+			if len(by) > 0 {
+				forced++
+				c.RequiredBy = append(c.RequiredBy, by...)
+			}
+		}
+		if forced > 1 {
+			conflicts = append(conflicts, c)
+		}
+	}
+	return conflicts, nil
+}
+
+// enforceConflicts runs checkConflicts, logging every conflict as a
+// warning and recording them in env, or failing with a
+// ModuleConflictError if strict.
+func (env *environment) enforceConflicts(ctx context.Context, plugins []Dependency, strict bool) error {
+	env.log.Printf("[INFO] Checking module graph for conflicts")
+	conflicts, err := env.checkConflicts(ctx, plugins)
+	if err != nil {
+		return err
+	}
+	for _, c := range conflicts {
+		env.log.Printf("[WARNING] Module conflict: %s", c)
+	}
+	env.conflicts = conflicts
+	if strict && len(conflicts) > 0 {
+		return &ModuleConflictError{Conflicts: conflicts}
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+package builder
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// Container is an Executor that runs builds inside containers, so that
+// the code of plugins is never built or run directly on the host.
+// Every command of the build, including `go get`, `go mod tidy`,
+// `go build`, the hooks, and UPX, runs in a fresh container of Image
+// with only the build environment, the module cache, and the
+// directories of local replacements (read-only) mounted, each at its
+// path on the host. The image must provide the go command and any
+// other command the build needs.
+//
+// The binary is written into the build environment
+// and copied to the output file after the build.
+type Container struct {
+	// Runtime is the container runtime to use,
+	// such as "docker" or "podman". Default: "docker"
+	Runtime string `json:"runtime,omitempty"`
+
+	// Image is the image to run the commands in. It should be
+	// pinned by digest, e.g. "golang:1.22@sha256:...", so that
+	// every build uses the same toolchain.
+	Image string `json:"image,omitempty"`
+
+	// Network, if set, is the network to run the containers in,
+	// e.g. "none" for Offline builds. The default network of
+	// the runtime is used otherwise.
+	Network string `json:"network,omitempty"`
+
+	// Args are additional arguments to `run`, e.g. to limit the
+	// resources of the containers or to mount a build cache.
+	Args []string `json:"args,omitempty"`
+}
+
+func (c Container) runtime() string {
+	if c.Runtime != "" {
+		return c.Runtime
+	}
+	return "docker"
+}
+
+// Start implements Executor.
+func (c Container) Start(ctx context.Context, dir string, inputs, goEnv []string) (ExecutorSession, error) {
+	if c.Image == "" {
+		return nil, fmt.Errorf("container builds require an image")
+	}
+	if runtime.GOOS == "windows" {
+		return nil, fmt.Errorf("container builds are not supported on windows")
+	}
+	path, err := exec.LookPath(c.runtime())
+	if err != nil {
+		return nil, fmt.Errorf("container runtime: %v", err)
+	}
+
+	modCache, ok := getEnv(goEnv, "GOMODCACHE")
+	if !ok {
+		out, err := exec.CommandContext(ctx, GetGo(), "env", "GOMODCACHE").Output()
+		if err != nil {
+			return nil, fmt.Errorf("locating module cache: %v", err)
+		}
+		modCache = strings.TrimSpace(string(out))
+	}
+	if modCache, err = filepath.Abs(modCache); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(modCache, 0755); err != nil {
+		return nil, err
+	}
+	return &containerSession{c: c, path: path, dir: dir, modCache: modCache, inputs: inputs}, nil
+}
+
+// containerSession runs the commands of a build environment in
+// containers. The build environment is mounted, so it needs no
+// copying.
+type containerSession struct {
+	c        Container
+	path     string
+	dir      string
+	modCache string
+	inputs   []string
+}
+
+func (s *containerSession) Upload(ctx context.Context) error   { return nil }
+func (s *containerSession) Download(ctx context.Context) error { return nil }
+func (s *containerSession) Close(ctx context.Context) error    { return nil }
+
+func (s *containerSession) Wrap(cmd *exec.Cmd, vars []string) error {
+	args := []string{s.c.runtime(), "run", "--rm", "--init"}
+	if uid := os.Getuid(); uid >= 0 {
+		// files in the build environment must remain the user's
+		args = append(args, "--user", fmt.Sprintf("%d:%d", uid, os.Getgid()))
+	}
+	args = append(args,
+		"-v", s.dir+":"+s.dir,
+		"-v", s.modCache+":"+s.modCache)
+	for _, dir := range s.inputs {
+		args = append(args, "-v", dir+":"+dir+":ro")
+	}
+	if s.c.Network != "" {
+		args = append(args, "--network", s.c.Network)
+	}
+	dir := cmd.Dir
+	if dir == "" {
+		dir = s.dir
+	}
+	args = append(args, "-w", dir, "-e", "HOME=/tmp")
+	for _, kv := range vars {
+		if !strings.HasPrefix(kv, "GOMODCACHE=") {
+			args = append(args, "-e", kv)
+		}
+	}
+	args = append(args, "-e", "GOMODCACHE="+s.modCache)
+	args = append(args, s.c.Args...)
+	args = append(args, s.c.Image, executorCommand(cmd))
+	args = append(args, cmd.Args[1:]...)
+
+	cmd.Path = s.path
+	cmd.Args = args
+	cmd.Env = nil
+	cmd.Dir = s.dir
+	return nil
+}
@@ -0,0 +1,136 @@
+package builder
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// credentialsFolder is the folder inside the build environment
+// that holds the files generated from Credentials. It is hidden,
+// so the go command ignores it.
+const credentialsFolder = ".credentials"
+
+// Credentials configure how the build environment authenticates to
+// private repositories. When they are set, git in the build
+// environment uses a configuration of its own instead of the global
+// and system configuration of the host, and the go command uses a
+// .netrc file of its own, so that only these settings apply.
+//
+// Private modules are usually fetched directly rather than through a
+// proxy, so their module paths should be listed in GoPrivate as well.
+type Credentials struct {
+	// NetrcFile is the path of a .netrc file with credentials for
+	// the go command, e.g. for a private module proxy. The entries
+	// of Tokens are added to it. The .netrc file of the host is not
+	// used otherwise.
+	NetrcFile string `json:"netrc_file,omitempty"`
+
+	// Tokens maps host names, e.g. "github.com", to access tokens for
+	// git over HTTPS and the go command's own requests. A token is sent
+	// as the password with the login "oauth2", which GitHub and GitLab
+	// accept; a value of the form "login:token" sets the login.
+	Tokens map[string]string `json:"tokens,omitempty"`
+
+	// SSHAgent passes SSH_AUTH_SOCK through to git, so that modules can
+	// be fetched over SSH with the keys of the running SSH agent.
+	// Otherwise SSH_AUTH_SOCK is removed from the build environment.
+	SSHAgent bool `json:"ssh_agent,omitempty"`
+
+	// InsteadOf maps URL prefixes to the URLs git rewrites them to,
+	// like url.<base>.insteadOf in a git configuration. For example,
+	// "https://github.com/acme/": "ssh://git@github.com/acme/"
+	// fetches the repositories of acme over SSH.
+	InsteadOf map[string]string `json:"instead_of,omitempty"`
+}
+
+// write generates the git configuration and .netrc file for c in
+// dir and returns the variables that make the go command and git
+// use them, along with the variables to remove from the environment.
+func (c Credentials) write(dir string) (vars, unset []string, err error) {
+	if c.SSHAgent && os.Getenv("SSH_AUTH_SOCK") == "" {
+		return nil, nil, fmt.Errorf("SSH agent requested, but SSH_AUTH_SOCK is not set")
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, nil, err
+	}
+
+	var netrc, gitCredentials strings.Builder
+	if c.NetrcFile != "" {
+		content, err := os.ReadFile(c.NetrcFile)
+		if err != nil {
+			return nil, nil, fmt.Errorf("reading netrc file: %v", err)
+		}
+		netrc.Write(content)
+		netrc.WriteString("\n")
+	}
+	for _, host := range sortedKeys(c.Tokens) {
+		login, token := "oauth2", c.Tokens[host]
+		if before, after, ok := strings.Cut(token, ":"); ok {
+			login, token = before, after
+		}
+		if strings.ContainsAny(host+login+token, " \t\r\n") {
+			return nil, nil, fmt.Errorf("token for %s: must not contain whitespace", host)
+		}
+		fmt.Fprintf(&netrc, "machine %s login %s password %s\n", host, login, token)
+		u := url.URL{Scheme: "https", User: url.UserPassword(login, token), Host: host}
+		gitCredentials.WriteString(u.String() + "\n")
+	}
+
+	netrcPath := filepath.Join(dir, "netrc")
+	credentialsPath := filepath.Join(dir, "git-credentials")
+	gitConfigPath := filepath.Join(dir, "gitconfig")
+
+	var gitConfig strings.Builder
+	if len(c.Tokens) > 0 {
+		// the store helper is run by the shell
+		helper := "store --file '" + strings.ReplaceAll(credentialsPath, "'", `'\''`) + "'"
+		fmt.Fprintf(&gitConfig, "[credential]\n\thelper = %s\n", gitConfigQuote(helper))
+	}
+	for _, prefix := range sortedKeys(c.InsteadOf) {
+		fmt.Fprintf(&gitConfig, "[url %s]\n\tinsteadOf = %s\n", gitConfigQuote(c.InsteadOf[prefix]), gitConfigQuote(prefix))
+	}
+
+	for _, f := range []struct {
+		path    string
+		content string
+	}{
+		{netrcPath, netrc.String()},
+		{credentialsPath, gitCredentials.String()},
+		{gitConfigPath, gitConfig.String()},
+	} {
+		if err := os.WriteFile(f.path, []byte(f.content), 0600); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	vars = []string{
+		"NETRC=" + netrcPath,
+		"GIT_CONFIG_GLOBAL=" + gitConfigPath,
+		"GIT_CONFIG_NOSYSTEM=1",
+	}
+	if !c.SSHAgent {
+		unset = append(unset, "SSH_AUTH_SOCK")
+	}
+	return vars, unset, nil
+}
+
+// gitConfigQuote quotes s as a value or subsection name
+// in a git configuration file.
+func gitConfigQuote(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `"`, `\"`)
+	return `"` + s + `"`
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
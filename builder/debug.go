@@ -0,0 +1,112 @@
+package builder
+
+import (
+	"debug/elf"
+	"debug/macho"
+	"debug/pe"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// debugMarker is the file that marks a DebugDir as a copy of a
+// build environment, which the next debug build may replace.
+const debugMarker = ".goaway-debug"
+
+// checkDWARF logs a warning if the binary at path has no DWARF
+// debugging information, such as when BuildFlags has -ldflags
+// "-w". Formats that cannot be read, such as wasm, are skipped.
+func (env environment) checkDWARF(path string) {
+	var err error
+	if f, openErr := elf.Open(path); openErr == nil {
+		_, err = f.DWARF()
+		f.Close()
+	} else if f, openErr := macho.Open(path); openErr == nil {
+		_, err = f.DWARF()
+		f.Close()
+	} else if f, openErr := pe.Open(path); openErr == nil {
+		_, err = f.DWARF()
+		f.Close()
+	} else {
+		return
+	}
+	if err != nil {
+		env.log.Printf("[WARNING] The debug build has no DWARF debugging information: %v; check -ldflags in the build flags", err)
+	}
+}
+
+// copyDebugSources copies the build environment to dir, replacing an
+// earlier copy, and writes the dlv configuration that maps the source
+// paths of the binary at absOutputFile to it next to the binary. It
+// returns the absolute path of dir and the path of the configuration.
+// The binary itself is not copied. Executors build at the same paths
+// as the host, so the paths of the binary are those of env.
+func (env environment) copyDebugSources(dir, absOutputFile string) (string, string, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", "", err
+	}
+	entries, err := os.ReadDir(dir)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+	case err != nil:
+		return "", "", err
+	case len(entries) > 0:
+		if _, err := os.Stat(filepath.Join(dir, debugMarker)); err != nil {
+			return "", "", fmt.Errorf("debug directory %s is not empty and not a copy of a build environment", dir)
+		}
+		if err := os.RemoveAll(dir); err != nil {
+			return "", "", err
+		}
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", "", err
+	}
+	entries, err = os.ReadDir(env.tempFolder)
+	if err != nil {
+		return "", "", err
+	}
+	for _, entry := range entries {
+		if entry.Name() == executorOutputFolder {
+			continue
+		}
+		if err := copyTree(filepath.Join(env.tempFolder, entry.Name()), filepath.Join(dir, entry.Name())); err != nil {
+			return "", "", fmt.Errorf("copying build environment to %s: %v", dir, err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(dir, debugMarker), nil, 0644); err != nil {
+		return "", "", err
+	}
+	env.log.Printf("[INFO] Copied the sources of the debug build to %s", dir)
+
+	// the compiler records the path that the go command was
+	// run in, which may be the build environment with its
+	// symbolic links resolved, such as on macOS
+	from := []string{env.tempFolder}
+	if resolved, err := filepath.EvalSymlinks(env.tempFolder); err == nil && resolved != env.tempFolder {
+		from = append(from, resolved)
+	}
+	configFile := absOutputFile + ".dlv.yml"
+	if err := os.WriteFile(configFile, dlvConfig(filepath.Base(absOutputFile), from, dir), 0644); err != nil {
+		return "", "", err
+	}
+	return dir, configFile, nil
+}
+
+// dlvConfig returns the substitute-path setting of dlv that
+// maps the source paths in from of the binary to dir.
+func dlvConfig(binary string, from []string, dir string) []byte {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "# Source paths of the debug build %s: add these rules to\n", binary)
+	sb.WriteString("# substitute-path in the dlv configuration (config.yml),\n")
+	sb.WriteString("# or run `config substitute-path FROM TO` in dlv.\n")
+	sb.WriteString("substitute-path:\n")
+	for _, path := range from {
+		fmt.Fprintf(&sb, "  - {from: %s, to: %s}\n", strconv.Quote(filepath.ToSlash(path)), strconv.Quote(filepath.ToSlash(dir)))
+	}
+	return []byte(sb.String())
+}
@@ -0,0 +1,178 @@
+package builder
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// BuildDiff describes what changed from one build to another,
+// as returned by Diff and DiffBinaries.
+type BuildDiff struct {
+	// The modules that only the new build has, that only
+	// the old build has, and those whose version or
+	// replacement changed, each sorted by path.
+	Added   []ModuleChange `json:"added,omitempty"`
+	Removed []ModuleChange `json:"removed,omitempty"`
+	Changed []ModuleChange `json:"changed,omitempty"`
+
+	// The versions of the Go toolchain, if they differ.
+	OldGoVersion string `json:"old_go_version,omitempty"`
+	NewGoVersion string `json:"new_go_version,omitempty"`
+
+	// The platforms, if they differ.
+	OldPlatform *Platform `json:"old_platform,omitempty"`
+	NewPlatform *Platform `json:"new_platform,omitempty"`
+}
+
+// ModuleChange describes a module in a BuildDiff. The
+// fields for the build that lacks the module are empty.
+type ModuleChange struct {
+	Path string `json:"path"`
+
+	OldVersion string `json:"old_version,omitempty"`
+	NewVersion string `json:"new_version,omitempty"`
+
+	// The module path and version or local directory
+	// that replaced the module, if any.
+	OldReplace string `json:"old_replace,omitempty"`
+	NewReplace string `json:"new_replace,omitempty"`
+}
+
+func (c ModuleChange) String() string {
+	return fmt.Sprintf("%s %s -> %s", c.Path, describeModule(c.OldVersion, c.OldReplace), describeModule(c.NewVersion, c.NewReplace))
+}
+
+// describeModule describes a module at version,
+// replaced by replace if that is set.
+func describeModule(version, replace string) string {
+	switch {
+	case version == "" && replace == "":
+		return "(none)"
+	case replace == "":
+		return version
+	}
+	return fmt.Sprintf("%s (replaced by %s)", version, replace)
+}
+
+// Empty reports whether the builds of d are the same
+// in every respect that d describes.
+func (d BuildDiff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0 &&
+		d.OldGoVersion == d.NewGoVersion && d.OldPlatform == nil && d.NewPlatform == nil
+}
+
+// String returns a summary of d with one change per line,
+// prefixed by "+" for added, "-" for removed, and "~" for
+// changed modules.
+func (d BuildDiff) String() string {
+	var lines []string
+	if d.OldGoVersion != d.NewGoVersion {
+		lines = append(lines, fmt.Sprintf("~ go %s -> %s", d.OldGoVersion, d.NewGoVersion))
+	}
+	if d.OldPlatform != nil || d.NewPlatform != nil {
+		lines = append(lines, fmt.Sprintf("~ platform %s -> %s", describePlatform(d.OldPlatform), describePlatform(d.NewPlatform)))
+	}
+	for _, c := range d.Added {
+		lines = append(lines, "+ "+c.Path+" "+describeModule(c.NewVersion, c.NewReplace))
+	}
+	for _, c := range d.Removed {
+		lines = append(lines, "- "+c.Path+" "+describeModule(c.OldVersion, c.OldReplace))
+	}
+	for _, c := range d.Changed {
+		lines = append(lines, "~ "+c.String())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// describePlatform describes p as GOOS/GOARCH,
+// with the ARM version, if any.
+func describePlatform(p *Platform) string {
+	if p == nil {
+		return "(none)"
+	}
+	s := p.OS + "/" + p.Arch
+	if p.ARM != "" {
+		s += "/v" + p.ARM
+	}
+	return s
+}
+
+// Diff returns the differences between the modules, Go
+// versions, and platforms of the builds old and new.
+func Diff(old, new BuildResult) BuildDiff {
+	var d BuildDiff
+	if old.GoVersion != new.GoVersion {
+		d.OldGoVersion, d.NewGoVersion = old.GoVersion, new.GoVersion
+	}
+	if old.Platform != new.Platform {
+		d.OldPlatform, d.NewPlatform = &old.Platform, &new.Platform
+	}
+
+	oldModules, newModules := resultModules(old), resultModules(new)
+	for _, path := range modulePaths(oldModules) {
+		o := oldModules[path]
+		n, ok := newModules[path]
+		switch {
+		case !ok:
+			d.Removed = append(d.Removed, ModuleChange{Path: path, OldVersion: o.version, OldReplace: o.replace})
+		case o != n:
+			d.Changed = append(d.Changed, ModuleChange{Path: path,
+				OldVersion: o.version, OldReplace: o.replace,
+				NewVersion: n.version, NewReplace: n.replace})
+		}
+	}
+	for _, path := range modulePaths(newModules) {
+		if _, ok := oldModules[path]; !ok {
+			n := newModules[path]
+			d.Added = append(d.Added, ModuleChange{Path: path, NewVersion: n.version, NewReplace: n.replace})
+		}
+	}
+	return d
+}
+
+// DiffBinaries is like Diff for the binaries at the paths old
+// and new, as described by their embedded build information.
+func DiffBinaries(old, new string) (BuildDiff, error) {
+	var results [2]BuildResult
+	for i, path := range []string{old, new} {
+		info, err := ReadBuildInfo(path)
+		if err != nil {
+			return BuildDiff{}, err
+		}
+		results[i].setBuildInfo(info)
+	}
+	return Diff(results[0], results[1]), nil
+}
+
+// resultModule is the version and replacement of a module of a build.
+type resultModule struct {
+	version string
+	replace string
+}
+
+// resultModules returns the modules of r by module path.
+func resultModules(r BuildResult) map[string]resultModule {
+	modules := make(map[string]resultModule, len(r.Modules))
+	for _, m := range r.Modules {
+		modules[m.PackagePath] = resultModule{version: m.Version}
+	}
+	for _, rep := range r.Replacements {
+		path := rep.Old.ModulePath()
+		if m, ok := modules[path]; ok {
+			m.replace = rep.New.String()
+			modules[path] = m
+		}
+	}
+	return modules
+}
+
+// modulePaths returns the module paths of modules in order.
+func modulePaths(modules map[string]resultModule) []string {
+	paths := make([]string, 0, len(modules))
+	for path := range modules {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return paths
+}
@@ -0,0 +1,9 @@
+//go:build !(linux || darwin || freebsd)
+
+package builder
+
+// freeDiskSpace is not supported on this platform,
+// where the free disk space is not checked.
+func freeDiskSpace(dir string) (free int64, device uint64, ok bool) {
+	return 0, 0, false
+}
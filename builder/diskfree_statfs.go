@@ -0,0 +1,20 @@
+//go:build linux || darwin || freebsd
+
+package builder
+
+import "syscall"
+
+// freeDiskSpace returns the number of bytes available to
+// unprivileged users on the volume of dir, along with the
+// device number of the volume, if known.
+func freeDiskSpace(dir string) (free int64, device uint64, ok bool) {
+	var st syscall.Stat_t
+	if err := syscall.Stat(dir, &st); err != nil {
+		return 0, 0, false
+	}
+	var fs syscall.Statfs_t
+	if err := syscall.Statfs(dir, &fs); err != nil {
+		return 0, 0, false
+	}
+	return int64(fs.Bavail) * int64(fs.Bsize), uint64(st.Dev), true
+}
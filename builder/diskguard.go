@@ -0,0 +1,211 @@
+package builder
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// diskGuardInterval is how often the disk guard
+// measures the size of the build environment.
+var diskGuardInterval = 2 * time.Second
+
+// diskGuard periodically measures the total size of folders
+// and trips once it grows beyond a maximum number of bytes.
+type diskGuard struct {
+	dirs     []string
+	maxBytes int64
+	log      Logger
+
+	tripped  chan struct{}
+	stop     chan struct{}
+	stopOnce sync.Once
+	size     int64
+}
+
+// startDiskGuard starts watching dirs in the background until
+// ctx is done or the guard is stopped. It returns nil if
+// maxBytes is not positive, which disables the check.
+func startDiskGuard(ctx context.Context, dirs []string, maxBytes int64, logger Logger) *diskGuard {
+	if maxBytes <= 0 {
+		return nil
+	}
+	g := &diskGuard{
+		dirs:     dirs,
+		maxBytes: maxBytes,
+		log:      logger,
+		tripped:  make(chan struct{}),
+		stop:     make(chan struct{}),
+	}
+	go g.watch(ctx)
+	return g
+}
+
+func (g *diskGuard) watch(ctx context.Context) {
+	ticker := time.NewTicker(diskGuardInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-g.stop:
+			return
+		case <-ticker.C:
+		}
+		var size int64
+		var err error
+		for _, dir := range g.dirs {
+			var n int64
+			n, err = dirSize(dir)
+			if os.IsNotExist(err) {
+				// the go command creates the caches when needed
+				n, err = 0, nil
+			}
+			if err != nil {
+				g.log.Printf("[WARNING] Measuring size of %s: %v", dir, err)
+				break
+			}
+			size += n
+		}
+		if err != nil {
+			continue
+		}
+		if size > g.maxBytes {
+			g.log.Printf("[ERROR] Build environment uses %d bytes, more than the limit of %d", size, g.maxBytes)
+			g.size = size
+			close(g.tripped)
+			return
+		}
+	}
+}
+
+// exceeded returns a channel that is closed once the limit
+// has been exceeded. It is safe to call on a nil guard.
+func (g *diskGuard) exceeded() <-chan struct{} {
+	if g == nil {
+		return nil
+	}
+	return g.tripped
+}
+
+// isTripped reports whether the limit has been exceeded.
+func (g *diskGuard) isTripped() bool {
+	select {
+	case <-g.exceeded():
+		return true
+	default:
+		return false
+	}
+}
+
+// err returns the error describing why the build was aborted.
+func (g *diskGuard) err() error {
+	dirs := g.dirs[0]
+	if len(g.dirs) > 1 {
+		dirs = fmt.Sprintf("%s with the caches %s", g.dirs[0], strings.Join(g.dirs[1:], ", "))
+	}
+	return fmt.Errorf("build aborted: %s grew to %d bytes, exceeding the limit of %d bytes",
+		dirs, g.size, g.maxBytes)
+}
+
+// diskGuardDirs returns the folders whose total size MaxDiskBytes
+// limits: the build environment in tempFolder and the GOPATH,
+// GOCACHE, and GOMODCACHE that Env sets, which are assumed to be
+// private to the build, as those of the builds of a Pool are. The
+// module cache of GoModCache is meant to be shared between builds,
+// so it is not counted, nor are the caches of the process
+// environment.
+func (b Builder) diskGuardDirs(tempFolder string) []string {
+	dirs := []string{tempFolder}
+	keys := []string{"GOPATH", "GOCACHE", "GOMODCACHE"}
+	if b.GoModCache != "" {
+		// which takes precedence over Env
+		keys = keys[:2]
+	}
+	for _, key := range keys {
+		dir := b.Env[key]
+		if list := filepath.SplitList(dir); key == "GOPATH" && len(list) > 0 {
+			// the first entry holds the module cache and binaries
+			dir = list[0]
+		}
+		if dir == "" || dir == "off" {
+			continue
+		}
+		if abs, err := filepath.Abs(dir); err == nil {
+			dir = abs
+		}
+		dirs = addDiskGuardDir(dirs, dir)
+	}
+	return dirs
+}
+
+// addDiskGuardDir adds dir to dirs, unless one of them contains it,
+// removing those that it contains, so that no file is counted twice.
+func addDiskGuardDir(dirs []string, dir string) []string {
+	kept := dirs[:0]
+	for _, d := range dirs {
+		if isWithin(d, dir) {
+			return dirs
+		}
+		if !isWithin(dir, d) {
+			kept = append(kept, d)
+		}
+	}
+	return append(kept, dir)
+}
+
+// isWithin reports whether path is dir or inside it.
+func isWithin(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// close stops the guard. It is safe to call on a nil guard.
+func (g *diskGuard) close() {
+	if g == nil {
+		return
+	}
+	g.stopOnce.Do(func() { close(g.stop) })
+}
+
+// dirSize returns the total size of the regular files in dir.
+// Files and directories that are removed while it walks dir, as
+// the go command does with its temporary files, are left out;
+// only if dir itself does not exist is the error one for which
+// os.IsNotExist is true.
+func dirSize(dir string) (int64, error) {
+	return fsSize(os.DirFS(dir))
+}
+
+// fsSize returns the total size of the regular files in fsys,
+// as dirSize does for a directory.
+func fsSize(fsys fs.FS) (int64, error) {
+	var size int64
+	err := fs.WalkDir(fsys, ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) && p != "." {
+				return nil
+			}
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		size += info.Size()
+		return nil
+	})
+	return size, err
+}
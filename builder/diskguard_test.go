@@ -0,0 +1,141 @@
+package builder
+
+import (
+	"context"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestMaxDiskBytes(t *testing.T) {
+	interval := diskGuardInterval
+	diskGuardInterval = 10 * time.Millisecond
+	defer func() { diskGuardInterval = interval }()
+
+	baseDir := testBaseModule(t)
+	for _, tt := range []struct {
+		name       string
+		maxBytes   int64
+		cacheBytes int64 // the size of a private GOCACHE, if positive
+		wantErr    bool
+	}{
+		{"build environment", 1, 0, true},
+		{"private GOCACHE", 32 << 20, 64 << 20, true},
+		{"under the limit", 32 << 20, 0, false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			b := testBuilder(t, baseDir)
+			b.MaxDiskBytes = tt.maxBytes
+			if tt.cacheBytes > 0 {
+				cache := t.TempDir()
+				f, err := os.Create(filepath.Join(cache, "filler"))
+				if err != nil {
+					t.Fatal(err)
+				}
+				err = f.Truncate(tt.cacheBytes)
+				f.Close()
+				if err != nil {
+					t.Fatal(err)
+				}
+				b.Env["GOCACHE"] = cache
+			}
+
+			output := filepath.Join(t.TempDir(), "goaway")
+			_, err := b.Build(context.Background(), output)
+			if tt.wantErr {
+				if err == nil || !strings.Contains(err.Error(), "exceeding the limit of") {
+					t.Fatalf("Build = %v, want the error of the exceeded disk limit", err)
+				}
+				if _, err := os.Stat(output); !os.IsNotExist(err) {
+					t.Errorf("aborted build wrote %s", output)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			if left := buildEnvFolders(t, b.WorkDir); len(left) > 0 {
+				t.Errorf("build environments were not removed: %v", left)
+			}
+		})
+	}
+}
+
+func TestDiskGuardDirs(t *testing.T) {
+	tempFolder := filepath.FromSlash("/work/buildenv_1")
+	for _, tt := range []struct {
+		name       string
+		env        map[string]string
+		goModCache string
+		want       []string
+	}{
+		{"no caches", nil, "", []string{"/work/buildenv_1"}},
+		{"pool", map[string]string{"GOPATH": "/work/pool_1/gopath", "GOCACHE": "/work/pool_1/gocache"}, "/shared/mod",
+			[]string{"/work/buildenv_1", "/work/pool_1/gopath", "/work/pool_1/gocache"}},
+		{"GOMODCACHE in Env", map[string]string{"GOMODCACHE": "/cache/mod"}, "",
+			[]string{"/work/buildenv_1", "/cache/mod"}},
+		{"GOMODCACHE replaced by GoModCache", map[string]string{"GOMODCACHE": "/cache/mod"}, "/shared/mod",
+			[]string{"/work/buildenv_1"}},
+		{"GOMODCACHE within GOPATH", map[string]string{"GOPATH": "/cache", "GOMODCACHE": "/cache/pkg/mod"}, "",
+			[]string{"/work/buildenv_1", "/cache"}},
+		{"GOPATH list", map[string]string{"GOPATH": "/cache" + string(filepath.ListSeparator) + "/other"}, "",
+			[]string{"/work/buildenv_1", "/cache"}},
+		{"build cache off", map[string]string{"GOCACHE": "off"}, "", []string{"/work/buildenv_1"}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			b := Builder{Env: tt.env}
+			b.GoModCache = tt.goModCache
+			var want []string
+			for _, dir := range tt.want {
+				abs, err := filepath.Abs(filepath.FromSlash(dir))
+				if err != nil {
+					t.Fatal(err)
+				}
+				want = append(want, abs)
+			}
+			if got := b.diskGuardDirs(tempFolder); !reflect.DeepEqual(got, want) {
+				t.Errorf("diskGuardDirs = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+// vanishingFS is a file system in which the directories
+// in gone are removed after their parents were read.
+type vanishingFS struct {
+	fstest.MapFS
+	gone map[string]bool
+}
+
+func (fsys vanishingFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	if fsys.gone[name] {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+	}
+	return fsys.MapFS.ReadDir(name)
+}
+
+func TestDirSizeWhileFilesVanish(t *testing.T) {
+	fsys := vanishingFS{
+		MapFS: fstest.MapFS{
+			"cache/download/a.zip":           {Data: make([]byte, 1000)},
+			"cache/download/tmp-123/partial": {Data: make([]byte, 500)},
+			"pkg/mod/b/b.go":                 {Data: make([]byte, 24)},
+		},
+		gone: map[string]bool{"cache/download/tmp-123": true},
+	}
+	size, err := fsSize(fsys)
+	if err != nil {
+		t.Fatalf("measuring while a directory vanishes: %v", err)
+	}
+	if size != 1024 {
+		t.Errorf("measured %d bytes, want the 1024 bytes that remain", size)
+	}
+
+	size, err = dirSize(filepath.Join(t.TempDir(), "missing"))
+	if !os.IsNotExist(err) || size != 0 {
+		t.Errorf("measuring a missing directory: %d bytes, %v, want an error that it does not exist", size, err)
+	}
+}
@@ -0,0 +1,284 @@
+package builder
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/mod/module"
+)
+
+// DownloadReport describes the module downloads of a
+// build, as collected with Builder.DownloadMetrics.
+type DownloadReport struct {
+	// The modules that were downloaded or requested from a
+	// module proxy, slowest first. Modules whose versions were
+	// only listed or queried have an empty Version.
+	Modules []ModuleDownload `json:"modules,omitempty"`
+
+	// The number of requests to module proxies, the time they
+	// took altogether, and the size of the downloaded zip files.
+	Requests int           `json:"requests,omitempty"`
+	Duration time.Duration `json:"duration,omitempty"`
+	Bytes    int64         `json:"bytes,omitempty"`
+
+	// The number of modules in the module graph, how many of
+	// them were found in the module cache rather than downloaded,
+	// and the fraction of them (0 to 1) that were.
+	GraphModules int     `json:"graph_modules,omitempty"`
+	CacheHits    int     `json:"cache_hits,omitempty"`
+	HitRate      float64 `json:"hit_rate,omitempty"`
+}
+
+// ModuleDownload describes the downloads of a module version.
+type ModuleDownload struct {
+	Path    string `json:"path"`
+	Version string `json:"version,omitempty"`
+
+	// The size of the module's zip file, or 0 if it
+	// was not downloaded or its size is unknown.
+	Bytes int64 `json:"bytes,omitempty"`
+
+	// The number of requests to module proxies for the module,
+	// such as for its .info, .mod, and .zip files, and the time
+	// they took. Both are 0 for modules fetched directly from
+	// version control or from file:// proxies, which the go
+	// command does not trace.
+	Requests int           `json:"requests,omitempty"`
+	Duration time.Duration `json:"duration,omitempty"`
+}
+
+func (m ModuleDownload) String() string {
+	if m.Version == "" {
+		return m.Path
+	}
+	return m.Path + "@" + m.Version
+}
+
+// slowestDownloads is how many of the slowest
+// downloads the summary of a DownloadReport names.
+const slowestDownloads = 5
+
+// proxyRequest is a request to a module proxy,
+// as traced by a go command run with -x.
+type proxyRequest struct {
+	url      string
+	ok       bool
+	duration time.Duration
+}
+
+// parseProxyRequest parses a line of the form
+// "# get URL: 200 OK (0.052s)", as printed by
+// go commands run with -x when a request ends.
+func parseProxyRequest(line string) (proxyRequest, bool) {
+	rest := strings.TrimPrefix(line, "# get ")
+	if rest == line || !strings.HasSuffix(rest, "s)") {
+		return proxyRequest{}, false
+	}
+	i := strings.LastIndex(rest, " (")
+	j := strings.Index(rest, ": ")
+	if i < 0 || j < 0 || j > i {
+		return proxyRequest{}, false
+	}
+	seconds, err := strconv.ParseFloat(rest[i+2:len(rest)-2], 64)
+	if err != nil {
+		return proxyRequest{}, false
+	}
+	return proxyRequest{
+		url:      rest[:j],
+		ok:       strings.HasPrefix(rest[j+2:], "2"),
+		duration: time.Duration(seconds * float64(time.Second)),
+	}, true
+}
+
+// downloadRecorder collects the downloads of the go
+// commands of a build environment for a DownloadReport.
+type downloadRecorder struct {
+	mu       sync.Mutex
+	modules  map[string]*ModuleDownload
+	requests int
+	duration time.Duration
+}
+
+func newDownloadRecorder() *downloadRecorder {
+	return &downloadRecorder{modules: make(map[string]*ModuleDownload)}
+}
+
+// module returns the download record of path at version.
+// The caller must hold d.mu.
+func (d *downloadRecorder) module(path, version string) *ModuleDownload {
+	key := path + "@" + version
+	m, ok := d.modules[key]
+	if !ok {
+		m = &ModuleDownload{Path: path, Version: version}
+		d.modules[key] = m
+	}
+	return m
+}
+
+// addFetched records the download of the module of e.
+// It does nothing if d is nil.
+func (d *downloadRecorder) addFetched(e ModuleFetched) {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.module(e.Path, e.Version).Bytes = e.Bytes
+}
+
+// addRequests records requests to the module proxies at the
+// URLs proxies. Failed requests, such as those for paths that
+// turn out not to be modules, and requests for other URLs, such
+// as those of the checksum database, only count toward the
+// totals. It does nothing if d is nil.
+func (d *downloadRecorder) addRequests(proxies []string, requests []proxyRequest) {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, r := range requests {
+		d.requests++
+		d.duration += r.duration
+		path, version, ok := proxyModule(proxies, r.url)
+		if !ok || !r.ok {
+			continue
+		}
+		m := d.module(path, version)
+		m.Requests++
+		m.Duration += r.duration
+	}
+}
+
+// proxyModule returns the module path and version that the
+// module proxy request for url is about. The version is empty
+// for requests that list versions or query the latest one.
+func proxyModule(proxies []string, url string) (string, string, bool) {
+	rest := url
+	for _, proxy := range proxies {
+		if prefix := strings.TrimSuffix(proxy, "/") + "/"; strings.HasPrefix(url, prefix) {
+			rest = strings.TrimPrefix(url, prefix)
+			break
+		}
+	}
+	if rest == url {
+		// an unknown proxy, presumably without a path
+		_, hostAndPath, ok := strings.Cut(url, "://")
+		if !ok {
+			return "", "", false
+		}
+		_, rest, _ = strings.Cut(hostAndPath, "/")
+	}
+	escPath, file, ok := strings.Cut(rest, "/@v/")
+	if !ok {
+		if !strings.HasSuffix(rest, "/@latest") {
+			return "", "", false
+		}
+		escPath = strings.TrimSuffix(rest, "/@latest")
+	}
+	path, err := module.UnescapePath(escPath)
+	if err != nil {
+		return "", "", false
+	}
+	var version string
+	for _, ext := range []string{".info", ".mod", ".zip"} {
+		if strings.HasSuffix(file, ext) {
+			if version, err = module.UnescapeVersion(strings.TrimSuffix(file, ext)); err != nil {
+				return "", "", false
+			}
+			break
+		}
+	}
+	return path, version, true
+}
+
+// proxyURLs returns the URLs of the module proxies
+// that the go commands of env use.
+func (env environment) proxyURLs() []string {
+	goProxy, ok := getEnv(env.environ(), "GOPROXY")
+	if !ok && env.executor == nil {
+		cmd := exec.Command(GetGo(), "env", "GOPROXY")
+		cmd.Env = env.environ()
+		if out, err := cmd.Output(); err == nil {
+			goProxy = strings.TrimSpace(string(out))
+		}
+	}
+	return strings.FieldsFunc(goProxy, func(r rune) bool { return r == ',' || r == '|' })
+}
+
+// downloadReport returns the DownloadReport of env,
+// which must have been created with download metrics.
+func (env environment) downloadReport(ctx context.Context) (*DownloadReport, error) {
+	modules, err := env.listModules(ctx)
+	if err != nil {
+		return nil, err
+	}
+	d := env.downloads
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	report := &DownloadReport{Requests: d.requests, Duration: d.duration}
+	for _, m := range d.modules {
+		report.Modules = append(report.Modules, *m)
+		report.Bytes += m.Bytes
+	}
+	sort.Slice(report.Modules, func(i, j int) bool {
+		mi, mj := report.Modules[i], report.Modules[j]
+		if mi.Duration != mj.Duration {
+			return mi.Duration > mj.Duration
+		}
+		return mi.String() < mj.String()
+	})
+	for _, m := range modules {
+		if m.Main || m.Replace != nil && m.Replace.Version == "" {
+			// not in the module cache
+			continue
+		}
+		report.GraphModules++
+		version := m.Version
+		if m.Replace != nil {
+			m.Path, version = m.Replace.Path, m.Replace.Version
+		}
+		if _, downloaded := d.modules[m.Path+"@"+version]; !downloaded {
+			report.CacheHits++
+		}
+	}
+	if report.GraphModules > 0 {
+		report.HitRate = float64(report.CacheHits) / float64(report.GraphModules)
+	}
+	return report, nil
+}
+
+// summary returns a one-line summary of r for the log.
+func (r DownloadReport) summary() string {
+	var slowest []string
+	for _, m := range r.Modules {
+		if len(slowest) == slowestDownloads || m.Duration == 0 {
+			break
+		}
+		slowest = append(slowest, fmt.Sprintf("%s (%s)", m, m.Duration.Round(time.Millisecond)))
+	}
+	s := fmt.Sprintf("%d request(s) to module proxies in %s, %d byte(s) of modules downloaded; %d of %d module(s) (%.0f%%) found in the module cache",
+		r.Requests, r.Duration.Round(time.Millisecond), r.Bytes, r.CacheHits, r.GraphModules, r.HitRate*100)
+	if len(slowest) > 0 {
+		s += "; slowest: " + strings.Join(slowest, ", ")
+	}
+	return s
+}
+
+// traceFetches adds -x to the go command cmd, after its
+// subcommand, so that it traces its module proxy requests.
+func traceFetches(cmd *exec.Cmd) {
+	for i := 1; i < len(cmd.Args); i++ {
+		switch cmd.Args[i] {
+		case "get", "list", "tidy", "download":
+			cmd.Args = append(cmd.Args[:i+1], append([]string{"-x"}, cmd.Args[i+1:]...)...)
+			return
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package builder
+
+import (
+	"bytes"
+	"fmt"
+	"go/token"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"golang.org/x/mod/module"
+)
+
+// EmbedMapping is a file or directory on the host
+// that Builder.Embeds puts into the binary.
+type EmbedMapping struct {
+	// Source is the file or directory to embed.
+	Source string `json:"source"`
+
+	// Target is its slash-separated path in the embedded file
+	// system, e.g. "Caddyfile" or "ui". Default: the base name
+	// of Source.
+	Target string `json:"target,omitempty"`
+}
+
+// target returns the path of m in the embedded file system.
+func (m EmbedMapping) target() string {
+	if m.Target != "" {
+		return m.Target
+	}
+	return filepath.Base(m.Source)
+}
+
+const (
+	// embedFolder is the folder in the build environment
+	// that the files of Builder.Embeds are copied to.
+	embedFolder = "embedded"
+
+	// embedVariable is the variable of the main package
+	// that holds the files of Builder.Embeds.
+	embedVariable = "embeddedFiles"
+)
+
+// embedTemplate generates embed.go, which embeds the files in
+// embedFolder into the main package and, if there is an embed
+// function, passes them to it before main runs.
+var embedTemplate = template.Must(template.New("embed").Parse(`package main
+
+import (
+	"embed"
+	"io/fs"
+	{{- if .Package}}
+
+	embedtarget "{{.Package}}"
+	{{- end}}
+)
+
+//go:embed all:` + embedFolder + `
+var embedded embed.FS
+
+// ` + embedVariable + ` are the files of the build configuration.
+var ` + embedVariable + ` fs.FS
+
+func init() {
+	var err error
+	` + embedVariable + `, err = fs.Sub(embedded, "` + embedFolder + `")
+	if err != nil {
+		panic(err)
+	}
+	{{- if .Func}}
+	embedtarget.{{.Func}}(` + embedVariable + `)
+	{{- end}}
+}
+`))
+
+// splitEmbedFunc splits the qualified name of the function that
+// receives the embedded files, e.g. github.com/acme/dist.SetFiles,
+// into its package path and name.
+func splitEmbedFunc(name string) (string, string, error) {
+	i := strings.LastIndex(name, ".")
+	if i < 0 || i < strings.LastIndex(name, "/") {
+		return "", "", fmt.Errorf("invalid embed function %q, expected a qualified name such as example.com/pkg.SetFiles", name)
+	}
+	pkg, fn := name[:i], name[i+1:]
+	if err := module.CheckImportPath(pkg); err != nil {
+		return "", "", fmt.Errorf("invalid embed function %q: %v", name, err)
+	}
+	if !token.IsIdentifier(fn) || !token.IsExported(fn) {
+		return "", "", fmt.Errorf("invalid embed function %q: %s is not an exported function name", name, fn)
+	}
+	return pkg, fn, nil
+}
+
+// checkEmbeds returns the problems with the embedded
+// files and the embed function of b.
+func (b Builder) checkEmbeds() []ValidationProblem {
+	v := &validator{}
+	targets := make(map[string]bool)
+	for i, m := range b.Embeds {
+		field := fmt.Sprintf("embeds[%d]", i)
+		if m.Source == "" {
+			v.add(field+".source", fmt.Errorf("source file or directory is required"))
+		} else if _, err := os.Stat(m.Source); err != nil {
+			v.add(field+".source", err)
+		}
+		target := m.target()
+		switch {
+		case !fs.ValidPath(target) || target == ".":
+			v.add(field+".target", fmt.Errorf("invalid path %q in the embedded files", target))
+		case targets[target]:
+			v.add(field+".target", fmt.Errorf("%s is already embedded", target))
+		}
+		targets[target] = true
+	}
+	if b.EmbedFunc != "" {
+		if len(b.Embeds) == 0 {
+			v.add("embed_func", fmt.Errorf("has no effect without embeds"))
+		} else if _, _, err := splitEmbedFunc(b.EmbedFunc); err != nil {
+			v.add("embed_func", err)
+		}
+	}
+	return v.problems
+}
+
+// writeEmbeds copies the files of b.Embeds into the build
+// environment in tempFolder and writes embed.go, which embeds
+// them into the main package, if there are any.
+func (b Builder) writeEmbeds(tempFolder string) error {
+	if len(b.Embeds) == 0 {
+		return nil
+	}
+	if problems := b.checkEmbeds(); len(problems) > 0 {
+		return &ValidationError{Problems: problems}
+	}
+	for _, m := range b.Embeds {
+		dst := filepath.Join(tempFolder, embedFolder, filepath.FromSlash(m.target()))
+		if err := copyTree(m.Source, dst); err != nil {
+			return fmt.Errorf("embedding %s: %v", m.Source, err)
+		}
+	}
+
+	var data struct{ Package, Func string }
+	if b.EmbedFunc != "" {
+		data.Package, data.Func, _ = splitEmbedFunc(b.EmbedFunc)
+	}
+	var buf bytes.Buffer
+	if err := embedTemplate.Execute(&buf, data); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(tempFolder, "embed.go"), buf.Bytes(), 0644)
+}
+
+// copyTree copies the file or directory src to dst. Symbolic
+// links are followed, as go:embed does not embed them.
+func copyTree(src, dst string) error {
+	info, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+			return err
+		}
+		return copyFile(src, dst, 0644)
+	}
+	return filepath.WalkDir(src, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, p)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		info, err := os.Stat(p)
+		if err != nil {
+			return err
+		}
+		switch {
+		case info.IsDir():
+			return os.MkdirAll(target, 0755)
+		case info.Mode().IsRegular():
+			return copyFile(p, target, 0644)
+		}
+		return fmt.Errorf("%s is not a regular file", path.Join(filepath.ToSlash(src), filepath.ToSlash(rel)))
+	})
+}
@@ -1,71 +1,163 @@
 package builder
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
-	"log"
+	"io"
+	"io/fs"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strings"
+	"text/template"
 	"time"
 
 	"github.com/google/shlex"
+	"golang.org/x/mod/modfile"
+	"golang.org/x/mod/module"
 )
 
-func (b Builder) newEnvironment(ctx context.Context) (*environment, error) {
-	caddyModulePath := "github.com/crackeer/goaway/server"
+func (b Builder) newEnvironment(ctx context.Context) (_ *environment, err error) {
+	ctx, span := b.instrumentation().StartSpan(ctx, SpanSetup)
+	defer func() { span.End(err) }()
+	caddyModulePath, _ := b.baseModule()
+	mainModulePath := b.mainModulePath()
+	if err := module.CheckImportPath(mainModulePath); err != nil {
+		return nil, fmt.Errorf("invalid main module path: %v", err)
+	}
+
 	// create the folder in which the build environment will operate
-	tempFolder, err := newTempFolder()
+	tempFolder, err := b.newBuildFolder()
 	if err != nil {
 		return nil, err
 	}
+	env := b.environmentFor(tempFolder, caddyModulePath)
+	if b.LogCapture != nil {
+		if err := env.startLogCapture(*b.LogCapture); err != nil {
+			_ = env.closeAfter(err)
+			return nil, err
+		}
+	}
+	env.diskGuard = startDiskGuard(ctx, b.diskGuardDirs(tempFolder), b.MaxDiskBytes, env.log)
+	env.emit(EnvCreated{Dir: tempFolder})
+	// ctx is limited by the phase timeouts below
+	parentCtx := ctx
+	var setupDeadline time.Time
+	defer func() {
+		if err != nil {
+			if !setupDeadline.IsZero() && !time.Now().Before(setupDeadline) && isCanceled(err) && parentCtx.Err() == nil {
+				err = &TimeoutError{Phase: "setup", Timeout: env.timeouts.Setup}
+			}
+			err = env.finish(parentCtx, err)
+			var getErr *GoGetError
+			if !errors.As(err, &getErr) {
+				err = wrapError(err, func(err error) error { return &SetupError{Err: err} })
+			}
+		}
+	}()
+	if b.Credentials != nil {
+		env.credentialEnv, env.credentialUnset, err = b.Credentials.write(filepath.Join(tempFolder, credentialsFolder))
+		if err != nil {
+			return nil, fmt.Errorf("writing credentials: %v", err)
+		}
+	}
+	if env.timeouts.Setup > 0 {
+		setupDeadline = time.Now().Add(env.timeouts.Setup)
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, setupDeadline)
+		defer cancel()
+	}
 
-	// write the main module file to temporary folder
-	mainPath := filepath.Join(tempFolder, "main.go")
+	// commands are only run through the executor when building;
+	// plans show the commands as they would run on the host
+	if executor := b.executor(); executor != nil && env.plan == nil {
+		inputs, err := b.localReplacementDirs()
+		if err != nil {
+			return nil, err
+		}
+		env.executor, err = executor.Start(ctx, tempFolder, inputs, env.goEnv)
+		if err != nil {
+			return nil, fmt.Errorf("starting executor: %v", err)
+		}
+	}
 
-	var newMainContent string = mainModuleTemplate
-	/*
-		for key := range b.ExtraInput {
-			newMainContent = strings.ReplaceAll(newMainContent, key, util.GetStringValFromMap(b.ExtraInput, key))
-		}*/
+	if b.GoVersion != "" {
+		if err := env.useToolchain(ctx, goToolchain(b.GoVersion)); err != nil {
+			return nil, err
+		}
+	}
 
-	err = os.WriteFile(mainPath, []byte(newMainContent), 0644)
+	// write the main module file to temporary folder
+	mainContent, err := b.mainFileContent()
 	if err != nil {
 		return nil, err
 	}
-
-	env := &environment{
-		caddyVersion:    b.CaddyVersion,
-		caddyModulePath: caddyModulePath,
-		tempFolder:      tempFolder,
-		timeoutGoGet:    b.TimeoutGet,
-		skipCleanup:     b.SkipCleanup,
-		buildFlags:      b.BuildFlags,
-		modFlags:        b.ModFlags,
+	mainPath := filepath.Join(tempFolder, "main.go")
+	err = os.WriteFile(mainPath, mainContent, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := b.writeEmbeds(tempFolder); err != nil {
+		return nil, err
 	}
 
 	// initialize the go module
-	log.Println("[INFO] Initializing Go module")
+	env.log.Printf("[INFO] Initializing Go module")
 	cmd := env.newGoModCommand(ctx, "init")
-	cmd.Args = append(cmd.Args, "goaway")
+	cmd.Args = append(cmd.Args, mainModulePath)
 	err = env.runCommand(ctx, cmd)
 	if err != nil {
 		return nil, err
 	}
+	if b.GoDirective != "" {
+		env.log.Printf("[INFO] Setting go directive to %s", b.GoDirective)
+		if err := env.setGoDirective(ctx, b.GoDirective); err != nil {
+			return nil, err
+		}
+	}
+
+	if b.Lock != nil {
+		if err := env.writeLockedSums(b.Lock); err != nil {
+			return nil, err
+		}
+	}
+	if len(b.Workspace) > 0 {
+		env.log.Printf("[INFO] Creating Go workspace")
+		if err := env.useWorkspace(ctx, b.Workspace); err != nil {
+			return nil, err
+		}
+	}
 
 	// specify module replacements before pinning versions
 	replaced := make(map[string]string)
 	for _, r := range b.Replacements {
-		log.Printf("[INFO] Replace %s => %s", r.Old.String(), r.New.String())
-		cmd := env.newGoModCommand(ctx, "edit",
-			"-replace", fmt.Sprintf("%s=%s", r.Old.Param(), r.New.Param()))
-		err := env.runCommand(ctx, cmd)
-		if err != nil {
+		if r.New.IsLocal() {
+			absPath, err := checkLocalReplacement(r)
+			if err != nil {
+				return nil, err
+			}
+			r.New = ReplacementPath(absPath)
+		} else if path, version := r.New.split(); version != "" {
+			// go.mod only holds concrete versions, so
+			// resolve branch names and commit hashes
+			resolved, err := env.resolveVersion(ctx, path, version)
+			if err != nil {
+				return nil, err
+			}
+			env.recordResolved(path, version, resolved)
+			r.New = ReplacementPath(path + " " + resolved)
+		}
+		env.log.Printf("[INFO] Replace %s => %s", r.Old.String(), r.New.String())
+		if err := env.addReplace(ctx, r); err != nil {
 			return nil, err
 		}
 		replaced[r.Old.String()] = r.New.String()
 	}
+	if err := env.excludeAndPin(ctx, b.Excludes, b.Pins); err != nil {
+		return nil, err
+	}
 
 	// check for early abort
 	select {
@@ -75,21 +167,69 @@ func (b Builder) newEnvironment(ctx context.Context) (*environment, error) {
 	}
 
 	// The timeout for the `go get` command may be different than `go build`,
-	// so create a new context with the timeout for `go get`
+	// so if one is set, limit `go get` by it as well; ctx still carries the
+	// deadline of the caller and the setup timeout, and the earliest wins
 	if env.timeoutGoGet > 0 {
 		var cancel context.CancelFunc
-		ctx, cancel = context.WithTimeout(context.Background(), env.timeoutGoGet)
+		ctx, cancel = context.WithTimeout(ctx, env.timeoutGoGet)
 		defer cancel()
 	}
 
 	// pin versions by populating go.mod, first for Caddy itself and then plugins
-	log.Println("[INFO] Pinning versions")
-
-	err = env.execGoGet(ctx, caddyModulePath, env.caddyVersion, "", "")
+	env.log.Printf("[INFO] Pinning versions")
+
+	caddyPinVersion := env.caddyVersion
+	if caddyModule, ok := localReplacementFor(caddyModulePath, b.Replacements); ok {
+		// Caddy itself is replaced by a local checkout, so there is no
+		// version to get; require a placeholder version instead, which
+		// the replacement takes precedence over
+		env.log.Printf("[INFO] Using local checkout of %s instead of a versioned module", caddyModule)
+		err = env.requirePlaceholder(ctx, caddyModule)
+		caddyPinVersion = ""
+	} else if caddyModule, ok := env.workspaceModuleFor(caddyModulePath); ok {
+		// the workspace provides Caddy itself
+		env.log.Printf("[INFO] Using workspace module %s instead of a versioned module", caddyModule)
+		caddyPinVersion = ""
+	} else {
+		resolved, err := env.resolveVersion(ctx, caddyModulePath, env.caddyVersion)
+		if err != nil {
+			return nil, err
+		}
+		env.recordResolved(caddyModulePath, env.caddyVersion, resolved)
+		env.caddyVersion, caddyPinVersion = resolved, resolved
+		err = env.execGoGet(ctx, caddyModulePath, env.caddyVersion, "", "")
+	}
 	if err != nil {
 		return nil, err
 	}
 
+	if err := env.prefetchPlugins(ctx, b, caddyModulePath, caddyPinVersion); err != nil {
+		return nil, err
+	}
+	for _, p := range b.Plugins {
+		if pluginModule, ok := localReplacementFor(p.PackagePath, b.Replacements); ok {
+			env.log.Printf("[INFO] Using local checkout of %s", pluginModule)
+			err = env.requirePlaceholder(ctx, pluginModule)
+		} else if pluginModule, ok := env.workspaceModuleFor(p.PackagePath); ok {
+			env.log.Printf("[INFO] Using workspace module %s", pluginModule)
+			continue
+		} else if caddyPinVersion != "" {
+			// also pass the Caddy version to prevent it from being upgraded
+			err = env.execGoGet(ctx, p.PackagePath, p.Version, caddyModulePath, caddyPinVersion)
+		} else {
+			err = env.execGoGet(ctx, p.PackagePath, p.Version, "", "")
+		}
+		if err != nil {
+			return nil, err
+		}
+		// check for early abort
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		default:
+		}
+	}
+
 	// doing an empty "go get -d" can potentially resolve some
 	// ambiguities introduced by one of the plugins;
 	// see https://github.com/caddyserver/xcaddy/pull/92
@@ -98,36 +238,438 @@ func (b Builder) newEnvironment(ctx context.Context) (*environment, error) {
 		return nil, err
 	}
 
-	log.Println("[INFO] Build environment ready")
+	if err := env.recordResolvedPlugins(ctx, b.Plugins); err != nil {
+		return nil, err
+	}
+
+	if b.Generate || len(b.GeneratePackages) > 0 {
+		if err := env.generate(ctx, b.GeneratePackages, b.Replacements); err != nil {
+			return nil, err
+		}
+	}
+
+	env.log.Printf("[INFO] Build environment ready")
+	return env, nil
+}
+
+// newModuleEnvironment prepares a build environment from a
+// provided go.mod and go.sum instead of resolving dependencies.
+func (b Builder) newModuleEnvironment(ctx context.Context, goMod, goSum []byte) (_ *environment, err error) {
+	ctx, span := b.instrumentation().StartSpan(ctx, SpanSetup)
+	defer func() { span.End(err) }()
+	tempFolder, err := b.newBuildFolder()
+	if err != nil {
+		return nil, err
+	}
+	caddyModulePath, _ := b.baseModule()
+	env := b.environmentFor(tempFolder, caddyModulePath)
+	if b.LogCapture != nil {
+		if err := env.startLogCapture(*b.LogCapture); err != nil {
+			_ = env.closeAfter(err)
+			return nil, err
+		}
+	}
+	env.diskGuard = startDiskGuard(ctx, b.diskGuardDirs(tempFolder), b.MaxDiskBytes, env.log)
+	env.emit(EnvCreated{Dir: tempFolder})
+
+	mainContent, err := b.mainFileContent()
+	if err != nil {
+		_ = env.closeAfter(err)
+		return nil, err
+	}
+
+	files := []struct {
+		name    string
+		content []byte
+	}{
+		{"main.go", mainContent},
+		{"go.mod", goMod},
+		{"go.sum", goSum},
+	}
+	for _, f := range files {
+		err := os.WriteFile(filepath.Join(tempFolder, f.name), f.content, 0644)
+		if err != nil {
+			_ = env.closeAfter(err)
+			return nil, err
+		}
+	}
+	if err := b.writeEmbeds(tempFolder); err != nil {
+		_ = env.closeAfter(err)
+		return nil, err
+	}
+	if b.GoVersion != "" {
+		if err := env.useToolchain(ctx, goToolchain(b.GoVersion)); err != nil {
+			_ = env.closeAfter(err)
+			return nil, err
+		}
+	}
+
+	env.log.Printf("[INFO] Build environment ready")
 	return env, nil
 }
 
+func (b Builder) environmentFor(tempFolder, caddyModulePath string) *environment {
+	// the policy was validated when tempFolder was created
+	cleanup, _ := b.cleanupPolicy()
+	env := &environment{
+		caddyVersion:      b.CaddyVersion,
+		caddyModulePath:   caddyModulePath,
+		tempFolder:        tempFolder,
+		timeoutGoGet:      b.TimeoutGet,
+		cleanup:           cleanup,
+		gracePeriod:       b.GracePeriod,
+		timeouts:          b.Timeouts.effective(),
+		fetchRetries:      b.FetchRetries,
+		fetchRetryBackoff: b.FetchRetryBackoff,
+		buildFlags:        b.BuildFlags,
+		modFlags:          b.ModFlags,
+		vendor:            b.Vendor,
+		goEnv:             b.goEnv(),
+		unsetEnv:          b.UnsetEnv,
+		sandboxEnv:        b.SandboxEnv,
+		log:               b.logger(),
+		stdout:            b.Stdout,
+		stderr:            b.Stderr,
+		plan:              b.plan,
+		onEvent:           b.OnEvent,
+		instr:             b.Instrumentation,
+	}
+	if b.DownloadMetrics {
+		env.downloads = newDownloadRecorder()
+	}
+	if env.gracePeriod <= 0 {
+		env.gracePeriod = defaultGracePeriod
+	}
+	if env.fetchRetryBackoff <= 0 {
+		env.fetchRetryBackoff = defaultFetchRetryBackoff
+	}
+	if env.stdout == nil {
+		env.stdout = os.Stdout
+	}
+	if env.stderr == nil {
+		env.stderr = os.Stderr
+	}
+	return env
+}
+
+// goEnv returns the key=value pairs that the builder
+// sets for every go command it runs.
+func (b Builder) goEnv() []string {
+	var vars []string
+	for _, key := range sortedKeys(b.Env) {
+		vars = append(vars, key+"="+b.Env[key])
+	}
+	if b.GoExperiment != "" {
+		vars = append(vars, "GOEXPERIMENT="+b.GoExperiment)
+	}
+	goProxy := b.GoProxy
+	if goProxy == "" && len(b.GoProxies) > 0 {
+		goProxy = goProxyList(b.GoProxies)
+	}
+	for _, v := range []struct{ key, value string }{
+		{"GOPROXY", goProxy},
+		{"GONOPROXY", b.GoNoProxy},
+		{"GOPRIVATE", b.GoPrivate},
+		{"GONOSUMDB", b.GoNoSumDB},
+		{"GOSUMDB", b.GoSumDB},
+		{"GOINSECURE", b.GoInsecure},
+		{"GOFLAGS", b.GoFlags},
+	} {
+		if v.value != "" {
+			vars = append(vars, v.key+"="+v.value)
+		}
+	}
+	if b.GoModCache != "" {
+		// the go command requires an absolute path
+		modCache := b.GoModCache
+		if abs, err := filepath.Abs(modCache); err == nil {
+			modCache = abs
+		}
+		vars = append(vars, "GOMODCACHE="+modCache)
+	}
+	if b.GoVersion != "" {
+		vars = append(vars, "GOTOOLCHAIN="+goToolchain(b.GoVersion))
+	}
+	if b.Offline {
+		goFlags := strings.TrimSpace("-mod=mod " + b.GoFlags)
+		vars = append(vars, "GOPROXY=off", "GOFLAGS="+goFlags)
+	}
+	return vars
+}
+
+// newBuildFolder creates the temporary folder for a build
+// environment of b, first removing stale folders if the
+// cleanup policy asks for it.
+func (b Builder) newBuildFolder() (string, error) {
+	policy, err := b.cleanupPolicy()
+	if err != nil {
+		return "", err
+	}
+	if policy == CleanupMaxAge {
+		removed, err := CleanStale(b.WorkDir, b.CleanupMaxAge)
+		if err != nil {
+			b.logger().Printf("[WARNING] Removing stale build environments: %v", err)
+		}
+		for _, path := range removed {
+			b.logger().Printf("[INFO] Removed stale build environment: %s", path)
+		}
+	}
+	return newTempFolder(b.WorkDir)
+}
+
+// checkLocalReplacement returns the absolute path of the local
+// directory that r replaces its module with, after checking that
+// the directory contains a go.mod file for the replaced module, so
+// that mistakes in r are not only reported by `go mod tidy`.
+func checkLocalReplacement(r Replace) (string, error) {
+	// the go command runs in the temporary folder, so
+	// relative directories must be made absolute
+	absPath, err := filepath.Abs(r.New.String())
+	if err != nil {
+		return "", err
+	}
+	goModPath := filepath.Join(absPath, "go.mod")
+	goMod, err := os.ReadFile(goModPath)
+	if err != nil {
+		return "", fmt.Errorf("replacement for %s: %v", r.Old, err)
+	}
+	modulePath := modfile.ModulePath(goMod)
+	if modulePath == "" {
+		return "", fmt.Errorf("replacement for %s: no module directive in %s", r.Old, goModPath)
+	}
+	if modulePath != r.Old.ModulePath() {
+		return "", fmt.Errorf("replacement for %s: %s declares module %s", r.Old, goModPath, modulePath)
+	}
+	return absPath, nil
+}
+
+// localReplacementFor returns the module path of the replacement
+// in replacements that points packagePath at a local directory.
+func localReplacementFor(packagePath string, replacements []Replace) (string, bool) {
+	for _, r := range replacements {
+		mod := r.Old.ModulePath()
+		if r.New.IsLocal() && (packagePath == mod || strings.HasPrefix(packagePath, mod+"/")) {
+			return mod, true
+		}
+	}
+	return "", false
+}
+
+// mainModulePath returns the module path
+// to use for the generated main module.
+func (b Builder) mainModulePath() string {
+	if b.MainModulePath != "" {
+		return b.MainModulePath
+	}
+	return defaultMainModulePath
+}
+
 type environment struct {
-	caddyVersion    string
-	caddyModulePath string
-	tempFolder      string
-	timeoutGoGet    time.Duration
-	skipCleanup     bool
-	buildFlags      string
-	modFlags        string
+	caddyVersion      string
+	caddyModulePath   string
+	tempFolder        string
+	timeoutGoGet      time.Duration
+	cleanup           CleanupPolicy
+	gracePeriod       time.Duration
+	timeouts          Timeouts
+	fetchRetries      int
+	fetchRetryBackoff time.Duration
+	buildFlags        string
+	modFlags          string
+	vendor            bool
+	diskGuard         *diskGuard
+	goEnv             []string
+	credentialEnv     []string
+	unsetEnv          []string
+	sandboxEnv        bool
+	credentialUnset   []string
+	executor          ExecutorSession
+	resolved          []ResolvedVersion
+	conflicts         []ModuleConflict
+	licenses          *LicenseReport
+	findings          []Finding
+	pins              []Dependency
+	workspace         []string
+	plan              *Plan
+	onEvent           func(Event)
+	downloads         *downloadRecorder
+	instr             Instrumentation
+	capture           *capturedLog
+	tailLines         int
+	log               Logger
+	stdout            io.Writer
+	stderr            io.Writer
 }
 
 // Close cleans up the build environment, including deleting
-// the temporary folder from the disk.
+// the temporary folder from the disk, unless the cleanup
+// policy keeps it.
 func (env environment) Close() error {
-	if env.skipCleanup {
-		log.Printf("[INFO] Skipping cleanup as requested; leaving folder intact: %s", env.tempFolder)
+	return env.closeAfter(nil)
+}
+
+// closeAfter cleans up the build environment like Close after
+// a build that ended with err, which may be nil for success.
+// Unless the cleanup policy is CleanupNever, the folder of a
+// canceled build is always removed.
+func (env environment) closeAfter(err error) error {
+	if env.capture != nil {
+		defer env.capture.close()
+	}
+	env.diskGuard.close()
+	if env.executor != nil {
+		if err := env.executor.Close(context.Background()); err != nil {
+			env.log.Printf("[WARNING] Closing executor: %v", err)
+		}
+	}
+	keep := env.cleanup.keepFolder(err)
+	if env.cleanup != CleanupNever && isCanceled(err) {
+		keep = false
+	}
+	if keep && !env.diskGuard.isTripped() {
+		// credentials are not left on the disk
+		if err := os.RemoveAll(filepath.Join(env.tempFolder, credentialsFolder)); err != nil {
+			env.log.Printf("[WARNING] Removing credentials: %v", err)
+		}
+		env.log.Printf("[INFO] Skipping cleanup as requested; leaving folder intact: %s", env.tempFolder)
 		return nil
 	}
-	log.Printf("[INFO] Cleaning up temporary folder: %s", env.tempFolder)
+	env.log.Printf("[INFO] Cleaning up temporary folder: %s", env.tempFolder)
 	return os.RemoveAll(env.tempFolder)
 }
 
+// finish closes env after a build that ended with err and returns
+// err, or the error of ctx if the build presumably failed because
+// ctx was canceled, so that cancellation is reported as such
+// rather than as whichever step failed because of it.
+func (env environment) finish(ctx context.Context, err error) error {
+	if err != nil && ctx.Err() != nil {
+		err = ctx.Err()
+	}
+	_ = env.closeAfter(err)
+	return err
+}
+
+// isCanceled returns true if err is the error
+// of a context that was canceled or timed out.
+func isCanceled(err error) bool {
+	return errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
+
+// tidy tidies the module to ensure go.mod and
+// go.sum are consistent with the module prereq.
+// It first checks that the Go toolchain is new
+// enough for every module in the module graph.
+func (env environment) tidy(ctx context.Context) error {
+	if err := env.checkGoVersion(ctx); err != nil {
+		return err
+	}
+	if len(env.workspace) > 0 {
+		// go mod tidy ignores go.work and would look for the
+		// workspace modules elsewhere; go get has already
+		// required everything else
+		env.log.Printf("[INFO] Not tidying the main module of a workspace")
+		return nil
+	}
+	env.emit(TidyStarted{})
+	tidyCtx, cancel := withPhaseTimeout(ctx, env.timeouts.Tidy)
+	defer cancel()
+	tidyCtx, span := env.instrumentation().StartSpan(tidyCtx, SpanTidy)
+	stderr, err := env.runFetchCommand(tidyCtx, func() *exec.Cmd {
+		return env.newGoModCommand(tidyCtx, "tidy", "-e")
+	})
+	err = phaseError(ctx, tidyCtx, "tidy", env.timeouts.Tidy, err)
+	span.End(err)
+	if err != nil {
+		return wrapError(err, func(err error) error {
+			return &TidyError{Stderr: stderr, Err: err}
+		})
+	}
+	return env.vendorModules(ctx)
+}
+
+// vendorModules runs `go mod vendor` if env is to be
+// built from vendored dependencies.
+func (env environment) vendorModules(ctx context.Context) error {
+	if !env.vendor {
+		return nil
+	}
+	env.log.Printf("[INFO] Vendoring dependencies")
+	cmd := env.newGoModCommand(ctx, "vendor")
+	if err := env.runCommand(ctx, cmd); err != nil {
+		return fmt.Errorf("vendoring dependencies: %v", err)
+	}
+	return nil
+}
+
+// verifyTidy runs `go mod tidy` and returns an error if it changed
+// go.mod or go.sum from the given original contents. Like tidy, it
+// first checks the Go version requirements of the module graph.
+func (env environment) verifyTidy(ctx context.Context, goMod, goSum []byte) error {
+	if err := env.checkGoVersion(ctx); err != nil {
+		return err
+	}
+	env.emit(TidyStarted{})
+	tidyCtx, cancel := withPhaseTimeout(ctx, env.timeouts.Tidy)
+	defer cancel()
+	tidyCtx, span := env.instrumentation().StartSpan(tidyCtx, SpanTidy)
+	stderr, err := env.runFetchCommand(tidyCtx, func() *exec.Cmd {
+		return env.newGoModCommand(tidyCtx, "tidy")
+	})
+	err = phaseError(ctx, tidyCtx, "tidy", env.timeouts.Tidy, err)
+	span.End(err)
+	if err != nil {
+		return wrapError(err, func(err error) error {
+			return &TidyError{Stderr: stderr, Err: err}
+		})
+	}
+	for name, original := range map[string][]byte{"go.mod": goMod, "go.sum": goSum} {
+		tidied, err := os.ReadFile(filepath.Join(env.tempFolder, name))
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(bytes.TrimSpace(tidied), bytes.TrimSpace(original)) {
+			return fmt.Errorf("provided %s is not tidy; run 'go mod tidy' and try again", name)
+		}
+	}
+	return env.vendorModules(ctx)
+}
+
+// environ returns the environment for commands run in env:
+// the current process environment with env's settings applied.
+func (env environment) environ() []string {
+	vars := processEnv(env.sandboxEnv)
+	for _, keys := range [][]string{env.unsetEnv, env.credentialUnset} {
+		for _, key := range keys {
+			vars = unsetEnv(vars, key)
+		}
+	}
+	for _, kv := range env.goEnv {
+		vars = setEnv(vars, kv)
+	}
+	for _, kv := range env.credentialEnv {
+		vars = setEnv(vars, kv)
+	}
+	if len(env.workspace) > 0 {
+		// rather than any go.work of the process
+		vars = setEnv(vars, "GOWORK="+filepath.Join(env.tempFolder, workspaceFile))
+	}
+	return vars
+}
+
+// newCommand creates a command that runs in env. It must be run
+// with runCommand, which terminates it when ctx is done.
 func (env environment) newCommand(ctx context.Context, command string, args ...string) *exec.Cmd {
-	cmd := exec.CommandContext(ctx, command, args...)
+	cmd := exec.Command(command, args...)
+	if env.executor != nil {
+		// the command need not exist on the host
+		cmd = &exec.Cmd{Path: command, Args: append([]string{command}, args...)}
+	}
+	setProcessGroup(cmd)
 	cmd.Dir = env.tempFolder
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+	cmd.Env = env.environ()
+	cmd.Stdout = env.stdout
+	cmd.Stderr = env.stderr
 	return cmd
 }
 
@@ -135,7 +677,7 @@ func (env environment) newCommand(ctx context.Context, command string, args ...s
 // created command will also have the value of `XCADDY_GO_BUILD_FLAGS` appended to its arguments, if set.
 func (env environment) newGoBuildCommand(ctx context.Context, args ...string) *exec.Cmd {
 	cmd := env.newCommand(ctx, GetGo(), args...)
-	return parseAndAppendFlags(cmd, env.buildFlags)
+	return parseAndAppendFlags(cmd, env.buildFlags, env.log)
 }
 
 // newGoModCommand creates a new *exec.Cmd which assumes `args` are the args for `go mod` command. The
@@ -143,17 +685,17 @@ func (env environment) newGoBuildCommand(ctx context.Context, args ...string) *e
 func (env environment) newGoModCommand(ctx context.Context, args ...string) *exec.Cmd {
 	args = append([]string{"mod"}, args...)
 	cmd := env.newCommand(ctx, GetGo(), args...)
-	return parseAndAppendFlags(cmd, env.modFlags)
+	return parseAndAppendFlags(cmd, env.modFlags, env.log)
 }
 
-func parseAndAppendFlags(cmd *exec.Cmd, flags string) *exec.Cmd {
+func parseAndAppendFlags(cmd *exec.Cmd, flags string, logger Logger) *exec.Cmd {
 	if strings.TrimSpace(flags) == "" {
 		return cmd
 	}
 
 	fs, err := shlex.Split(flags)
 	if err != nil {
-		log.Printf("[ERROR] Splitting arguments failed: %s", flags)
+		logger.Printf("[ERROR] Splitting arguments failed: %s", flags)
 		return cmd
 	}
 	cmd.Args = append(cmd.Args, fs...)
@@ -161,17 +703,44 @@ func parseAndAppendFlags(cmd *exec.Cmd, flags string) *exec.Cmd {
 	return cmd
 }
 
-func (env environment) runCommand(ctx context.Context, cmd *exec.Cmd) error {
+func (env environment) runCommand(ctx context.Context, cmd *exec.Cmd) (err error) {
 	deadline, ok := ctx.Deadline()
 	var timeout time.Duration
 	// context doesn't necessarily have a deadline
 	if ok {
 		timeout = time.Until(deadline)
 	}
-	log.Printf("[INFO] exec (timeout=%s): %+v ", timeout, cmd)
+	if env.executor != nil {
+		if err := env.executor.Upload(ctx); err != nil {
+			return err
+		}
+		if err := env.executor.Wrap(cmd, env.executorVars(cmd)); err != nil {
+			return err
+		}
+		defer func() {
+			if downloadErr := env.executor.Download(ctx); err == nil {
+				err = downloadErr
+			}
+		}()
+	}
+	env.log.Printf("[INFO] exec (timeout=%s): %+v ", timeout, cmd)
+
+	if env.plan != nil {
+		env.plan.record(cmd)
+		return nil
+	}
+
+	if env.diskGuard.isTripped() {
+		return env.diskGuard.err()
+	}
+	if env.onEvent != nil || env.downloads != nil || env.instr != nil {
+		fetches := &fetchWriter{w: cmd.Stderr}
+		cmd.Stderr = fetches
+		defer env.reportFetched(ctx, fetches)
+	}
 
 	// start the command; if it fails to start, report error immediately
-	err := cmd.Start()
+	err = cmd.Start()
 	if err != nil {
 		return err
 	}
@@ -191,20 +760,57 @@ func (env environment) runCommand(ctx context.Context, cmd *exec.Cmd) error {
 	// channel is closed -- whichever comes first
 	select {
 	case cmdErr := <-cmdErrChan:
-		// process ended; report any error immediately
+		// process ended; report any error immediately, unless
+		// it presumably failed because the context was canceled
+		if cmdErr != nil && ctx.Err() != nil {
+			return ctx.Err()
+		}
 		return cmdErr
 	case <-ctx.Done():
 		// context was canceled, either due to timeout or
 		// maybe a signal from higher up canceled the parent
-		// context; presumably, the OS also sent the signal
-		// to the child process, so wait for it to die
+		// context; interrupt the process and its children,
+		// and kill them if they don't exit in time
+		if err := interruptProcessGroup(cmd); err != nil {
+			_ = killProcessGroup(cmd)
+		}
 		select {
-		case <-time.After(15 * time.Second):
-			_ = cmd.Process.Kill()
+		case <-time.After(env.gracePeriod):
+			env.log.Printf("[WARNING] Killing %s, which did not exit within %s", cmd.Path, env.gracePeriod)
+			_ = killProcessGroup(cmd)
+			<-cmdErrChan
 		case <-cmdErrChan:
 		}
+		// children may outlive the process itself
+		_ = killProcessGroup(cmd)
 		return ctx.Err()
+	case <-env.diskGuard.exceeded():
+		// the build environment grew too large; there's
+		// no point in letting the command finish
+		_ = killProcessGroup(cmd)
+		<-cmdErrChan
+		return env.diskGuard.err()
+	}
+}
+
+// copyPGOProfile copies the profile at path into env as
+// default.pgo and returns the absolute path of the copy.
+func (env environment) copyPGOProfile(path string) (string, error) {
+	profile, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("reading PGO profile: %v", err)
 	}
+	dest := filepath.Join(env.tempFolder, "default.pgo")
+	if err := os.WriteFile(dest, profile, 0644); err != nil {
+		return "", err
+	}
+	return dest, nil
+}
+
+// requirePlaceholder adds a requirement on modulePath at a placeholder
+// version, for use when the module is replaced by a local directory.
+func (env environment) requirePlaceholder(ctx context.Context, modulePath string) error {
+	return env.addRequire(ctx, modulePath, placeholderVersion)
 }
 
 // execGoGet runs "go get -d -v" with the given module/version as an argument.
@@ -214,6 +820,12 @@ func (env environment) runCommand(ctx context.Context, cmd *exec.Cmd) error {
 // version requires a newer version of Caddy.
 // See https://github.com/caddyserver/xcaddy/issues/54
 func (env environment) execGoGet(ctx context.Context, modulePath, moduleVersion, caddyModulePath, caddyVersion string) error {
+	return env.goGet(ctx, "", modulePath, moduleVersion, caddyModulePath, caddyVersion)
+}
+
+// goGet is execGoGet in the module in dir, which
+// is outside of any workspace, if dir is not empty.
+func (env environment) goGet(ctx context.Context, dir, modulePath, moduleVersion, caddyModulePath, caddyVersion string) error {
 	mod := modulePath
 	if moduleVersion != "" {
 		mod += "@" + moduleVersion
@@ -223,27 +835,128 @@ func (env environment) execGoGet(ctx context.Context, modulePath, moduleVersion,
 		caddy += "@" + caddyVersion
 	}
 
-	cmd := env.newGoBuildCommand(ctx, "get", "-d", "-v")
-	// using an empty string as an additional argument to "go get"
-	// breaks the command since it treats the empty string as a
-	// distinct argument, so we're using an if statement to avoid it.
-	if caddy != "" {
-		cmd.Args = append(cmd.Args, mod, caddy)
-	} else {
-		cmd.Args = append(cmd.Args, mod)
+	getCtx, cancel := withPhaseTimeout(ctx, env.timeouts.GoGet)
+	defer cancel()
+	getCtx, span := env.instrumentation().StartSpan(getCtx, SpanGoGet,
+		Attribute{Key: "module", Value: modulePath}, Attribute{Key: "version", Value: moduleVersion})
+	stderr, err := env.runFetchCommand(getCtx, func() *exec.Cmd {
+		cmd := env.newGoBuildCommand(getCtx, "get", "-d", "-v")
+		if dir != "" {
+			cmd.Dir = dir
+			cmd.Env = setEnv(cmd.Env, "GOWORK=off")
+		}
+		// using an empty string as an additional argument to "go get"
+		// breaks the command since it treats the empty string as a
+		// distinct argument, so we're using an if statement to avoid it.
+		if caddy != "" {
+			cmd.Args = append(cmd.Args, mod, caddy)
+		} else {
+			cmd.Args = append(cmd.Args, mod)
+		}
+		for _, pin := range env.pins {
+			cmd.Args = append(cmd.Args, pin.PackagePath+"@"+pin.Version)
+		}
+		return cmd
+	})
+	err = phaseError(ctx, getCtx, "go get "+strings.TrimSpace(mod+" "+caddy), env.timeouts.GoGet, err)
+	span.End(err)
+	return wrapError(err, func(err error) error {
+		return &GoGetError{Module: modulePath, Version: moduleVersion, Stderr: stderr, Err: err}
+	})
+}
+
+// baseModule returns the path of the module that is built at
+// CaddyVersion and the import path of its package providing Main.
+func (b Builder) baseModule() (modulePath, mainPackage string) {
+	modulePath, mainPackage = b.BaseModule, b.MainPackage
+	switch {
+	case modulePath == "" && mainPackage == "":
+		return defaultBaseModule, defaultMainPackage
+	case modulePath == "":
+		return mainPackage, mainPackage
+	case mainPackage == "":
+		return modulePath, modulePath
 	}
+	return modulePath, mainPackage
+}
 
-	return env.runCommand(ctx, cmd)
+// mainFileContent renders the main.go file of the build environment,
+// which imports each of the plugins and calls Main of the base module,
+// preceded by the //go:debug directives of GoDebug.
+func (b Builder) mainFileContent() ([]byte, error) {
+	text, err := b.mainTemplateText()
+	if err != nil {
+		return nil, err
+	}
+	tpl, err := template.New("main").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("parsing main template: %v", err)
+	}
+	data := MainTemplateData{Version: b.CaddyVersion, Embedded: len(b.Embeds) > 0}
+	data.BaseModule, data.MainPackage = b.baseModule()
+	for _, p := range b.Plugins {
+		data.Plugins = append(data.Plugins, p.PackagePath)
+	}
+	if err := checkGoDebug(b.GoDebug); err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	buf.Write(goDebugDirectives(b.GoDebug))
+	if err := tpl.Execute(&buf, data); err != nil {
+		return nil, fmt.Errorf("executing main template: %v", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// mainTemplateText returns the text of the template for main.go.
+func (b Builder) mainTemplateText() (string, error) {
+	if b.MainTemplateFS != nil {
+		text, err := fs.ReadFile(b.MainTemplateFS, b.MainTemplate)
+		if err != nil {
+			return "", fmt.Errorf("reading main template: %v", err)
+		}
+		return string(text), nil
+	}
+	if b.MainTemplate != "" {
+		return b.MainTemplate, nil
+	}
+	return mainModuleTemplate, nil
+}
+
+// MainTemplateData is the data available to the
+// text/template that generates the main package.
+type MainTemplateData struct {
+	// The path of the base module, e.g. github.com/crackeer/goaway.
+	BaseModule string
+
+	// The import path of the package whose Main is called.
+	MainPackage string
+
+	// The version of the base module being built (CaddyVersion),
+	// which may be empty.
+	Version string
+
+	// The import paths of the plugins, which the
+	// template should import for their side effects.
+	Plugins []string
+
+	// Whether the main package has a variable embeddedFiles, an
+	// fs.FS with the files of Embeds, for the template to use.
+	Embedded bool
 }
 
 const mainModuleTemplate = `package main
 
 import (
-	"github.com/crackeer/goaway/server"
-)
+	basemain "{{.MainPackage}}"
 
+	// plug in modules here
+	{{- range .Plugins}}
+	_ "{{.}}"
+	{{- end}}
+)
 
 func main() {
-	server.Main()
+	basemain.Main()
 }
 `
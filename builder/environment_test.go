@@ -0,0 +1,164 @@
+package builder
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestBuildLocalBaseModuleOffline(t *testing.T) {
+	baseDir := testBaseModule(t)
+	b := testBuilder(t, baseDir)
+	// a relative path is resolved to the absolute directory
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rel, err := filepath.Rel(wd, baseDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.Replacements = []Replace{NewReplace(defaultBaseModule, rel)}
+
+	output := filepath.Join(t.TempDir(), "goaway")
+	result, err := b.Build(context.Background(), output)
+	if err != nil {
+		t.Fatalf("building without network access: %v", err)
+	}
+	if _, err := os.Stat(output); err != nil {
+		t.Fatalf("binary was not written: %v", err)
+	}
+	var replaced bool
+	for _, r := range result.Replacements {
+		if r.Old.ModulePath() == defaultBaseModule {
+			replaced = true
+			if r.New.String() != baseDir {
+				t.Errorf("base module replaced by %s, want %s", r.New, baseDir)
+			}
+		}
+	}
+	if !replaced {
+		t.Errorf("binary does not record the replacement of %s: %+v", defaultBaseModule, result.Replacements)
+	}
+	if left := buildEnvFolders(t, b.WorkDir); len(left) > 0 {
+		t.Errorf("build environments were not removed: %v", left)
+	}
+}
+
+func TestConcurrentBuildsWithOwnProxies(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skipf("sh not found: %v", err)
+	}
+	baseDir := testBaseModule(t)
+	processProxy, processHasProxy := os.LookupEnv("GOPROXY")
+
+	type tenant struct {
+		name, plugin string
+		proxy        *testProxy
+		envFile      string
+		b            Builder
+	}
+	var tenants []*tenant
+	for _, name := range []string{"a", "b"} {
+		tn := &tenant{name: name, plugin: "example.com/tenant" + name}
+		tn.proxy = newTestProxy(t, "v1.0.0", map[string]string{tn.plugin: "package tenant" + name + "\n"})
+		tn.envFile = filepath.Join(t.TempDir(), "env")
+		tn.b = testBuilder(t, baseDir)
+		tn.b.GoProxy = tn.proxy.URL
+		tn.b.GoModCache = testModCache(t)
+		tn.b.Env["TENANT"] = name
+		tn.b.Plugins = []Dependency{{PackagePath: tn.plugin, Version: "v1.0.0"}}
+		// the environment of the go commands, as a hook sees it
+		tn.b.Hooks.PostTidy = []string{fmt.Sprintf(`sh -c 'echo "$TENANT $GOPROXY" > %s'`, tn.envFile)}
+		tenants = append(tenants, tn)
+	}
+
+	var wg sync.WaitGroup
+	errs := make([]error, len(tenants))
+	for i, tn := range tenants {
+		wg.Add(1)
+		go func(i int, tn *tenant) {
+			defer wg.Done()
+			_, errs[i] = tn.b.Build(context.Background(), filepath.Join(t.TempDir(), "goaway"))
+		}(i, tn)
+	}
+	wg.Wait()
+
+	for i, tn := range tenants {
+		if errs[i] != nil {
+			t.Fatalf("build of tenant %s: %v", tn.name, errs[i])
+		}
+		var fetched bool
+		for _, path := range tn.proxy.Requests() {
+			if strings.HasPrefix(path, "/"+tn.plugin+"/") {
+				fetched = true
+			}
+			for _, other := range tenants {
+				if other != tn && strings.Contains(path, other.plugin) {
+					t.Errorf("proxy of tenant %s was asked for %s by the other build", tn.name, path)
+				}
+			}
+		}
+		if !fetched {
+			t.Errorf("tenant %s did not fetch %s from its proxy", tn.name, tn.plugin)
+		}
+		data, err := os.ReadFile(tn.envFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := strings.TrimSpace(string(data)), tn.name+" "+tn.proxy.URL; got != want {
+			t.Errorf("go commands of tenant %s had TENANT and GOPROXY %q, want %q", tn.name, got, want)
+		}
+	}
+	if proxy, hasProxy := os.LookupEnv("GOPROXY"); proxy != processProxy || hasProxy != processHasProxy {
+		t.Errorf("GOPROXY of the process changed to %q", proxy)
+	}
+}
+
+func TestResolve(t *testing.T) {
+	baseDir := testBaseModule(t)
+	for _, tt := range []struct {
+		name     string
+		cleanup  CleanupPolicy
+		postTidy []string
+		wantDir  bool
+		wantErr  bool
+	}{
+		{"removed", CleanupAlways, nil, false, false},
+		{"kept", CleanupNever, nil, true, false},
+		{"failing post-tidy hook", CleanupAlways, []string{"go version -bogus"}, false, true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			b := testBuilder(t, baseDir)
+			b.CleanupPolicy = tt.cleanup
+			b.Hooks.PostTidy = tt.postTidy
+			mod, err := b.Resolve(context.Background())
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("Resolve succeeded despite the failing check")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(string(mod.GoMod), "module ") {
+				t.Errorf("resolved go.mod is\n%s", mod.GoMod)
+			}
+			if !tt.wantDir {
+				if mod.Dir != "" {
+					t.Errorf("resolved module has the removed folder %s", mod.Dir)
+				}
+				return
+			}
+			if _, err := os.Stat(filepath.Join(mod.Dir, "go.mod")); err != nil {
+				t.Errorf("kept folder of the resolved module: %v", err)
+			}
+		})
+	}
+}
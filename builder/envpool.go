@@ -0,0 +1,222 @@
+package builder
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// EnvPool keeps build environments for common configurations
+// prepared and tidied in the background, so that a build of one of
+// them only has to compile, which takes seconds instead of the
+// minutes of resolving and downloading its modules. Every prepared
+// environment is handed to one build and closed after it, and
+// another is prepared in its place. Builds of other configurations,
+// or when no environment is ready, run from scratch. An EnvPool must
+// not be copied after first use.
+type EnvPool struct {
+	// Configurations are the builders to keep environments
+	// ready for. A build uses one of their environments if it
+	// only differs from the configuration in settings that do
+	// not affect its module graph, such as its platform, its
+	// timeouts, or the files written along with the binary.
+	Configurations []Builder
+
+	// Standby is the number of environments to keep
+	// ready for each configuration. Default: 1
+	Standby int
+
+	// MaxAge, if positive, is how long an environment may wait
+	// for a build; older ones are replaced, so that versions
+	// such as "latest" are resolved again from time to time.
+	MaxAge time.Duration
+
+	mu      sync.Mutex
+	started bool
+	closed  bool
+	ctx     context.Context
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+	configs map[string]Builder
+	ready   map[string][]standbyEnv
+	filling map[string]int
+	hits    int
+	misses  int
+}
+
+// standbyEnv is an environment of an EnvPool
+// and the time that it was ready.
+type standbyEnv struct {
+	env   *Environment
+	since time.Time
+}
+
+// EnvPoolStats reports the environments and builds of an EnvPool.
+type EnvPoolStats struct {
+	// The environments ready for a build, and those being prepared.
+	Ready     int `json:"ready"`
+	Preparing int `json:"preparing"`
+
+	// The builds that used a ready environment,
+	// and those that had to run from scratch.
+	Hits   int `json:"hits"`
+	Misses int `json:"misses"`
+}
+
+// Start prepares the environments of p in the background, until
+// ctx is done or p is closed. Environments are prepared with the
+// logger of their configuration.
+func (p *EnvPool) Start(ctx context.Context) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.started || p.closed {
+		return
+	}
+	p.started = true
+	p.ctx, p.cancel = context.WithCancel(ctx)
+	p.configs = make(map[string]Builder, len(p.Configurations))
+	p.ready = make(map[string][]standbyEnv)
+	p.filling = make(map[string]int)
+	for _, b := range p.Configurations {
+		key := envPoolKey(b)
+		p.configs[key] = b
+		p.fill(key)
+	}
+}
+
+// Build builds b to outputFile in an environment prepared for
+// its configuration, if one is ready, and otherwise from scratch
+// with b.Build. Either way, another environment is prepared for
+// the configuration if it is one of p.
+func (p *EnvPool) Build(ctx context.Context, b Builder, outputFile string) (*BuildResult, error) {
+	key := envPoolKey(b)
+	env := p.take(key)
+	if env == nil {
+		return b.Build(ctx, outputFile)
+	}
+	defer env.Close()
+	b.logger().Printf("[INFO] Using a standby build environment")
+	env.Builder = b
+	return env.Build(ctx, outputFile)
+}
+
+// take returns a ready environment for the configuration key and
+// starts preparing another, or returns nil if none is ready.
+func (p *EnvPool) take(key string) *Environment {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.started || p.closed {
+		p.misses++
+		return nil
+	}
+	defer p.fill(key)
+	for ready := p.ready[key]; len(ready) > 0; ready = p.ready[key] {
+		standby := ready[0]
+		p.ready[key] = ready[1:]
+		if p.MaxAge > 0 && time.Since(standby.since) > p.MaxAge {
+			go standby.env.Close()
+			continue
+		}
+		p.hits++
+		return standby.env
+	}
+	p.misses++
+	return nil
+}
+
+// fill starts preparing environments for the configuration key
+// until p.Standby of them are ready or being prepared. p.mu must
+// be held.
+func (p *EnvPool) fill(key string) {
+	b, ok := p.configs[key]
+	if !ok || p.closed {
+		return
+	}
+	standby := p.Standby
+	if standby <= 0 {
+		standby = 1
+	}
+	for n := len(p.ready[key]) + p.filling[key]; n < standby; n++ {
+		p.filling[key]++
+		p.wg.Add(1)
+		go p.prepare(key, b)
+	}
+}
+
+// prepare prepares an environment for b, the
+// configuration key, and adds it to the ready ones.
+func (p *EnvPool) prepare(key string, b Builder) {
+	defer p.wg.Done()
+	env, err := b.PrepareEnvironment(p.ctx)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.filling[key]--
+	if err != nil {
+		// the next build of the configuration tries again
+		if p.ctx.Err() == nil {
+			b.logger().Printf("[ERROR] Preparing a standby build environment: %v", err)
+		}
+		return
+	}
+	if p.closed {
+		_ = env.Close()
+		return
+	}
+	p.ready[key] = append(p.ready[key], standbyEnv{env: env, since: time.Now()})
+}
+
+// Stats returns the environments and builds of p.
+func (p *EnvPool) Stats() EnvPoolStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	stats := EnvPoolStats{Hits: p.hits, Misses: p.misses}
+	for _, ready := range p.ready {
+		stats.Ready += len(ready)
+	}
+	for _, n := range p.filling {
+		stats.Preparing += n
+	}
+	return stats
+}
+
+// Close stops preparing environments, waits for those being
+// prepared, and closes the ready ones. Builds that already use
+// an environment of p are not affected. Further builds run from
+// scratch.
+func (p *EnvPool) Close() error {
+	p.mu.Lock()
+	p.closed = true
+	if p.cancel != nil {
+		p.cancel()
+	}
+	p.mu.Unlock()
+	p.wg.Wait()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var firstErr error
+	for key, ready := range p.ready {
+		for _, standby := range ready {
+			if err := standby.env.Close(); err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+		delete(p.ready, key)
+	}
+	return firstErr
+}
+
+// envPoolKey returns the configuration of b that its build
+// environment depends on, leaving out the settings that the
+// builds in a prepared environment may change.
+func envPoolKey(b Builder) string {
+	b.Platform = Platform{}
+	b.TimeoutGet, b.TimeoutBuild, b.Timeouts = 0, 0, Timeouts{}
+	b.SBOMFormat, b.Provenance, b.SmokeTest, b.Compress = "", nil, nil, nil
+	b.MaxBinarySize, b.WarnBinarySize, b.SizeBreakdown = 0, false, false
+	b.DebugDir = ""
+	data, _ := json.Marshal(b)
+	return string(data)
+}
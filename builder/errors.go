@@ -0,0 +1,166 @@
+package builder
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+)
+
+// SetupError is returned when the build environment could not be
+// prepared, for reasons other than those with their own error types,
+// such as GoGetError.
+type SetupError struct {
+	Err error
+}
+
+func (e *SetupError) Error() string {
+	return fmt.Sprintf("setting up build environment: %v", e.Err)
+}
+
+func (e *SetupError) Unwrap() error { return e.Err }
+
+// GoGetError is returned when `go get` fails for a module, which
+// happens, for example, if the module or version does not exist or
+// cannot be downloaded.
+type GoGetError struct {
+	// The module (or package) and version that were requested;
+	// Module is empty for the final `go get` of all requirements.
+	Module  string
+	Version string
+
+	// The end of the output of `go get`.
+	Stderr string
+
+	Err error
+}
+
+func (e *GoGetError) Error() string {
+	mod := e.Module
+	if mod == "" {
+		mod = "module requirements"
+	} else if e.Version != "" {
+		mod += "@" + e.Version
+	}
+	return fmt.Sprintf("getting %s: %v%s", mod, e.Err, formatStderr(e.Stderr))
+}
+
+func (e *GoGetError) Unwrap() error { return e.Err }
+
+// TidyError is returned when `go mod tidy` fails.
+type TidyError struct {
+	// The end of the output of `go mod tidy`.
+	Stderr string
+
+	Err error
+}
+
+func (e *TidyError) Error() string {
+	return fmt.Sprintf("tidying module: %v%s", e.Err, formatStderr(e.Stderr))
+}
+
+func (e *TidyError) Unwrap() error { return e.Err }
+
+// CompileError is returned when `go build` fails, typically
+// because of a compile error in the base module or a plugin.
+type CompileError struct {
+	// The exit code of `go build`, or -1 if it did not exit normally.
+	ExitCode int
+
+	// The end of the output of `go build`, which
+	// contains the compiler's error messages.
+	Stderr string
+
+	Err error
+}
+
+func (e *CompileError) Error() string {
+	return fmt.Sprintf("compiling: %v%s", e.Err, formatStderr(e.Stderr))
+}
+
+func (e *CompileError) Unwrap() error { return e.Err }
+
+// formatStderr formats the output of a failed command
+// for inclusion at the end of an error message.
+func formatStderr(stderr string) string {
+	stderr = strings.TrimSpace(stderr)
+	if stderr == "" {
+		return ""
+	}
+	return ":\n" + stderr
+}
+
+// exitCode returns the exit code of the command that failed with
+// err, or -1 if it did not exit normally or did not run at all.
+func exitCode(err error) int {
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode()
+	}
+	return -1
+}
+
+// wrapError returns err wrapped by wrap, unless err is nil or the
+// error of a canceled context, which is always reported as is.
+func wrapError(err error, wrap func(error) error) error {
+	if err == nil || isCanceled(err) {
+		return err
+	}
+	return wrap(err)
+}
+
+// stderrTailSize is how many bytes of
+// output the typed errors include.
+const stderrTailSize = 4 << 10
+
+// tailWriter keeps the last max bytes written to it
+// or, if lines is positive, the last lines lines of those.
+type tailWriter struct {
+	buf   []byte
+	max   int
+	lines int
+}
+
+func (t *tailWriter) Write(p []byte) (int, error) {
+	t.buf = append(t.buf, p...)
+	if len(t.buf) > t.max {
+		t.buf = t.buf[len(t.buf)-t.max:]
+	}
+	return len(p), nil
+}
+
+// String returns the kept output, starting at a
+// line boundary if the output was truncated.
+func (t *tailWriter) String() string {
+	s := string(t.buf)
+	if len(t.buf) == t.max {
+		if i := strings.IndexByte(s, '\n'); i >= 0 {
+			s = s[i+1:]
+		}
+	}
+	if t.lines > 0 {
+		lines := strings.SplitAfter(strings.TrimSuffix(s, "\n"), "\n")
+		if len(lines) > t.lines {
+			s = strings.Join(lines[len(lines)-t.lines:], "")
+		}
+	}
+	return s
+}
+
+// runCommandTail runs cmd like runCommand and also returns
+// the end of its standard error output.
+func (env environment) runCommandTail(ctx context.Context, cmd *exec.Cmd) (string, error) {
+	tail := &tailWriter{max: stderrTailSize}
+	if env.tailLines > 0 {
+		tail = &tailWriter{max: maxTailLinesSize, lines: env.tailLines}
+	}
+	if cmd.Stderr == nil {
+		cmd.Stderr = tail
+	} else {
+		cmd.Stderr = io.MultiWriter(cmd.Stderr, tail)
+	}
+	err := env.runCommand(ctx, cmd)
+	return tail.String(), err
+}
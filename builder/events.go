@@ -0,0 +1,174 @@
+package builder
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/mod/module"
+)
+
+// Event is a progress event of a build, as reported to
+// Builder.OnEvent: one of EnvCreated, ModuleFetched,
+// TidyStarted, CompileStarted, and CompileFinished.
+type Event interface {
+	event()
+}
+
+// EnvCreated is reported when the temporary
+// folder of the build environment was created.
+type EnvCreated struct {
+	Dir string
+}
+
+// ModuleFetched is reported for each module that a go command
+// downloaded into the module cache, after the command ended.
+type ModuleFetched struct {
+	Path    string
+	Version string
+
+	// The size of the module's zip file, or 0 if it is
+	// unknown, e.g. because only its go.mod file was
+	// downloaded or the build runs on an executor.
+	Bytes int64
+}
+
+// TidyStarted is reported when `go mod tidy` starts.
+type TidyStarted struct{}
+
+// CompileStarted is reported when `go build` starts.
+type CompileStarted struct{}
+
+// CompileFinished is reported when `go build` ended,
+// with its error if it failed.
+type CompileFinished struct {
+	Duration time.Duration
+	Err      error
+}
+
+func (EnvCreated) event()      {}
+func (ModuleFetched) event()   {}
+func (TidyStarted) event()     {}
+func (CompileStarted) event()  {}
+func (CompileFinished) event() {}
+
+// emit reports e to the OnEvent callback of env, if any.
+func (env environment) emit(e Event) {
+	if env.onEvent != nil && env.plan == nil {
+		env.onEvent(e)
+	}
+}
+
+// fetchWriter forwards the standard error output of a go command
+// to w and records the modules that the command downloaded, from
+// its "go: downloading" lines, and, for download metrics, the
+// requests to module proxies that it traced with -x.
+type fetchWriter struct {
+	w io.Writer
+
+	mu       sync.Mutex
+	line     []byte
+	fetched  []Dependency
+	requests []proxyRequest
+}
+
+func (fw *fetchWriter) Write(p []byte) (int, error) {
+	fw.mu.Lock()
+	fw.line = append(fw.line, p...)
+	for {
+		i := bytes.IndexByte(fw.line, '\n')
+		if i < 0 {
+			break
+		}
+		line := string(fw.line[:i])
+		fields := strings.Fields(line)
+		// toolchain downloads end with the platform in parentheses
+		if len(fields) == 4 && fields[0] == "go:" && fields[1] == "downloading" && !strings.HasPrefix(fields[3], "(") {
+			fw.fetched = append(fw.fetched, Dependency{PackagePath: fields[2], Version: fields[3]})
+		} else if r, ok := parseProxyRequest(line); ok {
+			fw.requests = append(fw.requests, r)
+		}
+		fw.line = fw.line[i+1:]
+	}
+	fw.mu.Unlock()
+	if fw.w == nil {
+		return len(p), nil
+	}
+	return fw.w.Write(p)
+}
+
+// reportFetched emits a ModuleFetched event for each module
+// that was recorded by fw, counts them for the instrumentation,
+// and adds the downloads and requests to the download metrics
+// of env, if any.
+func (env environment) reportFetched(ctx context.Context, fw *fetchWriter) {
+	fw.mu.Lock()
+	fetched, requests := fw.fetched, fw.requests
+	fw.fetched, fw.requests = nil, nil
+	fw.mu.Unlock()
+	if len(fetched) == 0 && len(requests) == 0 {
+		return
+	}
+	var modCache string
+	if len(fetched) > 0 {
+		modCache = env.moduleCacheDir()
+	}
+	for _, d := range fetched {
+		e := ModuleFetched{Path: d.PackagePath, Version: d.Version}
+		if modCache != "" {
+			e.Bytes = moduleZipSize(modCache, d.PackagePath, d.Version)
+		}
+		env.emit(e)
+		env.downloads.addFetched(e)
+	}
+	if len(fetched) > 0 {
+		env.instrumentation().Add(ctx, MetricModuleDownloads, int64(len(fetched)))
+	}
+	if len(requests) > 0 {
+		env.downloads.addRequests(env.proxyURLs(), requests)
+	}
+}
+
+// moduleCacheDir returns the module cache directory of the go
+// commands of env on the host, or "" if it cannot be determined
+// or the commands run on an executor.
+func (env environment) moduleCacheDir() string {
+	if env.executor != nil {
+		return ""
+	}
+	vars := env.environ()
+	if modCache, ok := getEnv(vars, "GOMODCACHE"); ok && modCache != "" {
+		return modCache
+	}
+	cmd := exec.Command(GetGo(), "env", "GOMODCACHE")
+	cmd.Env = vars
+	out, err := cmd.Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// moduleZipSize returns the size of the zip file of the module
+// at modulePath and version in the module cache modCache, or 0.
+func moduleZipSize(modCache, modulePath, version string) int64 {
+	escPath, err := module.EscapePath(modulePath)
+	if err != nil {
+		return 0
+	}
+	escVersion, err := module.EscapeVersion(version)
+	if err != nil {
+		return 0
+	}
+	info, err := os.Stat(filepath.Join(modCache, "cache", "download", escPath, "@v", escVersion+".zip"))
+	if err != nil {
+		return 0
+	}
+	return info.Size()
+}
@@ -0,0 +1,111 @@
+package builder
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// Executor runs the commands of builds somewhere other than directly
+// on the host, such as in a container or on a remote machine. The
+// build environment is always prepared on the host; an executor may
+// have to copy it to where the commands run and back.
+//
+// Executors run the commands with the same absolute paths as on
+// the host, so that the arguments of commands and the paths in
+// go.mod, such as those of local replacements, need no translation.
+type Executor interface {
+	// Start prepares the executor for a build environment in dir on
+	// the host. inputs are the other directories on the host that the
+	// build reads, i.e. those of local replacements, and goEnv is the
+	// key=value pairs that the builder sets for every go command.
+	Start(ctx context.Context, dir string, inputs, goEnv []string) (ExecutorSession, error)
+}
+
+// ExecutorSession runs the commands of one build environment.
+type ExecutorSession interface {
+	// Upload is called before every command, to bring changes
+	// to the build environment on the host to the executor.
+	Upload(ctx context.Context) error
+
+	// Wrap changes cmd, which is to be run in the build environment
+	// with the environment variables vars, into a command on the host
+	// that runs it on the executor until it exits, and can be
+	// interrupted and killed like the command itself.
+	Wrap(cmd *exec.Cmd, vars []string) error
+
+	// Download is called after every command, to bring changes
+	// to the build environment on the executor back to the host.
+	Download(ctx context.Context) error
+
+	// Close releases everything the session holds on the executor. It
+	// is called when the build environment on the host is removed.
+	Close(ctx context.Context) error
+}
+
+// executorOutputFolder is the folder inside the build environment
+// that builds with an executor write the binary to, since only the
+// build environment itself is shared with the executor.
+const executorOutputFolder = ".output"
+
+// executorEnvKeys are the environment variables that are passed to
+// executors whenever they are set, since they select what is built,
+// even if the process environment has the same values.
+var executorEnvKeys = []string{
+	"GOOS", "GOARCH", "GOARM", "GOAMD64", "GOARM64", "GO386", "GOMIPS", "GOMIPS64",
+	"CGO_ENABLED",
+}
+
+// executorHostKeys are the environment variables that refer
+// to the host and are never passed to executors.
+var executorHostKeys = []string{
+	"PATH", "HOME", "TMPDIR", "GOROOT", "GOPATH", "GOCACHE", "SSH_AUTH_SOCK",
+}
+
+// executor returns the executor of b, if any.
+func (b Builder) executor() Executor {
+	if b.Container != nil {
+		return *b.Container
+	}
+	return b.Executor
+}
+
+// executorVars returns the environment variables of cmd that the
+// builder set, which are those to pass to an executor; the rest of
+// the process environment is not passed on.
+func (env environment) executorVars(cmd *exec.Cmd) []string {
+	vars := cmd.Env
+	if vars == nil {
+		vars = env.environ()
+	}
+	inherited := make(map[string]bool)
+	for _, kv := range os.Environ() {
+		inherited[kv] = true
+	}
+	keys := append([]string(nil), executorEnvKeys...)
+	for _, kv := range append(append([]string(nil), env.goEnv...), env.credentialEnv...) {
+		keys = append(keys, strings.SplitN(kv, "=", 2)[0])
+	}
+	var passed []string
+	for _, kv := range vars {
+		key := strings.SplitN(kv, "=", 2)[0]
+		if containsString(executorHostKeys, key) {
+			continue
+		}
+		if !inherited[kv] || containsString(keys, key) {
+			passed = append(passed, kv)
+		}
+	}
+	return passed
+}
+
+// executorCommand returns the name of the command of cmd to run
+// on an executor: the go command of the executor for the go
+// command, or the command as given.
+func executorCommand(cmd *exec.Cmd) string {
+	if cmd.Args[0] == GetGo() {
+		return "go"
+	}
+	return cmd.Args[0]
+}
@@ -0,0 +1,128 @@
+package builder
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// FetchError is returned when fetching plugins concurrently
+// fails, with the failure of each plugin that could not be got.
+type FetchError struct {
+	Failures []*GoGetError
+}
+
+func (e *FetchError) Error() string {
+	msgs := make([]string, 0, len(e.Failures))
+	for _, f := range e.Failures {
+		msgs = append(msgs, f.Error())
+	}
+	return fmt.Sprintf("fetching %d plugin(s) failed: %s", len(e.Failures), strings.Join(msgs, "; "))
+}
+
+// fetchFolder is the folder in the build environment with the
+// scratch modules that fetch plugins concurrently.
+const fetchFolder = ".fetch"
+
+// prefetchPlugins fetches the plugins of b that are not local
+// directories concurrently, if b.FetchConcurrency allows that.
+// Builds with a workspace, an executor, or a plan fetch serially.
+func (env environment) prefetchPlugins(ctx context.Context, b Builder, caddyModulePath, caddyVersion string) error {
+	if b.FetchConcurrency < 2 || len(b.Workspace) > 0 || env.executor != nil || env.plan != nil {
+		return nil
+	}
+	var plugins []Dependency
+	for _, p := range b.Plugins {
+		if _, ok := localReplacementFor(p.PackagePath, b.Replacements); !ok {
+			plugins = append(plugins, p)
+		}
+	}
+	if caddyVersion == "" {
+		// the base module is a local directory
+		caddyModulePath = ""
+	}
+	return env.fetchPlugins(ctx, plugins, b.FetchConcurrency, caddyModulePath, caddyVersion)
+}
+
+// fetchPlugins runs `go get` for up to concurrency of plugins at
+// once, each in a scratch module with a copy of the go.mod and
+// go.sum of env, so that the module cache holds everything the
+// plugins need before they are added to the main module one by
+// one, which go.mod allows no concurrency for. Unlike those, every
+// plugin is fetched even if others fail, and all failures are
+// returned together as a FetchError.
+func (env environment) fetchPlugins(ctx context.Context, plugins []Dependency, concurrency int, caddyModulePath, caddyVersion string) error {
+	if len(plugins) < 2 {
+		return nil
+	}
+	env.log.Printf("[INFO] Fetching %d plugins, up to %d at once", len(plugins), concurrency)
+	root := filepath.Join(env.tempFolder, fetchFolder)
+	defer os.RemoveAll(root)
+
+	failures := make([]error, len(plugins))
+	slots := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i, p := range plugins {
+		dir := filepath.Join(root, strconv.Itoa(i))
+		if err := env.copyModuleTo(dir); err != nil {
+			return err
+		}
+		wg.Add(1)
+		go func(i int, p Dependency) {
+			defer wg.Done()
+			select {
+			case slots <- struct{}{}:
+				defer func() { <-slots }()
+			case <-ctx.Done():
+				failures[i] = ctx.Err()
+				return
+			}
+			failures[i] = env.goGet(ctx, dir, p.PackagePath, p.Version, caddyModulePath, caddyVersion)
+		}(i, p)
+	}
+	wg.Wait()
+
+	fetchErr := &FetchError{}
+	for i, err := range failures {
+		var getErr *GoGetError
+		var timeoutErr *TimeoutError
+		switch {
+		case err == nil:
+		case errors.As(err, &getErr):
+			fetchErr.Failures = append(fetchErr.Failures, getErr)
+		case errors.As(err, &timeoutErr) && ctx.Err() == nil:
+			// only the `go get` of this plugin timed out
+			fetchErr.Failures = append(fetchErr.Failures, &GoGetError{Module: plugins[i].PackagePath, Version: plugins[i].Version, Err: err})
+		default:
+			// canceled, timed out altogether, or out of disk space
+			return err
+		}
+	}
+	if len(fetchErr.Failures) > 0 {
+		return fetchErr
+	}
+	return nil
+}
+
+// copyModuleTo copies the go.mod and, if there is one,
+// the go.sum of the main module of env into dir.
+func (env environment) copyModuleTo(dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	for _, name := range []string{"go.mod", "go.sum"} {
+		src := filepath.Join(env.tempFolder, name)
+		if _, err := os.Stat(src); os.IsNotExist(err) {
+			continue
+		}
+		if err := copyFile(src, filepath.Join(dir, name), 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
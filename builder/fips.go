@@ -0,0 +1,94 @@
+package builder
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/mod/semver"
+)
+
+const (
+	// minFIPS140GoVersion is the first Go version with the
+	// Go Cryptographic Module, which GOFIPS140 selects; older
+	// toolchains only have the BoringCrypto experiment.
+	minFIPS140GoVersion = "go1.24"
+
+	// fips140Module is the GOFIPS140 value of FIPS builds: the
+	// module version that was submitted for validation, which
+	// newer toolchains still ship.
+	fips140Module = "v1.0.0"
+
+	// boringCryptoExperiment is the GOEXPERIMENT that
+	// links the BoringCrypto module of BoringSSL.
+	boringCryptoExperiment = "boringcrypto"
+)
+
+// boringCryptoPlatforms lists the targets
+// that support boringCryptoExperiment.
+var boringCryptoPlatforms = map[string]bool{"linux/amd64": true, "linux/arm64": true}
+
+// fipsSettings adds the variables for a FIPS build with the
+// toolchain of goVersion to env: GOFIPS140 for toolchains that have
+// the Go Cryptographic Module, unless env sets it already, and the
+// BoringCrypto experiment, which requires cgo, for older ones, for
+// which enableRequiredCgo has enabled cgo.
+func (b *Builder) fipsSettings(goVersion string, env []string) ([]string, error) {
+	if !usesBoringCrypto(goVersion) {
+		if value, ok := getEnv(env, "GOFIPS140"); ok && value != "" && value != "off" {
+			b.logger().Printf("[INFO] Building in FIPS 140 mode with GOFIPS140=%s", value)
+			return env, nil
+		}
+		b.logger().Printf("[INFO] Building in FIPS 140 mode with GOFIPS140=%s", fips140Module)
+		return setEnv(env, "GOFIPS140="+fips140Module), nil
+	}
+
+	target := b.targetOS() + "/" + b.targetArch()
+	if !boringCryptoPlatforms[target] {
+		return nil, fmt.Errorf("FIPS builds with %s require linux/amd64 or linux/arm64, not %s; use %s or newer for other targets",
+			goVersion, target, minFIPS140GoVersion)
+	}
+	if b.Static {
+		return nil, fmt.Errorf("FIPS builds with %s cannot be static, as BoringCrypto requires cgo", goVersion)
+	}
+	if !hasGoExperiment(b.GoExperiment, boringCryptoExperiment) {
+		b.GoExperiment = strings.TrimPrefix(b.GoExperiment+","+boringCryptoExperiment, ",")
+	}
+	b.logger().Printf("[INFO] Building in FIPS 140 mode with BoringCrypto")
+	return setEnv(env, "GOEXPERIMENT="+b.GoExperiment), nil
+}
+
+// usesBoringCrypto reports whether FIPS builds with the toolchain of
+// goVersion use BoringCrypto, as toolchains older than
+// minFIPS140GoVersion do. Unknown versions are assumed to be newer.
+func usesBoringCrypto(goVersion string) bool {
+	v := goSemver(goVersion)
+	return semver.IsValid(v) && semver.Compare(v, goSemver(minFIPS140GoVersion)) < 0
+}
+
+// hasGoExperiment reports whether the GOEXPERIMENT
+// value experiment enables name.
+func hasGoExperiment(experiment, name string) bool {
+	enabled := false
+	for _, e := range strings.Split(experiment, ",") {
+		switch e {
+		case name:
+			enabled = true
+		case "no" + name:
+			enabled = false
+		}
+	}
+	return enabled
+}
+
+// fipsMode returns the FIPS 140 crypto that the binary with
+// the build settings uses: the version of the Go Cryptographic
+// Module, boringcrypto, or "" if neither.
+func fipsMode(settings map[string]string) string {
+	if v := settings["GOFIPS140"]; v != "" && v != "off" {
+		return v
+	}
+	if hasGoExperiment(settings["GOEXPERIMENT"], boringCryptoExperiment) {
+		return boringCryptoExperiment
+	}
+	return ""
+}
@@ -0,0 +1,127 @@
+package builder
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// mergeLDFlags removes every -ldflags flag from args and appends
+// a single one that combines their values, followed by extra, so
+// that flags added by the builder do not override those given in
+// BuildFlags (the go command only honors the last -ldflags).
+func mergeLDFlags(args []string, extra ...string) []string {
+	return mergeFlag(args, "ldflags", " ", extra)
+}
+
+// mergeTags is like mergeLDFlags, but for -tags.
+func mergeTags(args []string, extra ...string) []string {
+	return mergeFlag(args, "tags", ",", extra)
+}
+
+// mergeFlag removes every occurrence of the flag with the given
+// name from args and appends a single one whose value is their
+// values and then extra, joined by sep. If extra is empty, args
+// is returned unchanged.
+func mergeFlag(args []string, name, sep string, extra []string) []string {
+	if len(extra) == 0 {
+		return args
+	}
+	var values []string
+	out := make([]string, 0, len(args)+1)
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if !strings.HasPrefix(arg, "-") {
+			out = append(out, arg)
+			continue
+		}
+		flag := strings.TrimPrefix(strings.TrimPrefix(arg, "-"), "-")
+		switch {
+		case flag == name && i+1 < len(args):
+			values = append(values, args[i+1])
+			i++
+		case strings.HasPrefix(flag, name+"="):
+			values = append(values, strings.TrimPrefix(flag, name+"="))
+		default:
+			out = append(out, arg)
+		}
+	}
+	values = append(values, extra...)
+	return append(out, "-"+name+"="+strings.Join(values, sep))
+}
+
+// removeBoolFlag removes every occurrence of the boolean flag
+// with the given name from args, whatever its value, and reports
+// whether any of them set it.
+func removeBoolFlag(args []string, name string) ([]string, bool) {
+	var set bool
+	out := make([]string, 0, len(args))
+	for _, arg := range args {
+		flag := strings.TrimPrefix(strings.TrimPrefix(arg, "-"), "-")
+		switch {
+		case !strings.HasPrefix(arg, "-"):
+			out = append(out, arg)
+		case flag == name:
+			set = true
+		case strings.HasPrefix(flag, name+"="):
+			value, err := strconv.ParseBool(strings.TrimPrefix(flag, name+"="))
+			set = set || err != nil || value
+		default:
+			out = append(out, arg)
+		}
+	}
+	return out, set
+}
+
+// getEnv returns the value of key in env, a slice such
+// as is returned by os.Environ(), and whether it is set.
+func getEnv(env []string, key string) (string, bool) {
+	for i := len(env) - 1; i >= 0; i-- {
+		if strings.HasPrefix(env[i], key+"=") {
+			return strings.TrimPrefix(env[i], key+"="), true
+		}
+	}
+	return "", false
+}
+
+// linkerVariables returns the -X linker flags that set each
+// package.variable key of vars to its value, sorted by key so
+// that the resulting command line is deterministic.
+func linkerVariables(vars map[string]string) ([]string, error) {
+	keys := make([]string, 0, len(vars))
+	for k := range vars {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	flags := make([]string, 0, len(keys))
+	for _, k := range keys {
+		if !strings.Contains(k, ".") || strings.ContainsAny(k, " \t\n'\"=") {
+			return nil, fmt.Errorf("invalid linker variable %q: must be of the form importpath.name", k)
+		}
+		arg, err := quoteLinkerArg(k + "=" + vars[k])
+		if err != nil {
+			return nil, err
+		}
+		flags = append(flags, "-X "+arg)
+	}
+	return flags, nil
+}
+
+// quoteLinkerArg quotes s, if necessary, so that the go command
+// splits it into a single argument when parsing -ldflags. That
+// parser recognizes single and double quotes but no escapes, so
+// a value containing whitespace and both kinds of quotes cannot
+// be expressed.
+func quoteLinkerArg(s string) (string, error) {
+	if s != "" && !strings.ContainsAny(s, " \t\n\r'\"") {
+		return s, nil
+	}
+	if !strings.Contains(s, "'") {
+		return "'" + s + "'", nil
+	}
+	if !strings.Contains(s, `"`) {
+		return `"` + s + `"`, nil
+	}
+	return "", fmt.Errorf("linker argument %q cannot contain both single and double quotes", s)
+}
@@ -0,0 +1,215 @@
+package builder
+
+import (
+	"bytes"
+	"debug/elf"
+	"debug/gosym"
+	"debug/macho"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/mod/modfile"
+)
+
+// FromBinary returns a Builder for the plugin combination of the
+// binary at path, which must have been built from the base module,
+// such as by Build: with the version of the base module, the plugins
+// at their versions, the replacements of their modules, and the
+// platform of the binary. To upgrade the binary, change CaddyVersion,
+// for example to "latest", and clear the Version of those Plugins
+// to upgrade before building.
+//
+// The binary only records modules, not packages, so the plugins are
+// the dependency modules that require the base module, as their
+// go.mod files show, which are read from the module cache or from a
+// module proxy. If the binary has a symbol table, each plugin is the
+// package of its module with an init function, as plugins register
+// their modules in one; otherwise, it is the root package of the
+// module.
+func FromBinary(path string) (Builder, error) {
+	info, err := ReadBuildInfo(path)
+	if err != nil {
+		return Builder{}, err
+	}
+	var base *Dependency
+	for i, dep := range info.Dependencies {
+		if dep.PackagePath == defaultBaseModule {
+			base = &info.Dependencies[i]
+		}
+	}
+	if base == nil {
+		return Builder{}, fmt.Errorf("%s was not built from %s", path, defaultBaseModule)
+	}
+
+	b := Builder{CaddyVersion: base.Version}
+	if base.Version == placeholderVersion {
+		// a local directory replaced the base module
+		b.CaddyVersion = ""
+	}
+	if info.Main.PackagePath != defaultMainModulePath {
+		b.MainModulePath = info.Main.PackagePath
+	}
+	b.OS, b.Arch, b.ARM = info.Settings["GOOS"], info.Settings["GOARCH"], info.Settings["GOARM"]
+
+	replaced := make(map[string]ReplacementPath)
+	for _, r := range info.Replacements {
+		modulePath := r.Old.ModulePath()
+		replaced[modulePath] = r.New
+		// without the version, so that the replacement
+		// still applies when upgrading the module
+		b.Replacements = append(b.Replacements, Replace{Old: ReplacementPath(modulePath), New: r.New})
+	}
+
+	var candidates []Dependency
+	for _, dep := range info.Dependencies {
+		if dep.PackagePath != defaultBaseModule {
+			candidates = append(candidates, dep)
+		}
+	}
+	requirers, err := modulesRequiring(candidates, replaced, defaultBaseModule)
+	if err != nil {
+		return Builder{}, err
+	}
+
+	modules := make([]listedModule, 0, len(info.Dependencies))
+	for _, dep := range info.Dependencies {
+		modules = append(modules, listedModule{Path: dep.PackagePath, Version: dep.Version})
+	}
+	initPackages := packagesWithInit(path)
+	for _, dep := range requirers {
+		var packages []string
+		for _, pkg := range initPackages {
+			if m := moduleProviding(modules, pkg); m != nil && m.Path == dep.PackagePath {
+				packages = append(packages, pkg)
+			}
+		}
+		if len(packages) == 0 {
+			packages = []string{dep.PackagePath}
+		}
+		version := dep.Version
+		if version == placeholderVersion {
+			// a local directory replaced the module
+			version = ""
+		}
+		for _, pkg := range packages {
+			b.Plugins = append(b.Plugins, Dependency{PackagePath: pkg, Version: version})
+		}
+	}
+	return b, nil
+}
+
+// modulesRequiring returns the modules of deps whose go.mod files,
+// or those of their replacements in replaced, require modulePath.
+// The go.mod files of local replacements are read from their
+// directories; the others are looked up with the go command.
+func modulesRequiring(deps []Dependency, replaced map[string]ReplacementPath, modulePath string) ([]Dependency, error) {
+	goMods := make(map[string]string)
+	var queries []string
+	for _, dep := range deps {
+		if r, ok := replaced[dep.PackagePath]; ok {
+			if r.IsLocal() {
+				goMods[dep.PackagePath] = filepath.Join(r.String(), "go.mod")
+			} else {
+				queries = append(queries, r.ModulePath()+"@"+r.Version())
+			}
+			continue
+		}
+		queries = append(queries, dep.PackagePath+"@"+dep.Version)
+	}
+
+	if len(queries) > 0 {
+		var stdout, stderr bytes.Buffer
+		cmd := exec.Command(GetGo(), append([]string{"list", "-m", "-json"}, queries...)...)
+		// outside of any module or workspace
+		cmd.Dir = os.TempDir()
+		cmd.Env = setEnv(setEnv(os.Environ(), "GOWORK=off"), "GOFLAGS=")
+		cmd.Stdout = &stdout
+		cmd.Stderr = &stderr
+		if err := cmd.Run(); err != nil {
+			return nil, fmt.Errorf("looking up go.mod files of dependencies: %v: %s", err, strings.TrimSpace(stderr.String()))
+		}
+		for dec := json.NewDecoder(&stdout); dec.More(); {
+			var m listedModule
+			if err := dec.Decode(&m); err != nil {
+				return nil, fmt.Errorf("decoding module list: %v", err)
+			}
+			goMods[m.Path+"@"+m.Version] = m.GoMod
+		}
+	}
+
+	var requirers []Dependency
+	for _, dep := range deps {
+		key := dep.PackagePath
+		if r, ok := replaced[dep.PackagePath]; !ok {
+			key += "@" + dep.Version
+		} else if !r.IsLocal() {
+			key = r.ModulePath() + "@" + r.Version()
+		}
+		goModPath := goMods[key]
+		if goModPath == "" {
+			continue
+		}
+		data, err := os.ReadFile(goModPath)
+		if err != nil {
+			return nil, err
+		}
+		f, err := modfile.ParseLax(goModPath, data, nil)
+		if err != nil {
+			return nil, err
+		}
+		for _, req := range f.Require {
+			if req.Mod.Path == modulePath {
+				requirers = append(requirers, dep)
+				break
+			}
+		}
+	}
+	return requirers, nil
+}
+
+// packagesWithInit returns the packages that have an init function
+// in the binary at path, according to its symbol table, or nil if it
+// has no symbol table that can be read.
+func packagesWithInit(path string) []string {
+	pclntab, err := readPclntab(path)
+	if err != nil {
+		return nil
+	}
+	table, err := gosym.NewTable(nil, gosym.NewLineTable(pclntab, 0))
+	if err != nil {
+		return nil
+	}
+	var packages []string
+	for _, fn := range table.Funcs {
+		// init functions of packages are named
+		// init.0, init.1, and so on in the binary
+		if pkg := strings.TrimSuffix(fn.Name, ".init.0"); pkg != fn.Name {
+			packages = append(packages, pkg)
+		}
+	}
+	return packages
+}
+
+// readPclntab returns the contents of the Go symbol table
+// section of the ELF or Mach-O binary at path.
+func readPclntab(path string) ([]byte, error) {
+	if f, err := elf.Open(path); err == nil {
+		defer f.Close()
+		if s := f.Section(".gopclntab"); s != nil {
+			return s.Data()
+		}
+		return nil, fmt.Errorf("no .gopclntab section")
+	}
+	if f, err := macho.Open(path); err == nil {
+		defer f.Close()
+		if s := f.Section("__gopclntab"); s != nil {
+			return s.Data()
+		}
+		return nil, fmt.Errorf("no __gopclntab section")
+	}
+	return nil, fmt.Errorf("unsupported binary format")
+}
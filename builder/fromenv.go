@@ -0,0 +1,222 @@
+package builder
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// envPrefix starts the names of the environment variables
+// that FromEnv reads; GOAWAY_BUILDER_ is for the hooks.
+const envPrefix = "GOAWAY_"
+
+// FromEnv returns a Builder configured by environment variables,
+// so that containerized CI jobs can configure builds without a
+// configuration file or Go code. Each setting is read from the
+// JSON key of Builder in upper case with the prefix GOAWAY_, and
+// those of nested settings join the keys with underscores:
+//
+//	GOAWAY_CADDY_VERSION=v2.8.4
+//	GOAWAY_PLUGINS=github.com/acme/a@v1.2.0,github.com/acme/b
+//	GOAWAY_REPLACEMENTS=github.com/acme/b=./b,github.com/x/y=github.com/me/y@v1.0.1
+//	GOAWAY_BUILD_FLAGS=-trimpath
+//	GOAWAY_BUILD_TAGS=nobadger,nomysql
+//	GOAWAY_TIMEOUT_BUILD=20m
+//	GOAWAY_TIMEOUTS_GO_GET=2m
+//	GOAWAY_STATIC=true
+//	GOAWAY_ENV=GOFLAGS=-mod=mod,CGO_ENABLED=0
+//
+// Durations are like "90s" or "1h30m". Lists are separated by
+// commas, and plugins are written as module[@version] and
+// replacements as old=new. Maps are comma-separated key=value
+// pairs. Lists and maps may also be given in JSON, as may the
+// settings that are structs referred to by pointers, such as
+// GOAWAY_SMOKE_TEST='{"commands":[["version"]]}'. Any other
+// variable with the prefix, such as a misspelled one, is an
+// error, and all problems are returned in a ValidationError.
+func FromEnv() (Builder, error) {
+	vars := make(map[string]string)
+	for _, kv := range os.Environ() {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) == 2 && strings.HasPrefix(parts[0], envPrefix) && !strings.HasPrefix(parts[0], envPrefix+"BUILDER_") {
+			vars[parts[0]] = parts[1]
+		}
+	}
+
+	var b Builder
+	v := &validator{}
+	used := make(map[string]bool)
+	setFromEnv(reflect.ValueOf(&b).Elem(), envPrefix, vars, used, v)
+	for _, key := range sortedKeys(vars) {
+		if !used[key] {
+			v.add(key, fmt.Errorf("unknown setting"))
+		}
+	}
+	if len(v.problems) > 0 {
+		return Builder{}, &ValidationError{Problems: v.problems}
+	}
+	return b, nil
+}
+
+// setFromEnv sets the encoded fields of the struct value s, whose
+// variables start with prefix, from those of vars, and records the
+// variables it used.
+func setFromEnv(s reflect.Value, prefix string, vars map[string]string, used map[string]bool, v *validator) {
+	t := s.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" || f.PkgPath != "" && !f.Anonymous {
+			continue
+		}
+		name := strings.Split(tag, ",")[0]
+		if f.Anonymous && name == "" && f.Type.Kind() == reflect.Struct {
+			setFromEnv(s.Field(i), prefix, vars, used, v)
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		key := prefix + strings.ToUpper(name)
+		if isEnvStruct(f.Type) {
+			setFromEnv(s.Field(i), key+"_", vars, used, v)
+			continue
+		}
+		value, ok := vars[key]
+		if !ok {
+			continue
+		}
+		used[key] = true
+		if err := parseEnvValue(s.Field(i), value); err != nil {
+			v.add(key, err)
+		}
+	}
+}
+
+// isEnvStruct reports whether the settings of type t are read
+// from one variable per field, rather than from one variable.
+func isEnvStruct(t reflect.Type) bool {
+	return t.Kind() == reflect.Struct && t != dependencyType && t != replaceType &&
+		!reflect.PtrTo(t).Implements(textUnmarshalerType)
+}
+
+var (
+	dependencyType      = reflect.TypeOf(Dependency{})
+	replaceType         = reflect.TypeOf(Replace{})
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+// parseEnvValue sets the value fv from the environment variable s.
+func parseEnvValue(fv reflect.Value, s string) error {
+	t := fv.Type()
+	switch {
+	case t == durationType:
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return fmt.Errorf("invalid duration %q; use a duration such as 90s or 1h30m", s)
+		}
+		fv.SetInt(int64(d))
+		return nil
+	case t == dependencyType:
+		path, version, _ := strings.Cut(s, "@")
+		if path == "" {
+			return fmt.Errorf("invalid plugin %q; use module[@version]", s)
+		}
+		fv.Set(reflect.ValueOf(Dependency{PackagePath: path, Version: version}))
+		return nil
+	case t == replaceType:
+		old, new, ok := strings.Cut(s, "=")
+		if !ok || old == "" || new == "" {
+			return fmt.Errorf("invalid replacement %q; use old=new", s)
+		}
+		fv.Set(reflect.ValueOf(NewReplace(old, new)))
+		return nil
+	case reflect.PtrTo(t).Implements(textUnmarshalerType):
+		return fv.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(s))
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		fv.SetString(s)
+	case reflect.Bool:
+		value, err := strconv.ParseBool(s)
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q; use true or false", s)
+		}
+		fv.SetBool(value)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		value, err := strconv.ParseInt(s, 10, t.Bits())
+		if err != nil {
+			return fmt.Errorf("invalid integer %q", s)
+		}
+		fv.SetInt(value)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		value, err := strconv.ParseUint(s, 10, t.Bits())
+		if err != nil {
+			return fmt.Errorf("invalid non-negative integer %q", s)
+		}
+		fv.SetUint(value)
+	case reflect.Float32, reflect.Float64:
+		value, err := strconv.ParseFloat(s, t.Bits())
+		if err != nil {
+			return fmt.Errorf("invalid number %q", s)
+		}
+		fv.SetFloat(value)
+	case reflect.Slice:
+		if strings.HasPrefix(strings.TrimSpace(s), "[") {
+			return decodeEnvJSON(fv, s)
+		}
+		list := reflect.MakeSlice(t, 0, 0)
+		for _, item := range strings.Split(s, ",") {
+			if item = strings.TrimSpace(item); item == "" {
+				continue
+			}
+			elem := reflect.New(t.Elem()).Elem()
+			if err := parseEnvValue(elem, item); err != nil {
+				return err
+			}
+			list = reflect.Append(list, elem)
+		}
+		fv.Set(list)
+	case reflect.Map:
+		if strings.HasPrefix(strings.TrimSpace(s), "{") || t.Key().Kind() != reflect.String {
+			return decodeEnvJSON(fv, s)
+		}
+		m := reflect.MakeMap(t)
+		for _, pair := range strings.Split(s, ",") {
+			if pair = strings.TrimSpace(pair); pair == "" {
+				continue
+			}
+			key, value, ok := strings.Cut(pair, "=")
+			if !ok {
+				return fmt.Errorf("invalid pair %q; use key=value", pair)
+			}
+			elem := reflect.New(t.Elem()).Elem()
+			if err := parseEnvValue(elem, value); err != nil {
+				return err
+			}
+			m.SetMapIndex(reflect.ValueOf(key).Convert(t.Key()), elem)
+		}
+		fv.Set(m)
+	default:
+		return decodeEnvJSON(fv, s)
+	}
+	return nil
+}
+
+// decodeEnvJSON sets fv from the JSON encoding s, in
+// which unknown keys are errors, as in LoadConfig.
+func decodeEnvJSON(fv reflect.Value, s string) error {
+	dec := json.NewDecoder(bytes.NewReader([]byte(s)))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(fv.Addr().Interface()); err != nil {
+		return fmt.Errorf("invalid JSON: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package builder
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// generateTarget is a directory in which `go generate`
+// runs for the package patterns of a module built from source.
+type generateTarget struct {
+	dir      string
+	patterns []string
+
+	// whether dir is a local replacement, which is generated
+	// as its own module rather than in the workspace
+	replacement bool
+}
+
+// generate runs `go generate` for packages, or, if packages is empty,
+// for all packages built from source: those of the main module, of
+// the workspace modules, and of the local replacements. The go
+// command only runs generators in main modules, so a local
+// replacement is generated in its own directory; packages that are
+// neither there nor in the workspace cannot be generated.
+func (env environment) generate(ctx context.Context, packages []string, replacements []Replace) error {
+	var targets []*generateTarget
+	main := &generateTarget{dir: env.tempFolder}
+	replaced := make(map[string]*generateTarget)
+	replacementTarget := func(modulePath string) (*generateTarget, error) {
+		if t, ok := replaced[modulePath]; ok {
+			return t, nil
+		}
+		for _, r := range replacements {
+			if r.New.IsLocal() && r.Old.ModulePath() == modulePath {
+				dir, err := checkLocalReplacement(r)
+				if err != nil {
+					return nil, err
+				}
+				t := &generateTarget{dir: dir, replacement: true}
+				replaced[modulePath] = t
+				targets = append(targets, t)
+				return t, nil
+			}
+		}
+		return nil, fmt.Errorf("no local replacement for %s", modulePath)
+	}
+
+	if len(packages) == 0 {
+		main.patterns = append(main.patterns, "./...")
+		for _, mod := range env.workspace {
+			main.patterns = append(main.patterns, mod+"/...")
+		}
+		for _, r := range replacements {
+			if !r.New.IsLocal() {
+				continue
+			}
+			t, err := replacementTarget(r.Old.ModulePath())
+			if err != nil {
+				return err
+			}
+			t.patterns = []string{"./..."}
+		}
+	}
+	for _, pkg := range packages {
+		if mod, ok := localReplacementFor(pkg, replacements); ok {
+			t, err := replacementTarget(mod)
+			if err != nil {
+				return err
+			}
+			pattern := "."
+			if rel := strings.TrimPrefix(pkg, mod+"/"); rel != pkg {
+				pattern = "./" + rel
+			}
+			t.patterns = append(t.patterns, pattern)
+		} else if _, ok := env.workspaceModuleFor(pkg); ok {
+			main.patterns = append(main.patterns, pkg)
+		} else {
+			return fmt.Errorf("cannot generate %s: go generate only runs in workspace modules and local replacements, not in the module cache", pkg)
+		}
+	}
+	if len(main.patterns) > 0 {
+		targets = append([]*generateTarget{main}, targets...)
+	}
+
+	for _, t := range targets {
+		env.log.Printf("[INFO] Running go generate in %s: %s", t.dir, strings.Join(t.patterns, " "))
+		cmd := env.newCommand(ctx, GetGo(), append([]string{"generate"}, t.patterns...)...)
+		cmd.Dir = t.dir
+		if t.replacement {
+			// the replacement is not a module of the workspace
+			cmd.Env = setEnv(cmd.Env, "GOWORK=off")
+		}
+		if err := env.runCommand(ctx, cmd); err != nil {
+			return fmt.Errorf("go generate in %s: %v", t.dir, err)
+		}
+	}
+	return nil
+}
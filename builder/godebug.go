@@ -0,0 +1,62 @@
+package builder
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var (
+	// goExperimentName matches the names of GOEXPERIMENT
+	// values, such as greenteagc or noregabi.
+	goExperimentName = regexp.MustCompile(`^[a-z0-9]+$`)
+
+	// goDebugToken matches the keys and values of GODEBUG
+	// settings, such as http2client=0 or default=go1.21.
+	goDebugToken = regexp.MustCompile(`^[A-Za-z0-9_.]+$`)
+)
+
+// checkGoExperiment returns an error if experiment is not a
+// comma-separated list of experiment names, each of which may be
+// prefixed with "no" to disable the experiment.
+func checkGoExperiment(experiment string) error {
+	if experiment == "" {
+		return nil
+	}
+	for _, name := range strings.Split(experiment, ",") {
+		if !goExperimentName.MatchString(name) {
+			return fmt.Errorf("invalid experiment %q in %q", name, experiment)
+		}
+	}
+	return nil
+}
+
+// checkGoDebug returns an error if a key or
+// value of settings is not a GODEBUG token.
+func checkGoDebug(settings map[string]string) error {
+	for _, key := range sortedKeys(settings) {
+		if !goDebugToken.MatchString(key) {
+			return fmt.Errorf("invalid GODEBUG setting %q", key)
+		}
+		if !goDebugToken.MatchString(settings[key]) {
+			return fmt.Errorf("invalid value %q of GODEBUG setting %s", settings[key], key)
+		}
+	}
+	return nil
+}
+
+// goDebugDirectives returns the //go:debug directives that make
+// settings the GODEBUG defaults of the binary. The go command only
+// reads them before the package clause of the main package.
+func goDebugDirectives(settings map[string]string) []byte {
+	if len(settings) == 0 {
+		return nil
+	}
+	var buf bytes.Buffer
+	for _, key := range sortedKeys(settings) {
+		fmt.Fprintf(&buf, "//go:debug %s=%s\n", key, settings[key])
+	}
+	buf.WriteString("\n")
+	return buf.Bytes()
+}
@@ -0,0 +1,112 @@
+package builder
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/mod/modfile"
+	"golang.org/x/mod/module"
+)
+
+// editGoMod applies edit to the go.mod file of the main module of
+// env in-process, rather than by running `go mod edit`, so that no
+// path needs to be quoted for a command line and the file is
+// formatted as the go command formats it. args are the equivalent
+// arguments of `go mod edit`, which a Plan records instead. The
+// file is on this host even with an executor, which is given the
+// change before the next command runs.
+func (env environment) editGoMod(ctx context.Context, args []string, edit func(f *modfile.File) error) error {
+	if env.plan != nil {
+		env.plan.record(env.newGoModCommand(ctx, append([]string{"edit"}, args...)...))
+		return nil
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	goModPath := filepath.Join(env.tempFolder, "go.mod")
+	data, err := os.ReadFile(goModPath)
+	if err != nil {
+		return err
+	}
+	f, err := modfile.Parse(goModPath, data, nil)
+	if err != nil {
+		return err
+	}
+	if err := edit(f); err != nil {
+		return fmt.Errorf("editing go.mod: %v", err)
+	}
+	f.Cleanup()
+	data, err = f.Format()
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(goModPath, data, 0644)
+}
+
+// addReplace adds a replace directive for r to go.mod, whose new
+// path must be an absolute directory or have a concrete version.
+func (env environment) addReplace(ctx context.Context, r Replace) error {
+	oldPath, oldVersion := r.Old.split()
+	newPath, newVersion := r.New.split()
+	arg := pathAtVersion(oldPath, oldVersion) + "=" + pathAtVersion(newPath, newVersion)
+	return env.editGoMod(ctx, []string{"-replace", arg}, func(f *modfile.File) error {
+		if err := checkModuleVersion(oldPath, oldVersion); err != nil {
+			return err
+		}
+		if !r.New.IsLocal() {
+			if newVersion == "" {
+				return fmt.Errorf("replacement module %s needs a version", newPath)
+			}
+			if err := checkModuleVersion(newPath, newVersion); err != nil {
+				return err
+			}
+		}
+		return f.AddReplace(oldPath, oldVersion, newPath, newVersion)
+	})
+}
+
+// addExclude adds an exclude directive for modulePath at
+// version, which must be canonical, to go.mod.
+func (env environment) addExclude(ctx context.Context, modulePath, version string) error {
+	return env.editGoMod(ctx, []string{"-exclude", modulePath + "@" + version}, func(f *modfile.File) error {
+		return f.AddExclude(modulePath, version)
+	})
+}
+
+// addRequire adds a require directive for modulePath at
+// version, which must be canonical, to go.mod.
+func (env environment) addRequire(ctx context.Context, modulePath, version string) error {
+	return env.editGoMod(ctx, []string{"-require", modulePath + "@" + version}, func(f *modfile.File) error {
+		if err := checkModuleVersion(modulePath, version); err != nil {
+			return err
+		}
+		return f.AddRequire(modulePath, version)
+	})
+}
+
+// setGoDirective sets the go directive of go.mod to version.
+func (env environment) setGoDirective(ctx context.Context, version string) error {
+	return env.editGoMod(ctx, []string{"-go=" + version}, func(f *modfile.File) error {
+		return f.AddGoStmt(version)
+	})
+}
+
+// checkModuleVersion returns an error if modulePath is not a valid
+// module path or version, if any, is not a canonical version of it.
+func checkModuleVersion(modulePath, version string) error {
+	if version == "" {
+		return module.CheckPath(modulePath)
+	}
+	return module.Check(modulePath, version)
+}
+
+// pathAtVersion returns modulePath@version, or
+// modulePath alone if version is empty.
+func pathAtVersion(modulePath, version string) string {
+	if version == "" {
+		return modulePath
+	}
+	return modulePath + "@" + version
+}
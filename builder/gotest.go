@@ -0,0 +1,63 @@
+package builder
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// Test prepares the same build environment as Build, with all
+// plugins and replacements, and runs `go test` in it for the given
+// packages, so that tests run against exactly the module graph of
+// the final build. If no packages are given, the packages of all
+// plugins (and below them) are tested. testFlags are passed to
+// `go test` after the packages, so they may include -args.
+//
+// Tests run for the host platform, with BuildTags, BuildFlags, and
+// the race detector applied as for a build. The output of the tests
+// goes to Stdout and Stderr; a failing test results in an error.
+func (b Builder) Test(ctx context.Context, packages []string, testFlags []string) (err error) {
+	var cancel context.CancelFunc
+	if b.TimeoutBuild > 0 {
+		ctx, cancel = context.WithTimeout(ctx, b.TimeoutBuild)
+		defer cancel()
+	}
+	if len(packages) == 0 {
+		for _, p := range b.Plugins {
+			packages = append(packages, p.PackagePath+"/...")
+		}
+	}
+
+	ctx, _, endBuild, err := b.beginBuild(ctx, time.Now(), "")
+	defer func() { endBuild(err) }()
+	if err != nil {
+		return err
+	}
+	buildEnv, err := b.newEnvironment(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() { err = buildEnv.finish(ctx, err) }()
+	if err := buildEnv.tidy(ctx); err != nil {
+		return err
+	}
+	if err := b.checkEnvironment(ctx, buildEnv); err != nil {
+		return err
+	}
+
+	b.logger().Printf("[INFO] Testing %s", strings.Join(packages, " "))
+	cmd := buildEnv.newGoBuildCommand(ctx, "test")
+	env, err := b.sanitizerSettings(buildEnv, buildEnv.environ())
+	if err != nil {
+		return err
+	}
+	cmd.Args = append(cmd.Args, b.sanitizerFlags()...)
+	// go mod tidy does not keep the test dependencies of
+	// other modules' packages, so let go test add them
+	cmd.Args = append(cmd.Args, "-mod=mod")
+	cmd.Args = mergeTags(cmd.Args, b.BuildTags...)
+	cmd.Args = append(cmd.Args, packages...)
+	cmd.Args = append(cmd.Args, testFlags...)
+	cmd.Env = b.cgoEnv(env)
+	return buildEnv.runCommand(ctx, cmd)
+}
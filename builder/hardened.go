@@ -0,0 +1,137 @@
+package builder
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"golang.org/x/mod/semver"
+)
+
+// HardeningMeasure is a hardening measure that Hardened asks for,
+// and whether it applies to the binary of the build.
+type HardeningMeasure struct {
+	// The measure: trimpath, pie, full_relro,
+	// stack_protector, or fortify_source.
+	Name string `json:"name"`
+
+	// Whether the binary was built with it.
+	Applied bool `json:"applied"`
+
+	// Why the measure does not apply, if it does not.
+	Reason string `json:"reason,omitempty"`
+}
+
+const (
+	// minBindNowGoVersion is the first Go version whose
+	// linker has -bindnow, which gives PIE full RELRO.
+	minBindNowGoVersion = "go1.22"
+
+	// hardenedCFlags harden the C code of cgo builds. Setting
+	// CGO_CFLAGS replaces the default of the go command, -O2 -g,
+	// which _FORTIFY_SOURCE needs the optimization of.
+	hardenedCFlags = "-O2 -g -fstack-protector-strong -D_FORTIFY_SOURCE=2"
+)
+
+// pieTargets lists the targets for which
+// the go command supports -buildmode=pie.
+var pieTargets = map[string]bool{
+	"linux/386": true, "linux/amd64": true, "linux/arm": true, "linux/arm64": true,
+	"linux/loong64": true, "linux/ppc64le": true, "linux/riscv64": true, "linux/s390x": true,
+	"android/386": true, "android/amd64": true, "android/arm": true, "android/arm64": true,
+	"darwin/amd64": true, "darwin/arm64": true, "ios/amd64": true, "ios/arm64": true,
+	"freebsd/amd64": true, "openbsd/arm64": true, "aix/ppc64": true,
+	"windows/386": true, "windows/amd64": true, "windows/arm": true, "windows/arm64": true,
+}
+
+// elfTargets lists the operating systems whose binaries are
+// ELF files, the only ones that RELRO and the C flags apply to.
+var elfTargets = map[string]bool{
+	"linux": true, "android": true, "freebsd": true, "netbsd": true,
+	"openbsd": true, "dragonfly": true, "illumos": true, "solaris": true,
+}
+
+// hardenedSettings applies Hardened to b for the toolchain of
+// buildEnv, and returns the arguments and linker flags for go
+// build, along with the measures that do and do not apply to the
+// target. It sets BuildMode to pie and adds to the cgo flags of b.
+func (b *Builder) hardenedSettings(ctx context.Context, buildEnv *environment) (args, ldflags []string, measures []HardeningMeasure, err error) {
+	target := b.targetOS() + "/" + b.targetArch()
+	skip := func(name, format string, a ...interface{}) {
+		measures = append(measures, HardeningMeasure{Name: name, Reason: fmt.Sprintf(format, a...)})
+	}
+	apply := func(name string) {
+		measures = append(measures, HardeningMeasure{Name: name, Applied: true})
+	}
+
+	// -trimpath keeps the paths of the build host out of the
+	// binary; Reproducible already passes it
+	if !b.Reproducible {
+		args = append(args, "-trimpath")
+	}
+	apply("trimpath")
+
+	pie := false
+	switch {
+	case b.BuildMode == BuildModeExe:
+		return nil, nil, nil, fmt.Errorf("hardened builds cannot use build mode exe, which is not position independent")
+	case b.BuildMode == BuildModePIE:
+		pie = true
+	case b.BuildMode != "":
+		skip("pie", "build mode %s is not an executable", b.BuildMode)
+	case b.Static:
+		skip("pie", "static binaries are not position independent")
+	case !pieTargets[target]:
+		skip("pie", "%s does not support position independent executables", target)
+	default:
+		b.BuildMode = BuildModePIE
+		pie = true
+	}
+	if pie {
+		apply("pie")
+	}
+
+	elf := elfTargets[b.targetOS()]
+	switch {
+	case !elf:
+		skip("full_relro", "%s binaries are not ELF files", b.targetOS())
+	case !pie:
+		skip("full_relro", "requires a position independent executable")
+	default:
+		goVersion, err := buildEnv.goVersion(ctx)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		if v := goSemver(goVersion); semver.IsValid(v) && semver.Compare(v, goSemver(minBindNowGoVersion)) < 0 {
+			skip("full_relro", "the linker of %s has no -bindnow; use %s or newer", goVersion, minBindNowGoVersion)
+		} else {
+			ldflags = append(ldflags, "-bindnow")
+			apply("full_relro")
+		}
+	}
+
+	for _, name := range []string{"stack_protector", "fortify_source"} {
+		switch {
+		case !b.Compile.Cgo:
+			skip(name, "no C code is compiled without cgo")
+		case !elf:
+			skip(name, "only applied to C code for ELF targets")
+		default:
+			apply(name)
+		}
+	}
+	if b.Compile.Cgo && elf {
+		b.Compile.CgoCFlags = strings.TrimSpace(hardenedCFlags + " " + b.Compile.CgoCFlags)
+	}
+
+	var applied []string
+	for _, m := range measures {
+		if m.Applied {
+			applied = append(applied, m.Name)
+		} else {
+			b.logger().Printf("[INFO] Hardening: not applying %s: %s", m.Name, m.Reason)
+		}
+	}
+	b.logger().Printf("[INFO] Hardening %s with %s", target, strings.Join(applied, ", "))
+	return args, ldflags, measures, nil
+}
@@ -0,0 +1,55 @@
+package builder
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/google/shlex"
+)
+
+// Hooks are commands that run at certain points of a build, inside
+// the build environment. Each command is a command line, split into
+// arguments like BuildFlags (no shell is involved), and runs in the
+// folder of the build environment with the environment of its go
+// commands plus GOAWAY_BUILDER_DIR, the folder of the build
+// environment. A failing command fails the build.
+type Hooks struct {
+	// PostTidy commands run after the module has been tidied
+	// and before the checks that gate compilation.
+	PostTidy []string `json:"post_tidy,omitempty"`
+
+	// PreBuild commands run right before `go build`, with
+	// GOOS, GOARCH, and the other target settings of the build.
+	PreBuild []string `json:"pre_build,omitempty"`
+
+	// PostBuild commands run after the binary was built and
+	// compressed but before it is described in the BuildResult,
+	// so they may modify it (for example, to sign it). They also
+	// get GOAWAY_BUILDER_OUTPUT, the absolute path of the binary.
+	PostBuild []string `json:"post_build,omitempty"`
+}
+
+// runHooks runs the commands of the named stage in buildEnv with
+// the environment vars, or that of its go commands if vars is nil.
+func (env environment) runHooks(ctx context.Context, stage string, commands []string, vars []string) error {
+	if vars == nil {
+		vars = env.environ()
+	}
+	vars = setEnv(append([]string(nil), vars...), "GOAWAY_BUILDER_DIR="+env.tempFolder)
+	for _, command := range commands {
+		args, err := shlex.Split(command)
+		if err != nil {
+			return fmt.Errorf("%s hook %q: %v", stage, command, err)
+		}
+		if len(args) == 0 {
+			continue
+		}
+		env.log.Printf("[INFO] Running %s hook: %s", stage, command)
+		cmd := env.newCommand(ctx, args[0], args[1:]...)
+		cmd.Env = vars
+		if err := env.runCommand(ctx, cmd); err != nil {
+			return fmt.Errorf("%s hook %q: %v", stage, command, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,564 @@
+package builder
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ImageOptions configures the container image built by BuildImage.
+type ImageOptions struct {
+	// Base is the base image, e.g. "gcr.io/distroless/static". The
+	// empty string and "scratch" build the image from scratch, which
+	// needs no container runtime; any other base is built with
+	// Runtime, which also loads the image into its local store.
+	Base string `json:"base,omitempty"`
+
+	// Runtime is the container runtime used to build images with a
+	// base and to load images, such as "docker" or "podman".
+	// Default: "docker"
+	Runtime string `json:"runtime,omitempty"`
+
+	// Tags are the references to tag the image
+	// with, e.g. "example.com/caddy:v2.7.6".
+	Tags []string `json:"tags,omitempty"`
+
+	// Path is the path of the binary in
+	// the image. Default: "/usr/bin/caddy"
+	Path string `json:"path,omitempty"`
+
+	// Files are additional files to add to the image, mapping their
+	// paths in the image to files on the host, e.g. CA certificates
+	// at /etc/ssl/certs/ca-certificates.crt for images from scratch.
+	Files map[string]string `json:"files,omitempty"`
+
+	// Entrypoint and Cmd are the entrypoint and the default arguments
+	// of the image. The default entrypoint is the binary, and the
+	// default arguments are those to run Caddy with a Caddyfile at
+	// /etc/caddy/Caddyfile.
+	Entrypoint []string `json:"entrypoint,omitempty"`
+	Cmd        []string `json:"cmd,omitempty"`
+
+	// Env are environment variables of the image as key=value pairs.
+	Env []string `json:"env,omitempty"`
+
+	// User and WorkingDir are the user and the working directory
+	// that the entrypoint runs as and in.
+	User       string `json:"user,omitempty"`
+	WorkingDir string `json:"working_dir,omitempty"`
+
+	// ExposedPorts are the ports the image exposes,
+	// such as "80/tcp" or "443/udp".
+	ExposedPorts []string `json:"exposed_ports,omitempty"`
+
+	// Labels are the labels of the image. The version of
+	// Caddy is added as org.opencontainers.image.version
+	// unless it is set.
+	Labels map[string]string `json:"labels,omitempty"`
+
+	// Load loads the image into the local store of Runtime.
+	Load bool `json:"load,omitempty"`
+
+	// OCILayout, if set, is a directory to write the image to as an
+	// OCI image layout. It is only supported for images from scratch.
+	OCILayout string `json:"oci_layout,omitempty"`
+
+	// Tarball, if set, is a file to write the image to as a tarball
+	// that `docker load` and `podman load` accept: an OCI image
+	// layout for images from scratch, or the output of `save` of
+	// Runtime for images with a base.
+	Tarball string `json:"tarball,omitempty"`
+}
+
+func (o ImageOptions) runtime() string {
+	if o.Runtime != "" {
+		return o.Runtime
+	}
+	return "docker"
+}
+
+func (o ImageOptions) scratch() bool {
+	return o.Base == "" || o.Base == "scratch"
+}
+
+func (o ImageOptions) path() string {
+	if o.Path != "" {
+		return o.Path
+	}
+	return "/usr/bin/caddy"
+}
+
+func (o ImageOptions) entrypoint() []string {
+	if o.Entrypoint != nil {
+		return o.Entrypoint
+	}
+	return []string{o.path()}
+}
+
+func (o ImageOptions) cmd() []string {
+	if o.Cmd != nil || o.Entrypoint != nil {
+		return o.Cmd
+	}
+	return []string{"run", "--config", "/etc/caddy/Caddyfile", "--adapter", "caddyfile"}
+}
+
+// ImageResult describes an image built by BuildImage.
+type ImageResult struct {
+	// Build describes the binary in the image.
+	Build *BuildResult `json:"build,omitempty"`
+
+	// ID is the digest of the image: that of its manifest for images
+	// from scratch, or the image ID reported by Runtime otherwise.
+	ID string `json:"id,omitempty"`
+
+	// Tags are the references the image was tagged with.
+	Tags []string `json:"tags,omitempty"`
+}
+
+// BuildImage builds Caddy and wraps the binary into a container image
+// for linux, as configured by opts, which must load the image or
+// write it somewhere. The platform defaults to linux on the
+// architecture of the host or GOARCH.
+func (b Builder) BuildImage(ctx context.Context, opts ImageOptions) (*ImageResult, error) {
+	if !opts.Load && opts.OCILayout == "" && opts.Tarball == "" {
+		return nil, fmt.Errorf("the image must be loaded or written to an OCI layout or tarball")
+	}
+	if opts.OCILayout != "" && !opts.scratch() {
+		return nil, fmt.Errorf("OCI layouts are only supported for images from scratch, not %s", opts.Base)
+	}
+	if !path.IsAbs(opts.path()) {
+		return nil, fmt.Errorf("path of the binary in the image must be absolute: %s", opts.path())
+	}
+	for dest := range opts.Files {
+		if !path.IsAbs(dest) {
+			return nil, fmt.Errorf("path of file in the image must be absolute: %s", dest)
+		}
+	}
+	for _, tag := range opts.Tags {
+		if tag == "" || strings.ContainsAny(tag, " \t\n") {
+			return nil, fmt.Errorf("invalid image tag: %q", tag)
+		}
+	}
+	if b.OS == "" && os.Getenv("GOOS") == "" {
+		b.OS = "linux"
+	}
+	if goos := b.OS; goos != "linux" && (goos != "" || os.Getenv("GOOS") != "linux") {
+		return nil, fmt.Errorf("container images can only be built for linux, not %s", goos)
+	}
+	if b.SkipBuild {
+		return nil, fmt.Errorf("container images cannot be built with SkipBuild")
+	}
+	if opts.scratch() && b.Compile.Cgo {
+		b.logger().Printf("[WARNING] Images from scratch have no C library; binaries built with cgo may not run in them")
+	}
+
+	tempDir, err := os.MkdirTemp("", "goaway_image_")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tempDir)
+
+	build, err := b.Build(ctx, filepath.Join(tempDir, "caddy"))
+	if err != nil {
+		return nil, err
+	}
+	if opts.Labels["org.opencontainers.image.version"] == "" && build.CaddyVersion != "" {
+		labels := map[string]string{"org.opencontainers.image.version": build.CaddyVersion}
+		for k, v := range opts.Labels {
+			labels[k] = v
+		}
+		opts.Labels = labels
+	}
+	result := &ImageResult{Build: build, Tags: opts.Tags}
+
+	if !opts.scratch() {
+		result.ID, err = b.buildImageWithRuntime(ctx, opts, build, tempDir)
+		if err != nil {
+			return nil, err
+		}
+		return result, nil
+	}
+
+	created := time.Now().UTC()
+	if b.Reproducible {
+		created = time.Unix(0, 0).UTC()
+	}
+	layout := opts.OCILayout
+	if layout == "" {
+		layout = filepath.Join(tempDir, "oci")
+	}
+	b.logger().Printf("[INFO] Writing image from scratch: %s", layout)
+	result.ID, err = writeOCILayout(layout, opts, build, created)
+	if err != nil {
+		return nil, fmt.Errorf("writing image: %v", err)
+	}
+
+	tarball := opts.Tarball
+	if tarball == "" && opts.Load {
+		tarball = filepath.Join(tempDir, "image.tar")
+	}
+	if tarball != "" {
+		if err := writeLayoutTarball(tarball, layout); err != nil {
+			return nil, fmt.Errorf("writing image tarball: %v", err)
+		}
+	}
+	if opts.Load {
+		b.logger().Printf("[INFO] Loading image into %s", opts.runtime())
+		cmd := exec.CommandContext(ctx, opts.runtime(), "load", "-i", tarball)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			return nil, fmt.Errorf("loading image: %v: %s", err, strings.TrimSpace(string(out)))
+		}
+	}
+	return result, nil
+}
+
+// buildImageWithRuntime builds the image of opts on its base with the
+// container runtime from the binary in dir and returns the image ID.
+func (b Builder) buildImageWithRuntime(ctx context.Context, opts ImageOptions, build *BuildResult, dir string) (string, error) {
+	var df strings.Builder
+	fmt.Fprintf(&df, "FROM %s\n", opts.Base)
+	fmt.Fprintf(&df, "COPY %s\n", jsonString([]string{filepath.Base(build.OutputFile), opts.path()}))
+	for i, dest := range sortedKeys(opts.Files) {
+		name := fmt.Sprintf("file%d", i)
+		if err := copyFile(opts.Files[dest], filepath.Join(dir, name), 0644); err != nil {
+			return "", err
+		}
+		fmt.Fprintf(&df, "COPY %s\n", jsonString([]string{name, dest}))
+	}
+	for _, k := range sortedKeys(opts.Labels) {
+		fmt.Fprintf(&df, "LABEL %s=%s\n", jsonString(k), jsonString(opts.Labels[k]))
+	}
+	for _, kv := range opts.Env {
+		k, v, _ := strings.Cut(kv, "=")
+		fmt.Fprintf(&df, "ENV %s=%s\n", k, jsonString(v))
+	}
+	for _, port := range opts.ExposedPorts {
+		fmt.Fprintf(&df, "EXPOSE %s\n", port)
+	}
+	if opts.WorkingDir != "" {
+		fmt.Fprintf(&df, "WORKDIR %s\n", opts.WorkingDir)
+	}
+	if opts.User != "" {
+		fmt.Fprintf(&df, "USER %s\n", opts.User)
+	}
+	fmt.Fprintf(&df, "ENTRYPOINT %s\n", jsonString(opts.entrypoint()))
+	if cmd := opts.cmd(); cmd != nil {
+		fmt.Fprintf(&df, "CMD %s\n", jsonString(cmd))
+	}
+	if err := os.WriteFile(filepath.Join(dir, "Dockerfile"), []byte(df.String()), 0644); err != nil {
+		return "", err
+	}
+
+	iidFile := filepath.Join(dir, "iid")
+	args := []string{"build", "--platform", imagePlatform(build.Platform), "--iidfile", iidFile}
+	for _, tag := range opts.Tags {
+		args = append(args, "-t", tag)
+	}
+	args = append(args, dir)
+	b.logger().Printf("[INFO] Building image on %s with %s", opts.Base, opts.runtime())
+	cmd := exec.CommandContext(ctx, opts.runtime(), args...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("building image: %v", err)
+	}
+	iid, err := os.ReadFile(iidFile)
+	if err != nil {
+		return "", fmt.Errorf("reading image ID: %v", err)
+	}
+	id := strings.TrimSpace(string(iid))
+
+	if opts.Tarball != "" {
+		refs := opts.Tags
+		if len(refs) == 0 {
+			refs = []string{id}
+		}
+		args := append([]string{"save", "-o", opts.Tarball}, refs...)
+		if out, err := exec.CommandContext(ctx, opts.runtime(), args...).CombinedOutput(); err != nil {
+			return "", fmt.Errorf("saving image: %v: %s", err, strings.TrimSpace(string(out)))
+		}
+	}
+	return id, nil
+}
+
+// jsonString returns v encoded as JSON, for the
+// exec form and the quoted strings of Dockerfiles.
+func jsonString(v interface{}) string {
+	data, _ := json.Marshal(v)
+	return string(data)
+}
+
+// imagePlatform returns p in the os/arch[/variant]
+// form of the --platform flag of container runtimes.
+func imagePlatform(p Platform) string {
+	s := p.OS + "/" + p.Arch
+	if variant := imageVariant(p); variant != "" {
+		s += "/" + variant
+	}
+	return s
+}
+
+// imageVariant returns the OCI platform variant of p, if any.
+func imageVariant(p Platform) string {
+	switch p.Arch {
+	case "arm":
+		if p.ARM != "" {
+			return "v" + strings.SplitN(p.ARM, ",", 2)[0]
+		}
+	case "arm64":
+		return "v8"
+	}
+	return ""
+}
+
+// Media types of OCI images.
+const (
+	ociIndexMediaType    = "application/vnd.oci.image.index.v1+json"
+	ociManifestMediaType = "application/vnd.oci.image.manifest.v1+json"
+	ociConfigMediaType   = "application/vnd.oci.image.config.v1+json"
+	ociLayerMediaType    = "application/vnd.oci.image.layer.v1.tar+gzip"
+)
+
+type ociDescriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Platform    *ociPlatform      `json:"platform,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+type ociPlatform struct {
+	Architecture string `json:"architecture"`
+	OS           string `json:"os"`
+	Variant      string `json:"variant,omitempty"`
+}
+
+// writeOCILayout writes an image from scratch with the binary of
+// build and the files of opts as its only layer to dir as an OCI
+// image layout, and returns the digest of its manifest.
+func writeOCILayout(dir string, opts ImageOptions, build *BuildResult, created time.Time) (string, error) {
+	if err := os.MkdirAll(filepath.Join(dir, "blobs", "sha256"), 0755); err != nil {
+		return "", err
+	}
+	writeBlob := func(data []byte) (string, error) {
+		sum := sha256.Sum256(data)
+		digest := "sha256:" + hex.EncodeToString(sum[:])
+		return digest, os.WriteFile(filepath.Join(dir, "blobs", "sha256", hex.EncodeToString(sum[:])), data, 0644)
+	}
+
+	files := map[string]string{opts.path(): build.OutputFile}
+	for dest, src := range opts.Files {
+		files[dest] = src
+	}
+	layer, diffID, err := imageLayer(files, opts.path(), created)
+	if err != nil {
+		return "", err
+	}
+	layerDigest, err := writeBlob(layer)
+	if err != nil {
+		return "", err
+	}
+
+	platform := ociPlatform{
+		Architecture: build.Platform.Arch,
+		OS:           build.Platform.OS,
+		Variant:      imageVariant(build.Platform),
+	}
+	exposed := make(map[string]struct{})
+	for _, port := range opts.ExposedPorts {
+		if !strings.Contains(port, "/") {
+			port += "/tcp"
+		}
+		exposed[port] = struct{}{}
+	}
+	env := opts.Env
+	if len(env) == 0 {
+		env = []string{"PATH=/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"}
+	}
+	config, err := json.Marshal(struct {
+		ociPlatform
+		Created string      `json:"created"`
+		Config  interface{} `json:"config"`
+		RootFS  interface{} `json:"rootfs"`
+		History interface{} `json:"history"`
+	}{
+		ociPlatform: platform,
+		Created:     created.Format(time.RFC3339),
+		Config: struct {
+			Entrypoint   []string            `json:"Entrypoint,omitempty"`
+			Cmd          []string            `json:"Cmd,omitempty"`
+			Env          []string            `json:"Env,omitempty"`
+			User         string              `json:"User,omitempty"`
+			WorkingDir   string              `json:"WorkingDir,omitempty"`
+			ExposedPorts map[string]struct{} `json:"ExposedPorts,omitempty"`
+			Labels       map[string]string   `json:"Labels,omitempty"`
+		}{opts.entrypoint(), opts.cmd(), env, opts.User, opts.WorkingDir, exposed, opts.Labels},
+		RootFS: map[string]interface{}{"type": "layers", "diff_ids": []string{diffID}},
+		History: []map[string]string{{
+			"created":    created.Format(time.RFC3339),
+			"created_by": "goaway-builder",
+		}},
+	})
+	if err != nil {
+		return "", err
+	}
+	configDigest, err := writeBlob(config)
+	if err != nil {
+		return "", err
+	}
+
+	manifest, err := json.Marshal(map[string]interface{}{
+		"schemaVersion": 2,
+		"mediaType":     ociManifestMediaType,
+		"config":        ociDescriptor{MediaType: ociConfigMediaType, Digest: configDigest, Size: int64(len(config))},
+		"layers":        []ociDescriptor{{MediaType: ociLayerMediaType, Digest: layerDigest, Size: int64(len(layer))}},
+		"annotations":   map[string]string{"org.opencontainers.image.created": created.Format(time.RFC3339)},
+	})
+	if err != nil {
+		return "", err
+	}
+	manifestDigest, err := writeBlob(manifest)
+	if err != nil {
+		return "", err
+	}
+
+	desc := ociDescriptor{
+		MediaType: ociManifestMediaType,
+		Digest:    manifestDigest,
+		Size:      int64(len(manifest)),
+		Platform:  &platform,
+	}
+	var manifests []ociDescriptor
+	for _, tag := range opts.Tags {
+		d := desc
+		d.Annotations = map[string]string{
+			"io.containerd.image.name":          tag,
+			"org.opencontainers.image.ref.name": imageTag(tag),
+		}
+		manifests = append(manifests, d)
+	}
+	if len(manifests) == 0 {
+		manifests = append(manifests, desc)
+	}
+	index, err := json.Marshal(map[string]interface{}{
+		"schemaVersion": 2,
+		"mediaType":     ociIndexMediaType,
+		"manifests":     manifests,
+	})
+	if err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(filepath.Join(dir, "index.json"), index, 0644); err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(filepath.Join(dir, "oci-layout"), []byte(`{"imageLayoutVersion":"1.0.0"}`), 0644); err != nil {
+		return "", err
+	}
+	return manifestDigest, nil
+}
+
+// imageTag returns the tag of the image reference ref, or "latest".
+func imageTag(ref string) string {
+	if i := strings.LastIndex(ref, "@"); i >= 0 {
+		ref = ref[:i]
+	}
+	if i := strings.LastIndex(ref, ":"); i > strings.LastIndex(ref, "/") {
+		return ref[i+1:]
+	}
+	return "latest"
+}
+
+// imageLayer returns a gzip-compressed tar archive of files, which
+// maps paths in the image to files on the host, and the digest of the
+// uncompressed archive. The file at executable is made executable;
+// all entries are owned by root and have the modification time
+// created.
+func imageLayer(files map[string]string, executable string, created time.Time) ([]byte, string, error) {
+	var buf bytes.Buffer
+	diff := sha256.New()
+	zw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(io.MultiWriter(zw, diff))
+
+	dests := sortedKeys(files)
+	dirs := make(map[string]bool)
+	for _, dest := range dests {
+		for dir := path.Dir(path.Clean(dest)); dir != "/"; dir = path.Dir(dir) {
+			dirs[dir] = true
+		}
+	}
+	sortedDirs := make([]string, 0, len(dirs))
+	for dir := range dirs {
+		sortedDirs = append(sortedDirs, dir)
+	}
+	sort.Strings(sortedDirs)
+	for _, dir := range sortedDirs {
+		err := tw.WriteHeader(&tar.Header{
+			Typeflag: tar.TypeDir,
+			Name:     strings.TrimPrefix(dir, "/") + "/",
+			Mode:     0755,
+			ModTime:  created,
+			Format:   tar.FormatPAX,
+		})
+		if err != nil {
+			return nil, "", err
+		}
+	}
+	for _, dest := range dests {
+		data, err := os.ReadFile(files[dest])
+		if err != nil {
+			return nil, "", err
+		}
+		mode := int64(0644)
+		if dest == executable {
+			mode = 0755
+		}
+		err = tw.WriteHeader(&tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     strings.TrimPrefix(path.Clean(dest), "/"),
+			Mode:     mode,
+			Size:     int64(len(data)),
+			ModTime:  created,
+			Format:   tar.FormatPAX,
+		})
+		if err != nil {
+			return nil, "", err
+		}
+		if _, err := tw.Write(data); err != nil {
+			return nil, "", err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, "", err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, "", err
+	}
+	return buf.Bytes(), "sha256:" + hex.EncodeToString(diff.Sum(nil)), nil
+}
+
+// writeLayoutTarball writes the OCI image layout in dir to file as
+// a tar archive, which container runtimes can load.
+func writeLayoutTarball(file, dir string) error {
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	if err := writeTar(f, dir); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,303 @@
+package builder
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// LicenseUnknown is the license ID of modules
+// whose license could not be detected.
+const LicenseUnknown = "NOASSERTION"
+
+// LicensePolicy configures the license inventory that is taken
+// of the modules in the build before compiling. Licenses are
+// detected from the license files at the root of each module in
+// the module cache, so the module cache must be readable on the
+// host; with an executor that keeps its own module cache, the
+// licenses of its modules are unknown.
+type LicensePolicy struct {
+	// Deny lists the SPDX IDs of licenses that fail the build,
+	// such as "AGPL-3.0". An ID also denies its -only and
+	// -or-later variants, and an ID ending in "*" denies every
+	// ID that starts with the rest, e.g. "GPL-*".
+	Deny []string `json:"deny,omitempty"`
+
+	// DenyUnknown makes modules whose license cannot be
+	// detected fail the build as well.
+	DenyUnknown bool `json:"deny_unknown,omitempty"`
+}
+
+// denies reports whether the policy denies the license id.
+func (p LicensePolicy) denies(id string) bool {
+	if id == LicenseUnknown {
+		return p.DenyUnknown
+	}
+	for _, deny := range p.Deny {
+		if prefix := strings.TrimSuffix(deny, "*"); prefix != deny {
+			if strings.HasPrefix(strings.ToLower(id), strings.ToLower(prefix)) {
+				return true
+			}
+			continue
+		}
+		if strings.EqualFold(id, deny) || strings.HasPrefix(strings.ToLower(id), strings.ToLower(deny)+"-") {
+			return true
+		}
+	}
+	return false
+}
+
+// ModuleLicense is the license of a module in the build.
+type ModuleLicense struct {
+	Module  string `json:"module,omitempty"`
+	Version string `json:"version,omitempty"`
+
+	// The SPDX IDs of the detected licenses, or LicenseUnknown.
+	// Modules with several license files may have several.
+	Licenses []string `json:"licenses,omitempty"`
+
+	// The license files the licenses were detected from.
+	Files []string `json:"files,omitempty"`
+}
+
+func (m ModuleLicense) String() string {
+	s := m.Module
+	if m.Version != "" {
+		s += "@" + m.Version
+	}
+	return s
+}
+
+// LicenseReport is the license inventory of a build.
+type LicenseReport struct {
+	// Modules are all modules in the build, sorted by path.
+	Modules []ModuleLicense `json:"modules,omitempty"`
+
+	// ByLicense maps every detected SPDX ID, and LicenseUnknown,
+	// to the modules under that license, as path@version.
+	ByLicense map[string][]string `json:"by_license,omitempty"`
+}
+
+// LicenseError is returned when the license
+// policy denies the licenses of any modules.
+type LicenseError struct {
+	Denied []ModuleLicense
+}
+
+func (e *LicenseError) Error() string {
+	lines := make([]string, 0, len(e.Denied))
+	for _, m := range e.Denied {
+		lines = append(lines, fmt.Sprintf("%s (%s)", m, strings.Join(m.Licenses, ", ")))
+	}
+	return fmt.Sprintf("%d module(s) with denied licenses: %s", len(e.Denied), strings.Join(lines, "; "))
+}
+
+// checkLicenses takes the license inventory of the modules that
+// provide the packages of the build in env, with the given build
+// tags, logs it, and records it in env. It returns a LicenseError
+// if the policy denies any of the licenses.
+func (env *environment) checkLicenses(ctx context.Context, policy LicensePolicy, tags []string) error {
+	if env.plan != nil {
+		// queries are not run when planning
+		return nil
+	}
+	env.log.Printf("[INFO] Taking license inventory")
+	var out bytes.Buffer
+	cmd := env.newCommand(ctx, GetGo(), "list", "-deps", "-f",
+		"{{with .Module}}{{if not .Main}}{{.Path}}\t{{.Version}}\t{{if .Replace}}{{.Replace.Dir}}{{else}}{{.Dir}}{{end}}{{end}}{{end}}")
+	if len(tags) > 0 {
+		cmd.Args = append(cmd.Args, "-tags", strings.Join(tags, ","))
+	}
+	cmd.Args = append(cmd.Args, ".")
+	cmd.Stdout = &out
+	if err := env.runCommand(ctx, cmd); err != nil {
+		return err
+	}
+
+	// go list prints the module of every package
+	seen := make(map[string]bool)
+	report := &LicenseReport{ByLicense: make(map[string][]string)}
+	for _, line := range strings.Split(out.String(), "\n") {
+		fields := strings.Split(line, "\t")
+		if len(fields) != 3 || seen[fields[0]] {
+			continue
+		}
+		seen[fields[0]] = true
+		m := ModuleLicense{Module: fields[0], Version: fields[1]}
+		m.Licenses, m.Files = detectLicenses(fields[2])
+		if len(m.Licenses) == 0 {
+			m.Licenses = []string{LicenseUnknown}
+		}
+		for _, id := range m.Licenses {
+			report.ByLicense[id] = append(report.ByLicense[id], m.String())
+		}
+		report.Modules = append(report.Modules, m)
+	}
+	sort.Slice(report.Modules, func(i, j int) bool { return report.Modules[i].Module < report.Modules[j].Module })
+	for _, modules := range report.ByLicense {
+		sort.Strings(modules)
+	}
+
+	ids := make([]string, 0, len(report.ByLicense))
+	for id := range report.ByLicense {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	summary := make([]string, 0, len(ids))
+	for _, id := range ids {
+		summary = append(summary, fmt.Sprintf("%s (%d)", id, len(report.ByLicense[id])))
+	}
+	env.log.Printf("[INFO] Licenses of %d module(s): %s", len(report.Modules), strings.Join(summary, ", "))
+
+	var denied []ModuleLicense
+	for _, m := range report.Modules {
+		for _, id := range m.Licenses {
+			if policy.denies(id) {
+				env.log.Printf("[WARNING] License %s of %s is denied", id, m)
+				denied = append(denied, m)
+				break
+			}
+		}
+	}
+	env.licenses = report
+	if len(denied) > 0 {
+		return &LicenseError{Denied: denied}
+	}
+	return nil
+}
+
+// licenseFilePrefixes are the upper-cased prefixes
+// of the names of license files.
+var licenseFilePrefixes = []string{"LICENSE", "LICENCE", "COPYING", "UNLICENSE"}
+
+// maxLicenseFileSize limits how much of a license file is read.
+const maxLicenseFileSize = 64 << 10
+
+// detectLicenses returns the SPDX IDs of the licenses in the
+// license files at the root of the module in dir, and the names
+// of those files.
+func detectLicenses(dir string) ([]string, []string) {
+	if dir == "" {
+		return nil, nil
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, nil
+	}
+	var ids, files []string
+	found := make(map[string]bool)
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		isLicense := false
+		for _, prefix := range licenseFilePrefixes {
+			if strings.HasPrefix(strings.ToUpper(entry.Name()), prefix) {
+				isLicense = true
+				break
+			}
+		}
+		if !isLicense {
+			continue
+		}
+		f, err := os.Open(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			continue
+		}
+		text, err := io.ReadAll(io.LimitReader(f, maxLicenseFileSize))
+		f.Close()
+		if err != nil {
+			continue
+		}
+		detected := classifyLicense(string(text))
+		if len(detected) == 0 {
+			continue
+		}
+		files = append(files, entry.Name())
+		for _, id := range detected {
+			if !found[id] {
+				found[id] = true
+				ids = append(ids, id)
+			}
+		}
+	}
+	return ids, files
+}
+
+// licensePatterns identify licenses by phrases of their texts, all
+// of which must occur. They are tried in order, so that texts that
+// mention other licenses, such as the MPL naming the GPL as a
+// secondary license, are identified before those.
+var licensePatterns = []struct {
+	id      string
+	phrases []string
+}{
+	{"MPL-2.0", []string{"mozilla public license", "2.0"}},
+	{"EPL-2.0", []string{"eclipse public license - v 2.0"}},
+	{"EPL-1.0", []string{"eclipse public license - v 1.0"}},
+	{"AGPL-3.0", []string{"gnu affero general public license"}},
+	{"LGPL-3.0", []string{"gnu lesser general public license", "version 3"}},
+	{"LGPL-2.1", []string{"gnu lesser general public license", "version 2.1"}},
+	{"LGPL-2.0", []string{"gnu library general public license"}},
+	{"GPL-3.0", []string{"gnu general public license", "version 3"}},
+	{"GPL-2.0", []string{"gnu general public license", "version 2"}},
+	{"Apache-2.0", []string{"apache license", "version 2.0"}},
+	{"BSL-1.0", []string{"boost software license - version 1.0"}},
+	{"Unlicense", []string{"this is free and unencumbered software released into the public domain"}},
+	{"CC0-1.0", []string{"cc0 1.0 universal"}},
+	{"ISC", []string{"distribute this software for any purpose with or without fee"}},
+	{"MIT", []string{"permission is hereby granted, free of charge, to any person obtaining a copy"}},
+	{"BSD-3-Clause", []string{"redistribution and use in source and binary forms", "neither the name"}},
+	{"BSD-3-Clause", []string{"redistribution and use in source and binary forms", "may not be used to endorse or promote"}},
+	{"BSD-2-Clause", []string{"redistribution and use in source and binary forms"}},
+	{"Zlib", []string{"altered source versions must be plainly marked"}},
+}
+
+// classifyLicense returns the SPDX IDs of the license text: those
+// of its SPDX-License-Identifier line, if it has one, or else the
+// ID of the first of licensePatterns that it matches.
+func classifyLicense(text string) []string {
+	scanner := bufio.NewScanner(strings.NewReader(text))
+	for scanner.Scan() {
+		_, expr, ok := strings.Cut(scanner.Text(), "SPDX-License-Identifier:")
+		if !ok {
+			continue
+		}
+		var ids []string
+		fields := strings.Fields(strings.NewReplacer("(", " ", ")", " ").Replace(expr))
+		for i := 0; i < len(fields); i++ {
+			switch fields[i] {
+			case "OR", "AND":
+			case "WITH":
+				// skip the exception
+				i++
+			default:
+				ids = append(ids, fields[i])
+			}
+		}
+		if len(ids) > 0 {
+			return ids
+		}
+	}
+
+	normalized := strings.ToLower(strings.Join(strings.Fields(text), " "))
+	for _, pattern := range licensePatterns {
+		matches := true
+		for _, phrase := range pattern.phrases {
+			if !strings.Contains(normalized, phrase) {
+				matches = false
+				break
+			}
+		}
+		if matches {
+			return []string{pattern.id}
+		}
+	}
+	return nil
+}
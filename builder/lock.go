@@ -0,0 +1,238 @@
+package builder
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"golang.org/x/mod/modfile"
+	"golang.org/x/mod/semver"
+)
+
+// Lock records the outcome of resolving the dependencies of a
+// build, so that the build can be reproduced exactly later, even
+// if it requested the latest versions: see WriteLock and
+// BuilderFromLock.
+type Lock struct {
+	// The base module and main package, as configured.
+	BaseModule  string `json:"base_module,omitempty"`
+	MainPackage string `json:"main_package,omitempty"`
+
+	// The version of the base module and of every plugin, as
+	// selected for the build. Modules that are replaced by local
+	// directories keep the versions they were requested at.
+	CaddyVersion string       `json:"caddy_version,omitempty"`
+	Plugins      []Dependency `json:"plugins,omitempty"`
+
+	// The replace and exclude directives of go.mod,
+	// with their versions resolved.
+	Replacements []Replace    `json:"replacements,omitempty"`
+	Excludes     []Dependency `json:"excludes,omitempty"`
+
+	// The directories of the workspace modules, if any.
+	Workspace []string `json:"workspace,omitempty"`
+
+	// The version of the Go toolchain, e.g. "go1.22.1".
+	GoVersion string `json:"go_version,omitempty"`
+
+	// The modules in the module graph with their hashes
+	// from go.sum, sorted by path and version.
+	Modules []LockedModule `json:"modules,omitempty"`
+}
+
+// LockedModule is a module of a Lock.
+type LockedModule struct {
+	Path    string `json:"path"`
+	Version string `json:"version"`
+
+	// The hashes of the module's content and of its go.mod
+	// file, in go.sum format, e.g. "h1:...". Sum is empty for
+	// modules of which only the go.mod file is needed.
+	Sum      string `json:"sum,omitempty"`
+	GoModSum string `json:"go_mod_sum,omitempty"`
+}
+
+// WriteLock resolves the dependencies of the build like Resolve
+// and writes a Lock of the result to path as JSON, so that
+// BuilderFromLock can reproduce the build. It returns the Lock.
+func (b Builder) WriteLock(ctx context.Context, path string) (_ *Lock, err error) {
+	var cancel context.CancelFunc
+	if b.TimeoutBuild > 0 {
+		ctx, cancel = context.WithTimeout(ctx, b.TimeoutBuild)
+		defer cancel()
+	}
+
+	buildEnv, err := b.newEnvironment(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { err = buildEnv.finish(ctx, err) }()
+
+	if err := buildEnv.tidy(ctx); err != nil {
+		return nil, err
+	}
+	lock, err := b.lock(ctx, buildEnv)
+	if err != nil {
+		return nil, err
+	}
+	data, err := json.MarshalIndent(lock, "", "\t")
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		return nil, err
+	}
+	b.logger().Printf("[INFO] Wrote lock of %d module(s) to %s", len(lock.Modules), path)
+	return lock, nil
+}
+
+// lock returns the Lock of the tidied build environment env.
+func (b Builder) lock(ctx context.Context, env *environment) (*Lock, error) {
+	mod, err := env.resolvedModule()
+	if err != nil {
+		return nil, err
+	}
+	goMod, err := modfile.Parse("go.mod", mod.GoMod, nil)
+	if err != nil {
+		return nil, fmt.Errorf("parsing go.mod: %v", err)
+	}
+	goVersion, err := env.goVersion(ctx)
+	if err != nil {
+		return nil, err
+	}
+	lock := &Lock{
+		BaseModule:   b.BaseModule,
+		MainPackage:  b.MainPackage,
+		CaddyVersion: b.CaddyVersion,
+		GoVersion:    goVersion,
+	}
+	for _, dir := range b.Workspace {
+		absPath, _, err := checkWorkspaceModule(dir)
+		if err != nil {
+			return nil, err
+		}
+		lock.Workspace = append(lock.Workspace, absPath)
+	}
+	for _, r := range goMod.Replace {
+		lock.Replacements = append(lock.Replacements, NewReplace(
+			strings.TrimSpace(r.Old.Path+" "+r.Old.Version),
+			strings.TrimSpace(r.New.Path+" "+r.New.Version)))
+	}
+	for _, x := range goMod.Exclude {
+		lock.Excludes = append(lock.Excludes, Dependency{PackagePath: x.Mod.Path, Version: x.Mod.Version})
+	}
+
+	// the selected versions replace the queries
+	modules, err := env.listModules(ctx)
+	if err != nil {
+		return nil, err
+	}
+	lockedVersion := func(packagePath, version string) string {
+		provider := moduleProviding(modules, packagePath)
+		if provider == nil || provider.Main || provider.Replace != nil && modfile.IsDirectoryPath(provider.Replace.Path) {
+			return version
+		}
+		return provider.Version
+	}
+	lock.CaddyVersion = lockedVersion(env.caddyModulePath, b.CaddyVersion)
+	for _, p := range b.Plugins {
+		lock.Plugins = append(lock.Plugins, Dependency{PackagePath: p.PackagePath, Version: lockedVersion(p.PackagePath, p.Version)})
+	}
+
+	locked := make(map[string]*LockedModule)
+	for _, line := range strings.Split(string(mod.GoSum), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			continue
+		}
+		version := strings.TrimSuffix(fields[1], "/go.mod")
+		key := fields[0] + "@" + version
+		m, ok := locked[key]
+		if !ok {
+			m = &LockedModule{Path: fields[0], Version: version}
+			locked[key] = m
+		}
+		if version != fields[1] {
+			m.GoModSum = fields[2]
+		} else {
+			m.Sum = fields[2]
+		}
+	}
+	for _, m := range locked {
+		lock.Modules = append(lock.Modules, *m)
+	}
+	sort.Slice(lock.Modules, func(i, j int) bool {
+		mi, mj := lock.Modules[i], lock.Modules[j]
+		if mi.Path != mj.Path {
+			return mi.Path < mj.Path
+		}
+		return semver.Compare(mi.Version, mj.Version) < 0
+	})
+	return lock, nil
+}
+
+// goSum returns the go.sum lines of the modules of l.
+func (l Lock) goSum() []byte {
+	var sb strings.Builder
+	for _, m := range l.Modules {
+		if m.Sum != "" {
+			fmt.Fprintf(&sb, "%s %s %s\n", m.Path, m.Version, m.Sum)
+		}
+		if m.GoModSum != "" {
+			fmt.Fprintf(&sb, "%s %s/go.mod %s\n", m.Path, m.Version, m.GoModSum)
+		}
+	}
+	return []byte(sb.String())
+}
+
+// ReadLock reads a Lock written by WriteLock from path.
+func ReadLock(path string) (*Lock, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var lock Lock
+	if err := json.Unmarshal(data, &lock); err != nil {
+		return nil, fmt.Errorf("decoding lock %s: %v", path, err)
+	}
+	return &lock, nil
+}
+
+// BuilderFromLock returns a Builder for the build that the Lock at
+// path was written for: with the base module, plugins, and Go
+// toolchain at the locked versions, the locked replacements,
+// excludes, and workspace modules, and the Lock itself, so that
+// the go command verifies every module against the locked hashes.
+// Settings that do not affect the module graph, such as the
+// platform, are not locked.
+func BuilderFromLock(path string) (Builder, error) {
+	lock, err := ReadLock(path)
+	if err != nil {
+		return Builder{}, err
+	}
+	b := Builder{
+		CaddyVersion: lock.CaddyVersion,
+		BaseModule:   lock.BaseModule,
+		MainPackage:  lock.MainPackage,
+		Plugins:      lock.Plugins,
+		Replacements: lock.Replacements,
+		Excludes:     lock.Excludes,
+		Workspace:    lock.Workspace,
+		Lock:         lock,
+	}
+	if semver.IsValid(goSemver(lock.GoVersion)) {
+		b.GoVersion = lock.GoVersion
+	}
+	return b, nil
+}
+
+// writeLockedSums writes the hashes of the modules of lock to the
+// go.sum file of env, before any modules are fetched.
+func (env environment) writeLockedSums(lock *Lock) error {
+	env.log.Printf("[INFO] Using the hashes of %d locked module(s)", len(lock.Modules))
+	return os.WriteFile(filepath.Join(env.tempFolder, "go.sum"), lock.goSum(), 0644)
+}
@@ -0,0 +1,200 @@
+package builder
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// MacOSSignOptions configures code signing and notarization of
+// binaries for darwin with the tools of Xcode. Unsigned arm64
+// binaries do not run on macOS, and binaries downloaded from the
+// internet must be notarized to pass Gatekeeper.
+//
+// The tools run on the host, which must then be a Mac, or on the
+// Executor of the build, such as an SSHExecutor for a Mac.
+type MacOSSignOptions struct {
+	// Identity is the signing identity to pass to codesign, such as
+	// "Developer ID Application: Example Inc (TEAMID)", or "-" for an
+	// ad-hoc signature, which suffices to run on the machine itself.
+	Identity string `json:"identity,omitempty"`
+
+	// Keychain, if set, is the keychain to find the identity in.
+	Keychain string `json:"keychain,omitempty"`
+
+	// Entitlements, if set, is the path of an entitlements
+	// property list to sign the binary with.
+	Entitlements string `json:"entitlements,omitempty"`
+
+	// Notarize, if set, submits the signed binary for notarization
+	// and waits until it is accepted. The binary must be signed with
+	// a Developer ID identity; it is signed with the hardened
+	// runtime and a secure timestamp, as notarization requires.
+	//
+	// Unlike app bundles and disk images, standalone binaries cannot
+	// be stapled, so Gatekeeper looks up their notarization online
+	// the first time they run.
+	Notarize *Notarization `json:"notarize,omitempty"`
+}
+
+// Notarization configures the credentials for submitting binaries
+// to the notary service of Apple with notarytool. Either a keychain
+// profile, an App Store Connect API key, or an Apple ID is required.
+type Notarization struct {
+	// KeychainProfile is a profile stored with
+	// `xcrun notarytool store-credentials`.
+	KeychainProfile string `json:"keychain_profile,omitempty"`
+
+	// APIKey is the path of an App Store Connect API key (.p8
+	// file), with its key ID and the ID of its issuer.
+	APIKey    string `json:"api_key,omitempty"`
+	APIKeyID  string `json:"api_key_id,omitempty"`
+	APIIssuer string `json:"api_issuer,omitempty"`
+
+	// AppleID is an Apple ID, with the ID of its developer team
+	// and an app-specific password. notarytool only accepts the
+	// password on its command line, which is logged; prefer a
+	// keychain profile or an API key.
+	AppleID  string `json:"apple_id,omitempty"`
+	TeamID   string `json:"team_id,omitempty"`
+	Password string `json:"-"`
+}
+
+// check returns an error if the options are invalid, or if there
+// is nothing to run codesign on: neither a Mac nor an executor.
+func (o MacOSSignOptions) check(hasExecutor bool) error {
+	if o.Identity == "" {
+		return fmt.Errorf("signing for macOS requires an identity")
+	}
+	if !hasExecutor && runtime.GOOS != "darwin" {
+		return fmt.Errorf("signing for macOS requires a macOS host or an executor on macOS")
+	}
+	if o.Notarize != nil {
+		if o.Identity == "-" {
+			return fmt.Errorf("notarization requires a Developer ID identity, not an ad-hoc signature")
+		}
+		if len(o.Notarize.args()) == 0 {
+			return fmt.Errorf("notarization requires a keychain profile, an API key, or an Apple ID")
+		}
+	}
+	return nil
+}
+
+// args returns the arguments of notarytool
+// for the credentials, if they are complete.
+func (n Notarization) args() []string {
+	switch {
+	case n.KeychainProfile != "":
+		return []string{"--keychain-profile", n.KeychainProfile}
+	case n.APIKey != "" && n.APIKeyID != "" && n.APIIssuer != "":
+		return []string{"--key", n.APIKey, "--key-id", n.APIKeyID, "--issuer", n.APIIssuer}
+	case n.AppleID != "" && n.TeamID != "" && n.Password != "":
+		return []string{"--apple-id", n.AppleID, "--team-id", n.TeamID, "--password", n.Password}
+	}
+	return nil
+}
+
+// signMacOSBinary signs the binary at absOutputFile in place
+// and, if configured, has it notarized.
+func (env environment) signMacOSBinary(ctx context.Context, opts MacOSSignOptions, absOutputFile string) error {
+	env.log.Printf("[INFO] Signing %s with codesign", absOutputFile)
+	cmd := env.newCommand(ctx, "codesign", "--force", "--sign", opts.Identity)
+	if opts.Identity != "-" {
+		cmd.Args = append(cmd.Args, "--options", "runtime", "--timestamp")
+	}
+	if opts.Keychain != "" {
+		cmd.Args = append(cmd.Args, "--keychain", opts.Keychain)
+	}
+	if opts.Entitlements != "" {
+		cmd.Args = append(cmd.Args, "--entitlements", opts.Entitlements)
+	}
+	cmd.Args = append(cmd.Args, absOutputFile)
+	if err := env.runCommand(ctx, cmd); err != nil {
+		return fmt.Errorf("signing binary: %v", err)
+	}
+	if opts.Notarize == nil {
+		return nil
+	}
+	if err := env.notarize(ctx, *opts.Notarize, absOutputFile); err != nil {
+		return fmt.Errorf("notarizing binary: %v", err)
+	}
+	return nil
+}
+
+// notarize submits the binary at absOutputFile to the notary
+// service and waits until the submission is processed.
+func (env environment) notarize(ctx context.Context, n Notarization, absOutputFile string) error {
+	// the notary service only accepts archives
+	archive := filepath.Join(env.tempFolder, ".notarize", filepath.Base(absOutputFile)+".zip")
+	if err := os.MkdirAll(filepath.Dir(archive), 0755); err != nil {
+		return err
+	}
+	defer os.RemoveAll(filepath.Dir(archive))
+	if err := zipFile(archive, absOutputFile); err != nil {
+		return err
+	}
+
+	env.log.Printf("[INFO] Submitting %s for notarization; this may take a while", absOutputFile)
+	cmd := env.newCommand(ctx, "xcrun", "notarytool", "submit", archive, "--wait", "--output-format", "json")
+	cmd.Args = append(cmd.Args, n.args()...)
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	err := env.runCommand(ctx, cmd)
+	var submission struct {
+		ID      string `json:"id"`
+		Status  string `json:"status"`
+		Message string `json:"message"`
+	}
+	if jsonErr := json.Unmarshal(out.Bytes(), &submission); jsonErr != nil {
+		if err != nil {
+			return err
+		}
+		return fmt.Errorf("reading output of notarytool: %v: %s", jsonErr, strings.TrimSpace(out.String()))
+	}
+	if submission.Status == "Accepted" {
+		env.log.Printf("[INFO] Notarization accepted: %s", submission.ID)
+		return nil
+	}
+
+	// the log explains why the binary was rejected
+	var log bytes.Buffer
+	if submission.ID != "" {
+		cmd := env.newCommand(ctx, "xcrun", "notarytool", "log", submission.ID)
+		cmd.Args = append(cmd.Args, n.args()...)
+		cmd.Stdout = &log
+		if err := env.runCommand(ctx, cmd); err != nil {
+			env.log.Printf("[WARNING] Fetching notarization log: %v", err)
+		}
+	}
+	return fmt.Errorf("submission %s: %s: %s %s", submission.ID, submission.Status, submission.Message, strings.TrimSpace(log.String()))
+}
+
+// zipFile writes a zip archive with only the
+// executable file to the archive file.
+func zipFile(archive, file string) error {
+	return writeArchiveFile(archive, func(w io.Writer) error {
+		f, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		zw := zip.NewWriter(w)
+		hdr := &zip.FileHeader{Name: filepath.Base(file), Method: zip.Deflate}
+		hdr.SetMode(0755)
+		fw, err := zw.CreateHeader(hdr)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(fw, f); err != nil {
+			return err
+		}
+		return zw.Close()
+	})
+}
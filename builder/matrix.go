@@ -0,0 +1,134 @@
+package builder
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// BuildAll builds Caddy for each of the targets, writing the
+// binaries into outputDir. The build environment is prepared and
+// tidied only once and then compiled for every target, so modules
+// are downloaded a single time. Each binary is named by
+// OutputTemplate, or after its target, e.g. goaway_linux_arm64 or
+// goaway_linux_armv7, with ".exe" appended for Windows and ".wasm"
+// for WebAssembly unless SkipExeSuffix is set. The results
+// are returned in the same order as targets, or nil if SkipBuild
+// is set; the duration of each is that of its compilation only.
+func (b Builder) BuildAll(ctx context.Context, targets []Platform, outputDir string) (results []*BuildResult, err error) {
+	start := time.Now()
+	var cancel context.CancelFunc
+	if b.TimeoutBuild > 0 {
+		ctx, cancel = context.WithTimeout(ctx, b.TimeoutBuild)
+		defer cancel()
+	}
+	b, report := b.startReport()
+	defer func() { report.finish(results, err) }()
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("at least one target is required")
+	}
+	if outputDir == "" {
+		return nil, fmt.Errorf("output directory is required")
+	}
+	// the binaries all go into outputDir, so its
+	// volume is the one that the preflight checks
+	ctx, _, endBuild, err := b.beginBuild(ctx, start, outputDir+string(filepath.Separator))
+	defer func() { endBuild(err) }()
+	if err != nil {
+		return nil, err
+	}
+
+	// resolve and check every output file up front, so
+	// that a bad target does not fail halfway through
+	outputFiles := make([]string, len(targets))
+	for i, target := range targets {
+		tb := b
+		tb.Platform = target
+		if err := checkPlatform(ctx, target); err != nil {
+			return nil, err
+		}
+		absOutputFile, err := tb.outputPathAt(outputDir+string(filepath.Separator), b.CaddyVersion)
+		if err != nil {
+			return nil, err
+		}
+		if err := prepareOutputFile(absOutputFile); err != nil {
+			return nil, err
+		}
+		outputFiles[i] = absOutputFile
+	}
+
+	buildEnv, err := b.newEnvironment(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { err = buildEnv.finish(ctx, err) }()
+
+	if b.SkipBuild {
+		b.logger().Printf("[INFO] Skipping build as requested")
+
+		return nil, nil
+	}
+
+	if err := buildEnv.tidy(ctx); err != nil {
+		return nil, err
+	}
+	if err := b.checkEnvironment(ctx, buildEnv); err != nil {
+		return nil, err
+	}
+
+	for i, target := range targets {
+		tb := b
+		tb.Platform = target
+		outputFiles[i], err = tb.finalOutputPath(ctx, buildEnv, outputDir+string(filepath.Separator), outputFiles[i])
+		if err != nil {
+			return nil, err
+		}
+		b.logger().Printf("[INFO] Building Caddy for %s", target.key())
+		result, err := tb.compile(ctx, buildEnv, outputFiles[i], time.Now())
+		if err != nil {
+			return nil, fmt.Errorf("building for %s: %w", target.key(), err)
+		}
+		results = append(results, result)
+	}
+
+	return results, nil
+}
+
+// key returns the target in the form os/arch, with the
+// ARM version appended if set (e.g. linux/arm/7). It is
+// deliberately not a String method, which would be
+// promoted to Compile and Builder.
+func (p Platform) key() string {
+	s := p.OS + "/" + p.Arch
+	if p.ARM != "" {
+		s += "/" + p.ARM
+	}
+	return s
+}
+
+// fileName returns the name of a binary with the
+// given base name that is built for p.
+func (p Platform) fileName(base string) string {
+	return base + "_" + p.OS + "_" + p.Arch + p.variant()
+}
+
+// variant returns the suffix for the microarchitecture
+// of p in the names of binaries, if it has one.
+func (p Platform) variant() string {
+	level := strings.NewReplacer(",", "_", ".", "_").Replace(p.microArch())
+	switch {
+	case level == "":
+		return ""
+	case level[0] >= '0' && level[0] <= '9':
+		// e.g. goaway_linux_armv7
+		return "v" + level
+	case level[0] == 'v':
+		// e.g. goaway_linux_amd64v3
+		return level
+	default:
+		// e.g. goaway_linux_mips_softfloat
+		return "_" + level
+	}
+}
@@ -0,0 +1,39 @@
+package builder
+
+import (
+	"context"
+	"os/exec"
+)
+
+// WarmCache downloads Caddy and all plugins of b, along with their
+// dependencies, into the module cache without building anything, so
+// that later builds with the same modules (or a subset of them) find
+// everything in the cache. It is most useful with GoModCache set to
+// a module cache that builds share.
+func (b Builder) WarmCache(ctx context.Context) (err error) {
+	var cancel context.CancelFunc
+	if b.TimeoutBuild > 0 {
+		ctx, cancel = context.WithTimeout(ctx, b.TimeoutBuild)
+		defer cancel()
+	}
+
+	buildEnv, err := b.newEnvironment(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() { err = buildEnv.finish(ctx, err) }()
+
+	if err := buildEnv.tidy(ctx); err != nil {
+		return err
+	}
+	// tidy only downloads the modules that provide packages;
+	// download the rest of the module graph as well
+	b.logger().Printf("[INFO] Downloading modules")
+	if _, err := buildEnv.runFetchCommand(ctx, func() *exec.Cmd {
+		return buildEnv.newGoModCommand(ctx, "download", "all")
+	}); err != nil {
+		return err
+	}
+	b.logger().Printf("[INFO] Module cache is ready")
+	return nil
+}
@@ -0,0 +1,107 @@
+package builder
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"golang.org/x/mod/modfile"
+)
+
+// LocalModule is a Go module in a directory tree, such as one
+// of the modules of a repository that holds several plugins.
+type LocalModule struct {
+	// The module path, as declared in its go.mod.
+	Path string `json:"path"`
+
+	// The absolute path of the directory of its go.mod.
+	Dir string `json:"dir"`
+}
+
+// FindModules returns the modules in the directory tree at root,
+// including root itself if it has a go.mod, sorted by module path.
+// Like the go command, it skips directories named vendor or
+// testdata, and those whose names start with a dot or underscore.
+func FindModules(root string) ([]LocalModule, error) {
+	absRoot, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+	var modules []LocalModule
+	err = filepath.WalkDir(absRoot, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			name := d.Name()
+			if path != absRoot && (name == "vendor" || name == "testdata" ||
+				strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_")) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.Name() != "go.mod" {
+			return nil
+		}
+		goMod, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		modulePath := modfile.ModulePath(goMod)
+		if modulePath == "" {
+			return fmt.Errorf("no module directive in %s", path)
+		}
+		modules = append(modules, LocalModule{Path: modulePath, Dir: filepath.Dir(path)})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("finding modules in %s: %v", root, err)
+	}
+	if len(modules) == 0 {
+		return nil, fmt.Errorf("no modules in %s", root)
+	}
+	sort.Slice(modules, func(i, j int) bool { return modules[i].Path < modules[j].Path })
+	return modules, nil
+}
+
+// AddModules adds every module in the directory tree at root, as
+// found by FindModules, to the plugins of b, so that a repository
+// with several plugin modules takes one call rather than one
+// Dependency per module. If version is empty, each module is
+// replaced by its directory, as for a local checkout; otherwise,
+// every module is required at version as published, which is how
+// the nested modules of a repository are tagged, such as with
+// sub/v1.2.0 for the module in its sub directory. Modules that are
+// plugins already are left out, and those that are replaced already
+// keep their replacement. It returns the plugins it added.
+func (b *Builder) AddModules(root, version string) ([]Dependency, error) {
+	modules, err := FindModules(root)
+	if err != nil {
+		return nil, err
+	}
+	existing := make(map[string]bool, len(b.Plugins))
+	for _, p := range b.Plugins {
+		existing[p.PackagePath] = true
+	}
+	replaced := make(map[string]bool, len(b.Replacements))
+	for _, r := range b.Replacements {
+		replaced[r.Old.ModulePath()] = true
+	}
+
+	var added []Dependency
+	for _, mod := range modules {
+		if existing[mod.Path] {
+			continue
+		}
+		plugin := Dependency{PackagePath: mod.Path, Version: version}
+		b.Plugins = append(b.Plugins, plugin)
+		if version == "" && !replaced[mod.Path] {
+			b.Replacements = append(b.Replacements, NewReplace(mod.Path, mod.Dir))
+		}
+		added = append(added, plugin)
+	}
+	return added, nil
+}
@@ -0,0 +1,69 @@
+package builder
+
+// Option configures a Builder created by New.
+type Option func(*Builder)
+
+// New returns a Builder configured by opts, which are applied in
+// order; settings without an option can be changed on the result.
+// As with any Builder, building does not modify it, so it may be
+// shared by concurrent builds once it is configured.
+func New(opts ...Option) *Builder {
+	b := &Builder{}
+	for _, opt := range opts {
+		opt(b)
+	}
+	return b
+}
+
+// WithCaddyVersion sets the version of the base module to build,
+// which may also be "latest" or a constraint such as "~2.8".
+func WithCaddyVersion(version string) Option {
+	return func(b *Builder) {
+		b.CaddyVersion = version
+	}
+}
+
+// WithPlugin adds the plugin at packagePath, at version or, if
+// that is empty, at the latest version.
+func WithPlugin(packagePath, version string) Option {
+	return func(b *Builder) {
+		b.Plugins = append(b.Plugins, Dependency{PackagePath: packagePath, Version: version})
+	}
+}
+
+// WithReplace adds a replacement of the module old by new, which
+// may be a module path with a version or a local directory, as
+// with replace directives in go.mod.
+func WithReplace(old, new string) Option {
+	return func(b *Builder) {
+		b.Replacements = append(b.Replacements, NewReplace(old, new))
+	}
+}
+
+// WithTarget sets the platform to build for.
+func WithTarget(p Platform) Option {
+	return func(b *Builder) {
+		b.Platform = p
+	}
+}
+
+// WithTimeouts sets the timeouts of the phases of the build.
+func WithTimeouts(t Timeouts) Option {
+	return func(b *Builder) {
+		b.Timeouts = t
+	}
+}
+
+// WithBuildTags adds build tags.
+func WithBuildTags(tags ...string) Option {
+	return func(b *Builder) {
+		b.BuildTags = append(b.BuildTags, tags...)
+	}
+}
+
+// WithLogger sets the Logger for the progress messages of builds.
+func WithLogger(l Logger) Option {
+	return func(b *Builder) {
+		b.Logger = l
+	}
+}
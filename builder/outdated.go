@@ -0,0 +1,247 @@
+package builder
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+
+	"golang.org/x/mod/semver"
+)
+
+// OutdatedReport describes the newer versions of the base
+// module and of the plugins of a Builder, as returned by
+// Builder.Outdated.
+type OutdatedReport struct {
+	Base    OutdatedModule   `json:"base"`
+	Plugins []OutdatedModule `json:"plugins,omitempty"`
+}
+
+// OutdatedModule describes the versions of a module of a build.
+type OutdatedModule struct {
+	// The plugin package, or empty for the base module.
+	Package string `json:"package,omitempty"`
+
+	// The module path.
+	Path string `json:"path"`
+
+	// The version that the build asks for, resolved to a
+	// concrete version if it is a query such as "latest".
+	Current string `json:"current,omitempty"`
+
+	// The latest version, as the go command resolves "latest".
+	Latest string `json:"latest,omitempty"`
+
+	// The newest version that works with the rest of the
+	// build: for the base module, the newest release of the
+	// same major version as Current; for plugins, the newest
+	// version that requires no newer base module than the
+	// current one.
+	LatestCompatible string `json:"latest_compatible,omitempty"`
+
+	// The local directory that the module is replaced with,
+	// if any, in which case its versions are not queried.
+	Replace string `json:"replace,omitempty"`
+}
+
+// UpdateAvailable reports whether LatestCompatible
+// is newer than Current.
+func (m OutdatedModule) UpdateAvailable() bool {
+	return m.LatestCompatible != "" && semver.Compare(m.LatestCompatible, m.Current) > 0
+}
+
+// Updates returns the modules of r that have updates available.
+func (r OutdatedReport) Updates() []OutdatedModule {
+	var updates []OutdatedModule
+	for _, m := range append([]OutdatedModule{r.Base}, r.Plugins...) {
+		if m.UpdateAvailable() {
+			updates = append(updates, m)
+		}
+	}
+	return updates
+}
+
+// Outdated queries the module proxy for newer versions of the base
+// module and of every plugin of b and reports them, without fetching
+// the modules or building. The queries run in a build environment
+// with an empty module, which is cleaned up like any other.
+func (b Builder) Outdated(ctx context.Context) (_ *OutdatedReport, err error) {
+	var cancel context.CancelFunc
+	if b.TimeoutBuild > 0 {
+		ctx, cancel = context.WithTimeout(ctx, b.TimeoutBuild)
+		defer cancel()
+	}
+
+	goMod := []byte(fmt.Sprintf("module %s\n", b.mainModulePath()))
+	env, err := b.newModuleEnvironment(ctx, goMod, nil)
+	if err != nil {
+		return nil, wrapError(err, func(err error) error { return &SetupError{Err: err} })
+	}
+	defer func() { err = env.finish(ctx, err) }()
+
+	caddyModulePath, _ := b.baseModule()
+	report := &OutdatedReport{Base: OutdatedModule{Path: caddyModulePath}}
+	if err := env.queryUpdates(ctx, &report.Base, b.CaddyVersion, b.localReplacement(caddyModulePath), ""); err != nil {
+		return nil, err
+	}
+	for _, p := range b.Plugins {
+		m := OutdatedModule{Package: p.PackagePath}
+		replace := b.localReplacement(p.PackagePath)
+		if replace != "" {
+			m.Path = b.replacedModule(p.PackagePath)
+		} else {
+			m.Path, err = env.modulePathOf(ctx, p.PackagePath)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if err := env.queryUpdates(ctx, &m, p.Version, replace, report.Base.Current); err != nil {
+			return nil, err
+		}
+		report.Plugins = append(report.Plugins, m)
+	}
+	return report, nil
+}
+
+// localReplacement returns the local directory that replaces
+// the module providing packagePath, or "" if there is none.
+func (b Builder) localReplacement(packagePath string) string {
+	for _, r := range b.Replacements {
+		if modulePath := r.Old.ModulePath(); r.New.IsLocal() &&
+			(packagePath == modulePath || strings.HasPrefix(packagePath, modulePath+"/")) {
+			return r.New.String()
+		}
+	}
+	return ""
+}
+
+// replacedModule returns the path of the replaced
+// module that provides packagePath.
+func (b Builder) replacedModule(packagePath string) string {
+	var longest string
+	for _, r := range b.Replacements {
+		if modulePath := r.Old.ModulePath(); len(modulePath) > len(longest) &&
+			(packagePath == modulePath || strings.HasPrefix(packagePath, modulePath+"/")) {
+			longest = modulePath
+		}
+	}
+	return longest
+}
+
+// modulePathOf returns the path of the module that provides the
+// latest version of packagePath, which is the longest prefix of it
+// that the module proxy knows as a module.
+func (env environment) modulePathOf(ctx context.Context, packagePath string) (string, error) {
+	for p := packagePath; ; p = path.Dir(p) {
+		if _, err := env.listModule(ctx, p+"@latest"); err == nil {
+			return p, nil
+		} else if isCanceled(err) {
+			return "", err
+		}
+		if !strings.Contains(p, "/") {
+			return "", fmt.Errorf("no module provides package %s", packagePath)
+		}
+	}
+}
+
+// queryUpdates sets the current, latest, and latest compatible
+// versions of m, whose version is requested at version, unless it
+// is replaced by the local directory replace. For plugins, base is
+// the current version of the base module; it is empty for the base
+// module itself.
+func (env environment) queryUpdates(ctx context.Context, m *OutdatedModule, version, replace, base string) error {
+	if replace != "" {
+		m.Replace = replace
+		m.Current = version
+		return nil
+	}
+	latest, err := env.listModule(ctx, m.Path+"@latest")
+	if err != nil {
+		return fmt.Errorf("querying latest version of %s: %v", m.Path, err)
+	}
+	m.Latest = latest.Version
+	m.Current, err = env.resolveVersion(ctx, m.Path, version)
+	if err != nil {
+		return err
+	}
+	if m.Current == "" {
+		m.Current = m.Latest
+	}
+
+	listed, err := env.listModule(ctx, "-versions", m.Path)
+	if err != nil {
+		return fmt.Errorf("listing versions of %s: %v", m.Path, err)
+	}
+	// the newest releases first, down to the current version
+	var candidates []string
+	for i := len(listed.Versions) - 1; i >= 0; i-- {
+		v := listed.Versions[i]
+		if semver.Compare(v, m.Current) <= 0 {
+			break
+		}
+		if semver.Prerelease(v) == "" {
+			candidates = append(candidates, v)
+		}
+	}
+
+	m.LatestCompatible = m.Current
+	if m.Package == "" {
+		for _, v := range candidates {
+			if semver.Major(v) == semver.Major(m.Current) {
+				m.LatestCompatible = v
+				break
+			}
+		}
+		return nil
+	}
+	for _, v := range candidates {
+		ok, err := env.worksWithBase(ctx, m.Path, v, base)
+		if err != nil {
+			return err
+		}
+		if ok {
+			m.LatestCompatible = v
+			break
+		}
+	}
+	return nil
+}
+
+// worksWithBase reports whether the go.mod file of modulePath at
+// version requires no newer version of the base module than base.
+// Any version works if base is not a release, such as when the
+// base module is replaced by a local directory.
+func (env environment) worksWithBase(ctx context.Context, modulePath, version, base string) (bool, error) {
+	if !semver.IsValid(base) || strings.HasPrefix(base, "v0.0.0-") {
+		return true, nil
+	}
+	info, err := env.listModule(ctx, modulePath+"@"+version)
+	if err != nil {
+		return false, fmt.Errorf("querying %s@%s: %v", modulePath, version, err)
+	}
+	if info.GoMod == "" {
+		return true, nil
+	}
+	// read by the go command, as the file
+	// may be on the executor rather than here
+	var out bytes.Buffer
+	cmd := env.newCommand(ctx, GetGo(), "mod", "edit", "-json", info.GoMod)
+	cmd.Stdout = &out
+	if err := env.runCommand(ctx, cmd); err != nil {
+		return false, fmt.Errorf("reading go.mod of %s@%s: %v", modulePath, version, err)
+	}
+	var goMod struct {
+		Require []struct{ Path, Version string }
+	}
+	if err := json.Unmarshal(out.Bytes(), &goMod); err != nil {
+		return false, fmt.Errorf("decoding go.mod of %s@%s: %v", modulePath, version, err)
+	}
+	for _, req := range goMod.Require {
+		if req.Path == env.caddyModulePath {
+			return semver.Compare(req.Version, base) <= 0, nil
+		}
+	}
+	return true, nil
+}
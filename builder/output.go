@@ -0,0 +1,55 @@
+package builder
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// BuildTo is like Build, but writes the binary to w instead of
+// leaving it at a path, e.g. to stream it to object storage or an
+// HTTP response. The go command can only link to a file, so the
+// binary is written to a temporary folder, like those of build
+// environments, which is removed once the binary is copied to w;
+// the OutputFile of the result is empty. Settings that write more
+// files next to the binary are not supported: signers, SBOM files
+// unless SBOMWriter is set, provenance files unless Provenance.Writer
+// is set, wasm_exec.js, and C header files.
+// To read the binary instead, pass the writer of an io.Pipe.
+func (b Builder) BuildTo(ctx context.Context, w io.Writer) (*BuildResult, error) {
+	switch {
+	case len(b.Signers) > 0:
+		return nil, fmt.Errorf("signing is not supported when building to a writer")
+	case b.SBOMFormat != "" && b.SBOMWriter == nil:
+		return nil, fmt.Errorf("SBOM files are not supported when building to a writer; set SBOMWriter")
+	case b.Provenance != nil && b.Provenance.Writer == nil:
+		return nil, fmt.Errorf("provenance files are not supported when building to a writer; set Provenance.Writer")
+	case b.WasmExec:
+		return nil, fmt.Errorf("copying %s is not supported when building to a writer", wasmExecFile)
+	case b.BuildMode.writesHeader():
+		return nil, fmt.Errorf("build mode %s is not supported when building to a writer", b.BuildMode)
+	}
+
+	dir, err := newTempFolder(b.WorkDir)
+	if err != nil {
+		return nil, fmt.Errorf("creating temporary folder for the binary: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	result, err := b.Build(ctx, filepath.Join(dir, "goaway"))
+	if err != nil || result == nil {
+		return result, err
+	}
+	f, err := os.Open(result.OutputFile)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if _, err := io.Copy(w, f); err != nil {
+		return nil, fmt.Errorf("writing binary: %v", err)
+	}
+	result.OutputFile = ""
+	return result, nil
+}
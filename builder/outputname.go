@@ -0,0 +1,146 @@
+package builder
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+// defaultOutputTemplate names the binaries of BuildAll, and those
+// of Build given a directory, unless OutputTemplate is set.
+const defaultOutputTemplate = "{{.Name}}_{{.OS}}_{{.Arch}}{{.Variant}}{{.Ext}}"
+
+// OutputName is the data that OutputTemplate is executed with.
+type OutputName struct {
+	// The base name of binaries: goaway.
+	Name string
+
+	// The version of the base module, which names builds that ask
+	// for a query such as "latest" once the query is resolved. It
+	// is "devel" if the base module is a local directory.
+	Version string
+
+	// The target platform, e.g. linux and arm,
+	// and its ARM version, if any, e.g. 7.
+	OS   string
+	Arch string
+	ARM  string
+
+	// The suffix for the microarchitecture of the target, if
+	// any, e.g. v7 for linux/arm/7, v3 for GOAMD64=v3, or
+	// _softfloat for GOMIPS=softfloat.
+	Variant string
+
+	// The extension of binaries for the target: ".exe" for
+	// windows, ".wasm" for WebAssembly, and empty otherwise
+	// or if SkipExeSuffix is set.
+	Ext string
+}
+
+// outputName returns the name of the binary of b at version,
+// executing OutputTemplate or the default template.
+func (b Builder) outputName(version string) (string, error) {
+	text := b.OutputTemplate
+	if text == "" {
+		text = defaultOutputTemplate
+	}
+	tmpl, err := template.New("output").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("parsing output template: %v", err)
+	}
+	data := OutputName{
+		Name:    defaultBinaryName,
+		Version: version,
+		OS:      b.targetOS(),
+		Arch:    b.targetArch(),
+		ARM:     b.ARM,
+		Variant: b.Platform.variant(),
+	}
+	switch {
+	case b.SkipExeSuffix:
+	case data.OS == "windows":
+		data.Ext = ".exe"
+	case data.Arch == "wasm":
+		data.Ext = ".wasm"
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("executing output template: %v", err)
+	}
+	name := buf.String()
+	if name == "" || strings.ContainsAny(name, `/\`) || name == "." || name == ".." {
+		return "", fmt.Errorf("output template produced invalid file name %q", name)
+	}
+	return name, nil
+}
+
+// isOutputDir reports whether outputFile, whose absolute path
+// is absOutputFile, names a directory to put the binary in: an
+// existing one, or any path that ends with a separator.
+func isOutputDir(outputFile, absOutputFile string) bool {
+	if strings.HasSuffix(outputFile, "/") || strings.HasSuffix(outputFile, string(filepath.Separator)) {
+		return true
+	}
+	info, err := os.Stat(absOutputFile)
+	return err == nil && info.IsDir()
+}
+
+// outputVersion returns the version of the base module in
+// buildEnv for naming the binary: the resolved version, or, if
+// the base module was requested without a concrete version, the
+// version that the module graph selects.
+func (env environment) outputVersion(ctx context.Context) (string, error) {
+	if env.plan != nil || isConcreteVersion(env.caddyVersion) {
+		return env.caddyVersion, nil
+	}
+	m, err := env.listModule(ctx, env.caddyModulePath)
+	if err != nil {
+		return "", err
+	}
+	if m.Replace != nil && m.Replace.Version == "" || m.Version == placeholderVersion {
+		return "devel", nil
+	}
+	return m.Version, nil
+}
+
+// finalOutputPath returns the path of the binary for outputFile,
+// which was absOutputFile before buildEnv resolved the version of
+// the base module: if the binary goes into a directory, it is
+// named after the resolved version, and its directory is prepared
+// again if that changes its path.
+func (b Builder) finalOutputPath(ctx context.Context, buildEnv *environment, outputFile, absOutputFile string) (string, error) {
+	if dir, err := absOutputPath(outputFile); err != nil || !isOutputDir(outputFile, dir) {
+		return absOutputFile, err
+	}
+	version, err := buildEnv.outputVersion(ctx)
+	if err != nil {
+		return "", err
+	}
+	final, err := b.outputPathAt(outputFile, version)
+	if err != nil || final == absOutputFile {
+		return final, err
+	}
+	return final, prepareOutputFile(final)
+}
+
+// renameToVersion renames the binary of result, if it went into
+// the directory outputFile, after the version of the base module
+// that it contains, like finalOutputPath does before building.
+func (b Builder) renameToVersion(result *BuildResult, outputFile string) error {
+	if dir, err := absOutputPath(outputFile); err != nil || !isOutputDir(outputFile, dir) {
+		return err
+	}
+	final, err := b.outputPathAt(outputFile, result.CaddyVersion)
+	if err != nil || final == result.OutputFile {
+		return err
+	}
+	if err := os.Rename(result.OutputFile, final); err != nil {
+		return err
+	}
+	result.OutputFile = final
+	return nil
+}
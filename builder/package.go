@@ -0,0 +1,288 @@
+package builder
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// PackageOptions configures the release archives written by Package.
+type PackageOptions struct {
+	// Dir is the directory to write the archives and the checksums
+	// to. Default: the directory of the first binary
+	Dir string `json:"dir,omitempty"`
+
+	// Name is the name that archives are named after, followed by
+	// their target, e.g. goaway_linux_amd64.tar.gz or
+	// goaway_linux_armv7.tar.gz. Default: "goaway"
+	Name string `json:"name,omitempty"`
+
+	// BinaryName is the name of the binary in the archives, with
+	// ".exe" appended for Windows. Default: Name
+	BinaryName string `json:"binary_name,omitempty"`
+
+	// Files are additional files to put next to the binary
+	// in every archive, such as LICENSE and README.md.
+	Files []string `json:"files,omitempty"`
+
+	// ChecksumFile is the name of the file in Dir that lists the
+	// SHA-256 checksums of the archives in the format of sha256sum.
+	// Default: "SHA256SUMS"
+	ChecksumFile string `json:"checksum_file,omitempty"`
+}
+
+// Archive describes a release archive written by Package.
+type Archive struct {
+	// The absolute path of the archive.
+	File string `json:"file,omitempty"`
+
+	// The size of the archive in bytes.
+	Size int64 `json:"size,omitempty"`
+
+	// The hex-encoded SHA-256 checksum of the archive.
+	SHA256 string `json:"sha256,omitempty"`
+
+	// The platform of the binary in the archive.
+	Platform Platform `json:"platform,omitempty"`
+}
+
+// defaultMicroArch are the micro-architecture levels that Go
+// targets by default, which release archives are not named after.
+var defaultMicroArch = map[string]string{
+	"amd64":    "v1",
+	"arm64":    "v8.0",
+	"386":      "sse2",
+	"mips":     "hardfloat",
+	"mipsle":   "hardfloat",
+	"mips64":   "hardfloat",
+	"mips64le": "hardfloat",
+}
+
+// archiveName returns the name of the release archive of a
+// binary built for p, without the extension of the format.
+func archiveName(name string, p Platform) string {
+	if level := p.microArch(); level != "" && level == defaultMicroArch[p.Arch] {
+		p.AMD64, p.ARM64, p.I386, p.MIPS, p.MIPS64 = "", "", "", "", ""
+	}
+	return p.fileName(name)
+}
+
+// Package writes a release archive for each of the binaries of
+// results, such as those of BuildAll, as configured by opts: a
+// .tar.gz file, or a .zip file for Windows, that contains the binary
+// and any additional files. The SHA-256 checksums of the archives are
+// written to a file next to them. The archives are returned in the
+// same order as results.
+func (b Builder) Package(results []*BuildResult, opts PackageOptions) ([]Archive, error) {
+	if len(results) == 0 {
+		return nil, fmt.Errorf("at least one binary is required")
+	}
+	name := opts.Name
+	if name == "" {
+		name = defaultBinaryName
+	}
+	binaryName := opts.BinaryName
+	if binaryName == "" {
+		binaryName = name
+	}
+	checksumFile := opts.ChecksumFile
+	if checksumFile == "" {
+		checksumFile = "SHA256SUMS"
+	}
+	dir := opts.Dir
+	if dir == "" {
+		dir = filepath.Dir(results[0].OutputFile)
+	}
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	for _, file := range opts.Files {
+		if info, err := os.Stat(file); err != nil {
+			return nil, err
+		} else if !info.Mode().IsRegular() {
+			return nil, fmt.Errorf("not a regular file: %s", file)
+		}
+	}
+
+	var mtime time.Time
+	if b.Reproducible {
+		mtime = time.Unix(0, 0).UTC()
+	}
+
+	archives := make([]Archive, len(results))
+	seen := make(map[string]bool)
+	for i, result := range results {
+		if result == nil || result.OutputFile == "" {
+			return nil, fmt.Errorf("binary %d has not been built", i)
+		}
+		entries := []archiveEntry{{name: binaryName, file: result.OutputFile, mode: 0755}}
+		if result.Platform.OS == "windows" {
+			entries[0].name += ".exe"
+		}
+		for _, file := range opts.Files {
+			entries = append(entries, archiveEntry{name: filepath.Base(file), file: file, mode: 0644})
+		}
+
+		ext := ".tar.gz"
+		if result.Platform.OS == "windows" {
+			ext = ".zip"
+		}
+		archiveFile := filepath.Join(dir, archiveName(name, result.Platform)+ext)
+		if seen[archiveFile] {
+			return nil, fmt.Errorf("more than one binary would be packaged as %s", archiveFile)
+		}
+		seen[archiveFile] = true
+
+		b.logger().Printf("[INFO] Packaging %s", archiveFile)
+		if ext == ".zip" {
+			err = writeZipArchive(archiveFile, entries, mtime)
+		} else {
+			err = writeTarGzArchive(archiveFile, entries, mtime)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("packaging %s: %v", result.OutputFile, err)
+		}
+		archives[i] = Archive{File: archiveFile, Platform: result.Platform}
+		archives[i].Size, archives[i].SHA256, err = fileChecksum(archiveFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	sorted := append([]Archive(nil), archives...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].File < sorted[j].File })
+	var sums strings.Builder
+	for _, archive := range sorted {
+		fmt.Fprintf(&sums, "%s  %s\n", archive.SHA256, filepath.Base(archive.File))
+	}
+	if err := os.WriteFile(filepath.Join(dir, checksumFile), []byte(sums.String()), 0644); err != nil {
+		return nil, err
+	}
+	return archives, nil
+}
+
+// archiveEntry is a file on the host to put into an archive.
+type archiveEntry struct {
+	name string
+	file string
+	mode os.FileMode
+}
+
+// open opens the file of e and returns it with its size and
+// modification time, or mtime if that is not zero.
+func (e archiveEntry) open(mtime time.Time) (*os.File, int64, time.Time, error) {
+	f, err := os.Open(e.file)
+	if err != nil {
+		return nil, 0, time.Time{}, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, 0, time.Time{}, err
+	}
+	if mtime.IsZero() {
+		mtime = info.ModTime()
+	}
+	return f, info.Size(), mtime, nil
+}
+
+func writeTarGzArchive(file string, entries []archiveEntry, mtime time.Time) error {
+	return writeArchiveFile(file, func(w io.Writer) error {
+		zw := gzip.NewWriter(w)
+		tw := tar.NewWriter(zw)
+		for _, e := range entries {
+			f, size, modTime, err := e.open(mtime)
+			if err != nil {
+				return err
+			}
+			err = tw.WriteHeader(&tar.Header{
+				Typeflag: tar.TypeReg,
+				Name:     e.name,
+				Mode:     int64(e.mode),
+				Size:     size,
+				ModTime:  modTime,
+				Format:   tar.FormatPAX,
+			})
+			if err == nil {
+				_, err = io.Copy(tw, f)
+			}
+			f.Close()
+			if err != nil {
+				return err
+			}
+		}
+		if err := tw.Close(); err != nil {
+			return err
+		}
+		return zw.Close()
+	})
+}
+
+func writeZipArchive(file string, entries []archiveEntry, mtime time.Time) error {
+	return writeArchiveFile(file, func(w io.Writer) error {
+		zw := zip.NewWriter(w)
+		for _, e := range entries {
+			f, _, modTime, err := e.open(mtime)
+			if err != nil {
+				return err
+			}
+			hdr := &zip.FileHeader{Name: e.name, Method: zip.Deflate, Modified: modTime}
+			hdr.SetMode(e.mode)
+			fw, err := zw.CreateHeader(hdr)
+			if err == nil {
+				_, err = io.Copy(fw, f)
+			}
+			f.Close()
+			if err != nil {
+				return err
+			}
+		}
+		return zw.Close()
+	})
+}
+
+// writeArchiveFile creates file with the contents written by write,
+// and removes it if that fails.
+func writeArchiveFile(file string, write func(io.Writer) error) error {
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	err = write(f)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(file)
+	}
+	return err
+}
+
+// fileChecksum returns the size and the
+// hex-encoded SHA-256 checksum of file.
+func fileChecksum(file string) (int64, string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return 0, "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	size, err := io.Copy(h, f)
+	if err != nil {
+		return 0, "", err
+	}
+	return size, hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,105 @@
+package builder
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"golang.org/x/mod/semver"
+)
+
+// PinError is returned when the tidied module graph selects
+// pinned modules at other versions than they were pinned to.
+type PinError struct {
+	// Conflicts has a ConflictUpgraded or ConflictDowngraded
+	// entry for every such module.
+	Conflicts []ModuleConflict
+}
+
+func (e *PinError) Error() string {
+	lines := make([]string, 0, len(e.Conflicts))
+	for _, c := range e.Conflicts {
+		lines = append(lines, c.String())
+	}
+	return fmt.Sprintf("%d pinned module(s) changed: %s", len(e.Conflicts), strings.Join(lines, "; "))
+}
+
+// excludeAndPin adds an exclude directive to go.mod for each of
+// excludes and records the pins in env, for execGoGet and
+// checkPins, after resolving their versions.
+func (env *environment) excludeAndPin(ctx context.Context, excludes, pins []Dependency) error {
+	for _, d := range excludes {
+		version, err := env.pinnedVersion(ctx, "exclude", d)
+		if err != nil {
+			return err
+		}
+		env.log.Printf("[INFO] Exclude %s@%s", d.PackagePath, version)
+		if err := env.addExclude(ctx, d.PackagePath, version); err != nil {
+			return err
+		}
+	}
+	for _, d := range pins {
+		version, err := env.pinnedVersion(ctx, "pin", d)
+		if err != nil {
+			return err
+		}
+		env.log.Printf("[INFO] Pin %s@%s", d.PackagePath, version)
+		env.pins = append(env.pins, Dependency{PackagePath: d.PackagePath, Version: version})
+	}
+	return nil
+}
+
+// pinnedVersion resolves the version of d, a module
+// that is excluded or pinned, to a concrete version.
+func (env *environment) pinnedVersion(ctx context.Context, what string, d Dependency) (string, error) {
+	if d.PackagePath == "" || d.Version == "" {
+		return "", fmt.Errorf("%s %s@%s: both a module path and a version are required", what, d.PackagePath, d.Version)
+	}
+	version, err := env.resolveVersion(ctx, d.PackagePath, d.Version)
+	if err != nil {
+		return "", err
+	}
+	env.recordResolved(d.PackagePath, d.Version, version)
+	return version, nil
+}
+
+// checkPins fails with a PinError if the module graph of env
+// selects any of its pinned modules that are not replaced at
+// another version than they were pinned to.
+func (env environment) checkPins(ctx context.Context) error {
+	if env.plan != nil || len(env.pins) == 0 {
+		// queries are not run when planning
+		return nil
+	}
+	modules, err := env.listModules(ctx)
+	if err != nil {
+		return err
+	}
+	selected := make(map[string]listedModule, len(modules))
+	for _, m := range modules {
+		selected[m.Path] = m
+	}
+	var conflicts []ModuleConflict
+	for _, pin := range env.pins {
+		m, ok := selected[pin.PackagePath]
+		if !ok || m.Replace != nil || m.Version == pin.Version {
+			// pins of modules that are not in the build, or that
+			// are replaced, hold
+			continue
+		}
+		c := ModuleConflict{
+			Kind:      ConflictUpgraded,
+			Module:    pin.PackagePath,
+			Requested: pin.Version,
+			Selected:  []string{m.Version},
+		}
+		if semver.Compare(m.Version, pin.Version) < 0 {
+			c.Kind = ConflictDowngraded
+		}
+		conflicts = append(conflicts, c)
+	}
+	if len(conflicts) > 0 {
+		return &PinError{Conflicts: conflicts}
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package builder
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// Plan describes what a build would do.
+type Plan struct {
+	// The generated main.go of the main module.
+	MainFile string `json:"main_file,omitempty"`
+
+	// The commands the build would run, in order.
+	Commands []PlannedCommand `json:"commands,omitempty"`
+}
+
+// PlannedCommand is a command that a build would run.
+type PlannedCommand struct {
+	// The command and its arguments.
+	Args []string `json:"args,omitempty"`
+
+	// The environment variables the builder sets for the command
+	// in addition to (or instead of) those of the process.
+	Env []string `json:"env,omitempty"`
+}
+
+func (c PlannedCommand) String() string {
+	return strings.Join(append(append([]string(nil), c.Env...), c.Args...), " ")
+}
+
+func (p *Plan) String() string {
+	var sb strings.Builder
+	for _, c := range p.Commands {
+		sb.WriteString(c.String())
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
+
+// record adds cmd to the plan.
+func (p *Plan) record(cmd *exec.Cmd) {
+	var vars []string
+	if cmd.Env != nil {
+		inherited := make(map[string]bool)
+		for _, kv := range os.Environ() {
+			inherited[kv] = true
+		}
+		for _, kv := range cmd.Env {
+			if !inherited[kv] {
+				vars = append(vars, kv)
+			}
+		}
+	}
+	p.Commands = append(p.Commands, PlannedCommand{
+		Args: append([]string(nil), cmd.Args...),
+		Env:  vars,
+	})
+}
+
+// Plan returns what Build would do to build outputFile without
+// doing it: the generated main.go and the commands that prepare the
+// module, such as `go get`, tidy it, and build the binary, along with
+// the hooks. The edits of go.mod, which Build makes in-process, are
+// shown as the equivalent `go mod edit` commands. Commands that only
+// query the module graph, such as those that resolve version queries
+// or implement the optional checks, are left out; their results are
+// not known without running them, so version queries appear
+// unresolved.
+//
+// Nothing but the local filesystem is accessed, and the temporary
+// folder used for planning is removed.
+func (b Builder) Plan(ctx context.Context, outputFile string) (*Plan, error) {
+	b.setPlatformDefaults()
+	if err := checkPlatform(ctx, b.Platform); err != nil {
+		return nil, err
+	}
+	absOutputFile, err := b.outputPath(outputFile)
+	if err != nil {
+		return nil, err
+	}
+	mainContent, err := b.mainFileContent()
+	if err != nil {
+		return nil, err
+	}
+
+	plan := &Plan{MainFile: string(mainContent)}
+	b.plan = plan
+	buildEnv, err := b.newEnvironment(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer buildEnv.Close()
+	if err := buildEnv.tidy(ctx); err != nil {
+		return nil, err
+	}
+	if err := buildEnv.runHooks(ctx, "post-tidy", b.Hooks.PostTidy, nil); err != nil {
+		return nil, err
+	}
+	if _, err := b.compile(ctx, buildEnv, absOutputFile, time.Now()); err != nil {
+		return nil, fmt.Errorf("planning build: %v", err)
+	}
+	return plan, nil
+}
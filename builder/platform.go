@@ -1,14 +1,22 @@
 package builder
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
 	"os/exec"
+	"strings"
 )
 
 // Compile contains parameters for compilation.
 type Compile struct {
 	Platform
 	Cgo bool `json:"cgo,omitempty"`
+
+	// CgoCFlags and CgoLdFlags are exported as CGO_CFLAGS and
+	// CGO_LDFLAGS, respectively, but only when cgo is enabled.
+	CgoCFlags  string `json:"cgo_cflags,omitempty"`
+	CgoLdFlags string `json:"cgo_ldflags,omitempty"`
 }
 
 // CgoEnabled returns "1" if c.Cgo is true, "0" otherwise.
@@ -25,12 +33,53 @@ type Platform struct {
 	OS   string `json:"os,omitempty"`
 	Arch string `json:"arch,omitempty"`
 	ARM  string `json:"arm,omitempty"`
+
+	// Micro-architecture levels for the other architectures
+	// that support them, exported as GOAMD64, GOARM64, GO386,
+	// GOMIPS, and GOMIPS64, respectively.
+	AMD64  string `json:"amd64,omitempty"`
+	ARM64  string `json:"arm64,omitempty"`
+	I386   string `json:"386,omitempty"`
+	MIPS   string `json:"mips,omitempty"`
+	MIPS64 string `json:"mips64,omitempty"`
+}
+
+// microArchEnv returns the environment variables for
+// the micro-architecture levels of p as key=value pairs.
+func (p Platform) microArchEnv() []string {
+	return []string{
+		"GOAMD64=" + p.AMD64,
+		"GOARM64=" + p.ARM64,
+		"GO386=" + p.I386,
+		"GOMIPS=" + p.MIPS,
+		"GOMIPS64=" + p.MIPS64,
+	}
+}
+
+// microArch returns the micro-architecture level
+// of p that applies to its architecture, if any.
+func (p Platform) microArch() string {
+	switch p.Arch {
+	case "arm":
+		return p.ARM
+	case "amd64":
+		return p.AMD64
+	case "arm64":
+		return p.ARM64
+	case "386":
+		return p.I386
+	case "mips", "mipsle":
+		return p.MIPS
+	case "mips64", "mips64le":
+		return p.MIPS64
+	}
+	return ""
 }
 
 // SupportedPlatforms runs `go tool dist list` to make
 // a list of possible build targets.
-func SupportedPlatforms() ([]Compile, error) {
-	out, err := exec.Command(GetGo(), "tool", "dist", "list", "-json").Output()
+func SupportedPlatforms(ctx context.Context) ([]Compile, error) {
+	out, err := exec.CommandContext(ctx, GetGo(), "tool", "dist", "list", "-json").Output()
 	if err != nil {
 		return nil, err
 	}
@@ -63,6 +112,48 @@ func SupportedPlatforms() ([]Compile, error) {
 	return compiles, nil
 }
 
+// UnsupportedPlatformError is returned when a build
+// targets a platform that the Go toolchain does not support.
+type UnsupportedPlatformError struct {
+	Platform  Platform
+	Supported []Platform
+}
+
+func (e *UnsupportedPlatformError) Error() string {
+	valid := make([]string, 0, len(e.Supported))
+	for _, p := range e.Supported {
+		valid = append(valid, p.key())
+	}
+	return fmt.Sprintf("unsupported platform %s; valid platforms are: %s",
+		e.Platform.key(), strings.Join(valid, ", "))
+}
+
+// checkPlatform returns an *UnsupportedPlatformError if p is
+// not among the build targets supported by the Go toolchain.
+// Empty fields stand for the defaults of the toolchain and are
+// not checked.
+func checkPlatform(ctx context.Context, p Platform) error {
+	if p.OS == "" && p.Arch == "" && p.ARM == "" {
+		return nil
+	}
+	compiles, err := SupportedPlatforms(ctx)
+	if err != nil {
+		return fmt.Errorf("listing supported platforms: %v", err)
+	}
+	// ARM versions may have a suffix such as ",softfloat"
+	arm := strings.SplitN(p.ARM, ",", 2)[0]
+	supported := make([]Platform, 0, len(compiles))
+	for _, c := range compiles {
+		supported = append(supported, c.Platform)
+		if (p.OS == "" || p.OS == c.OS) &&
+			(p.Arch == "" || p.Arch == c.Arch) &&
+			(arm == "" || arm == c.ARM) {
+			return nil
+		}
+	}
+	return &UnsupportedPlatformError{Platform: p, Supported: supported}
+}
+
 // dist is the structure that fits the output
 // of the `go tool dist list -json` command.
 type dist struct {
@@ -0,0 +1,223 @@
+package builder
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// PluginProblem describes a requested plugin that would
+// not add any modules to Caddy.
+type PluginProblem struct {
+	// The package path of the plugin.
+	Package string `json:"package,omitempty"`
+
+	// What is wrong with it.
+	Problem string `json:"problem,omitempty"`
+}
+
+func (p PluginProblem) String() string {
+	return fmt.Sprintf("%s %s", p.Package, p.Problem)
+}
+
+// PluginError is returned when strict plugin
+// checking is enabled and any problems were found.
+type PluginError struct {
+	Problems []PluginProblem
+}
+
+func (e *PluginError) Error() string {
+	lines := make([]string, 0, len(e.Problems))
+	for _, p := range e.Problems {
+		lines = append(lines, p.String())
+	}
+	return fmt.Sprintf("%d plugin(s) add no modules: %s", len(e.Problems), strings.Join(lines, "; "))
+}
+
+// listedPackage is the subset of the output
+// of `go list -json` that the builder uses.
+type listedPackage struct {
+	ImportPath string
+	Name       string
+	Dir        string
+	GoFiles    []string
+	CgoFiles   []string
+	Error      *struct{ Err string }
+}
+
+// maxPluginDepth limits how deep checkPlugins follows the blank
+// imports of packages that only import plugins from elsewhere.
+const maxPluginDepth = 3
+
+// checkPlugins reports every plugin package that cannot be imported,
+// is a command, or neither registers a module with RegisterModule of
+// the Caddy package nor blank-imports a package that does. Such a
+// plugin is usually a library rather than a plugin and adds nothing
+// to the binary. The packages are analyzed from their source files,
+// with the given build tags.
+func (env environment) checkPlugins(ctx context.Context, plugins []Dependency, tags []string) ([]PluginProblem, error) {
+	if env.plan != nil || len(plugins) == 0 {
+		// queries are not run when planning
+		return nil, nil
+	}
+	var problems []PluginProblem
+	for _, p := range plugins {
+		problem, err := env.checkPlugin(ctx, p.PackagePath, tags, 0, make(map[string]bool))
+		if err != nil {
+			return nil, err
+		}
+		if problem != "" {
+			problems = append(problems, PluginProblem{Package: p.PackagePath, Problem: problem})
+		}
+	}
+	return problems, nil
+}
+
+// checkPlugin returns what is wrong with the plugin package pkg,
+// if anything, following blank imports up to maxPluginDepth.
+func (env environment) checkPlugin(ctx context.Context, pkgPath string, tags []string, depth int, seen map[string]bool) (string, error) {
+	seen[pkgPath] = true
+	pkg, err := env.listPackage(ctx, pkgPath, tags)
+	if err != nil {
+		return "", err
+	}
+	if pkg.Error != nil {
+		return "cannot be imported: " + strings.TrimSpace(pkg.Error.Err), nil
+	}
+	if pkg.Name == "main" {
+		return "is a command, not a plugin", nil
+	}
+
+	registers, blankImports, err := env.scanPluginFiles(pkg)
+	if err != nil {
+		// e.g. the sources are only on an executor
+		env.log.Printf("[INFO] Not checking plugin %s: %v", pkgPath, err)
+		return "", nil
+	}
+	if registers {
+		return "", nil
+	}
+	if depth < maxPluginDepth {
+		for _, imp := range blankImports {
+			if seen[imp] || !strings.Contains(strings.SplitN(imp, "/", 2)[0], ".") {
+				// skip the standard library
+				continue
+			}
+			problem, err := env.checkPlugin(ctx, imp, tags, depth+1, seen)
+			if err != nil {
+				return "", err
+			}
+			if problem == "" {
+				return "", nil
+			}
+		}
+	}
+	if len(blankImports) > 0 {
+		return "does not register a module, nor does any package it blank-imports", nil
+	}
+	return "does not register a module; it may be a library rather than a plugin", nil
+}
+
+// listPackage runs `go list -json` for the package pkgPath in env.
+func (env environment) listPackage(ctx context.Context, pkgPath string, tags []string) (*listedPackage, error) {
+	var out bytes.Buffer
+	cmd := env.newCommand(ctx, GetGo(), "list", "-e", "-json")
+	if len(tags) > 0 {
+		cmd.Args = append(cmd.Args, "-tags", strings.Join(tags, ","))
+	}
+	cmd.Args = append(cmd.Args, pkgPath)
+	cmd.Stdout = &out
+	if err := env.runCommand(ctx, cmd); err != nil {
+		return nil, err
+	}
+	var pkg listedPackage
+	if err := json.Unmarshal(out.Bytes(), &pkg); err != nil {
+		return nil, fmt.Errorf("decoding package information: %v", err)
+	}
+	return &pkg, nil
+}
+
+// scanPluginFiles parses the source files of pkg and reports whether
+// they call RegisterModule of the Caddy package, and which packages
+// they blank-import.
+func (env environment) scanPluginFiles(pkg *listedPackage) (bool, []string, error) {
+	fset := token.NewFileSet()
+	var blankImports []string
+	for _, name := range append(append([]string(nil), pkg.GoFiles...), pkg.CgoFiles...) {
+		file, err := parser.ParseFile(fset, filepath.Join(pkg.Dir, name), nil, parser.SkipObjectResolution)
+		if err != nil {
+			return false, nil, err
+		}
+		caddyName := ""
+		for _, imp := range file.Imports {
+			importPath, _ := strconv.Unquote(imp.Path.Value)
+			switch {
+			case imp.Name != nil && imp.Name.Name == "_":
+				blankImports = append(blankImports, importPath)
+			case importPath == env.caddyModulePath:
+				caddyName = packageName(importPath)
+				if imp.Name != nil {
+					caddyName = imp.Name.Name
+				}
+			}
+		}
+		if caddyName == "" {
+			continue
+		}
+		registers := false
+		ast.Inspect(file, func(n ast.Node) bool {
+			call, ok := n.(*ast.CallExpr)
+			if !ok {
+				return !registers
+			}
+			if sel, ok := call.Fun.(*ast.SelectorExpr); ok && sel.Sel.Name == "RegisterModule" {
+				if x, ok := sel.X.(*ast.Ident); ok && x.Name == caddyName {
+					registers = true
+				}
+			}
+			return !registers
+		})
+		if registers {
+			return true, nil, nil
+		}
+	}
+	return false, blankImports, nil
+}
+
+// packageName returns the conventional name of the package
+// importPath: its last element, or the one before a major
+// version suffix, e.g. "caddy" for ".../caddy/v2".
+func packageName(importPath string) string {
+	base := path.Base(importPath)
+	if len(base) > 1 && base[0] == 'v' {
+		if _, err := strconv.Atoi(base[1:]); err == nil {
+			return path.Base(path.Dir(importPath))
+		}
+	}
+	return base
+}
+
+// enforcePlugins runs checkPlugins, logging every problem
+// as a warning, or failing with a PluginError if strict.
+func (env environment) enforcePlugins(ctx context.Context, plugins []Dependency, tags []string, strict bool) error {
+	env.log.Printf("[INFO] Checking plugins")
+	problems, err := env.checkPlugins(ctx, plugins, tags)
+	if err != nil {
+		return err
+	}
+	for _, p := range problems {
+		env.log.Printf("[WARNING] Plugin %s", p)
+	}
+	if strict && len(problems) > 0 {
+		return &PluginError{Problems: problems}
+	}
+	return nil
+}
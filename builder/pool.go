@@ -0,0 +1,179 @@
+package builder
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Errors returned by Pool.Build when a build is not accepted.
+var (
+	ErrPoolFull   = errors.New("build pool queue is full")
+	ErrPoolClosed = errors.New("build pool is closed")
+)
+
+// Pool runs builds concurrently, up to a limit, and queues the rest.
+// Every build runs in its own build environment with a GOPATH and
+// GOCACHE of its own, so that concurrent builds, which may be for
+// different tenants, cannot affect each other; only the module cache
+// is shared, which the go command supports. The zero value is a pool
+// that runs one build at a time. A Pool must not be copied after
+// first use.
+type Pool struct {
+	// Size is the number of builds that may run at once. Default: 1
+	Size int
+
+	// QueueSize, if positive, limits the number of builds waiting
+	// to run; further builds fail with ErrPoolFull.
+	QueueSize int
+
+	// GoModCache is the module cache shared by all builds of the
+	// pool, unless a Builder sets GoModCache itself. It defaults
+	// to the module cache of the go command (`go env GOMODCACHE`).
+	GoModCache string
+
+	// WorkDir is the directory in which the GOPATH and GOCACHE of
+	// every build are created. They are removed after the build.
+	// It defaults to the directory for temporary files.
+	WorkDir string
+
+	// The limits of every build, unless its Builder sets its own:
+	// the time it may take, which is applied as TimeoutBuild, the
+	// disk space of its build environment with its GOPATH and
+	// GOCACHE, applied as MaxDiskBytes, and the number of CPUs
+	// its go commands may use (GOMAXPROCS).
+	TimeoutBuild time.Duration
+	MaxDiskBytes int64
+	MaxProcs     int
+
+	init    sync.Once
+	initErr error
+	slots   chan struct{}
+	mu      sync.Mutex
+	queued  int
+	running int
+	closed  bool
+	wg      sync.WaitGroup
+}
+
+// PoolStats reports the builds of a Pool.
+type PoolStats struct {
+	Running int `json:"running"`
+	Queued  int `json:"queued"`
+}
+
+// Build runs b.Build(ctx, outputFile) as soon as less than p.Size
+// builds are running, with the isolation and limits of p applied to
+// b. It waits in the queue until then, or until ctx is done.
+func (p *Pool) Build(ctx context.Context, b Builder, outputFile string) (*BuildResult, error) {
+	p.init.Do(p.setup)
+	if p.initErr != nil {
+		return nil, p.initErr
+	}
+
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil, ErrPoolClosed
+	}
+	if p.QueueSize > 0 && p.queued >= p.QueueSize {
+		p.mu.Unlock()
+		return nil, ErrPoolFull
+	}
+	p.queued++
+	p.wg.Add(1)
+	p.mu.Unlock()
+	defer p.wg.Done()
+
+	select {
+	case p.slots <- struct{}{}:
+	case <-ctx.Done():
+		p.mu.Lock()
+		p.queued--
+		p.mu.Unlock()
+		return nil, ctx.Err()
+	}
+	p.mu.Lock()
+	p.queued--
+	p.running++
+	p.mu.Unlock()
+	defer func() {
+		p.mu.Lock()
+		p.running--
+		p.mu.Unlock()
+		<-p.slots
+	}()
+
+	dir, err := os.MkdirTemp(p.WorkDir, "pool_")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	return p.isolate(b, dir).Build(ctx, outputFile)
+}
+
+// isolate returns b with the settings of p applied, using the
+// GOPATH and GOCACHE in dir.
+func (p *Pool) isolate(b Builder, dir string) Builder {
+	env := make(map[string]string, len(b.Env)+3)
+	for k, v := range b.Env {
+		env[k] = v
+	}
+	env["GOPATH"] = filepath.Join(dir, "gopath")
+	env["GOCACHE"] = filepath.Join(dir, "gocache")
+	if _, ok := env["GOMAXPROCS"]; !ok && p.MaxProcs > 0 {
+		env["GOMAXPROCS"] = strconv.Itoa(p.MaxProcs)
+	}
+	b.Env = env
+	if b.GoModCache == "" {
+		b.GoModCache = p.GoModCache
+	}
+	if b.TimeoutBuild == 0 {
+		b.TimeoutBuild = p.TimeoutBuild
+	}
+	if b.MaxDiskBytes == 0 {
+		b.MaxDiskBytes = p.MaxDiskBytes
+	}
+	return b
+}
+
+// setup initializes p before its first build.
+func (p *Pool) setup() {
+	size := p.Size
+	if size <= 0 {
+		size = 1
+	}
+	p.slots = make(chan struct{}, size)
+	if p.GoModCache == "" {
+		// the isolated GOPATH would otherwise
+		// move the module cache as well
+		out, err := exec.Command(GetGo(), "env", "GOMODCACHE").Output()
+		if err != nil {
+			p.initErr = err
+			return
+		}
+		p.GoModCache = strings.TrimSpace(string(out))
+	}
+}
+
+// Stats returns the number of running and queued builds of p.
+func (p *Pool) Stats() PoolStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return PoolStats{Running: p.running, Queued: p.queued}
+}
+
+// Close stops p from accepting new builds and waits for
+// the running and queued builds to finish.
+func (p *Pool) Close() {
+	p.mu.Lock()
+	p.closed = true
+	p.mu.Unlock()
+	p.wg.Wait()
+}
@@ -0,0 +1,305 @@
+package builder
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// PrebuiltSource is a download service that builds the base module
+// with plugins for a platform on request, such as the one of Caddy at
+// https://caddyserver.com/api/download. Builds that such a service
+// can produce, which are those for which only the version of the base
+// module, the plugins, and the platform are configured, download the
+// binary from it instead of compiling it. If the download fails, the
+// binary is built locally.
+type PrebuiltSource struct {
+	// URL is the download endpoint, which is queried with the
+	// parameters os, arch, arm, version (unless it is the latest
+	// version that is requested), and p for each plugin, as
+	// package@version or, for the latest version, as package.
+	URL string `json:"url,omitempty"`
+
+	// SHA256, if set, is the checksum in hex that the
+	// downloaded binary must have.
+	SHA256 string `json:"sha256,omitempty"`
+
+	// ChecksumURL, if set, is queried with the same parameters as
+	// URL and returns the checksum in hex that the downloaded binary
+	// must have, possibly followed by other fields, as in the output
+	// of sha256sum.
+	ChecksumURL string `json:"checksum_url,omitempty"`
+
+	// Timeout limits how long the download may take. Default: 5m
+	Timeout time.Duration `json:"timeout,omitempty"`
+}
+
+// defaultPrebuiltTimeout is the default of PrebuiltSource.Timeout.
+const defaultPrebuiltTimeout = 5 * time.Minute
+
+// ChecksumError is returned when a prebuilt binary
+// does not have the expected checksum.
+type ChecksumError struct {
+	URL      string
+	Expected string
+	Actual   string
+}
+
+func (e *ChecksumError) Error() string {
+	return fmt.Sprintf("binary downloaded from %s has SHA-256 %s instead of %s", e.URL, e.Actual, e.Expected)
+}
+
+// notPrebuiltReason returns why the build of b cannot be
+// downloaded from b.Prebuilt, or "" if it can.
+func (b Builder) notPrebuiltReason() string {
+	if b.ForceLocalBuild {
+		return "local builds are forced"
+	}
+	if b.CaddyVersion != "" && b.CaddyVersion != "latest" && !isConcreteVersion(b.CaddyVersion) {
+		return fmt.Sprintf("version %q is not a concrete version", b.CaddyVersion)
+	}
+	for _, p := range b.Plugins {
+		if p.Version != "" && p.Version != "latest" && !isConcreteVersion(p.Version) {
+			return fmt.Sprintf("version %q of %s is not a concrete version", p.Version, p.PackagePath)
+		}
+	}
+	if b.MainTemplateFS != nil || b.Executor != nil || len(b.Signers) > 0 || b.SBOMWriter != nil {
+		return "settings other than the versions, the plugins, and the platform are configured"
+	}
+
+	// everything that the service builds with from b, and
+	// the settings that do not affect the binary, are cleared;
+	// any other setting makes a difference
+	settings := func(b Builder) string {
+		b.CaddyVersion, b.Plugins, b.BaseModule, b.MainPackage = "", nil, "", ""
+		b.OS, b.Arch, b.ARM = "", "", ""
+		b.TimeoutGet, b.TimeoutBuild, b.Timeouts = 0, 0, Timeouts{}
+		b.SkipCleanup, b.CleanupPolicy, b.CleanupMaxAge, b.WorkDir = false, "", 0, ""
+		b.GracePeriod, b.FetchRetries, b.FetchRetryBackoff = 0, 0, 0
+		b.GoModCache, b.Credentials, b.ArtifactCache, b.Prebuilt = "", nil, nil, nil
+		b.GoProxy, b.GoNoProxy, b.GoPrivate, b.GoNoSumDB = "", "", "", ""
+		b.GoSumDB, b.GoInsecure, b.FetchConcurrency, b.GoProxies = "", "", 0, nil
+		b.Checkpoint = ""
+		b.MaxDiskBytes, b.MinFreeDiskBytes, b.LogCapture, b.DownloadMetrics = 0, 0, nil, false
+		data, _ := json.Marshal(b)
+		return string(data)
+	}
+	if settings(b) != settings(Builder{}) {
+		return "settings other than the versions, the plugins, and the platform are configured"
+	}
+	return ""
+}
+
+// prebuiltQuery returns the query parameters
+// of the requests to b.Prebuilt for the build of b.
+func (b Builder) prebuiltQuery() url.Values {
+	query := url.Values{}
+	query.Set("os", b.targetOS())
+	query.Set("arch", b.targetArch())
+	if b.ARM != "" {
+		query.Set("arm", b.ARM)
+	}
+	if b.CaddyVersion != "" && b.CaddyVersion != "latest" {
+		query.Set("version", b.CaddyVersion)
+	}
+	for _, p := range b.Plugins {
+		if p.Version != "" && p.Version != "latest" {
+			query.Add("p", p.PackagePath+"@"+p.Version)
+		} else {
+			query.Add("p", p.PackagePath)
+		}
+	}
+	return query
+}
+
+// buildPrebuilt downloads the binary for the build of b from
+// b.Prebuilt to absOutputFile, if the service can produce it. It
+// returns a nil result if the binary is to be built locally instead,
+// either because the service cannot produce it or because the
+// download failed; only a checksum mismatch is returned as an error.
+func (b Builder) buildPrebuilt(ctx context.Context, absOutputFile string, start time.Time) (*BuildResult, error) {
+	if reason := b.notPrebuiltReason(); reason != "" {
+		b.logger().Printf("[INFO] Not using a prebuilt binary: %s", reason)
+		return nil, nil
+	}
+	timeout := b.Prebuilt.Timeout
+	if timeout <= 0 {
+		timeout = defaultPrebuiltTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	query := b.prebuiltQuery()
+	downloadURL, err := withQuery(b.Prebuilt.URL, query)
+	if err != nil {
+		return nil, err
+	}
+	expected := strings.ToLower(b.Prebuilt.SHA256)
+	if b.Prebuilt.ChecksumURL != "" {
+		checksumURL, err := withQuery(b.Prebuilt.ChecksumURL, query)
+		if err != nil {
+			return nil, err
+		}
+		sum, err := fetchChecksum(ctx, checksumURL)
+		if err != nil {
+			b.logger().Printf("[WARNING] Fetching checksum of prebuilt binary, building locally: %v", err)
+			return nil, nil
+		}
+		if expected != "" && sum != expected {
+			return nil, &ChecksumError{URL: checksumURL, Expected: expected, Actual: sum}
+		}
+		expected = sum
+	}
+
+	b.logger().Printf("[INFO] Downloading prebuilt binary: %s", downloadURL)
+	tmpFile, actual, err := downloadFile(ctx, downloadURL, filepath.Dir(absOutputFile))
+	if err != nil {
+		b.logger().Printf("[WARNING] Downloading prebuilt binary, building locally: %v", err)
+		return nil, nil
+	}
+	defer os.Remove(tmpFile)
+	if expected != "" && actual != expected {
+		return nil, &ChecksumError{URL: downloadURL, Expected: expected, Actual: actual}
+	}
+	if problem := b.checkPrebuiltInfo(tmpFile); problem != "" {
+		b.logger().Printf("[WARNING] Prebuilt binary does not match the build, building locally: %s", problem)
+		return nil, nil
+	}
+	if err := os.Chmod(tmpFile, 0755); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(tmpFile, absOutputFile); err != nil {
+		return nil, err
+	}
+
+	result, err := b.newBuildResult(absOutputFile, start)
+	if err != nil {
+		return nil, err
+	}
+	caddyModulePath, _ := b.baseModule()
+	for _, m := range result.Modules {
+		if m.PackagePath == caddyModulePath {
+			result.CaddyVersion = m.Version
+		}
+	}
+	result.Prebuilt = downloadURL
+	b.logger().Printf("[INFO] Downloaded prebuilt binary: %s", absOutputFile)
+	return result, nil
+}
+
+// checkPrebuiltInfo returns how the build information of the binary
+// at path differs from the build of b, or "" if it does not: the
+// base module and the modules of the plugins must be there, at the
+// requested versions, and the binary must be for the target platform.
+func (b Builder) checkPrebuiltInfo(path string) string {
+	info, err := ReadBuildInfo(path)
+	if err != nil {
+		return err.Error()
+	}
+	if goos, goarch := info.Settings["GOOS"], info.Settings["GOARCH"]; goos != b.targetOS() || goarch != b.targetArch() {
+		return fmt.Sprintf("it is for %s/%s", goos, goarch)
+	}
+	modules := make([]listedModule, 0, len(info.Dependencies))
+	for _, dep := range info.Dependencies {
+		modules = append(modules, listedModule{Path: dep.PackagePath, Version: dep.Version})
+	}
+	caddyModulePath, _ := b.baseModule()
+	wanted := append([]Dependency{{PackagePath: caddyModulePath, Version: b.CaddyVersion}}, b.Plugins...)
+	for _, d := range wanted {
+		m := moduleProviding(modules, d.PackagePath)
+		switch {
+		case m == nil:
+			return fmt.Sprintf("it does not contain %s", d.PackagePath)
+		case isConcreteVersion(d.Version) && m.Version != d.Version:
+			return fmt.Sprintf("it contains %s %s instead of %s", m.Path, m.Version, d.Version)
+		}
+	}
+	return ""
+}
+
+// withQuery returns rawURL with the parameters of query added.
+func withQuery(rawURL string, query url.Values) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid prebuilt URL: %v", err)
+	}
+	q := u.Query()
+	for key, values := range query {
+		for _, value := range values {
+			q.Add(key, value)
+		}
+	}
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// fetchChecksum returns the checksum in hex at url,
+// which is the first field of the response.
+func fetchChecksum(ctx context.Context, url string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s: %s", url, resp.Status)
+	}
+	line, err := bufio.NewReader(io.LimitReader(resp.Body, 1024)).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return "", fmt.Errorf("%s: empty checksum", url)
+	}
+	if _, err := hex.DecodeString(fields[0]); err != nil || len(fields[0]) != 2*sha256.Size {
+		return "", fmt.Errorf("%s: invalid checksum %q", url, fields[0])
+	}
+	return strings.ToLower(fields[0]), nil
+}
+
+// downloadFile downloads url into a new file in dir and
+// returns the path of the file and its SHA-256 checksum.
+func downloadFile(ctx context.Context, url, dir string) (string, string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return "", "", err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", "", fmt.Errorf("%s: %s", url, resp.Status)
+	}
+
+	f, err := os.CreateTemp(dir, ".goaway-prebuilt")
+	if err != nil {
+		return "", "", err
+	}
+	h := sha256.New()
+	_, err = io.Copy(io.MultiWriter(f, h), resp.Body)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", "", err
+	}
+	return f.Name(), hex.EncodeToString(h.Sum(nil)), nil
+}
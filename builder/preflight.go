@@ -0,0 +1,210 @@
+package builder
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/mod/module"
+	"golang.org/x/mod/semver"
+)
+
+const (
+	// minGoVersion is the oldest Go toolchain that supports
+	// the go commands and flags that the builder runs, such as
+	// `go build -buildvcs`.
+	minGoVersion = "go1.18"
+
+	// minToolchainSwitchVersion is the oldest Go toolchain
+	// that honors GOTOOLCHAIN, as Builder.GoVersion needs.
+	minToolchainSwitchVersion = "go1.21"
+
+	// defaultMinFreeDiskBytes is the default of
+	// Builder.MinFreeDiskBytes.
+	defaultMinFreeDiskBytes = 1 << 30
+)
+
+// PreflightError is returned when the host cannot run a build,
+// as found before the build environment is created, such as
+// because the go command is missing or the disk is nearly full.
+type PreflightError struct {
+	Problems []string
+}
+
+func (e *PreflightError) Error() string {
+	return "preflight checks failed: " + strings.Join(e.Problems, "; ")
+}
+
+// preflight checks that the host can run the build of b to
+// absOutputFile: that the go command exists and is recent enough,
+// that git is available if modules will be fetched from version
+// control, and that the volumes of the build environment and the
+// output file, unless absOutputFile is empty, have MinFreeDiskBytes
+// of free space. The go command
+// and git are not checked if the build runs on an executor.
+func (b Builder) preflight(ctx context.Context, absOutputFile string) error {
+	var problems []string
+	if b.executor() == nil {
+		problems = append(problems, b.checkTools(ctx)...)
+	}
+	problems = append(problems, b.checkFreeSpace(absOutputFile)...)
+	if len(problems) > 0 {
+		return &PreflightError{Problems: problems}
+	}
+	return nil
+}
+
+// checkTools returns the problems with the go command and git.
+func (b Builder) checkTools(ctx context.Context) []string {
+	goPath, err := exec.LookPath(GetGo())
+	if err != nil {
+		return []string{fmt.Sprintf("go command not found: %v", err)}
+	}
+	goEnv, err := b.hostGoEnv(ctx, goPath, "GOVERSION", "GOPROXY", "GONOPROXY")
+	if err != nil {
+		return []string{fmt.Sprintf("running %s: %v", goPath, err)}
+	}
+
+	var problems []string
+	goVersion := goEnv["GOVERSION"]
+	if v := goSemver(goVersion); semver.IsValid(v) {
+		if semver.Compare(v, goSemver(minGoVersion)) < 0 {
+			problems = append(problems, fmt.Sprintf("Go toolchain %s is too old: the builder requires %s or newer", goVersion, minGoVersion))
+		} else if b.GoVersion != "" && goVersion != goToolchain(b.GoVersion) && semver.Compare(v, goSemver(minToolchainSwitchVersion)) < 0 {
+			problems = append(problems, fmt.Sprintf("Go toolchain %s cannot switch to the requested %s: that requires %s or newer",
+				goVersion, goToolchain(b.GoVersion), minToolchainSwitchVersion))
+		}
+	}
+
+	if reason := b.vcsFetchReason(goEnv["GOPROXY"], goEnv["GONOPROXY"]); reason != "" {
+		if _, err := exec.LookPath("git"); err != nil {
+			problems = append(problems, fmt.Sprintf("git not found, but %s: %v", reason, err))
+		}
+	}
+	return problems
+}
+
+// hostGoEnv returns the values of the go environment variables keys
+// as the go command at goPath on the host sees them in the builds of
+// b, without switching toolchains.
+func (b Builder) hostGoEnv(ctx context.Context, goPath string, keys ...string) (map[string]string, error) {
+	vars := processEnv(b.SandboxEnv)
+	for _, key := range b.UnsetEnv {
+		vars = unsetEnv(vars, key)
+	}
+	for _, kv := range b.goEnv() {
+		vars = setEnv(vars, kv)
+	}
+	// the version on the host is checked, not that of GoVersion
+	vars = setEnv(vars, "GOTOOLCHAIN=local")
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, goPath, append([]string{"env"}, keys...)...)
+	cmd.Env = vars
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("%v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	lines := strings.Split(strings.TrimSuffix(stdout.String(), "\n"), "\n")
+	if len(lines) != len(keys) {
+		return nil, fmt.Errorf("unexpected output of go env: %q", stdout.String())
+	}
+	values := make(map[string]string, len(keys))
+	for i, key := range keys {
+		values[key] = lines[i]
+	}
+	return values, nil
+}
+
+// vcsFetchReason returns why the go commands of the builds of b
+// will fetch modules from version control, given the effective
+// GOPROXY and GONOPROXY, or "" if they will not (or only as a
+// fallback after a module proxy).
+func (b Builder) vcsFetchReason(goProxy, goNoProxy string) string {
+	if b.Offline {
+		return ""
+	}
+	if b.Credentials != nil && len(b.Credentials.InsteadOf) > 0 {
+		return "the credentials rewrite git URLs"
+	}
+	if proxies := strings.FieldsFunc(goProxy, func(r rune) bool { return r == ',' || r == '|' }); len(proxies) > 0 && proxies[0] == "direct" {
+		return "GOPROXY fetches modules directly"
+	}
+	if goNoProxy == "" {
+		return ""
+	}
+	caddyModulePath, _ := b.baseModule()
+	paths := []string{caddyModulePath}
+	for _, p := range b.Plugins {
+		paths = append(paths, p.PackagePath)
+	}
+	for _, r := range b.Replacements {
+		if !r.New.IsLocal() {
+			paths = append(paths, r.New.ModulePath())
+		}
+	}
+	for _, path := range paths {
+		if module.MatchPrefixPatterns(goNoProxy, path) {
+			return fmt.Sprintf("%s is fetched directly as it matches GONOPROXY or GOPRIVATE", path)
+		}
+	}
+	return ""
+}
+
+// checkFreeSpace returns the problems with the free space on the
+// volumes of the build environment and of absOutputFile, if set.
+func (b Builder) checkFreeSpace(absOutputFile string) []string {
+	minFree := b.MinFreeDiskBytes
+	if minFree < 0 {
+		return nil
+	}
+	if minFree == 0 {
+		minFree = defaultMinFreeDiskBytes
+	}
+	workDir := b.WorkDir
+	if workDir == "" {
+		workDir = os.TempDir()
+	}
+	dirs := []string{workDir}
+	if absOutputFile != "" {
+		dirs = append(dirs, filepath.Dir(absOutputFile))
+	}
+	var problems []string
+	checked := make(map[uint64]bool)
+	for _, dir := range dirs {
+		dir = existingAncestor(dir)
+		free, device, ok := freeDiskSpace(dir)
+		if !ok || checked[device] {
+			continue
+		}
+		checked[device] = true
+		if free < minFree {
+			problems = append(problems, fmt.Sprintf("only %d bytes free on the volume of %s, less than the required %d", free, dir, minFree))
+		}
+	}
+	return problems
+}
+
+// existingAncestor returns dir, made absolute, or its
+// closest ancestor that exists, since directories are
+// only created when the build starts.
+func existingAncestor(dir string) string {
+	if abs, err := filepath.Abs(dir); err == nil {
+		dir = abs
+	}
+	for {
+		if _, err := os.Stat(dir); err == nil {
+			return dir
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return dir
+		}
+		dir = parent
+	}
+}
@@ -0,0 +1,101 @@
+package builder
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Environment is a build environment that has been prepared and
+// tidied once and can then be compiled from repeatedly, which avoids
+// resolving and downloading all modules for every build. It must be
+// closed when no longer needed.
+type Environment struct {
+	// Builder holds the configuration used for each build. Only the
+	// settings that affect compilation (such as the target platform,
+	// BuildFlags, Debug, or RaceDetector) may be changed between
+	// builds; the module graph was fixed when the environment was
+	// prepared.
+	Builder Builder
+
+	env *environment
+}
+
+// PrepareEnvironment prepares and tidies a build environment
+// for b that can be used for any number of builds.
+func (b Builder) PrepareEnvironment(ctx context.Context) (_ *Environment, err error) {
+	ctx, _, endBuild, err := b.beginBuild(ctx, time.Now(), "")
+	defer func() { endBuild(err) }()
+	if err != nil {
+		return nil, err
+	}
+	buildEnv, err := b.newEnvironment(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := buildEnv.tidy(ctx); err != nil {
+		_ = buildEnv.closeAfter(err)
+		return nil, err
+	}
+	if err := b.checkEnvironment(ctx, buildEnv); err != nil {
+		_ = buildEnv.closeAfter(err)
+		return nil, err
+	}
+	return &Environment{Builder: b, env: buildEnv}, nil
+}
+
+// Dir returns the folder of the build environment.
+func (e *Environment) Dir() string {
+	return e.env.tempFolder
+}
+
+// Build compiles the prepared environment according to
+// e.Builder and writes the binary to outputFile.
+func (e *Environment) Build(ctx context.Context, outputFile string) (_ *BuildResult, err error) {
+	start := time.Now()
+	if e.env == nil {
+		return nil, fmt.Errorf("build environment is closed")
+	}
+	b := e.Builder
+	var cancel context.CancelFunc
+	if b.TimeoutBuild > 0 {
+		ctx, cancel = context.WithTimeout(ctx, b.TimeoutBuild)
+		defer cancel()
+	}
+	ctx, absOutputFile, endBuild, err := b.beginBuild(ctx, start, outputFile)
+	defer func() { endBuild(err) }()
+	if err != nil {
+		return nil, err
+	}
+	if err := checkPlatform(ctx, b.Platform); err != nil {
+		return nil, err
+	}
+	if err := prepareOutputFile(absOutputFile); err != nil {
+		return nil, err
+	}
+
+	// the flags and environment of the go command
+	// may have changed since the environment was prepared
+	buildEnv := *e.env
+	buildEnv.buildFlags = b.BuildFlags
+	buildEnv.goEnv = b.goEnv()
+	buildEnv.unsetEnv = b.UnsetEnv
+	buildEnv.timeouts = b.Timeouts.effective()
+	absOutputFile, err = b.finalOutputPath(ctx, &buildEnv, outputFile, absOutputFile)
+	if err != nil {
+		return nil, err
+	}
+
+	b.logger().Printf("[INFO] Building Caddy in prepared environment")
+	return b.compile(ctx, &buildEnv, absOutputFile, start)
+}
+
+// Close cleans up the build environment.
+func (e *Environment) Close() error {
+	if e.env == nil {
+		return nil
+	}
+	err := e.env.Close()
+	e.env = nil
+	return err
+}
@@ -0,0 +1,23 @@
+//go:build !unix
+
+package builder
+
+import (
+	"os"
+	"os/exec"
+)
+
+// setProcessGroup does nothing on this platform, where
+// only the command itself can be terminated.
+func setProcessGroup(cmd *exec.Cmd) {}
+
+// interruptProcessGroup interrupts cmd, which
+// is not supported on every platform.
+func interruptProcessGroup(cmd *exec.Cmd) error {
+	return cmd.Process.Signal(os.Interrupt)
+}
+
+// killProcessGroup kills cmd.
+func killProcessGroup(cmd *exec.Cmd) error {
+	return cmd.Process.Kill()
+}
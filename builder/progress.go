@@ -0,0 +1,85 @@
+package builder
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"sync"
+)
+
+// progressWriter forwards output from `go build -v` to w and
+// reports the fraction of packages compiled so far. With -v, the
+// go command prints the import path of every package it compiles
+// on a line of its own; other lines, such as the commands that
+// -x prints or compiler errors, are not counted. Linking counts
+// as one more step, so the fraction only reaches 1 when the
+// build has succeeded, which the caller reports.
+type progressWriter struct {
+	w        io.Writer
+	packages map[string]bool // the packages that may be compiled
+	report   func(float64)
+
+	mu       sync.Mutex
+	partial  []byte // the end of the output without a newline
+	compiled map[string]bool
+}
+
+func (pw *progressWriter) Write(p []byte) (int, error) {
+	pw.mu.Lock()
+	pw.partial = append(pw.partial, p...)
+	var counted bool
+	for {
+		i := bytes.IndexByte(pw.partial, '\n')
+		if i < 0 {
+			break
+		}
+		line := strings.TrimSuffix(string(pw.partial[:i]), "\r")
+		pw.partial = pw.partial[i+1:]
+		if pw.packages[line] && !pw.compiled[line] {
+			if pw.compiled == nil {
+				pw.compiled = make(map[string]bool)
+			}
+			pw.compiled[line] = true
+			counted = true
+		}
+	}
+	if counted {
+		pw.report(pw.fraction())
+	}
+	pw.mu.Unlock()
+	return pw.w.Write(p)
+}
+
+// fraction returns the fraction of the packages compiled so far.
+func (pw *progressWriter) fraction() float64 {
+	return float64(len(pw.compiled)) / float64(len(pw.packages)+1)
+}
+
+// listPackages returns the packages that `go build` may compile,
+// listing the dependencies of the main package with the given
+// environment variables and build tags.
+func (env environment) listPackages(ctx context.Context, vars []string, tags []string) (map[string]bool, error) {
+	if env.plan != nil {
+		// queries are not run when planning
+		return nil, nil
+	}
+	var out bytes.Buffer
+	cmd := env.newCommand(ctx, GetGo(), "list", "-deps")
+	if len(tags) > 0 {
+		cmd.Args = append(cmd.Args, "-tags", strings.Join(tags, ","))
+	}
+	cmd.Args = append(cmd.Args, ".")
+	cmd.Env = vars
+	cmd.Stdout = &out
+	if err := env.runCommand(ctx, cmd); err != nil {
+		return nil, err
+	}
+	packages := make(map[string]bool)
+	for _, line := range strings.Split(out.String(), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			packages[line] = true
+		}
+	}
+	return packages, nil
+}
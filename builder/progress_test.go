@@ -0,0 +1,135 @@
+package builder
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// buildOutputX is the output of `go build -v -x` for a main package
+// that imports errors, shortened to some of the packages it compiles.
+const buildOutputX = `WORK=/tmp/go-build4001325157
+mkdir -p $WORK/b005/
+internal/goarch
+echo '# import config' > $WORK/b005/importcfg # internal
+cd /tmp/px
+/usr/local/go/pkg/tool/linux_amd64/compile -o $WORK/b005/_pkg_.a -trimpath "$WORK/b005=>" -p internal/goarch -lang=go1.22 -std -complete -buildid fAXQ4ufam2YAJ-yQDtq0/fAXQ4ufam2YAJ-yQDtq0 -goversion go1.22.1 -nolocalimports -importcfg $WORK/b005/importcfg -pack /usr/local/go/src/internal/goarch/goarch.go /usr/local/go/src/internal/goarch/goarch_amd64.go /usr/local/go/src/internal/goarch/zgoarch_amd64.go
+go tool buildid -w $WORK/b005/_pkg_.a # internal
+cp $WORK/b005/_pkg_.a /root/.cache/go-build/82/826ebd6edc30fa6d8accfaaea482eb1f16617ffc0bba087d7a388efc32dde56f-d # internal
+mkdir -p $WORK/b004/
+internal/abi
+echo -n > $WORK/b004/go_asm.h # internal
+cd /usr/local/go/src/internal/abi
+/usr/local/go/pkg/tool/linux_amd64/asm -p internal/abi -trimpath "$WORK/b004=>" -I $WORK/b004/ -I /usr/local/go/pkg/include -D GOOS_linux -D GOARCH_amd64 -std -D GOAMD64_v1 -gensymabis -o $WORK/b004/symabis ./abi_test.s ./stub.s
+cat >/tmp/go-build4001325157/b004/importcfg << 'EOF' # internal
+# import config
+packagefile internal/goarch=/tmp/go-build4001325157/b005/_pkg_.a
+EOF
+cd /tmp/px
+/usr/local/go/pkg/tool/linux_amd64/compile -o $WORK/b004/_pkg_.a -trimpath "$WORK/b004=>" -p internal/abi -lang=go1.22 -std -buildid I7sg2JHFFF5s9kdk8IBU/I7sg2JHFFF5s9kdk8IBU -goversion go1.22.1 -symabis $WORK/b004/symabis -nolocalimports -importcfg $WORK/b004/importcfg -pack -asmhdr $WORK/b004/go_asm.h /usr/local/go/src/internal/abi/abi.go /usr/local/go/src/internal/abi/abi_amd64.go
+cd /usr/local/go/src/internal/abi
+/usr/local/go/pkg/tool/linux_amd64/asm -p internal/abi -trimpath "$WORK/b004=>" -I $WORK/b004/ -I /usr/local/go/pkg/include -D GOOS_linux -D GOARCH_amd64 -std -D GOAMD64_v1 -o $WORK/b004/abi_test.o ./abi_test.s
+/usr/local/go/pkg/tool/linux_amd64/asm -p internal/abi -trimpath "$WORK/b004=>" -I $WORK/b004/ -I /usr/local/go/pkg/include -D GOOS_linux -D GOARCH_amd64 -std -D GOAMD64_v1 -o $WORK/b004/stub.o ./stub.s
+go tool pack r $WORK/b004/_pkg_.a $WORK/b004/abi_test.o $WORK/b004/stub.o # internal
+go tool buildid -w $WORK/b004/_pkg_.a # internal
+cp $WORK/b004/_pkg_.a /root/.cache/go-build/bb/bb1467fb1ce75e2110f343d01df5565605094379150e30d0534e01ef217bf681-d # internal
+mkdir -p $WORK/b002/
+errors
+cat >/tmp/go-build4001325157/b002/importcfg << 'EOF' # internal
+# import config
+packagefile internal/reflectlite=/tmp/go-build4001325157/b003/_pkg_.a
+EOF
+cd /tmp/px
+/usr/local/go/pkg/tool/linux_amd64/compile -o $WORK/b002/_pkg_.a -trimpath "$WORK/b002=>" -p errors -lang=go1.22 -std -complete -buildid px2Nh-yJ51gdmIiZ7twP/px2Nh-yJ51gdmIiZ7twP -goversion go1.22.1 -nolocalimports -importcfg $WORK/b002/importcfg -pack /usr/local/go/src/errors/errors.go /usr/local/go/src/errors/join.go /usr/local/go/src/errors/wrap.go
+go tool buildid -w $WORK/b002/_pkg_.a # internal
+cp $WORK/b002/_pkg_.a /root/.cache/go-build/85/85be88377cd933a517aa198cb48396ec27e4dcfd1a854baa31c2ab880f7dfd23-d # internal
+mkdir -p $WORK/b001/
+goaway
+cat >/tmp/go-build4001325157/b001/importcfg << 'EOF' # internal
+# import config
+packagefile errors=/tmp/go-build4001325157/b002/_pkg_.a
+packagefile runtime=/root/.cache/go-build/3b/3b6b1f4ac8e4b35b3a1e7a5fa2e549d1e1a1cb9b5c09d1e5a2aeb4fb5d2b57e0-d
+EOF
+cd /tmp/buildenv_1234
+/usr/local/go/pkg/tool/linux_amd64/compile -o $WORK/b001/_pkg_.a -trimpath "$WORK/b001=>" -p main -lang=go1.19 -complete -buildid Zbm3_s5c1nPbCIq2G1Ah/Zbm3_s5c1nPbCIq2G1Ah -goversion go1.22.1 -c=4 -nolocalimports -importcfg $WORK/b001/importcfg -pack ./main.go
+go tool buildid -w $WORK/b001/_pkg_.a # internal
+cat >/tmp/go-build4001325157/b001/importcfg.link << 'EOF' # internal
+packagefile goaway=/tmp/go-build4001325157/b001/_pkg_.a
+packagefile errors=/tmp/go-build4001325157/b002/_pkg_.a
+modinfo "0w\xaf\f\x92t\b\x02A\xe1\xc1\a\xe6\xd6\x18\xe6path\tgoaway\n"
+EOF
+mkdir -p $WORK/b001/exe/
+cd .
+/usr/local/go/pkg/tool/linux_amd64/link -o $WORK/b001/exe/a.out -importcfg $WORK/b001/importcfg.link -buildmode=exe -buildid=2IE4JjbB_wcC3ZzM0adH/Zbm3_s5c1nPbCIq2G1Ah/Zbm3_s5c1nPbCIq2G1Ah/2IE4JjbB_wcC3ZzM0adH -extld=gcc $WORK/b001/_pkg_.a
+/usr/local/go/pkg/tool/linux_amd64/buildid -w $WORK/b001/exe/a.out # internal
+mv $WORK/b001/exe/a.out /tmp/dist/goaway
+`
+
+func TestProgressWriter(t *testing.T) {
+	// as listed by go list -deps, with packages that are
+	// not compiled because they are in the build cache
+	packages := map[string]bool{
+		"internal/goarch": true, "unsafe": true, "internal/abi": true,
+		"internal/reflectlite": true, "runtime": true, "errors": true, "goaway": true,
+	}
+	for _, size := range []int{len(buildOutputX), 4096, 100, 7, 1} {
+		var reports []float64
+		var out bytes.Buffer
+		pw := &progressWriter{w: &out, packages: packages, report: func(f float64) { reports = append(reports, f) }}
+		// the go command writes the output in chunks of any size
+		for data := []byte(buildOutputX); len(data) > 0; {
+			n := size
+			if n > len(data) {
+				n = len(data)
+			}
+			if _, err := pw.Write(data[:n]); err != nil {
+				t.Fatal(err)
+			}
+			data = data[n:]
+		}
+
+		if out.String() != buildOutputX {
+			t.Errorf("writes of %d bytes: the output was not forwarded unchanged", size)
+		}
+		// 4 of the 7 packages are compiled, and linking is a step of its own
+		if len(reports) == 0 || reports[len(reports)-1] != 4.0/8 {
+			t.Fatalf("writes of %d bytes reported %v, want a progress of 4/8 in the end", size, reports)
+		}
+		for i := 1; i < len(reports); i++ {
+			if reports[i] <= reports[i-1] {
+				t.Errorf("writes of %d bytes reported %v, which does not increase", size, reports)
+			}
+		}
+		if size == 1 && len(reports) != 4 {
+			t.Errorf("writes of single bytes reported %v, want one report for every compiled package", reports)
+		}
+	}
+}
+
+func TestBuildReportsProgress(t *testing.T) {
+	b := testBuilder(t, testBaseModule(t))
+	// a build cache of its own, so that packages
+	// are compiled, and -x for more output
+	b.Env["GOCACHE"] = t.TempDir()
+	b.BuildFlags = "-x"
+	var mu sync.Mutex
+	var reports []float64
+	b.OnProgress = func(f float64) {
+		mu.Lock()
+		reports = append(reports, f)
+		mu.Unlock()
+	}
+	if _, err := b.Build(context.Background(), filepath.Join(t.TempDir(), "goaway")); err != nil {
+		t.Fatal(err)
+	}
+	if len(reports) < 2 || reports[len(reports)-1] != 1 {
+		t.Fatalf("progress %v does not end with 1", reports)
+	}
+	for i, f := range reports[:len(reports)-1] {
+		if f <= 0 || f >= 1 || i > 0 && f < reports[i-1] {
+			t.Fatalf("progress %v is not increasing from 0 to 1 before the build has succeeded", reports)
+		}
+	}
+}
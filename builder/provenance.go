@@ -0,0 +1,167 @@
+package builder
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"time"
+)
+
+// ProvenanceOptions configures the SLSA provenance attestation of
+// a build: an in-toto statement that the binary, identified by its
+// SHA-256 digest, was built by this builder from the configuration,
+// the modules, and the Go toolchain that it describes.
+type ProvenanceOptions struct {
+	// BuilderID is the URI of the platform that runs the build,
+	// such as that of a CI runner. Default: provenanceBuilderID
+	BuilderID string `json:"builder_id,omitempty"`
+
+	// InvocationID, if set, identifies this run of the
+	// build, such as the URL of a CI job.
+	InvocationID string `json:"invocation_id,omitempty"`
+
+	// Writer, if set, receives the attestation instead of a
+	// file next to the binary, such as goaway.intoto.jsonl.
+	// Signers do not sign what is written to it.
+	Writer io.Writer `json:"-"`
+}
+
+const (
+	// provenanceBuilderID is the default of ProvenanceOptions.BuilderID.
+	provenanceBuilderID = "https://github.com/crackeer/goaway/builder"
+
+	// provenanceBuildType describes how to interpret the
+	// parameters of the build definition.
+	provenanceBuildType = "https://github.com/crackeer/goaway/builder/provenance/v1"
+
+	// provenanceExtension is the file extension of
+	// attestations written next to the binary.
+	provenanceExtension = ".intoto.jsonl"
+)
+
+// writeProvenance writes the provenance attestation of the binary
+// described by result, which was built by b from the given start
+// time, to opts.Writer or, if that is nil, to a file next to the
+// binary, whose path is returned.
+func (b Builder) writeProvenance(opts ProvenanceOptions, result *BuildResult, start time.Time) (string, error) {
+	bi, err := readBuildInfo(result.OutputFile)
+	if err != nil {
+		return "", fmt.Errorf("reading build information for provenance: %v", err)
+	}
+	config, err := json.Marshal(b)
+	if err != nil {
+		return "", err
+	}
+	configSum := sha256.Sum256(config)
+
+	var deps []map[string]interface{}
+	for _, mod := range append([]*debug.Module{&bi.Main}, bi.Deps...) {
+		if mod.Path == "" {
+			continue
+		}
+		if r := mod.Replace; r != nil && (r.Version == "" || r.Version == "(devel)") {
+			// a local directory has no version or checksum
+			deps = append(deps, map[string]interface{}{
+				"uri":         purl(mod.Path, ""),
+				"annotations": map[string]string{"local_replacement": r.Path},
+			})
+			continue
+		}
+		if mod.Replace != nil {
+			mod = mod.Replace
+		}
+		dep := map[string]interface{}{"uri": purl(mod.Path, mod.Version)}
+		if mod.Sum != "" {
+			// the go.sum hash of the module, such as h1:...
+			dep["digest"] = map[string]string{"dirHash": mod.Sum}
+		}
+		deps = append(deps, dep)
+	}
+	settings := make(map[string]string, len(bi.Settings))
+	for _, s := range bi.Settings {
+		settings[s.Key] = s.Value
+	}
+
+	builderID := opts.BuilderID
+	if builderID == "" {
+		builderID = provenanceBuilderID
+	}
+	metadata := map[string]interface{}{}
+	if opts.InvocationID != "" {
+		metadata["invocationId"] = opts.InvocationID
+	}
+	if !b.Reproducible {
+		// the times would make the attestation differ between builds
+		metadata["startedOn"] = start.UTC().Format(time.RFC3339)
+		metadata["finishedOn"] = time.Now().UTC().Format(time.RFC3339)
+	}
+
+	statement := map[string]interface{}{
+		"_type": "https://in-toto.io/Statement/v1",
+		"subject": []map[string]interface{}{{
+			"name":   filepath.Base(result.OutputFile),
+			"digest": map[string]string{"sha256": result.SHA256},
+		}},
+		"predicateType": "https://slsa.dev/provenance/v1",
+		"predicate": map[string]interface{}{
+			"buildDefinition": map[string]interface{}{
+				"buildType": provenanceBuildType,
+				"externalParameters": map[string]interface{}{
+					"version":       b.CaddyVersion,
+					"plugins":       b.Plugins,
+					"platform":      b.targetOS() + "/" + b.targetArch(),
+					"config_sha256": hex.EncodeToString(configSum[:]),
+				},
+				"internalParameters": map[string]interface{}{
+					"go_version":     bi.GoVersion,
+					"build_settings": settings,
+				},
+				"resolvedDependencies": deps,
+			},
+			"runDetails": map[string]interface{}{
+				"builder": map[string]interface{}{
+					"id":      builderID,
+					"version": map[string]string{"goaway-builder": builderVersion()},
+				},
+				"metadata": metadata,
+			},
+		},
+	}
+
+	w := opts.Writer
+	var provenanceFile string
+	if w == nil {
+		provenanceFile = result.OutputFile + provenanceExtension
+		f, err := os.Create(provenanceFile)
+		if err != nil {
+			return "", err
+		}
+		defer f.Close()
+		w = f
+	}
+	// one statement per line, as in-toto bundles have it
+	if err := json.NewEncoder(w).Encode(statement); err != nil {
+		return "", err
+	}
+	return provenanceFile, nil
+}
+
+// builderVersion returns the version of the module of this
+// package in the running program, or "(devel)" if unknown.
+func builderVersion() string {
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "(devel)"
+	}
+	for _, mod := range append([]*debug.Module{&bi.Main}, bi.Deps...) {
+		if mod.Path == defaultBaseModule && mod.Version != "" {
+			return mod.Version
+		}
+	}
+	return "(devel)"
+}
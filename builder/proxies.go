@@ -0,0 +1,140 @@
+package builder
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ModuleProxy is a module proxy of Builder.GoProxies.
+type ModuleProxy struct {
+	// URL is the URL of the proxy, such as "https://goproxy.cn",
+	// or "direct" to fetch from version control, or "off".
+	URL string `json:"url"`
+
+	// Timeout, if positive, is how long the proxy may take to
+	// answer when it is probed before the build; one that does
+	// not is skipped. Default: defaultProxyTimeout
+	Timeout time.Duration `json:"timeout,omitempty"`
+}
+
+// defaultProxyTimeout is the default of ModuleProxy.Timeout.
+const defaultProxyTimeout = 5 * time.Second
+
+// isProxyKeyword reports whether url is a keyword of GOPROXY
+// rather than the URL of a proxy.
+func isProxyKeyword(url string) bool {
+	return url == "direct" || url == "off"
+}
+
+// checkProxies returns the problems with proxies.
+func checkProxies(proxies []ModuleProxy) []ValidationProblem {
+	v := &validator{}
+	for i, p := range proxies {
+		field := fmt.Sprintf("go_proxies[%d]", i)
+		switch {
+		case i > 0 && proxies[i-1].URL == "off":
+			v.add(field, fmt.Errorf("is never used, as it follows off"))
+		case isProxyKeyword(p.URL):
+		default:
+			u, err := url.Parse(p.URL)
+			if err != nil || u.Scheme != "https" && u.Scheme != "http" && u.Scheme != "file" || u.Scheme != "file" && u.Host == "" {
+				v.add(field+".url", fmt.Errorf("invalid module proxy URL %q; expected an http(s) or file URL, direct, or off", p.URL))
+			}
+		}
+		if p.Timeout < 0 {
+			v.add(field+".timeout", fmt.Errorf("must not be negative"))
+		}
+	}
+	return v.problems
+}
+
+// goProxyList returns the value of GOPROXY for proxies. They are
+// separated by pipes, so that the go command falls back to the
+// next proxy on any error, not only when a module is not found.
+func goProxyList(proxies []ModuleProxy) string {
+	urls := make([]string, 0, len(proxies))
+	for _, p := range proxies {
+		urls = append(urls, p.URL)
+	}
+	return strings.Join(urls, "|")
+}
+
+// selectProxies probes the proxies of b.GoProxies concurrently, each
+// with its timeout, and leaves out those that are unreachable or
+// fail with a server error, so that the go commands of the build do
+// not wait on them for every module. The order of the others is
+// kept. If none is reachable, a PreflightError is returned. Builds
+// on an executor do not probe, as its network may differ.
+func (b *Builder) selectProxies(ctx context.Context) error {
+	if len(b.GoProxies) == 0 || b.executor() != nil {
+		return nil
+	}
+	failures := make([]error, len(b.GoProxies))
+	var wg sync.WaitGroup
+	for i, p := range b.GoProxies {
+		if isProxyKeyword(p.URL) || strings.HasPrefix(p.URL, "file:") {
+			continue
+		}
+		wg.Add(1)
+		go func(i int, p ModuleProxy) {
+			defer wg.Done()
+			failures[i] = probeProxy(ctx, p)
+		}(i, p)
+	}
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	var selected []ModuleProxy
+	var problems []string
+	for i, p := range b.GoProxies {
+		if failures[i] != nil {
+			b.logger().Printf("[WARNING] Skipping module proxy %s: %v", p.URL, failures[i])
+			problems = append(problems, fmt.Sprintf("module proxy %s: %v", p.URL, failures[i]))
+			continue
+		}
+		selected = append(selected, p)
+	}
+	if len(selected) == 0 {
+		return &PreflightError{Problems: problems}
+	}
+	if len(selected) < len(b.GoProxies) {
+		b.logger().Printf("[INFO] Using module proxies %s", goProxyList(selected))
+	}
+	b.GoProxies = selected
+	return nil
+}
+
+// probeProxy returns an error if the proxy p does not answer a
+// request for its root within its timeout, or with a server error.
+// Any other status, such as 404 or 401, means that it is reachable.
+func probeProxy(ctx context.Context, p ModuleProxy) error {
+	timeout := p.Timeout
+	if timeout <= 0 {
+		timeout = defaultProxyTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(p.URL, "/")+"/", nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return fmt.Errorf("no answer within %s", timeout)
+		}
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return fmt.Errorf("server error: %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,167 @@
+package builder
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ReportEvent is an event of the report of a build, which is
+// written to Builder.Report as a line of JSON, so that tools that
+// orchestrate builds can follow them without parsing the log.
+type ReportEvent struct {
+	// When the event happened.
+	Time time.Time `json:"time"`
+
+	// The type of the event: ReportPhaseStarted, ReportPhaseFinished,
+	// ReportWarning, ReportResult, or ReportFinished.
+	Type string `json:"type"`
+
+	// The phase that started or finished, which is the name of its
+	// span, such as "setup" or "compile" (see SpanBuild), with its
+	// attributes, such as the module of a "go get".
+	Phase      string            `json:"phase,omitempty"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+
+	// How long the phase or the build took.
+	Duration time.Duration `json:"duration,omitempty"`
+
+	// The message of a warning, as logged
+	// without the [WARNING] prefix.
+	Message string `json:"message,omitempty"`
+
+	// The error of a phase or build that failed, and for a
+	// build, the reason as returned by FailureReason.
+	Error  string `json:"error,omitempty"`
+	Reason string `json:"reason,omitempty"`
+
+	// The binary that was built.
+	Result *BuildResult `json:"result,omitempty"`
+}
+
+// The types of the events of a report. A build reports a result for
+// each binary it built, which is one except for BuildAll, and then
+// that it finished, whether it failed or not.
+const (
+	ReportPhaseStarted  = "phase_started"
+	ReportPhaseFinished = "phase_finished"
+	ReportWarning       = "warning"
+	ReportResult        = "result"
+	ReportFinished      = "finished"
+)
+
+// reportMu serializes the events of concurrent builds,
+// which may share a writer, so that lines do not mix.
+var reportMu sync.Mutex
+
+// reporter writes the report of a build. Its
+// methods do nothing if it is nil.
+type reporter struct {
+	w     io.Writer
+	start time.Time
+}
+
+// startReport returns b with a Logger and Instrumentation that
+// report its warnings and phases to b.Report, and the reporter to
+// finish the report with, or b unchanged and nil if Report is not set.
+func (b Builder) startReport() (Builder, *reporter) {
+	if b.Report == nil {
+		return b, nil
+	}
+	r := &reporter{w: b.Report, start: time.Now()}
+	b.Logger = reportLogger{Logger: b.logger(), r: r}
+	b.Instrumentation = reportInstrumentation{Instrumentation: b.instrumentation(), r: r}
+	return b, r
+}
+
+// emit writes e to the report. Errors are dropped,
+// as the report must not fail the build.
+func (r *reporter) emit(e ReportEvent) {
+	if r == nil {
+		return
+	}
+	e.Time = time.Now().UTC()
+	line, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	reportMu.Lock()
+	defer reportMu.Unlock()
+	_, _ = r.w.Write(append(line, '\n'))
+}
+
+// finish reports the results of the build and that it
+// finished, with err if it failed.
+func (r *reporter) finish(results []*BuildResult, err error) {
+	if r == nil {
+		return
+	}
+	for _, result := range results {
+		if result != nil {
+			r.emit(ReportEvent{Type: ReportResult, Result: result})
+		}
+	}
+	e := ReportEvent{Type: ReportFinished, Duration: time.Since(r.start)}
+	if err != nil {
+		e.Error, e.Reason = err.Error(), FailureReason(err)
+	}
+	r.emit(e)
+}
+
+// reportLogger reports the warnings that
+// are logged to a Logger as well.
+type reportLogger struct {
+	Logger
+	r *reporter
+}
+
+func (l reportLogger) Printf(format string, v ...interface{}) {
+	l.Logger.Printf(format, v...)
+	if !strings.HasPrefix(format, "[WARNING]") {
+		return
+	}
+	msg := strings.TrimSpace(strings.TrimPrefix(fmt.Sprintf(format, v...), "[WARNING]"))
+	l.r.emit(ReportEvent{Type: ReportWarning, Message: msg})
+}
+
+// reportInstrumentation reports the spans of an
+// Instrumentation as phases that start and finish.
+type reportInstrumentation struct {
+	Instrumentation
+	r *reporter
+}
+
+func (i reportInstrumentation) StartSpan(ctx context.Context, name string, attrs ...Attribute) (context.Context, Span) {
+	ctx, span := i.Instrumentation.StartSpan(ctx, name, attrs...)
+	var attributes map[string]string
+	if len(attrs) > 0 {
+		attributes = make(map[string]string, len(attrs))
+		for _, a := range attrs {
+			attributes[a.Key] = a.Value
+		}
+	}
+	i.r.emit(ReportEvent{Type: ReportPhaseStarted, Phase: name, Attributes: attributes})
+	return ctx, reportSpan{Span: span, r: i.r, phase: name, attributes: attributes, start: time.Now()}
+}
+
+// reportSpan reports that its phase finished when it ends.
+type reportSpan struct {
+	Span
+	r          *reporter
+	phase      string
+	attributes map[string]string
+	start      time.Time
+}
+
+func (s reportSpan) End(err error) {
+	s.Span.End(err)
+	e := ReportEvent{Type: ReportPhaseFinished, Phase: s.phase, Attributes: s.attributes, Duration: time.Since(s.start)}
+	if err != nil {
+		e.Error = err.Error()
+	}
+	s.r.emit(e)
+}
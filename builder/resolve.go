@@ -0,0 +1,151 @@
+package builder
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ResolvedModule is the fully resolved module
+// assembled in a build environment.
+type ResolvedModule struct {
+	// The folder of the build environment, if the cleanup policy
+	// keeps it after a successful build. Otherwise it is empty, as
+	// the folder is removed before Resolve returns.
+	Dir string `json:"dir,omitempty"`
+
+	// The contents of the tidied go.mod and go.sum files.
+	GoMod []byte `json:"go_mod,omitempty"`
+	GoSum []byte `json:"go_sum,omitempty"`
+}
+
+// Resolve prepares, tidies, and checks the build environment like
+// Build does, running the PostTidy hooks and enforcing settings such
+// as Pins and VerifyChecksums, but stops before compiling and returns
+// the resolved module instead, so that the module graph can be
+// inspected or archived.
+func (b Builder) Resolve(ctx context.Context) (_ *ResolvedModule, err error) {
+	var cancel context.CancelFunc
+	if b.TimeoutBuild > 0 {
+		ctx, cancel = context.WithTimeout(ctx, b.TimeoutBuild)
+		defer cancel()
+	}
+
+	ctx, _, endBuild, err := b.beginBuild(ctx, time.Now(), "")
+	defer func() { endBuild(err) }()
+	if err != nil {
+		return nil, err
+	}
+
+	buildEnv, err := b.newEnvironment(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { err = buildEnv.finish(ctx, err) }()
+
+	if err := buildEnv.tidy(ctx); err != nil {
+		return nil, err
+	}
+	if err := b.checkEnvironment(ctx, buildEnv); err != nil {
+		return nil, err
+	}
+
+	mod, err := buildEnv.resolvedModule()
+	if err != nil {
+		return nil, err
+	}
+	if !buildEnv.cleanup.keepFolder(nil) {
+		mod.Dir = ""
+	}
+	return mod, nil
+}
+
+// Export prepares, tidies, and checks the build environment like Resolve and
+// writes the resulting module, that is main.go, go.mod, and go.sum,
+// as well as embed.go and the embedded files if Embeds is set, into
+// dir, which is created if necessary. The exported module can be
+// built with `go build` or any other tooling later. Existing files
+// of the same names in dir are overwritten. Local replacements refer
+// to absolute paths in the exported go.mod.
+func (b Builder) Export(ctx context.Context, dir string) (err error) {
+	var cancel context.CancelFunc
+	if b.TimeoutBuild > 0 {
+		ctx, cancel = context.WithTimeout(ctx, b.TimeoutBuild)
+		defer cancel()
+	}
+
+	ctx, _, endBuild, err := b.beginBuild(ctx, time.Now(), "")
+	defer func() { endBuild(err) }()
+	if err != nil {
+		return err
+	}
+
+	buildEnv, err := b.newEnvironment(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() { err = buildEnv.finish(ctx, err) }()
+
+	if err := buildEnv.tidy(ctx); err != nil {
+		return err
+	}
+	if err := b.checkEnvironment(ctx, buildEnv); err != nil {
+		return err
+	}
+	mod, err := buildEnv.resolvedModule()
+	if err != nil {
+		return err
+	}
+	mainContent, err := os.ReadFile(filepath.Join(buildEnv.tempFolder, "main.go"))
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	files := []struct {
+		name    string
+		content []byte
+	}{
+		{"main.go", mainContent},
+		{"go.mod", mod.GoMod},
+		{"go.sum", mod.GoSum},
+	}
+	for _, f := range files {
+		if f.content == nil {
+			// a module without dependencies has no go.sum
+			continue
+		}
+		if err := os.WriteFile(filepath.Join(dir, f.name), f.content, 0644); err != nil {
+			return err
+		}
+	}
+	if len(b.Embeds) > 0 {
+		for _, name := range []string{"embed.go", embedFolder} {
+			if err := copyTree(filepath.Join(buildEnv.tempFolder, name), filepath.Join(dir, name)); err != nil {
+				return err
+			}
+		}
+	}
+	b.logger().Printf("[INFO] Exported module to %s", dir)
+	return nil
+}
+
+// resolvedModule reads the current go.mod and go.sum of env.
+func (env environment) resolvedModule() (*ResolvedModule, error) {
+	goMod, err := os.ReadFile(filepath.Join(env.tempFolder, "go.mod"))
+	if err != nil {
+		return nil, err
+	}
+	goSum, err := os.ReadFile(filepath.Join(env.tempFolder, "go.sum"))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	return &ResolvedModule{
+		Dir:   env.tempFolder,
+		GoMod: goMod,
+		GoSum: goSum,
+	}, nil
+}
@@ -0,0 +1,196 @@
+package builder
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"time"
+)
+
+// BuildResult describes a binary produced by a build.
+type BuildResult struct {
+	// The absolute path of the binary.
+	OutputFile string `json:"output_file,omitempty"`
+
+	// The size of the binary in bytes.
+	Size int64 `json:"size,omitempty"`
+
+	// The hex-encoded SHA-256 checksum of the binary.
+	SHA256 string `json:"sha256,omitempty"`
+
+	// The platform the binary was built for.
+	Platform Platform `json:"platform,omitempty"`
+
+	// The concrete version of the base module that was requested
+	// as CaddyVersion, after resolving "latest" or a constraint.
+	CaddyVersion string `json:"caddy_version,omitempty"`
+
+	// The version queries of the build, such as branch names or
+	// commit hashes, and the concrete versions they resolved to.
+	Resolved []ResolvedVersion `json:"resolved,omitempty"`
+
+	// The version of the Go toolchain that built the binary.
+	GoVersion string `json:"go_version,omitempty"`
+
+	// The GOEXPERIMENT value used for the build, if any.
+	GoExperiment string `json:"go_experiment,omitempty"`
+
+	// The default GODEBUG settings of the binary that differ from
+	// those of its Go version, as set by GoDebug or implied by the
+	// go version of the main module, e.g. "http2client=0".
+	DefaultGoDebug string `json:"default_godebug,omitempty"`
+
+	// The hardening measures for the target
+	// of the build, if Hardened is set.
+	Hardening []HardeningMeasure `json:"hardening,omitempty"`
+
+	// The size of the binary by package and by
+	// module, if SizeBreakdown is set.
+	Sizes *SizeReport `json:"sizes,omitempty"`
+
+	// The FIPS 140 crypto of the binary, if FIPS is set: the version
+	// of the Go Cryptographic Module, e.g. v1.0.0-c2097c7c, or
+	// boringcrypto for builds with the BoringCrypto experiment.
+	FIPS string `json:"fips,omitempty"`
+
+	// The modules compiled into the binary and
+	// the replacements in effect for them.
+	Modules      []Dependency `json:"modules,omitempty"`
+	Replacements []Replace    `json:"replacements,omitempty"`
+
+	// Whether the binary is instrumented for coverage. If so,
+	// run it with GOCOVERDIR set to an existing directory to
+	// collect coverage data, and inspect that directory with
+	// `go tool covdata`.
+	Coverage bool `json:"coverage,omitempty"`
+
+	// The C header file written next to the output
+	// file, if the build mode is c-shared or c-archive
+	// and the main package exports functions.
+	HeaderFile string `json:"header_file,omitempty"`
+
+	// The copy of wasm_exec.js next to the
+	// output file, if WasmExec is set.
+	WasmExecFile string `json:"wasm_exec_file,omitempty"`
+
+	// The ways in which the module graph differs from the
+	// requested versions, if CheckConflicts is set.
+	Conflicts []ModuleConflict `json:"conflicts,omitempty"`
+
+	// The licenses of the modules in the
+	// build, if LicenseCheck is set.
+	Licenses *LicenseReport `json:"licenses,omitempty"`
+
+	// The findings of the static analysis of
+	// the plugins, if Analysis is set.
+	Findings []Finding `json:"findings,omitempty"`
+
+	// The module downloads of the build,
+	// if DownloadMetrics is set.
+	Downloads *DownloadReport `json:"downloads,omitempty"`
+
+	// The log of the build, if LogCapture is set: in LogFile if
+	// LogCapture.File is set, and otherwise in Log, as of the
+	// time the binary was built.
+	Log     string `json:"log,omitempty"`
+	LogFile string `json:"log_file,omitempty"`
+
+	// The Caddy module and the plugins as found in the
+	// binary, which were verified to be at the requested
+	// versions, or newer ones.
+	Verified []VerifiedModule `json:"verified,omitempty"`
+
+	// The path of the SBOM written for the binary, if any.
+	SBOMFile string `json:"sbom_file,omitempty"`
+
+	// The path of the provenance attestation written
+	// for the binary, if any.
+	ProvenanceFile string `json:"provenance_file,omitempty"`
+
+	// The copy of the build environment of a debug build, if
+	// Builder.DebugDir is set, and the dlv configuration that maps
+	// the source paths of the binary to it, which can be added to
+	// the dlv configuration file (config.yml) or applied in a session
+	// with `config substitute-path FROM TO`.
+	DebugDir        string `json:"debug_dir,omitempty"`
+	DebugConfigFile string `json:"debug_config_file,omitempty"`
+
+	// The files written by the Signers, such as detached signatures.
+	Signatures []string `json:"signatures,omitempty"`
+
+	// Whether the binary was copied from the
+	// artifact cache instead of being built.
+	Cached bool `json:"cached,omitempty"`
+
+	// The URL that the binary was downloaded from, if
+	// it is a prebuilt one rather than built locally.
+	Prebuilt string `json:"prebuilt,omitempty"`
+
+	// How long the build took, from start to finish.
+	Duration time.Duration `json:"duration,omitempty"`
+}
+
+// newBuildResult describes the binary at absOutputFile
+// that was built by b, starting at the given time.
+func (b Builder) newBuildResult(absOutputFile string, start time.Time) (*BuildResult, error) {
+	f, err := os.Open(absOutputFile)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	size, err := io.Copy(h, f)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &BuildResult{
+		OutputFile:   absOutputFile,
+		Size:         size,
+		SHA256:       hex.EncodeToString(h.Sum(nil)),
+		Platform:     b.Platform,
+		GoExperiment: b.GoExperiment,
+		Coverage:     b.Coverage,
+	}
+	info, err := ReadBuildInfo(absOutputFile)
+	if err != nil {
+		// not every build mode embeds build information
+		b.logger().Printf("[WARNING] Reading build information: %v", err)
+	} else {
+		result.setBuildInfo(info)
+	}
+	result.Duration = time.Since(start)
+
+	return result, nil
+}
+
+// setBuildInfo sets the Go version, platform, modules, and
+// replacements of r from the build information of its binary.
+func (r *BuildResult) setBuildInfo(info *BuildInfo) {
+	r.GoVersion = info.GoVersion
+	// report the platform that was actually targeted,
+	// including any defaults of the toolchain
+	if goos, ok := info.Settings["GOOS"]; ok {
+		r.Platform.OS = goos
+	}
+	if goarch, ok := info.Settings["GOARCH"]; ok {
+		r.Platform.Arch = goarch
+	}
+	for key, field := range map[string]*string{
+		"GOARM":    &r.Platform.ARM,
+		"GOAMD64":  &r.Platform.AMD64,
+		"GOARM64":  &r.Platform.ARM64,
+		"GO386":    &r.Platform.I386,
+		"GOMIPS":   &r.Platform.MIPS,
+		"GOMIPS64": &r.Platform.MIPS64,
+	} {
+		if value, ok := info.Settings[key]; ok {
+			*field = value
+		}
+	}
+	r.DefaultGoDebug = info.Settings["DefaultGODEBUG"]
+	r.FIPS = fipsMode(info.Settings)
+	r.Modules = info.Dependencies
+	r.Replacements = info.Replacements
+}
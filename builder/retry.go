@@ -0,0 +1,76 @@
+package builder
+
+import (
+	"context"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// defaultFetchRetryBackoff is the default of FetchRetryBackoff.
+const defaultFetchRetryBackoff = time.Second
+
+// maxFetchRetryBackoff caps the delay between attempts.
+const maxFetchRetryBackoff = 30 * time.Second
+
+// transientFetchErrors are fragments of go command output that
+// indicate network or proxy failures that may go away on their own,
+// as opposed to deterministic errors such as unknown revisions or
+// modules that do not exist.
+var transientFetchErrors = []string{
+	"dial tcp",
+	"i/o timeout",
+	"connection reset by peer",
+	"connection refused",
+	"broken pipe",
+	"TLS handshake timeout",
+	"unexpected EOF",
+	"temporary failure in name resolution",
+	"server misbehaving",
+	"429 Too Many Requests",
+	"500 Internal Server Error",
+	"502 Bad Gateway",
+	"503 Service Unavailable",
+	"504 Gateway Timeout",
+}
+
+// isTransientFetchError returns true if the output of a
+// failed go command suggests that retrying it may succeed.
+func isTransientFetchError(stderr string) bool {
+	for _, fragment := range transientFetchErrors {
+		if strings.Contains(stderr, fragment) {
+			return true
+		}
+	}
+	return false
+}
+
+// runFetchCommand runs the command made by newCmd, a go command
+// that downloads modules, like runCommandTail. If it fails with
+// what looks like a transient network error, a new command is made
+// and run again, up to env.fetchRetries more times, waiting with
+// exponential backoff in between.
+func (env environment) runFetchCommand(ctx context.Context, newCmd func() *exec.Cmd) (string, error) {
+	backoff := env.fetchRetryBackoff
+	for attempt := 0; ; attempt++ {
+		cmd := newCmd()
+		if env.downloads != nil {
+			traceFetches(cmd)
+		}
+		stderr, err := env.runCommandTail(ctx, cmd)
+		if err == nil || isCanceled(err) || attempt >= env.fetchRetries || !isTransientFetchError(stderr) {
+			return stderr, err
+		}
+		env.log.Printf("[WARNING] Transient failure fetching modules (attempt %d of %d), retrying in %s: %v",
+			attempt+1, env.fetchRetries+1, backoff, err)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return stderr, ctx.Err()
+		}
+		backoff *= 2
+		if backoff > maxFetchRetryBackoff {
+			backoff = maxFetchRetryBackoff
+		}
+	}
+}
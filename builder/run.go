@@ -0,0 +1,234 @@
+package builder
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// RunOptions configures how Run executes the binary it builds.
+type RunOptions struct {
+	// Args are the arguments to run the binary with, e.g. "run".
+	Args []string `json:"args,omitempty"`
+
+	// ConfigFile, if set, is passed to the binary after Args as
+	// the value of ConfigFlag and watched for changes, which
+	// reload the running binary.
+	ConfigFile string `json:"config_file,omitempty"`
+
+	// ConfigFlag is the flag that ConfigFile is passed
+	// with. Default: "--config"
+	ConfigFlag string `json:"config_flag,omitempty"`
+
+	// ReloadArgs, if set, are the arguments to run the binary
+	// with (as a separate process) to reload its configuration
+	// after ConfigFile changed, e.g. "reload". ConfigFile is
+	// passed with ConfigFlag after them as well.
+	ReloadArgs []string `json:"reload_args,omitempty"`
+
+	// ReloadSignal, if set and ReloadArgs is not, is sent to the
+	// running binary to reload its configuration after ConfigFile
+	// changed, e.g. syscall.SIGUSR1. If neither is set, the binary
+	// is restarted instead.
+	ReloadSignal os.Signal `json:"-"`
+
+	// StopTimeout is how long to wait for the binary to exit after
+	// it was interrupted before it is killed. Default: 10s
+	StopTimeout time.Duration `json:"stop_timeout,omitempty"`
+
+	// Stdout and Stderr receive the output of the binary.
+	// They default to os.Stdout and os.Stderr.
+	Stdout io.Writer `json:"-"`
+	Stderr io.Writer `json:"-"`
+}
+
+// Run builds b to outputFile and runs the binary according to
+// opts. If b has local replacements, it rebuilds whenever their
+// sources change, like Watch, and gracefully restarts the binary
+// after every successful build; failed builds leave the running
+// binary alone. Changes to opts.ConfigFile reload the binary.
+//
+// Run blocks until ctx is done, then stops the binary and returns
+// ctx.Err(). The binary exiting by itself does not end Run.
+func (b Builder) Run(ctx context.Context, outputFile string, opts RunOptions) error {
+	if opts.ConfigFlag == "" {
+		opts.ConfigFlag = "--config"
+	}
+	if opts.StopTimeout <= 0 {
+		opts.StopTimeout = 10 * time.Second
+	}
+	if opts.Stdout == nil {
+		opts.Stdout = os.Stdout
+	}
+	if opts.Stderr == nil {
+		opts.Stderr = os.Stderr
+	}
+	log := b.logger()
+
+	// every successful build is delivered with the path of the binary
+	builds := make(chan string)
+	deliver := func(result BuildResult, err error) {
+		if err != nil {
+			log.Printf("[ERROR] Build failed: %v", err)
+			return
+		}
+		select {
+		case builds <- result.OutputFile:
+		case <-ctx.Done():
+		}
+	}
+	dirs, err := b.localReplacementDirs()
+	if err != nil {
+		return err
+	}
+	buildErr := make(chan error, 1)
+	if len(dirs) > 0 {
+		go func() { buildErr <- b.Watch(ctx, outputFile, deliver) }()
+	} else {
+		result, err := b.Build(ctx, outputFile)
+		if err != nil {
+			return err
+		}
+		go deliver(*result, nil)
+	}
+
+	var configChanges <-chan fsnotify.Event
+	if opts.ConfigFile != "" {
+		abs, err := filepath.Abs(opts.ConfigFile)
+		if err != nil {
+			return err
+		}
+		opts.ConfigFile = abs
+		watcher, err := fsnotify.NewWatcher()
+		if err != nil {
+			return fmt.Errorf("creating file watcher: %v", err)
+		}
+		defer watcher.Close()
+		// watch the directory, since editors often replace
+		// files rather than write to them
+		if err := watcher.Add(filepath.Dir(abs)); err != nil {
+			return fmt.Errorf("watching %s: %v", abs, err)
+		}
+		configChanges = watcher.Events
+	}
+
+	var proc *runningProcess
+	defer func() {
+		if proc != nil {
+			proc.stop(opts.StopTimeout, log)
+		}
+	}()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case err := <-buildErr:
+			return err
+		case binary := <-builds:
+			if proc != nil {
+				log.Printf("[INFO] Restarting %s", binary)
+				proc.stop(opts.StopTimeout, log)
+			}
+			proc, err = startProcess(binary, opts.args(opts.Args), opts)
+			if err != nil {
+				log.Printf("[ERROR] Starting %s: %v", binary, err)
+				proc = nil
+			}
+		case event := <-configChanges:
+			if event.Name != opts.ConfigFile || event.Op == fsnotify.Chmod || proc == nil {
+				continue
+			}
+			if err := proc.reload(ctx, opts, log); err != nil {
+				log.Printf("[ERROR] Reloading %s: %v", opts.ConfigFile, err)
+			}
+		}
+	}
+}
+
+// args returns the arguments to run the binary with:
+// args, followed by the config file if there is one.
+func (opts RunOptions) args(args []string) []string {
+	args = append([]string(nil), args...)
+	if opts.ConfigFile != "" {
+		args = append(args, opts.ConfigFlag, opts.ConfigFile)
+	}
+	return args
+}
+
+// runningProcess is a binary started by Run.
+type runningProcess struct {
+	cmd  *exec.Cmd
+	done chan struct{}
+}
+
+func startProcess(binary string, args []string, opts RunOptions) (*runningProcess, error) {
+	cmd := exec.Command(binary, args...)
+	cmd.Stdout = opts.Stdout
+	cmd.Stderr = opts.Stderr
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	p := &runningProcess{cmd: cmd, done: make(chan struct{})}
+	go func() {
+		_ = cmd.Wait()
+		close(p.done)
+	}()
+	return p, nil
+}
+
+func (p *runningProcess) exited() bool {
+	select {
+	case <-p.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// stop interrupts the process and kills it
+// if it has not exited after timeout.
+func (p *runningProcess) stop(timeout time.Duration, log Logger) {
+	if p.exited() {
+		return
+	}
+	// interrupting is not supported on every platform
+	if err := p.cmd.Process.Signal(os.Interrupt); err != nil {
+		_ = p.cmd.Process.Kill()
+	}
+	select {
+	case <-p.done:
+	case <-time.After(timeout):
+		log.Printf("[WARNING] Killing %s, which did not exit within %s", p.cmd.Path, timeout)
+		_ = p.cmd.Process.Kill()
+		<-p.done
+	}
+}
+
+// reload makes the process load its configuration again, with
+// the reload command or signal of opts, or by restarting it.
+func (p *runningProcess) reload(ctx context.Context, opts RunOptions, log Logger) error {
+	log.Printf("[INFO] Reloading after changes to %s", opts.ConfigFile)
+	switch {
+	case len(opts.ReloadArgs) > 0:
+		cmd := exec.CommandContext(ctx, p.cmd.Path, opts.args(opts.ReloadArgs)...)
+		cmd.Stdout = opts.Stdout
+		cmd.Stderr = opts.Stderr
+		return cmd.Run()
+	case opts.ReloadSignal != nil && !p.exited():
+		return p.cmd.Process.Signal(opts.ReloadSignal)
+	default:
+		p.stop(opts.StopTimeout, log)
+		restarted, err := startProcess(p.cmd.Path, p.cmd.Args[1:], opts)
+		if err != nil {
+			return err
+		}
+		*p = *restarted
+		return nil
+	}
+}
@@ -0,0 +1,39 @@
+package builder
+
+import (
+	"os"
+	"runtime"
+	"strings"
+)
+
+// sandboxEnvKeys are the variables of the process environment that
+// builds with SandboxEnv inherit: those that the go command and the
+// C toolchain need to find programs and the home and temporary
+// directories, including those of Windows.
+var sandboxEnvKeys = []string{
+	"PATH", "HOME", "TMPDIR", "XDG_CACHE_HOME", "XDG_CONFIG_HOME",
+	"USERPROFILE", "LOCALAPPDATA", "APPDATA", "TEMP", "TMP",
+	"SystemRoot", "WINDIR", "ComSpec", "PATHEXT",
+}
+
+// processEnv returns the environment of the process that the
+// commands of builds start from: all of it, or, if sandbox is set,
+// only the variables of sandboxEnvKeys.
+func processEnv(sandbox bool) []string {
+	vars := os.Environ()
+	if !sandbox {
+		return vars
+	}
+	kept := vars[:0:0]
+	for _, kv := range vars {
+		key := strings.SplitN(kv, "=", 2)[0]
+		for _, allowed := range sandboxEnvKeys {
+			// variables of Windows are case-insensitive
+			if key == allowed || runtime.GOOS == "windows" && strings.EqualFold(key, allowed) {
+				kept = append(kept, kv)
+				break
+			}
+		}
+	}
+	return kept
+}
@@ -0,0 +1,150 @@
+package builder
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// sanitizer is an instrumentation of go build that
+// links a runtime library and thus requires cgo.
+type sanitizer struct {
+	// The field of Builder that enables it, and the go build flag.
+	field, flag string
+
+	// Whether it is enabled, and the targets that support it.
+	enabled bool
+	targets map[string]bool
+
+	// The C compiler it needs, if any other than the default.
+	cc string
+}
+
+// sanitizers returns the race detector and the memory and
+// address sanitizers with the settings of b.
+func (b Builder) sanitizers() []sanitizer {
+	return []sanitizer{
+		{
+			field: "race_detector", flag: "-race", enabled: b.RaceDetector,
+			targets: map[string]bool{
+				"linux/amd64": true, "linux/arm64": true, "linux/ppc64le": true, "linux/s390x": true,
+				"darwin/amd64": true, "darwin/arm64": true,
+				"freebsd/amd64": true, "netbsd/amd64": true, "windows/amd64": true,
+			},
+		},
+		{
+			field: "memory_sanitizer", flag: "-msan", enabled: b.MemorySanitizer, cc: "clang",
+			targets: map[string]bool{
+				"linux/amd64": true, "linux/arm64": true, "linux/loong64": true, "freebsd/amd64": true,
+			},
+		},
+		{
+			field: "address_sanitizer", flag: "-asan", enabled: b.AddressSanitizer,
+			targets: map[string]bool{
+				"linux/amd64": true, "linux/arm64": true, "linux/loong64": true,
+				"linux/ppc64le": true, "linux/riscv64": true,
+			},
+		},
+	}
+}
+
+// enabledSanitizers returns the sanitizers that b enables.
+func (b Builder) enabledSanitizers() []sanitizer {
+	var enabled []sanitizer
+	for _, s := range b.sanitizers() {
+		if s.enabled {
+			enabled = append(enabled, s)
+		}
+	}
+	return enabled
+}
+
+// sanitizerFlags returns the go build flags
+// of the sanitizers that b enables.
+func (b Builder) sanitizerFlags() []string {
+	var flags []string
+	for _, s := range b.enabledSanitizers() {
+		flags = append(flags, s.flag)
+	}
+	return flags
+}
+
+// checkSanitizers returns the problems with the sanitizers of b
+// that are known before building: those that the target does not
+// support, or that cannot be combined with each other or static
+// linking.
+func (b Builder) checkSanitizers() []ValidationProblem {
+	v := &validator{}
+	enabled := b.enabledSanitizers()
+	target := b.targetOS() + "/" + b.targetArch()
+	for i, s := range enabled {
+		if !s.targets[target] {
+			v.add(s.field, fmt.Errorf("%s does not support %s", target, s.flag))
+		}
+		if i > 0 {
+			v.add(s.field, fmt.Errorf("cannot be combined with %s", enabled[0].field))
+		}
+		if b.Static {
+			v.add("static", fmt.Errorf("cannot be combined with %s, which requires dynamic linking", s.field))
+		}
+	}
+	return v.problems
+}
+
+// sanitizerSettings prepares b and env, the environment of the go
+// command in buildEnv, for the sanitizers of b. They link C runtime
+// libraries, so cgo is enabled, as enableRequiredCgo does before;
+// when cross-compiling, that needs a
+// C compiler for the target, from env or the toolchain configured
+// for the target. The memory sanitizer only works with clang, which
+// it uses unless a compiler is configured.
+func (b *Builder) sanitizerSettings(buildEnv *environment, env []string) ([]string, error) {
+	enabled := b.enabledSanitizers()
+	if len(enabled) == 0 {
+		return env, nil
+	}
+	if problems := b.checkSanitizers(); len(problems) > 0 {
+		return nil, &ValidationError{Problems: problems}
+	}
+	var names []string
+	for _, s := range enabled {
+		names = append(names, s.field)
+	}
+	b.enableRequiredCgo("")
+
+	cc, hasCC := getEnv(env, "CC")
+	if tc, ok := b.toolchain(); ok && tc.CC != "" {
+		cc, hasCC = tc.CC, true
+	}
+	target := b.targetOS() + "/" + b.targetArch()
+	if b.isCrossCompiling() {
+		if !hasCC {
+			return nil, fmt.Errorf("%s for %s requires a C compiler for the target, as it is cross-compiled with cgo; set CC or toolchains[%q].cc",
+				strings.Join(names, " and "), target, target)
+		}
+		if fields := strings.Fields(cc); buildEnv.executor == nil && len(fields) > 0 {
+			if _, err := exec.LookPath(fields[0]); err != nil {
+				return nil, fmt.Errorf("%s for %s requires the C compiler %s, which was not found: %v",
+					strings.Join(names, " and "), target, fields[0], err)
+			}
+		}
+	}
+	for _, s := range enabled {
+		switch {
+		case s.cc == "":
+		case hasCC:
+			if !strings.Contains(cc, s.cc) {
+				b.logger().Printf("[WARNING] %s requires %s, but the C compiler is %s", s.flag, s.cc, cc)
+			}
+		default:
+			if buildEnv.executor == nil {
+				if _, err := exec.LookPath(s.cc); err != nil {
+					return nil, fmt.Errorf("%s requires %s as the C compiler, which was not found: %v", s.flag, s.cc, err)
+				}
+			}
+			b.logger().Printf("[INFO] Using %s as the C compiler for %s", s.cc, s.flag)
+			env = setEnv(env, "CC="+s.cc)
+		}
+	}
+	return env, nil
+}
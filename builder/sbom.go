@@ -0,0 +1,198 @@
+package builder
+
+import (
+	"crypto/sha256"
+	"debug/buildinfo"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"time"
+)
+
+// Supported values for Builder.SBOMFormat.
+const (
+	SBOMFormatSPDX      = "spdx"
+	SBOMFormatCycloneDX = "cyclonedx"
+)
+
+// sbomExtensions are the file extensions of
+// SBOM documents written next to the binary.
+var sbomExtensions = map[string]string{
+	SBOMFormatSPDX:      ".spdx.json",
+	SBOMFormatCycloneDX: ".cdx.json",
+}
+
+// checkSBOMFormat returns an error if format is
+// neither empty nor a supported SBOM format.
+func checkSBOMFormat(format string) error {
+	if _, ok := sbomExtensions[format]; format != "" && !ok {
+		return fmt.Errorf("unsupported SBOM format %q; use %q or %q", format, SBOMFormatSPDX, SBOMFormatCycloneDX)
+	}
+	return nil
+}
+
+// writeSBOM writes an SBOM of the binary described by result in
+// b.SBOMFormat, either to b.SBOMWriter or, if that is nil, to a
+// file next to the binary, whose path is returned.
+func (b Builder) writeSBOM(result *BuildResult) (string, error) {
+	bi, err := readBuildInfo(result.OutputFile)
+	if err != nil {
+		return "", fmt.Errorf("reading build information for SBOM: %v", err)
+	}
+	created := time.Now().UTC()
+	if b.Reproducible {
+		created = time.Unix(0, 0).UTC()
+	}
+
+	var doc interface{}
+	switch b.SBOMFormat {
+	case SBOMFormatSPDX:
+		doc = spdxDocument(bi, result, created)
+	case SBOMFormatCycloneDX:
+		doc = cycloneDXDocument(bi, result, created)
+	default:
+		return "", checkSBOMFormat(b.SBOMFormat)
+	}
+
+	w := b.SBOMWriter
+	var sbomFile string
+	if w == nil {
+		sbomFile = result.OutputFile + sbomExtensions[b.SBOMFormat]
+		f, err := os.Create(sbomFile)
+		if err != nil {
+			return "", err
+		}
+		defer f.Close()
+		w = f
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return "", err
+	}
+	return sbomFile, nil
+}
+
+// purl returns the package URL of a Go module.
+func purl(path, version string) string {
+	if version == "" || version == "(devel)" {
+		return "pkg:golang/" + path
+	}
+	return "pkg:golang/" + path + "@" + version
+}
+
+func spdxDocument(bi *buildinfo.BuildInfo, result *BuildResult, created time.Time) map[string]interface{} {
+	name := filepath.Base(result.OutputFile)
+	packages := []map[string]interface{}{{
+		"name":             name,
+		"SPDXID":           "SPDXRef-Binary",
+		"downloadLocation": "NOASSERTION",
+		"filesAnalyzed":    false,
+		"checksums": []map[string]string{{
+			"algorithm":     "SHA256",
+			"checksumValue": result.SHA256,
+		}},
+	}}
+	relationships := []map[string]string{{
+		"spdxElementId":      "SPDXRef-DOCUMENT",
+		"relationshipType":   "DESCRIBES",
+		"relatedSpdxElement": "SPDXRef-Binary",
+	}}
+	modules := append([]*debug.Module{&bi.Main}, bi.Deps...)
+	for i, mod := range modules {
+		if mod.Path == "" {
+			continue
+		}
+		id := fmt.Sprintf("SPDXRef-Module-%d", i)
+		version := mod.Version
+		if mod.Replace != nil {
+			version = mod.Replace.Version
+		}
+		pkg := map[string]interface{}{
+			"name":             mod.Path,
+			"SPDXID":           id,
+			"versionInfo":      version,
+			"downloadLocation": "NOASSERTION",
+			"filesAnalyzed":    false,
+			"externalRefs": []map[string]string{{
+				"referenceCategory": "PACKAGE-MANAGER",
+				"referenceType":     "purl",
+				"referenceLocator":  purl(mod.Path, version),
+			}},
+		}
+		packages = append(packages, pkg)
+		relationships = append(relationships, map[string]string{
+			"spdxElementId":      "SPDXRef-Binary",
+			"relationshipType":   "CONTAINS",
+			"relatedSpdxElement": id,
+		})
+	}
+	return map[string]interface{}{
+		"spdxVersion":       "SPDX-2.3",
+		"dataLicense":       "CC0-1.0",
+		"SPDXID":            "SPDXRef-DOCUMENT",
+		"name":              name,
+		"documentNamespace": "https://spdx.org/spdxdocs/" + name + "-" + result.SHA256,
+		"creationInfo": map[string]interface{}{
+			"created":  created.Format(time.RFC3339),
+			"creators": []string{"Tool: goaway-builder"},
+		},
+		"packages":      packages,
+		"relationships": relationships,
+	}
+}
+
+func cycloneDXDocument(bi *buildinfo.BuildInfo, result *BuildResult, created time.Time) map[string]interface{} {
+	var components []map[string]interface{}
+	for _, mod := range bi.Deps {
+		version := mod.Version
+		if mod.Replace != nil {
+			version = mod.Replace.Version
+		}
+		c := map[string]interface{}{
+			"type":    "library",
+			"name":    mod.Path,
+			"version": version,
+			"purl":    purl(mod.Path, version),
+		}
+		components = append(components, c)
+	}
+	return map[string]interface{}{
+		"bomFormat":    "CycloneDX",
+		"specVersion":  "1.5",
+		"serialNumber": "urn:uuid:" + uuidFromChecksum(result.SHA256),
+		"version":      1,
+		"metadata": map[string]interface{}{
+			"timestamp": created.Format(time.RFC3339),
+			"tools": []map[string]string{{
+				"name": "goaway-builder",
+			}},
+			"component": map[string]interface{}{
+				"type":    "application",
+				"name":    bi.Path,
+				"version": bi.Main.Version,
+				"purl":    purl(bi.Main.Path, bi.Main.Version),
+				"hashes": []map[string]string{{
+					"alg":     "SHA-256",
+					"content": result.SHA256,
+				}},
+			},
+		},
+		"components": components,
+	}
+}
+
+// uuidFromChecksum derives a stable, RFC 4122 version 5 style UUID
+// from the checksum of the binary, so that the same binary always
+// gets the same SBOM serial number.
+func uuidFromChecksum(checksum string) string {
+	sum := sha256.Sum256([]byte(checksum))
+	u := sum[:16]
+	u[6] = (u[6] & 0x0f) | 0x50
+	u[8] = (u[8] & 0x3f) | 0x80
+	h := hex.EncodeToString(u)
+	return h[0:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:32]
+}
@@ -0,0 +1,140 @@
+package builder
+
+import (
+	"encoding"
+	"encoding/json"
+	"reflect"
+	"time"
+)
+
+// schemaDialect is the JSON Schema version of ConfigSchema.
+const schemaDialect = "https://json-schema.org/draft/2020-12/schema"
+
+// schemaEnums are the allowed values of string types
+// that are restricted to a set of constants.
+var schemaEnums = map[reflect.Type][]string{
+	reflect.TypeOf(CleanupPolicy("")): {
+		string(CleanupAlways), string(CleanupOnSuccess), string(CleanupNever), string(CleanupMaxAge),
+	},
+}
+
+// schemaFields are the schemas of fields whose values are
+// restricted further than their types, keyed by JSON name.
+var schemaFields = map[string]map[string]interface{}{
+	"sbom_format": {"type": "string", "enum": []string{SBOMFormatSPDX, SBOMFormatCycloneDX}},
+}
+
+// ConfigSchema returns a JSON Schema document of the JSON encoding
+// of Builder, as read by LoadConfig and by build services, so that
+// editors and web frontends can validate and complete build
+// configurations. It is generated from the Builder type, with the
+// struct types it refers to, such as Dependency and Replace, under
+// "$defs". Fields that are not encoded, such as Logger or Signers,
+// are left out, durations are integers of nanoseconds, which build
+// services require but LoadConfig also accepts as strings such as
+// "10m", and unknown properties are not allowed.
+func ConfigSchema() []byte {
+	g := schemaGenerator{defs: make(map[string]interface{})}
+	schema := g.schema(reflect.TypeOf(Builder{}), "")
+	schema["$schema"] = schemaDialect
+	schema["title"] = "Builder"
+	schema["$defs"] = g.defs
+	data, err := json.MarshalIndent(schema, "", "\t")
+	if err != nil {
+		// the schema consists only of maps, slices, and strings
+		panic(err)
+	}
+	return data
+}
+
+// schemaGenerator generates the schemas of Go types.
+type schemaGenerator struct {
+	// the schemas of the named struct
+	// types, other than Builder itself
+	defs map[string]interface{}
+}
+
+var (
+	durationType      = reflect.TypeOf(time.Duration(0))
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+// schema returns the schema of the values of t, as encoded by
+// encoding/json. field is the JSON name of the field of type t,
+// if any.
+func (g schemaGenerator) schema(t reflect.Type, field string) map[string]interface{} {
+	if s, ok := schemaFields[field]; ok {
+		return s
+	}
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if enum, ok := schemaEnums[t]; ok {
+		return map[string]interface{}{"type": "string", "enum": enum}
+	}
+	switch {
+	case t == durationType:
+		return map[string]interface{}{"type": "integer", "description": "A duration in nanoseconds."}
+	case t.Implements(textMarshalerType) || reflect.PtrTo(t).Implements(textMarshalerType):
+		return map[string]interface{}{"type": "string"}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return map[string]interface{}{"type": "integer", "minimum": 0}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]interface{}{"type": "string", "contentEncoding": "base64"}
+		}
+		return map[string]interface{}{"type": "array", "items": g.schema(t.Elem(), "")}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": g.schema(t.Elem(), "")}
+	case reflect.Struct:
+		if t == reflect.TypeOf(Builder{}) || t.Name() == "" {
+			return g.object(t)
+		}
+		name := t.Name()
+		if _, ok := g.defs[name]; !ok {
+			// reserve the name first, for recursive types
+			g.defs[name] = nil
+			g.defs[name] = g.object(t)
+		}
+		return map[string]interface{}{"$ref": "#/$defs/" + name}
+	}
+	// interfaces may hold any value
+	return map[string]interface{}{}
+}
+
+// object returns the schema of the struct type t.
+func (g schemaGenerator) object(t reflect.Type) map[string]interface{} {
+	properties := make(map[string]interface{})
+	g.properties(t, properties)
+	return map[string]interface{}{
+		"type":                 "object",
+		"properties":           properties,
+		"additionalProperties": false,
+	}
+}
+
+// properties adds the schemas of the encoded fields of the struct
+// type t to properties, including those of embedded structs.
+func (g schemaGenerator) properties(t reflect.Type, properties map[string]interface{}) {
+	fields := make(map[string]reflect.Type)
+	jsonFields(t, fields)
+	for name, ft := range fields {
+		switch ft.Kind() {
+		case reflect.Func, reflect.Chan, reflect.Complex64, reflect.Complex128:
+			// cannot be encoded
+			continue
+		}
+		properties[name] = g.schema(ft, name)
+	}
+}
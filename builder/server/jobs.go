@@ -0,0 +1,565 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/crackeer/goaway/builder"
+)
+
+// The states of a Job.
+const (
+	StatusQueued    = "queued"
+	StatusRunning   = "running"
+	StatusSucceeded = "succeeded"
+	StatusFailed    = "failed"
+)
+
+// ErrNotFound is returned for jobs that do not exist.
+var ErrNotFound = errors.New("job not found")
+
+// Job is the state of a build submitted to Jobs.
+type Job struct {
+	ID     string `json:"id"`
+	Status string `json:"status"`
+
+	// The fraction of the compilation that is done,
+	// from 0 to 1, while the job is running.
+	Progress float64 `json:"progress,omitempty"`
+
+	// The error of a failed build.
+	Error string `json:"error,omitempty"`
+
+	// The result of a successful build. Its OutputFile
+	// is a path on the server and is left out.
+	Result *builder.BuildResult `json:"result,omitempty"`
+
+	// The file name of the binary of a successful build in the
+	// directory of the job, which may also hold files next to it,
+	// such as the SBOM.
+	Binary string `json:"binary,omitempty"`
+
+	// The URL that the job is posted to when it has finished.
+	Webhook string `json:"webhook,omitempty"`
+
+	Created  time.Time  `json:"created"`
+	Started  *time.Time `json:"started,omitempty"`
+	Finished *time.Time `json:"finished,omitempty"`
+}
+
+// Store keeps the state of jobs. Implementations
+// must be safe for concurrent use.
+type Store interface {
+	// Put adds or replaces the job with the ID of job.
+	Put(job Job) error
+
+	// Get returns the job with the given ID, or ErrNotFound.
+	Get(id string) (Job, error)
+
+	// List returns all jobs.
+	List() ([]Job, error)
+
+	// Delete removes the job with the given ID.
+	Delete(id string) error
+}
+
+// Jobs runs builds in the background and keeps track of their state,
+// their logs, and their binaries, and notifies about finished builds.
+// It must not be copied after first use.
+type Jobs struct {
+	// Pool runs the builds. Default: a pool that
+	// runs one build at a time
+	Pool *builder.Pool
+
+	// Store keeps the state of the jobs. With a persistent store such
+	// as a FileStore, jobs survive restarts of the process; jobs that
+	// were unfinished at that time are marked as failed. Default: a
+	// store in memory
+	Store Store
+
+	// Dir is the directory in which the logs and binaries of the
+	// jobs are kept. Default: a directory in the directory for
+	// temporary files
+	Dir string
+
+	// Retention is how long finished jobs, including their
+	// logs and binaries, are kept. Default: 24h
+	Retention time.Duration
+
+	// OnFinish, if set, is called with every job
+	// that has finished, whether it failed or not.
+	OnFinish func(Job)
+
+	// Client sends the webhooks. Default: a client with
+	// a timeout of 30 seconds
+	Client *http.Client
+
+	init    sync.Once
+	initErr error
+	ctx     context.Context
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+	mu      sync.Mutex
+	live    map[string]*liveJob
+}
+
+// liveJob is a job that is queued or running in this process.
+type liveJob struct {
+	mu      sync.Mutex
+	job     Job
+	log     *os.File
+	changed chan struct{} // closed and replaced on every change
+}
+
+func (j *Jobs) setup() {
+	if j.Pool == nil {
+		j.Pool = &builder.Pool{}
+	}
+	if j.Store == nil {
+		j.Store = &memoryStore{jobs: make(map[string]Job)}
+	}
+	if j.Dir == "" {
+		j.Dir = filepath.Join(os.TempDir(), "goaway-jobs")
+	}
+	if j.Retention <= 0 {
+		j.Retention = 24 * time.Hour
+	}
+	if j.Client == nil {
+		j.Client = &http.Client{Timeout: 30 * time.Second}
+	}
+	j.ctx, j.cancel = context.WithCancel(context.Background())
+	j.live = make(map[string]*liveJob)
+	if j.initErr = os.MkdirAll(j.Dir, 0755); j.initErr != nil {
+		return
+	}
+
+	// no job can be running before this process started
+	jobs, err := j.Store.List()
+	if err != nil {
+		j.initErr = err
+		return
+	}
+	for _, job := range jobs {
+		if job.Finished != nil {
+			continue
+		}
+		now := time.Now()
+		job.Status, job.Error, job.Finished = StatusFailed, "interrupted by a restart", &now
+		if j.initErr = j.Store.Put(job); j.initErr != nil {
+			return
+		}
+	}
+}
+
+// Submit queues a build with b and returns the ID of its job. If
+// webhook is not empty, the job is posted to it as JSON when it has
+// finished. The Logger, Stdout, Stderr, and OnProgress of b are
+// replaced to record the log and progress of the job.
+func (j *Jobs) Submit(b builder.Builder, webhook string) (string, error) {
+	j.init.Do(j.setup)
+	if j.initErr != nil {
+		return "", j.initErr
+	}
+	j.expire()
+
+	id, err := newJobID()
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(filepath.Join(j.Dir, id), 0755); err != nil {
+		return "", err
+	}
+	log, err := os.Create(j.logPath(id))
+	if err != nil {
+		return "", err
+	}
+	lj := &liveJob{
+		job:     Job{ID: id, Status: StatusQueued, Webhook: webhook, Created: time.Now()},
+		log:     log,
+		changed: make(chan struct{}),
+	}
+	if err := j.Store.Put(lj.job); err != nil {
+		log.Close()
+		return "", err
+	}
+	j.mu.Lock()
+	j.live[id] = lj
+	j.mu.Unlock()
+
+	b.Logger = lj
+	b.Stdout = lj
+	b.Stderr = lj
+	b.OnProgress = lj.setProgress
+	j.wg.Add(1)
+	go func() {
+		defer j.wg.Done()
+		result, err := j.Pool.Build(j.ctx, b, filepath.Join(j.Dir, id, "goaway"))
+		j.finish(lj, result, err)
+	}()
+	return id, nil
+}
+
+// Get returns the job with the given ID.
+func (j *Jobs) Get(id string) (Job, error) {
+	j.init.Do(j.setup)
+	if j.initErr != nil {
+		return Job{}, j.initErr
+	}
+	j.mu.Lock()
+	lj := j.live[id]
+	j.mu.Unlock()
+	if lj != nil {
+		return lj.snapshot(), nil
+	}
+	return j.Store.Get(id)
+}
+
+// StreamLog writes the log of the job with the given ID to w as
+// it is written, calling flush after every write if it is not nil,
+// until the job has finished or ctx is done.
+func (j *Jobs) StreamLog(ctx context.Context, id string, w io.Writer, flush func()) error {
+	if _, err := j.Get(id); err != nil {
+		return err
+	}
+	f, err := os.Open(j.logPath(id))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	for {
+		// check for changes before reading, so that
+		// none are missed between reading and waiting
+		var changed chan struct{}
+		j.mu.Lock()
+		lj := j.live[id]
+		j.mu.Unlock()
+		if lj != nil {
+			lj.mu.Lock()
+			changed = lj.changed
+			lj.mu.Unlock()
+		}
+		n, err := io.Copy(w, f)
+		if err != nil {
+			return err
+		}
+		if n > 0 && flush != nil {
+			flush()
+		}
+		if changed == nil {
+			// the job has finished and its log is complete
+			return nil
+		}
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Artifact returns the path of the binary of the job with the given
+// ID, or an error if the job has not finished successfully.
+func (j *Jobs) Artifact(id string) (string, error) {
+	job, err := j.Get(id)
+	if err != nil {
+		return "", err
+	}
+	switch job.Status {
+	case StatusSucceeded:
+	case StatusFailed:
+		return "", fmt.Errorf("build failed: %s", job.Error)
+	default:
+		return "", fmt.Errorf("build is %s", job.Status)
+	}
+	// the name comes from the store, so it must not
+	// lead out of the directory of the job
+	if job.Binary == "" || job.Binary != filepath.Base(job.Binary) || strings.HasPrefix(job.Binary, ".") {
+		return "", fmt.Errorf("binary of job %s not found", id)
+	}
+	path := filepath.Join(j.Dir, id, job.Binary)
+	if _, err := os.Stat(path); err != nil {
+		return "", fmt.Errorf("binary of job %s not found", id)
+	}
+	return path, nil
+}
+
+// Close cancels all jobs and waits for them to finish.
+func (j *Jobs) Close() {
+	j.init.Do(j.setup)
+	j.cancel()
+	j.wg.Wait()
+}
+
+func (j *Jobs) logPath(id string) string {
+	return filepath.Join(j.Dir, id, "build.log")
+}
+
+// finish records the outcome of the build of lj
+// and sends the notifications about it.
+func (j *Jobs) finish(lj *liveJob, result *builder.BuildResult, err error) {
+	lj.mu.Lock()
+	finished := time.Now()
+	lj.job.Finished = &finished
+	if err == nil && result == nil {
+		// SkipBuild only prepares the build environment
+		err = errors.New("no binary was built")
+	}
+	if err != nil {
+		lj.job.Status = StatusFailed
+		lj.job.Error = err.Error()
+	} else {
+		lj.job.Status = StatusSucceeded
+		lj.job.Progress = 1
+		published := *result
+		published.OutputFile = ""
+		lj.job.Result = &published
+		lj.job.Binary = filepath.Base(result.OutputFile)
+	}
+	job := lj.job
+	lj.mu.Unlock()
+
+	if err := j.Store.Put(job); err != nil {
+		lj.Printf("[ERROR] Saving job: %v", err)
+	}
+	if job.Webhook != "" {
+		if err := j.sendWebhook(job); err != nil {
+			lj.Printf("[ERROR] Sending webhook: %v", err)
+		}
+	}
+	j.mu.Lock()
+	delete(j.live, job.ID)
+	j.mu.Unlock()
+	lj.mu.Lock()
+	_ = lj.log.Close()
+	lj.notify()
+	lj.mu.Unlock()
+
+	if j.OnFinish != nil {
+		j.OnFinish(job)
+	}
+}
+
+func (j *Jobs) sendWebhook(job Job) error {
+	body, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	resp, err := j.Client.Post(job.Webhook, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("%s responded with %s", job.Webhook, resp.Status)
+	}
+	return nil
+}
+
+// expire removes the jobs that finished longer than j.Retention ago.
+func (j *Jobs) expire() {
+	jobs, err := j.Store.List()
+	if err != nil {
+		return
+	}
+	cutoff := time.Now().Add(-j.Retention)
+	for _, job := range jobs {
+		if job.Finished == nil || job.Finished.After(cutoff) {
+			continue
+		}
+		if err := j.Store.Delete(job.ID); err == nil {
+			_ = os.RemoveAll(filepath.Join(j.Dir, job.ID))
+		}
+	}
+}
+
+// Write appends p to the log of j.
+func (j *liveJob) Write(p []byte) (int, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	n, err := j.log.Write(p)
+	j.notify()
+	return n, err
+}
+
+// Printf adds a message to the log of j. The first message
+// of a build means that it has left the queue of the pool.
+func (j *liveJob) Printf(format string, args ...interface{}) {
+	msg := time.Now().Format("2006/01/02 15:04:05 ") + fmt.Sprintf(format, args...)
+	if !strings.HasSuffix(msg, "\n") {
+		msg += "\n"
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.job.Status == StatusQueued {
+		now := time.Now()
+		j.job.Status, j.job.Started = StatusRunning, &now
+	}
+	_, _ = io.WriteString(j.log, msg)
+	j.notify()
+}
+
+func (j *liveJob) setProgress(fraction float64) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.job.Progress = fraction
+}
+
+// notify wakes up everyone waiting for changes to j.
+// j.mu must be held.
+func (j *liveJob) notify() {
+	close(j.changed)
+	j.changed = make(chan struct{})
+}
+
+func (j *liveJob) snapshot() Job {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.job
+}
+
+func newJobID() (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(id), nil
+}
+
+// memoryStore is the default Store, which keeps the jobs in memory.
+type memoryStore struct {
+	mu   sync.Mutex
+	jobs map[string]Job
+}
+
+func (s *memoryStore) Put(job Job) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.jobs[job.ID] = job
+	return nil
+}
+
+func (s *memoryStore) Get(id string) (Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job, ok := s.jobs[id]
+	if !ok {
+		return Job{}, ErrNotFound
+	}
+	return job, nil
+}
+
+func (s *memoryStore) List() ([]Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	jobs := make([]Job, 0, len(s.jobs))
+	for _, job := range s.jobs {
+		jobs = append(jobs, job)
+	}
+	return jobs, nil
+}
+
+func (s *memoryStore) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.jobs, id)
+	return nil
+}
+
+// FileStore is a Store that keeps every job
+// in a JSON file of its own in Dir.
+type FileStore struct {
+	Dir string
+}
+
+func (s FileStore) path(id string) string {
+	return filepath.Join(s.Dir, id+".json")
+}
+
+// Put implements Store.
+func (s FileStore) Put(job Job) error {
+	if err := os.MkdirAll(s.Dir, 0755); err != nil {
+		return err
+	}
+	data, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	// write atomically, so that a crash never leaves a partial file
+	tmp, err := os.CreateTemp(s.Dir, ".tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path(job.ID))
+}
+
+// Get implements Store.
+func (s FileStore) Get(id string) (Job, error) {
+	if strings.ContainsAny(id, `/\.`) {
+		return Job{}, ErrNotFound
+	}
+	data, err := os.ReadFile(s.path(id))
+	if os.IsNotExist(err) {
+		return Job{}, ErrNotFound
+	}
+	if err != nil {
+		return Job{}, err
+	}
+	var job Job
+	if err := json.Unmarshal(data, &job); err != nil {
+		return Job{}, fmt.Errorf("reading job %s: %v", id, err)
+	}
+	return job, nil
+}
+
+// List implements Store.
+func (s FileStore) List() ([]Job, error) {
+	entries, err := os.ReadDir(s.Dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var jobs []Job
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || strings.HasPrefix(name, ".") || !strings.HasSuffix(name, ".json") {
+			continue
+		}
+		job, err := s.Get(strings.TrimSuffix(name, ".json"))
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, nil
+}
+
+// Delete implements Store.
+func (s FileStore) Delete(id string) error {
+	err := os.Remove(s.path(id))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,150 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/crackeer/goaway/builder"
+)
+
+// testBase returns the Base of a Server that builds a minimal
+// checkout of the base module without network access.
+func testBase(t *testing.T) builder.Builder {
+	t.Helper()
+	if testing.Short() {
+		t.Skip("builds a binary")
+	}
+	for _, command := range []string{builder.GetGo(), "sh"} {
+		if _, err := exec.LookPath(command); err != nil {
+			t.Skipf("%s not found: %v", command, err)
+		}
+	}
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"go.mod":           "module github.com/crackeer/goaway\n\ngo 1.19\n",
+		"goaway.go":        "package goaway\n\nfunc RegisterModule(interface{}) {}\n",
+		"server/server.go": "package server\n\nfunc Main() {}\n",
+	} {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return builder.Builder{
+		Replacements: []builder.Replace{builder.NewReplace("github.com/crackeer/goaway", dir)},
+		GoProxy:      "off",
+		Env:          map[string]string{"GOFLAGS": "-mod=mod", "GOSUMDB": "off"},
+	}
+}
+
+func TestArtifactWithSidecarFile(t *testing.T) {
+	base := testBase(t)
+	// a signature next to the binary, which
+	// sorts before it in the job directory
+	base.Hooks.PostBuild = []string{`sh -c 'echo signature > "${GOAWAY_BUILDER_OUTPUT%.exe}.asc"'`}
+	finished := make(chan Job, 1)
+	jobs := &Jobs{
+		Pool:     &builder.Pool{WorkDir: t.TempDir()},
+		Dir:      t.TempDir(),
+		OnFinish: func(job Job) { finished <- job },
+	}
+	s := &Server{Base: base, Jobs: jobs}
+	defer s.Close()
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+
+	resp, err := http.Post(srv.URL+"/builds", "application/json", strings.NewReader(`{"os": "windows", "arch": "amd64"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var started Job
+	err = json.NewDecoder(resp.Body).Decode(&started)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("starting build: %s", resp.Status)
+	}
+
+	var job Job
+	select {
+	case job = <-finished:
+	case <-time.After(5 * time.Minute):
+		t.Fatal("build did not finish")
+	}
+	if job.Status != StatusSucceeded {
+		data, _ := os.ReadFile(jobs.logPath(job.ID))
+		t.Fatalf("build %s: %s\n%s", job.Status, job.Error, data)
+	}
+	if job.Binary != "goaway.exe" {
+		t.Errorf("job has the binary %q, want goaway.exe", job.Binary)
+	}
+	if _, err := os.Stat(filepath.Join(jobs.Dir, job.ID, "goaway.asc")); err != nil {
+		t.Fatalf("hook did not write the sidecar file: %v", err)
+	}
+
+	resp, err = http.Get(srv.URL + "/builds/" + job.ID + "/artifact")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("fetching artifact: %s: %s", resp.Status, data)
+	}
+	if sum := sha256.Sum256(data); hex.EncodeToString(sum[:]) != job.Result.SHA256 {
+		t.Errorf("artifact of %d bytes is not the binary", len(data))
+	}
+	if got, want := resp.Header.Get("Content-Disposition"), `attachment; filename="goaway_windows_amd64.exe"`; got != want {
+		t.Errorf("Content-Disposition is %s, want %s", got, want)
+	}
+}
+
+func TestJobWithoutBinary(t *testing.T) {
+	base := testBase(t)
+	base.SkipBuild = true
+	finished := make(chan Job, 1)
+	jobs := &Jobs{
+		Pool:     &builder.Pool{WorkDir: t.TempDir()},
+		Dir:      t.TempDir(),
+		OnFinish: func(job Job) { finished <- job },
+	}
+	defer jobs.Close()
+	id, err := jobs.Submit(base, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var job Job
+	select {
+	case job = <-finished:
+	case <-time.After(5 * time.Minute):
+		t.Fatal("build did not finish")
+	}
+	if job.Status != StatusFailed || job.Error != "no binary was built" {
+		t.Errorf("job without a binary is %s with the error %q, want it to fail because no binary was built", job.Status, job.Error)
+	}
+	if job.Result != nil || job.Binary != "" {
+		t.Errorf("job without a binary has the result %+v and binary %q", job.Result, job.Binary)
+	}
+	if _, err := jobs.Artifact(id); err == nil {
+		t.Error("job without a binary has an artifact")
+	}
+}
@@ -0,0 +1,228 @@
+// Package server provides an HTTP API for building custom binaries
+// with the builder package, e.g. for a self-hosted download service.
+//
+// The API consists of these endpoints:
+//
+//	POST /builds                start a build; the body is a builder.Builder,
+//	                            and ?webhook=URL asks for a webhook
+//	GET  /builds/{id}           the status of a build, as a Job
+//	GET  /builds/{id}/log       the log of a build, streamed until it ends
+//	GET  /builds/{id}/artifact  the binary of a successful build
+//	GET  /schema                the JSON Schema of builder.Builder,
+//	                            as returned by builder.ConfigSchema
+//
+// Only the settings that select what to build are taken from the
+// requested Builder: CaddyVersion, Plugins, Replacements (which must
+// not be local), the target platform, BuildTags, and StripSymbols.
+// Everything else comes from Server.Base, so that clients cannot run
+// commands or read files on the server. Requests whose Builder
+// does not pass Builder.Validate are rejected with the problems.
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/crackeer/goaway/builder"
+)
+
+// Server serves the build API. It must not be copied after first use.
+type Server struct {
+	// Base provides all settings of the builds
+	// other than those taken from the requests.
+	Base builder.Builder
+
+	// Jobs runs the builds and keeps their state.
+	// Default: Jobs with default settings
+	Jobs *Jobs
+
+	// MaxRequestBytes limits the size of build requests.
+	// Default: 1 MiB
+	MaxRequestBytes int64
+
+	// AllowWebhooks permits requests to ask for a webhook
+	// with the webhook query parameter, which makes the
+	// server post to arbitrary URLs.
+	AllowWebhooks bool
+
+	init sync.Once
+}
+
+func (s *Server) setup() {
+	if s.Jobs == nil {
+		s.Jobs = &Jobs{}
+	}
+	if s.MaxRequestBytes <= 0 {
+		s.MaxRequestBytes = 1 << 20
+	}
+}
+
+// ServeHTTP implements http.Handler.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.init.Do(s.setup)
+
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) == 1 && parts[0] == "schema" {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+			return
+		}
+		w.Header().Set("Content-Type", "application/schema+json")
+		_, _ = w.Write(builder.ConfigSchema())
+		return
+	}
+	if parts[0] != "builds" || len(parts) > 3 {
+		writeError(w, http.StatusNotFound, fmt.Errorf("not found"))
+		return
+	}
+	if len(parts) == 1 {
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+			return
+		}
+		s.startBuild(w, r)
+		return
+	}
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+		return
+	}
+	id := parts[1]
+	job, err := s.Jobs.Get(id)
+	if err == ErrNotFound {
+		writeError(w, http.StatusNotFound, fmt.Errorf("no build with ID %s", id))
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if len(parts) == 2 {
+		writeJSON(w, http.StatusOK, job)
+		return
+	}
+	switch parts[2] {
+	case "log":
+		s.streamLog(w, r, id)
+	case "artifact":
+		s.serveArtifact(w, r, job)
+	default:
+		writeError(w, http.StatusNotFound, fmt.Errorf("not found"))
+	}
+}
+
+// Close cancels all builds and waits for them to end.
+func (s *Server) Close() {
+	s.init.Do(s.setup)
+	s.Jobs.Close()
+}
+
+func (s *Server) startBuild(w http.ResponseWriter, r *http.Request) {
+	var req builder.Builder
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, s.MaxRequestBytes))
+	if err := dec.Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("decoding request: %v", err))
+		return
+	}
+	b, err := s.builderFor(req)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := b.Validate(r.Context(), ""); err != nil {
+		var invalid *builder.ValidationError
+		if errors.As(err, &invalid) {
+			writeJSON(w, http.StatusBadRequest, map[string]interface{}{"error": err.Error(), "problems": invalid.Problems})
+		} else {
+			writeError(w, http.StatusInternalServerError, err)
+		}
+		return
+	}
+
+	var webhook string
+	if hook := r.URL.Query().Get("webhook"); hook != "" {
+		if !s.AllowWebhooks {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("webhooks are not allowed"))
+			return
+		}
+		if u, err := url.Parse(hook); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid webhook URL: %s", hook))
+			return
+		}
+		webhook = hook
+	}
+
+	id, err := s.Jobs.Submit(b, webhook)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	job, err := s.Jobs.Get(id)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusAccepted, job)
+}
+
+// builderFor returns the Builder for req: s.Base
+// with the settings that requests may choose.
+func (s *Server) builderFor(req builder.Builder) (builder.Builder, error) {
+	for _, r := range req.Replacements {
+		if r.New.IsLocal() {
+			return builder.Builder{}, fmt.Errorf("replacement %s: local replacements are not allowed", r.Old)
+		}
+	}
+	b := s.Base
+	b.CaddyVersion = req.CaddyVersion
+	b.Plugins = req.Plugins
+	b.Replacements = append(append([]builder.Replace(nil), s.Base.Replacements...), req.Replacements...)
+	b.Platform = req.Platform
+	b.BuildTags = append(append([]string(nil), s.Base.BuildTags...), req.BuildTags...)
+	b.StripSymbols = b.StripSymbols || req.StripSymbols
+	return b, nil
+}
+
+// streamLog writes the log of the job with the given ID
+// as it is written, until the build has ended or the
+// client goes away.
+func (s *Server) streamLog(w http.ResponseWriter, r *http.Request, id string) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	var flush func()
+	if flusher, ok := w.(http.Flusher); ok {
+		flush = flusher.Flush
+	}
+	_ = s.Jobs.StreamLog(r.Context(), id, w, flush)
+}
+
+func (s *Server) serveArtifact(w http.ResponseWriter, r *http.Request, job Job) {
+	artifact, err := s.Jobs.Artifact(job.ID)
+	if err != nil {
+		writeError(w, http.StatusConflict, err)
+		return
+	}
+	name := "goaway"
+	if p := job.Result.Platform; p.OS != "" && p.Arch != "" {
+		name += "_" + p.OS + "_" + p.Arch
+	}
+	name += filepath.Ext(artifact)
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+	http.ServeFile(w, r, artifact)
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,191 @@
+package builder
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// Signer signs the artifacts of builds, such as binaries and SBOMs.
+type Signer interface {
+	// Sign signs file and returns the files it wrote next to it, such
+	// as a detached signature and a certificate.
+	Sign(ctx context.Context, file string) ([]string, error)
+}
+
+// CosignSigner signs artifacts with cosign as blobs, writing the
+// signature to file.sig. Without Key, it signs keyless: the
+// signature is bound to an OpenID Connect identity, such as that
+// of a CI workflow, and its certificate is written to file.pem.
+type CosignSigner struct {
+	// Command is the cosign command. Default: "cosign"
+	Command string `json:"command,omitempty"`
+
+	// Key, if set, is the key to sign with: a file or a KMS URI.
+	// A password of the key is read from COSIGN_PASSWORD.
+	Key string `json:"key,omitempty"`
+
+	// Bundle also writes a Sigstore bundle to file.sigstore.json,
+	// which verification with cosign v2.4 and later prefers.
+	Bundle bool `json:"bundle,omitempty"`
+
+	// Args are additional arguments to `cosign sign-blob`.
+	Args []string `json:"args,omitempty"`
+}
+
+// Sign implements Signer.
+func (s CosignSigner) Sign(ctx context.Context, file string) ([]string, error) {
+	files := []string{file + ".sig"}
+	args := []string{"sign-blob", "--yes", "--output-signature", files[0]}
+	if s.Key != "" {
+		args = append(args, "--key", s.Key)
+	} else {
+		files = append(files, file+".pem")
+		args = append(args, "--output-certificate", files[1])
+	}
+	if s.Bundle {
+		files = append(files, file+".sigstore.json")
+		args = append(args, "--bundle", file+".sigstore.json")
+	}
+	args = append(append(args, s.Args...), file)
+	if err := runSigner(ctx, commandOrDefault(s.Command, "cosign"), args, ""); err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// GPGSigner signs artifacts with GnuPG, writing an
+// OpenPGP detached signature to file.sig, or to
+// file.asc if it is ASCII-armored.
+type GPGSigner struct {
+	// Command is the gpg command. Default: "gpg"
+	Command string `json:"command,omitempty"`
+
+	// Key is the key to sign with, as a user ID or a fingerprint.
+	// Default: the default key of gpg
+	Key string `json:"key,omitempty"`
+
+	// Homedir, if set, is the home directory of gpg.
+	Homedir string `json:"homedir,omitempty"`
+
+	// PassphraseFile, if set, is a file with the passphrase of the
+	// key, which is passed to gpg without a pinentry program.
+	PassphraseFile string `json:"passphrase_file,omitempty"`
+
+	// Armor writes ASCII-armored signatures.
+	Armor bool `json:"armor,omitempty"`
+}
+
+// Sign implements Signer.
+func (s GPGSigner) Sign(ctx context.Context, file string) ([]string, error) {
+	sig := file + ".sig"
+	args := []string{"--batch", "--yes"}
+	if s.Homedir != "" {
+		args = append(args, "--homedir", s.Homedir)
+	}
+	if s.Key != "" {
+		args = append(args, "--local-user", s.Key)
+	}
+	if s.PassphraseFile != "" {
+		args = append(args, "--pinentry-mode", "loopback", "--passphrase-file", s.PassphraseFile)
+	}
+	if s.Armor {
+		sig = file + ".asc"
+		args = append(args, "--armor")
+	}
+	args = append(args, "--detach-sign", "--output", sig, file)
+	if err := runSigner(ctx, commandOrDefault(s.Command, "gpg"), args, ""); err != nil {
+		return nil, err
+	}
+	return []string{sig}, nil
+}
+
+// MinisignSigner signs artifacts with minisign,
+// writing the signature to file.minisig.
+type MinisignSigner struct {
+	// Command is the minisign command. Default: "minisign"
+	Command string `json:"command,omitempty"`
+
+	// SecretKey is the secret key file to sign with.
+	// Default: the default secret key of minisign
+	SecretKey string `json:"secret_key,omitempty"`
+
+	// PasswordFile, if set, is a file with the password
+	// of the secret key, which is passed to minisign.
+	PasswordFile string `json:"password_file,omitempty"`
+
+	// TrustedComment, if set, is the trusted comment of the signatures.
+	TrustedComment string `json:"trusted_comment,omitempty"`
+}
+
+// Sign implements Signer.
+func (s MinisignSigner) Sign(ctx context.Context, file string) ([]string, error) {
+	sig := file + ".minisig"
+	args := []string{"-S", "-m", file, "-x", sig}
+	if s.SecretKey != "" {
+		args = append(args, "-s", s.SecretKey)
+	}
+	if s.TrustedComment != "" {
+		args = append(args, "-t", s.TrustedComment)
+	}
+	var password string
+	if s.PasswordFile != "" {
+		data, err := os.ReadFile(s.PasswordFile)
+		if err != nil {
+			return nil, err
+		}
+		password = strings.TrimRight(string(data), "\r\n") + "\n"
+	}
+	if err := runSigner(ctx, commandOrDefault(s.Command, "minisign"), args, password); err != nil {
+		return nil, err
+	}
+	return []string{sig}, nil
+}
+
+// commandOrDefault returns command, or def if it is empty.
+func commandOrDefault(command, def string) string {
+	if command != "" {
+		return command
+	}
+	return def
+}
+
+// runSigner runs the signing command name with args
+// and the given standard input on the host.
+func runSigner(ctx context.Context, name string, args []string, stdin string) error {
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Stdin = strings.NewReader(stdin)
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s: %v: %s", name, err, strings.TrimSpace(out.String()))
+	}
+	return nil
+}
+
+// sign signs the binary of result and its SBOM, if any, with each
+// of the Signers and records the files they wrote in result.
+func (b Builder) sign(ctx context.Context, result *BuildResult) error {
+	files := []string{result.OutputFile}
+	if result.SBOMFile != "" {
+		files = append(files, result.SBOMFile)
+	}
+	if result.ProvenanceFile != "" {
+		files = append(files, result.ProvenanceFile)
+	}
+	for _, signer := range b.Signers {
+		for _, file := range files {
+			b.logger().Printf("[INFO] Signing %s", file)
+			written, err := signer.Sign(ctx, file)
+			if err != nil {
+				return fmt.Errorf("signing %s: %v", file, err)
+			}
+			result.Signatures = append(result.Signatures, written...)
+		}
+	}
+	return nil
+}
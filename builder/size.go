@@ -0,0 +1,250 @@
+package builder
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// BinarySizeError is returned when the binary
+// is larger than Builder.MaxBinarySize.
+type BinarySizeError struct {
+	// The size of the binary and the limit, in bytes.
+	Size int64
+	Max  int64
+
+	// The modules with the most code and data in the binary,
+	// the largest first, if its symbol table could be read.
+	Modules []ModuleSize
+}
+
+func (e *BinarySizeError) Error() string {
+	msg := fmt.Sprintf("binary has %d bytes, more than the maximum of %d", e.Size, e.Max)
+	if len(e.Modules) == 0 {
+		return msg
+	}
+	largest := make([]string, 0, len(e.Modules))
+	for _, m := range e.Modules {
+		largest = append(largest, fmt.Sprintf("%s: %d bytes", m.Path, m.Bytes))
+	}
+	return fmt.Sprintf("%s; largest modules: %s", msg, strings.Join(largest, "; "))
+}
+
+// ModuleSize is how much of a binary is a module's code and
+// data, according to the sizes of its symbols, which leave out
+// what the linker does not attribute to packages, such as the
+// tables of the runtime. The standard library is one module,
+// "std".
+type ModuleSize struct {
+	Path    string `json:"path"`
+	Version string `json:"version,omitempty"`
+	Bytes   int64  `json:"bytes"`
+}
+
+// stdModule is the module path of the
+// standard library in a ModuleSize.
+const stdModule = "std"
+
+// sizeReportModules is how many of the largest
+// modules a BinarySizeError reports.
+const sizeReportModules = 10
+
+// symbolSizes returns the sizes of the code and data of the
+// packages in the binary at path, according to `go tool nm`,
+// keyed by import path. Symbols that belong to no package, such
+// as those of types without names, are left out.
+func (env environment) symbolSizes(ctx context.Context, path string) (map[string]int64, error) {
+	var out bytes.Buffer
+	cmd := env.newCommand(ctx, GetGo(), "tool", "nm", "-size", path)
+	cmd.Stdout = &out
+	stderr, err := env.runCommandTail(ctx, cmd)
+	if err != nil {
+		return nil, fmt.Errorf("reading symbol table: %v%s", err, formatStderr(stderr))
+	}
+	sizes := make(map[string]int64)
+	scanner := bufio.NewScanner(&out)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		m := nmLine.FindStringSubmatch(scanner.Text())
+		if m == nil {
+			continue
+		}
+		switch m[2] {
+		case "U", "B", "b":
+			// undefined, or in a segment that takes no space in the file
+			continue
+		}
+		size, err := strconv.ParseInt(m[1], 10, 64)
+		if err != nil || size == 0 {
+			continue
+		}
+		if pkg := symbolPackage(m[3]); pkg != "" {
+			sizes[pkg] += size
+		}
+	}
+	return sizes, scanner.Err()
+}
+
+// nmLine matches the lines of `go tool nm -size`: the
+// address, size, type, and name of a symbol.
+var nmLine = regexp.MustCompile(`^\s*[0-9a-f]+\s+(\d+)\s+(\S)\s+(.+)$`)
+
+// symbolPrefixes are the prefixes of the names of symbols that
+// the linker generates for the types, methods, and interface
+// tables of packages, in the old and the new (Go 1.20) forms.
+var symbolPrefixes = []string{
+	"type:.eq.", "type:.hash.", "type..eq.", "type..hash.",
+	"type:", "type.", "go:itab.", "go.itab.",
+}
+
+// symbolPackage returns the import path of the package that
+// the symbol name belongs to, or "" if it belongs to none.
+func symbolPackage(name string) string {
+	for _, prefix := range symbolPrefixes {
+		if strings.HasPrefix(name, prefix) {
+			name = strings.TrimPrefix(name, prefix)
+			break
+		}
+	}
+	name = strings.TrimLeft(name, "*")
+	if strings.HasPrefix(name, "go:") || strings.HasPrefix(name, "go.") {
+		return ""
+	}
+	// the package path ends at the first dot after its last
+	// slash; type arguments and receivers may contain slashes
+	if i := strings.IndexAny(name, "[("); i >= 0 {
+		name = name[:i]
+	}
+	start := strings.LastIndex(name, "/") + 1
+	dot := strings.Index(name[start:], ".")
+	if dot <= 0 {
+		return ""
+	}
+	// dots in the last element of the path are escaped
+	return strings.ReplaceAll(name[:start+dot], "%2e", ".")
+}
+
+// SizeReport is a breakdown of the size of a binary by package and
+// by module, according to the sizes of its symbols, which leave out
+// what the linker does not attribute to packages, such as the tables
+// of the runtime and string data. The packages and modules are
+// sorted by size, the largest first.
+type SizeReport struct {
+	// The bytes of the binary that are attributed to packages,
+	// which is less than the size of the binary.
+	Attributed int64 `json:"attributed"`
+
+	Modules  []ModuleSize  `json:"modules,omitempty"`
+	Packages []PackageSize `json:"packages,omitempty"`
+}
+
+// PackageSize is how much of a binary is a package's code
+// and data, and the module that provides the package.
+type PackageSize struct {
+	Path   string `json:"path"`
+	Module string `json:"module,omitempty"`
+	Bytes  int64  `json:"bytes"`
+}
+
+// sizeReport returns the sizes of the packages in the binary at path,
+// added up by module according to its build info.
+func (env environment) sizeReport(ctx context.Context, path string) (*SizeReport, error) {
+	packageSizes, err := env.symbolSizes(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	bi, err := readBuildInfo(path)
+	if err != nil {
+		return nil, err
+	}
+	modules := []ModuleSize{{Path: bi.Main.Path, Version: bi.Main.Version}}
+	for _, dep := range bi.Deps {
+		modules = append(modules, ModuleSize{Path: dep.Path, Version: dep.Version})
+	}
+	std := ModuleSize{Path: stdModule}
+	report := &SizeReport{}
+	for pkg, size := range packageSizes {
+		importPath := pkg
+		if pkg == "main" {
+			// symbols of the main package are named after "main"
+			importPath = bi.Path
+		}
+		ps := PackageSize{Path: importPath, Bytes: size}
+		if i := moduleOfPackage(modules, importPath); i >= 0 {
+			modules[i].Bytes += size
+			ps.Module = modules[i].Path
+		} else if !strings.Contains(strings.SplitN(importPath, "/", 2)[0], ".") {
+			std.Bytes += size
+			ps.Module = stdModule
+		}
+		report.Packages = append(report.Packages, ps)
+		report.Attributed += size
+	}
+	for _, m := range append(modules, std) {
+		if m.Bytes > 0 {
+			report.Modules = append(report.Modules, m)
+		}
+	}
+	sort.Slice(report.Modules, func(i, j int) bool {
+		a, b := report.Modules[i], report.Modules[j]
+		return a.Bytes > b.Bytes || a.Bytes == b.Bytes && a.Path < b.Path
+	})
+	sort.Slice(report.Packages, func(i, j int) bool {
+		a, b := report.Packages[i], report.Packages[j]
+		return a.Bytes > b.Bytes || a.Bytes == b.Bytes && a.Path < b.Path
+	})
+	return report, nil
+}
+
+// moduleOfPackage returns the index of the module of modules
+// that provides pkg, the one with the longest matching path,
+// or -1 if none does.
+func moduleOfPackage(modules []ModuleSize, pkg string) int {
+	best := -1
+	for i, m := range modules {
+		if m.Path != "" && (pkg == m.Path || strings.HasPrefix(pkg, m.Path+"/")) &&
+			(best < 0 || len(m.Path) > len(modules[best].Path)) {
+			best = i
+		}
+	}
+	return best
+}
+
+// checkBinarySize returns a BinarySizeError if the binary at path
+// is larger than max, or only logs it if warn is set. sizes is the
+// size report of the binary, if it was taken already; otherwise it
+// is taken now if the binary is too large, unless compressed, as the
+// symbols of a compressed binary cannot be read.
+func (env environment) checkBinarySize(ctx context.Context, path string, max int64, warn bool, sizes *SizeReport, compressed bool) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if info.Size() <= max {
+		return nil
+	}
+	if sizes == nil && !compressed {
+		sizes, err = env.sizeReport(ctx, path)
+		if err != nil {
+			env.log.Printf("[WARNING] Cannot tell which modules make the binary large: %v", err)
+		}
+	}
+	sizeErr := &BinarySizeError{Size: info.Size(), Max: max}
+	if sizes != nil {
+		sizeErr.Modules = sizes.Modules
+		if len(sizeErr.Modules) > sizeReportModules {
+			sizeErr.Modules = sizeErr.Modules[:sizeReportModules]
+		}
+	}
+	if warn {
+		env.log.Printf("[WARNING] %v", sizeErr)
+		return nil
+	}
+	return sizeErr
+}
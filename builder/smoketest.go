@@ -0,0 +1,286 @@
+package builder
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// SmokeTest configures running the built binary after the build,
+// to catch binaries that build fine but do not work, such as when
+// a plugin never registers its modules.
+//
+// Binaries for the host run natively, or on the Executor of the
+// build if there is one. Binaries for other architectures of the
+// host's operating system run under qemu user-mode emulation: with
+// Emulator, with qemu-<arch> if it is installed, or natively if
+// binfmt_misc is set up for qemu, as by the docker/binfmt images,
+// so that binaries for linux/arm64 or linux/riscv64 built on amd64
+// run before they are released. Modules for wasip1/wasm run with
+// Emulator or with wasmtime if it is installed. Binaries that cannot
+// be run are not tested, with a warning, unless Strict is set.
+type SmokeTest struct {
+	// Commands are the arguments to run the binary with,
+	// each of which must succeed. Default: "version"
+	Commands [][]string `json:"commands,omitempty"`
+
+	// Modules are the IDs of modules that the binary must
+	// register, such as "http.handlers.rate_limit", as
+	// listed by running the binary with "list-modules".
+	Modules []string `json:"modules,omitempty"`
+
+	// Emulator, if set, is the command to run binaries for
+	// other architectures with, e.g. "qemu-aarch64-static",
+	// followed by EmulatorArgs, such as -L for the libraries
+	// of binaries built with cgo.
+	Emulator     string   `json:"emulator,omitempty"`
+	EmulatorArgs []string `json:"emulator_args,omitempty"`
+
+	// Sysroot, if set, is the directory that emulated binaries
+	// load shared libraries from, such as /usr/aarch64-linux-gnu
+	// for binaries built with cgo; qemu gets it as QEMU_LD_PREFIX,
+	// which also works with binfmt_misc, unlike EmulatorArgs.
+	Sysroot string `json:"sysroot,omitempty"`
+
+	// With Strict, binaries that cannot be run on this host
+	// fail the smoke test instead of skipping it.
+	Strict bool `json:"strict,omitempty"`
+
+	// Timeout is how long each command may run. Default: 1m
+	Timeout time.Duration `json:"timeout,omitempty"`
+}
+
+// SmokeTestError is returned when a command of a
+// smoke test failed or modules were not registered.
+type SmokeTestError struct {
+	// The arguments that the binary was run with.
+	Command []string
+
+	// The modules that the binary did not register.
+	Missing []string
+
+	// The end of the output of the binary.
+	Output string
+
+	Err error
+}
+
+func (e *SmokeTestError) Error() string {
+	if len(e.Command) == 0 {
+		return fmt.Sprintf("smoke test: %v", e.Err)
+	}
+	if len(e.Missing) > 0 {
+		return fmt.Sprintf("smoke test: %d module(s) not registered: %s", len(e.Missing), strings.Join(e.Missing, ", "))
+	}
+	return fmt.Sprintf("smoke test %q: %v%s", strings.Join(e.Command, " "), e.Err, formatStderr(e.Output))
+}
+
+func (e *SmokeTestError) Unwrap() error { return e.Err }
+
+// qemuArchs are the names of the architectures in the names
+// of the qemu user-mode emulators, where they differ from Go.
+var qemuArchs = map[string]string{
+	"amd64":    "x86_64",
+	"386":      "i386",
+	"arm64":    "aarch64",
+	"mipsle":   "mipsel",
+	"mips64le": "mips64el",
+	"loong64":  "loongarch64",
+}
+
+// smokeRunner is how a smoke test runs the binary.
+type smokeRunner struct {
+	// The command to run the binary with, followed by its
+	// arguments, or none if the binary is run directly.
+	command string
+	args    []string
+
+	// Whether the binary is emulated, by command
+	// or by binfmt_misc with no command.
+	emulated bool
+}
+
+func (r smokeRunner) String() string {
+	switch {
+	case r.command != "":
+		return r.command
+	case r.emulated:
+		return "binfmt_misc"
+	}
+	return "native"
+}
+
+// runner returns how to run a binary for p,
+// and whether it can run at all.
+func (t SmokeTest) runner(p Platform, hasExecutor bool) (smokeRunner, bool) {
+	goos, goarch := p.OS, p.Arch
+	if goos == "" {
+		goos = runtime.GOOS
+	}
+	if goarch == "" {
+		goarch = runtime.GOARCH
+	}
+	if hasExecutor || goarch == runtime.GOARCH && goos == runtime.GOOS {
+		return smokeRunner{}, true
+	}
+	if t.Emulator != "" {
+		return smokeRunner{command: t.Emulator, args: t.EmulatorArgs, emulated: true}, true
+	}
+	if goarch == "wasm" {
+		if goos == "wasip1" {
+			if path, err := exec.LookPath("wasmtime"); err == nil {
+				return smokeRunner{command: path, args: t.EmulatorArgs, emulated: true}, true
+			}
+		}
+		return smokeRunner{}, false
+	}
+	if goos != runtime.GOOS || runtime.GOOS != "linux" {
+		return smokeRunner{}, false
+	}
+	qemuArch := goarch
+	if name, ok := qemuArchs[goarch]; ok {
+		qemuArch = name
+	}
+	for _, name := range []string{"qemu-" + qemuArch, "qemu-" + qemuArch + "-static"} {
+		if path, err := exec.LookPath(name); err == nil {
+			return smokeRunner{command: path, args: t.EmulatorArgs, emulated: true}, true
+		}
+	}
+	if binfmtRuns(qemuArch) {
+		return smokeRunner{emulated: true}, true
+	}
+	return smokeRunner{}, false
+}
+
+// binfmtMisc is where the kernel lists the interpreters of
+// binfmt_misc, which run binaries for other architectures.
+const binfmtMisc = "/proc/sys/fs/binfmt_misc"
+
+// binfmtRuns reports whether binfmt_misc runs binaries for
+// qemuArch with qemu: its entry must be enabled, and so must
+// binfmt_misc, and the interpreter must exist unless the kernel
+// opened it when it was registered, with the F flag, as for
+// containers that do not have it.
+func binfmtRuns(qemuArch string) bool {
+	if status, err := os.ReadFile(filepath.Join(binfmtMisc, "status")); err == nil &&
+		strings.TrimSpace(string(status)) != "enabled" {
+		return false
+	}
+	entry, err := os.ReadFile(filepath.Join(binfmtMisc, "qemu-"+qemuArch))
+	if err != nil {
+		return false
+	}
+	var enabled, fixed bool
+	var interpreter string
+	for _, line := range strings.Split(string(entry), "\n") {
+		switch {
+		case line == "enabled":
+			enabled = true
+		case strings.HasPrefix(line, "interpreter "):
+			interpreter = strings.TrimPrefix(line, "interpreter ")
+		case strings.HasPrefix(line, "flags: "):
+			fixed = strings.Contains(strings.TrimPrefix(line, "flags: "), "F")
+		}
+	}
+	if !enabled {
+		return false
+	}
+	if fixed {
+		return true
+	}
+	_, err = os.Stat(interpreter)
+	return interpreter != "" && err == nil
+}
+
+// smokeTest runs the commands of t with the binary at absOutputFile
+// in buildEnv, then checks that it registers the modules of t.
+func (b Builder) smokeTest(ctx context.Context, t SmokeTest, buildEnv *environment, absOutputFile string) error {
+	runner, ok := t.runner(b.Platform, buildEnv.executor != nil)
+	if !ok {
+		if t.Strict {
+			return &SmokeTestError{Err: fmt.Errorf("binaries for %s cannot run on this host; set an emulator", b.Platform.key())}
+		}
+		b.logger().Printf("[WARNING] Skipping smoke test: binaries for %s cannot run on this host; set an emulator", b.Platform.key())
+		return nil
+	}
+	timeout := t.Timeout
+	if timeout <= 0 {
+		timeout = time.Minute
+	}
+	commands := t.Commands
+	if len(commands) == 0 {
+		commands = [][]string{{"version"}}
+	}
+	run := func(args []string) (string, error) {
+		var cmd *exec.Cmd
+		if runner.command != "" {
+			cmd = buildEnv.newCommand(ctx, runner.command, append(append(append([]string(nil), runner.args...), absOutputFile), args...)...)
+		} else {
+			cmd = buildEnv.newCommand(ctx, absOutputFile, args...)
+		}
+		if runner.emulated && t.Sysroot != "" {
+			cmd.Env = setEnv(cmd.Env, "QEMU_LD_PREFIX="+t.Sysroot)
+		}
+		var out bytes.Buffer
+		cmd.Stdout = &out
+		cmd.Stderr = &out
+		runCtx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+		err := buildEnv.runCommand(runCtx, cmd)
+		if err != nil && runCtx.Err() == context.DeadlineExceeded && ctx.Err() == nil {
+			err = fmt.Errorf("timed out after %s", timeout)
+		}
+		if err != nil {
+			return "", &SmokeTestError{Command: args, Output: outputTail(out.String()), Err: err}
+		}
+		return out.String(), nil
+	}
+
+	if runner.emulated {
+		b.logger().Printf("[INFO] Smoke testing %s for %s with %s", absOutputFile, b.Platform.key(), runner)
+	} else {
+		b.logger().Printf("[INFO] Smoke testing %s", absOutputFile)
+	}
+	for _, args := range commands {
+		if _, err := run(args); err != nil {
+			return err
+		}
+	}
+	if len(t.Modules) == 0 {
+		return nil
+	}
+	out, err := run([]string{"list-modules"})
+	if err != nil {
+		return err
+	}
+	registered := make(map[string]bool)
+	for _, line := range strings.Split(out, "\n") {
+		if fields := strings.Fields(line); len(fields) > 0 {
+			registered[fields[0]] = true
+		}
+	}
+	var missing []string
+	for _, id := range t.Modules {
+		if !registered[id] {
+			missing = append(missing, id)
+		}
+	}
+	if len(missing) > 0 {
+		return &SmokeTestError{Command: []string{"list-modules"}, Missing: missing, Output: outputTail(out)}
+	}
+	return nil
+}
+
+// outputTail returns the end of the output s
+// that the typed errors include.
+func outputTail(s string) string {
+	tail := &tailWriter{max: stderrTailSize}
+	tail.Write([]byte(s))
+	return tail.String()
+}
@@ -0,0 +1,252 @@
+package builder
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// SSHExecutor is an Executor that runs builds on a remote machine over
+// SSH, e.g. on a native darwin/arm64 machine for targets that are hard
+// to cross-compile with cgo. The build environment and the directories
+// of local replacements are copied to the same paths on the remote
+// machine, the commands run there, and the changed build environment,
+// including the binary, is copied back after every command. The remote
+// machine must have the go command and tar; its module cache is used.
+type SSHExecutor struct {
+	// Host is the machine to connect to, as [user@]host.
+	Host string `json:"host,omitempty"`
+
+	// Port is the SSH port of Host. Default: the port of the
+	// SSH configuration, usually 22
+	Port int `json:"port,omitempty"`
+
+	// IdentityFile is the private key to authenticate with.
+	IdentityFile string `json:"identity_file,omitempty"`
+
+	// Options are additional options for ssh, such
+	// as "StrictHostKeyChecking=yes", passed with -o.
+	Options []string `json:"options,omitempty"`
+
+	// Go is the go command on the remote machine. Default: "go"
+	Go string `json:"go,omitempty"`
+}
+
+// Start implements Executor.
+func (e SSHExecutor) Start(ctx context.Context, dir string, inputs, goEnv []string) (ExecutorSession, error) {
+	if e.Host == "" {
+		return nil, fmt.Errorf("remote builds require a host")
+	}
+	path, err := exec.LookPath("ssh")
+	if err != nil {
+		return nil, fmt.Errorf("ssh: %v", err)
+	}
+	s := &sshSession{e: e, path: path, dir: dir}
+	for _, input := range inputs {
+		if err := s.upload(ctx, input); err != nil {
+			return nil, fmt.Errorf("copying %s to %s: %v", input, e.Host, err)
+		}
+	}
+	return s, nil
+}
+
+type sshSession struct {
+	e    SSHExecutor
+	path string
+	dir  string
+}
+
+// command returns an ssh command that runs the
+// shell command line on the remote machine.
+func (s *sshSession) command(ctx context.Context, line string) *exec.Cmd {
+	return exec.CommandContext(ctx, s.path, s.args(line)...)
+}
+
+func (s *sshSession) args(line string) []string {
+	args := []string{"-o", "BatchMode=yes"}
+	if s.e.Port > 0 {
+		args = append(args, "-p", strconv.Itoa(s.e.Port))
+	}
+	if s.e.IdentityFile != "" {
+		args = append(args, "-i", s.e.IdentityFile)
+	}
+	for _, opt := range s.e.Options {
+		args = append(args, "-o", opt)
+	}
+	return append(args, s.e.Host, "--", line)
+}
+
+func (s *sshSession) Upload(ctx context.Context) error {
+	return s.upload(ctx, s.dir)
+}
+
+// upload copies the local directory dir to the same path on the
+// remote machine, excluding version control metadata.
+func (s *sshSession) upload(ctx context.Context, dir string) error {
+	pr, pw := io.Pipe()
+	go func() { pw.CloseWithError(writeTar(pw, dir)) }()
+	cmd := s.command(ctx, "mkdir -p "+shellQuote(dir)+" && tar -C "+shellQuote(dir)+" -xf -")
+	cmd.Stdin = pr
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		pr.CloseWithError(err)
+		return fmt.Errorf("%v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+func (s *sshSession) Download(ctx context.Context) error {
+	cmd := s.command(ctx, "tar -C "+shellQuote(s.dir)+" -cf - .")
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	extractErr := readTar(out, s.dir)
+	if err := cmd.Wait(); err != nil {
+		return fmt.Errorf("copying build environment from %s: %v: %s", s.e.Host, err, strings.TrimSpace(stderr.String()))
+	}
+	return extractErr
+}
+
+func (s *sshSession) Wrap(cmd *exec.Cmd, vars []string) error {
+	dir := cmd.Dir
+	if dir == "" {
+		dir = s.dir
+	}
+	command := executorCommand(cmd)
+	if command == "go" && s.e.Go != "" {
+		command = s.e.Go
+	}
+	line := []string{"cd", shellQuote(dir), "&&", "exec", "env"}
+	for _, kv := range vars {
+		line = append(line, shellQuote(kv))
+	}
+	line = append(line, shellQuote(command))
+	for _, arg := range cmd.Args[1:] {
+		line = append(line, shellQuote(arg))
+	}
+
+	cmd.Path = s.path
+	cmd.Args = append([]string{"ssh"}, s.args(strings.Join(line, " "))...)
+	cmd.Env = nil
+	cmd.Dir = s.dir
+	return nil
+}
+
+func (s *sshSession) Close(ctx context.Context) error {
+	return s.command(ctx, "rm -rf "+shellQuote(s.dir)).Run()
+}
+
+// shellQuote quotes s for a POSIX shell.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// writeTar writes the regular files and directories
+// below dir to w as a tar archive, excluding .git.
+func writeTar(w io.Writer, dir string) error {
+	tw := tar.NewWriter(w)
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() && info.Name() == ".git" {
+			return filepath.SkipDir
+		}
+		if !info.IsDir() && !info.Mode().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil || rel == "." {
+			return err
+		}
+		hdr, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		hdr.Name = filepath.ToSlash(rel)
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(tw, f)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+// readTar extracts the regular files and directories
+// of the tar archive in r into dir.
+func readTar(r io.Reader, dir string) error {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		name := filepath.Clean(filepath.FromSlash(hdr.Name))
+		if name == "." {
+			continue
+		}
+		if filepath.IsAbs(name) || name == ".." || strings.HasPrefix(name, ".."+string(filepath.Separator)) {
+			return fmt.Errorf("invalid path in archive: %s", hdr.Name)
+		}
+		path := filepath.Join(dir, name)
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(path, 0755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				return err
+			}
+			// replace files instead of truncating them, in case
+			// they are still open, like a binary that is running
+			f, err := os.CreateTemp(filepath.Dir(path), ".tmp-")
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(f, tr)
+			if closeErr := f.Close(); err == nil {
+				err = closeErr
+			}
+			if err == nil {
+				err = os.Chmod(f.Name(), hdr.FileInfo().Mode().Perm())
+			}
+			if err == nil {
+				err = os.Rename(f.Name(), path)
+			}
+			if err != nil {
+				os.Remove(f.Name())
+				return err
+			}
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package builder
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+
+	"golang.org/x/mod/module"
+)
+
+// UnverifiedModule is a module of the build that the go
+// command did not verify against the checksum database.
+type UnverifiedModule struct {
+	Path    string `json:"path"`
+	Version string `json:"version,omitempty"`
+
+	// Why it was not verified, e.g. "GONOSUMDB matches".
+	Reason string `json:"reason"`
+}
+
+// UnverifiedModulesError is returned when VerifyChecksums is set
+// and modules of the build were not verified against the checksum
+// database.
+type UnverifiedModulesError struct {
+	Modules []UnverifiedModule
+}
+
+func (e *UnverifiedModulesError) Error() string {
+	mods := make([]string, 0, len(e.Modules))
+	for _, m := range e.Modules {
+		mod := m.Path
+		if m.Version != "" {
+			mod += "@" + m.Version
+		}
+		mods = append(mods, fmt.Sprintf("%s (%s)", mod, m.Reason))
+	}
+	return fmt.Sprintf("%d module(s) not verified against the checksum database: %s", len(e.Modules), strings.Join(mods, "; "))
+}
+
+// checkSumDB returns an error if sumdb is not a valid value of
+// GOSUMDB: off, or the name of a checksum database, optionally
+// with its public key and followed by the URL to reach it by.
+func checkSumDB(sumdb string) error {
+	fields := strings.Fields(sumdb)
+	switch {
+	case sumdb == "" || sumdb == "off":
+		return nil
+	case len(fields) == 0 || len(fields) > 2:
+		return fmt.Errorf("invalid checksum database %q; expected a name or key, optionally followed by a URL", sumdb)
+	case strings.HasPrefix(fields[0], "http:") || strings.HasPrefix(fields[0], "https:"):
+		return fmt.Errorf("invalid checksum database %q; the URL goes after the name or key", sumdb)
+	case len(fields) == 2:
+		u, err := url.Parse(fields[1])
+		if err != nil || u.Scheme != "https" && u.Scheme != "http" || u.Host == "" {
+			return fmt.Errorf("invalid URL %q of checksum database %s", fields[1], fields[0])
+		}
+	}
+	return nil
+}
+
+// checkModulePatterns returns an error if patterns is not a
+// comma-separated list of module path patterns, the format of
+// GONOPROXY, GOPRIVATE, GONOSUMDB, and GOINSECURE.
+func checkModulePatterns(patterns string) error {
+	for _, p := range strings.Split(patterns, ",") {
+		if p == "" {
+			continue
+		}
+		if _, err := path.Match(p, ""); err != nil {
+			return fmt.Errorf("invalid module path pattern %q: %v", p, err)
+		}
+	}
+	return nil
+}
+
+// verifyChecksums returns an UnverifiedModulesError if any
+// module in the module graph of env is exempt from verification
+// against the checksum database, as the go command decides it,
+// or is replaced with a local directory, which has no checksum.
+func (env environment) verifyChecksums(ctx context.Context) error {
+	sumdb, err := env.goEnvValue(ctx, "GOSUMDB")
+	if err != nil {
+		return err
+	}
+	// GONOSUMDB defaults to GOPRIVATE
+	noSumDB, err := env.goEnvValue(ctx, "GONOSUMDB")
+	if err != nil {
+		return err
+	}
+	modules, err := env.listModules(ctx)
+	if err != nil {
+		return err
+	}
+
+	unverified := &UnverifiedModulesError{}
+	checked := 0
+	for _, m := range modules {
+		if m.Main {
+			continue
+		}
+		checked++
+		mod := m
+		if m.Replace != nil {
+			if m.Replace.Version == "" {
+				unverified.Modules = append(unverified.Modules, UnverifiedModule{
+					Path: m.Path, Version: m.Version, Reason: "replaced by local directory " + m.Replace.Path,
+				})
+				continue
+			}
+			mod = *m.Replace
+		}
+		switch {
+		case sumdb == "off":
+			unverified.Modules = append(unverified.Modules, UnverifiedModule{Path: mod.Path, Version: mod.Version, Reason: "GOSUMDB is off"})
+		case module.MatchPrefixPatterns(noSumDB, mod.Path):
+			unverified.Modules = append(unverified.Modules, UnverifiedModule{Path: mod.Path, Version: mod.Version, Reason: "GONOSUMDB matches"})
+		}
+	}
+	if len(unverified.Modules) > 0 {
+		return unverified
+	}
+	env.log.Printf("[INFO] All %d modules are verified against checksum database %s", checked, sumdb)
+	return nil
+}
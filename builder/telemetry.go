@@ -0,0 +1,204 @@
+package builder
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+)
+
+// Instrumentation receives the traces and metrics of builds, so that
+// operators of build services can monitor them, e.g. with OpenTelemetry:
+// an adapter implements StartSpan with a trace.Tracer and Add and
+// Record with the counters and histograms of a metric.Meter, keyed by
+// name. Its methods may be called concurrently by concurrent builds.
+type Instrumentation interface {
+	// StartSpan starts a span with the given name and attributes
+	// as a child of the span in ctx, if any, and returns a context
+	// with the new span.
+	StartSpan(ctx context.Context, name string, attrs ...Attribute) (context.Context, Span)
+
+	// Add adds n to the counter with the given name.
+	Add(ctx context.Context, counter string, n int64, attrs ...Attribute)
+
+	// Record records value in the histogram with the given name.
+	Record(ctx context.Context, histogram string, value float64, attrs ...Attribute)
+}
+
+// Span is a span started by Instrumentation.StartSpan.
+type Span interface {
+	// End ends the span, with the error of the
+	// operation it describes if that failed.
+	End(err error)
+}
+
+// Attribute is an attribute of a span or a measurement.
+type Attribute struct {
+	Key   string
+	Value string
+}
+
+// The spans of a build: a build span for each Build, BuildWithModule,
+// BuildAll, Environment.Build, PrepareEnvironment, Resolve, Export,
+// and Test, with child spans for setting up the build environment,
+// with one for each `go get`, tidying, and compiling.
+const (
+	SpanBuild   = "build"
+	SpanSetup   = "setup"
+	SpanGoGet   = "go get"
+	SpanTidy    = "tidy"
+	SpanCompile = "compile"
+)
+
+// The metrics of builds.
+const (
+	// MetricBuilds counts builds, with a "result" attribute of
+	// "success" or "failure" and, for failures, a "reason" such
+	// as "get", "compile", or "timeout"; see FailureReason.
+	MetricBuilds = "goaway_builder.builds"
+
+	// MetricBuildDuration is a histogram of the durations of
+	// builds in seconds, with the same "result" attribute.
+	MetricBuildDuration = "goaway_builder.build.duration"
+
+	// MetricArtifactCache counts lookups in the artifact
+	// cache, with a "result" attribute of "hit" or "miss".
+	MetricArtifactCache = "goaway_builder.artifact_cache"
+
+	// MetricModuleDownloads counts the modules that
+	// were downloaded into the module cache.
+	MetricModuleDownloads = "goaway_builder.module_downloads"
+)
+
+// FailureReason returns a short name for the reason why a build
+// failed with err, for metrics: "timeout", "canceled", "setup",
+// "get", "tidy", "compile", "platform", "go_version", "pins",
+// "checksums", "conflicts", "compatibility", "plugins", "license",
+// "vulnerabilities", "analysis", "verification", "smoke_test",
+// "size", "validation", "preflight", or "other".
+func FailureReason(err error) string {
+	var (
+		timeoutErr    *TimeoutError
+		getErr        *GoGetError
+		fetchErr      *FetchError
+		tidyErr       *TidyError
+		compileErr    *CompileError
+		platformErr   *UnsupportedPlatformError
+		goVersionErr  *GoVersionError
+		pinErr        *PinError
+		unverifiedErr *UnverifiedModulesError
+		conflictErr   *ModuleConflictError
+		compatErr     *CompatibilityError
+		pluginErr     *PluginError
+		licenseErr    *LicenseError
+		vulnErr       *VulnerabilityError
+		analysisErr   *AnalysisError
+		verifyErr     *VerificationError
+		smokeTestErr  *SmokeTestError
+		sizeErr       *BinarySizeError
+		validationErr *ValidationError
+		setupErr      *SetupError
+		preflightErr  *PreflightError
+	)
+	switch {
+	case errors.As(err, &timeoutErr), errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	case errors.As(err, &getErr), errors.As(err, &fetchErr):
+		return "get"
+	case errors.As(err, &tidyErr):
+		return "tidy"
+	case errors.As(err, &compileErr):
+		return "compile"
+	case errors.As(err, &platformErr):
+		return "platform"
+	case errors.As(err, &goVersionErr):
+		return "go_version"
+	case errors.As(err, &pinErr):
+		return "pins"
+	case errors.As(err, &unverifiedErr):
+		return "checksums"
+	case errors.As(err, &conflictErr):
+		return "conflicts"
+	case errors.As(err, &compatErr):
+		return "compatibility"
+	case errors.As(err, &pluginErr):
+		return "plugins"
+	case errors.As(err, &licenseErr):
+		return "license"
+	case errors.As(err, &vulnErr):
+		return "vulnerabilities"
+	case errors.As(err, &analysisErr):
+		return "analysis"
+	case errors.As(err, &verifyErr):
+		return "verification"
+	case errors.As(err, &smokeTestErr):
+		return "smoke_test"
+	case errors.As(err, &sizeErr):
+		return "size"
+	case errors.As(err, &validationErr):
+		return "validation"
+	case errors.As(err, &setupErr):
+		return "setup"
+	case errors.As(err, &preflightErr):
+		return "preflight"
+	}
+	return "other"
+}
+
+// noopInstrumentation is the default Instrumentation.
+type noopInstrumentation struct{}
+
+func (noopInstrumentation) StartSpan(ctx context.Context, _ string, _ ...Attribute) (context.Context, Span) {
+	return ctx, noopSpan{}
+}
+
+func (noopInstrumentation) Add(context.Context, string, int64, ...Attribute) {}
+
+func (noopInstrumentation) Record(context.Context, string, float64, ...Attribute) {}
+
+type noopSpan struct{}
+
+func (noopSpan) End(error) {}
+
+// instrumentation returns the Instrumentation of b,
+// or one that does nothing if there is none.
+func (b Builder) instrumentation() Instrumentation {
+	if b.Instrumentation != nil {
+		return b.Instrumentation
+	}
+	return noopInstrumentation{}
+}
+
+// instrumentation is like Builder.instrumentation for env.
+func (env environment) instrumentation() Instrumentation {
+	if env.instr != nil {
+		return env.instr
+	}
+	return noopInstrumentation{}
+}
+
+// startBuild starts the build span of b and returns a function to
+// call with the error of the build when it ended, which ends the
+// span and records the metrics of the build that began at start.
+func (b Builder) startBuild(ctx context.Context, start time.Time) (context.Context, func(error)) {
+	instr := b.instrumentation()
+	ctx, span := instr.StartSpan(ctx, SpanBuild,
+		Attribute{Key: "caddy_version", Value: b.CaddyVersion},
+		Attribute{Key: "os", Value: b.targetOS()},
+		Attribute{Key: "arch", Value: b.targetArch()},
+		Attribute{Key: "plugins", Value: strconv.Itoa(len(b.Plugins))})
+	return ctx, func(err error) {
+		span.End(err)
+		result := []Attribute{{Key: "result", Value: "success"}}
+		if err != nil {
+			result[0].Value = "failure"
+		}
+		instr.Record(ctx, MetricBuildDuration, time.Since(start).Seconds(), result...)
+		if err != nil {
+			result = append(result, Attribute{Key: "reason", Value: FailureReason(err)})
+		}
+		instr.Add(ctx, MetricBuilds, 1, result...)
+	}
+}
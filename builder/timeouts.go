@@ -0,0 +1,81 @@
+package builder
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Timeouts limit how long each phase of a build may take. A zero
+// value selects the default of the phase, and a negative value
+// disables its timeout. They apply in addition to TimeoutGet, which
+// limits all `go get` commands together, and TimeoutBuild, and never
+// extend the deadline of the context of the build.
+type Timeouts struct {
+	// Setup limits preparing the build environment, including
+	// all `go get` commands. Default: 30m
+	Setup time.Duration `json:"setup,omitempty"`
+
+	// GoGet limits each `go get` command. Default: 10m
+	GoGet time.Duration `json:"go_get,omitempty"`
+
+	// Tidy limits `go mod tidy`. Default: 10m
+	Tidy time.Duration `json:"tidy,omitempty"`
+
+	// Compile limits `go build`. Default: 30m
+	Compile time.Duration `json:"compile,omitempty"`
+}
+
+// effective returns t with the defaults applied.
+func (t Timeouts) effective() Timeouts {
+	for _, v := range []struct {
+		timeout *time.Duration
+		def     time.Duration
+	}{
+		{&t.Setup, 30 * time.Minute},
+		{&t.GoGet, 10 * time.Minute},
+		{&t.Tidy, 10 * time.Minute},
+		{&t.Compile, 30 * time.Minute},
+	} {
+		if *v.timeout == 0 {
+			*v.timeout = v.def
+		}
+	}
+	return t
+}
+
+// TimeoutError is returned when a phase of
+// the build did not finish within its timeout.
+type TimeoutError struct {
+	// The phase that timed out, e.g. "tidy".
+	Phase string
+
+	// The timeout of the phase.
+	Timeout time.Duration
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("%s did not finish within %s", e.Phase, e.Timeout)
+}
+
+// Unwrap returns context.DeadlineExceeded, so a *TimeoutError
+// can be recognized like any other exceeded deadline.
+func (e *TimeoutError) Unwrap() error { return context.DeadlineExceeded }
+
+// withPhaseTimeout returns a context for a phase with the
+// given timeout, which is disabled if it is not positive.
+func withPhaseTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// phaseError returns a *TimeoutError instead of err if the phase
+// run with phaseCtx, derived from ctx, failed because it timed out.
+func phaseError(ctx, phaseCtx context.Context, phase string, timeout time.Duration, err error) error {
+	if err != nil && ctx.Err() == nil && phaseCtx.Err() == context.DeadlineExceeded {
+		return &TimeoutError{Phase: phase, Timeout: timeout}
+	}
+	return err
+}
@@ -0,0 +1,44 @@
+package builder
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCallerDeadlineAbortsGoGet(t *testing.T) {
+	for _, tt := range []struct {
+		name       string
+		timeoutGet time.Duration
+	}{
+		{"default timeouts", 0},
+		{"longer TimeoutGet", time.Hour},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			const plugin = "example.com/slow"
+			proxy := newHangingTestProxy(t, "v1.0.0", map[string]string{plugin: "package slow\n"})
+			b := testBuilder(t, testBaseModule(t))
+			b.GoProxy = proxy.URL
+			b.GoModCache = testModCache(t)
+			b.TimeoutGet = tt.timeoutGet
+			b.GracePeriod = time.Second
+			b.Plugins = []Dependency{{PackagePath: plugin, Version: "v1.0.0"}}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+			defer cancel()
+			start := time.Now()
+			_, err := b.Build(ctx, filepath.Join(t.TempDir(), "goaway"))
+			if !errors.Is(err, context.DeadlineExceeded) {
+				t.Fatalf("Build = %v, want the exceeded deadline of the caller", err)
+			}
+			if elapsed := time.Since(start); elapsed > 30*time.Second {
+				t.Errorf("go get ran for %s after the deadline of the caller", elapsed)
+			}
+			if left := buildEnvFolders(t, b.WorkDir); len(left) > 0 {
+				t.Errorf("build environments were not removed: %v", left)
+			}
+		})
+	}
+}
@@ -0,0 +1,182 @@
+package builder
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/mod/modfile"
+	"golang.org/x/mod/semver"
+)
+
+// goToolchain returns the name of the Go toolchain for
+// version, which may be given with or without the "go"
+// prefix, e.g. "1.22.1" or "go1.22.1".
+func goToolchain(version string) string {
+	if strings.HasPrefix(version, "go") {
+		return version
+	}
+	return "go" + version
+}
+
+// useToolchain makes sure that the go commands run in env use
+// the toolchain named want, which the go command downloads into
+// the module cache if it is not the one on the PATH. Toolchain
+// switching requires Go 1.21 or newer on the host; older hosts
+// ignore GOTOOLCHAIN, so the version in use is always verified.
+func (env environment) useToolchain(ctx context.Context, want string) error {
+	env.log.Printf("[INFO] Using Go toolchain %s", want)
+	if env.plan != nil {
+		// queries are not run when planning
+		return nil
+	}
+	got, err := env.goVersion(ctx)
+	if err != nil {
+		return fmt.Errorf("provisioning Go toolchain %s: %v", want, err)
+	}
+	if got != want {
+		return fmt.Errorf("go command is %s instead of the requested %s; pinning the toolchain requires Go 1.21 or newer on the host", got, want)
+	}
+	return nil
+}
+
+// goVersion returns the version of the Go toolchain
+// that the go commands run in env use, e.g. "go1.22.1".
+func (env environment) goVersion(ctx context.Context) (string, error) {
+	return env.goEnvValue(ctx, "GOVERSION")
+}
+
+// goEnvValue returns the value of the go environment
+// variable key for the go commands run in env.
+func (env environment) goEnvValue(ctx context.Context, key string) (string, error) {
+	var out bytes.Buffer
+	cmd := env.newCommand(ctx, GetGo(), "env", key)
+	cmd.Stdout = &out
+	if err := env.runCommand(ctx, cmd); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(out.String()), nil
+}
+
+// GoRequirement describes a module that requires
+// a minimum version of the Go toolchain.
+type GoRequirement struct {
+	// The module (and version) that has the requirement.
+	Module string `json:"module,omitempty"`
+
+	// The minimum Go version, from the go (or, for
+	// the main module, toolchain) directive of Module.
+	Go string `json:"go,omitempty"`
+}
+
+// GoVersionError is returned when modules in the
+// build require a newer Go toolchain than the one used.
+type GoVersionError struct {
+	// The version of the Go toolchain in use.
+	Running string
+
+	// The requirements that Running does not satisfy.
+	Requirements []GoRequirement
+}
+
+func (e *GoVersionError) Error() string {
+	lines := make([]string, 0, len(e.Requirements))
+	for _, r := range e.Requirements {
+		lines = append(lines, fmt.Sprintf("%s requires go >= %s", r.Module, r.Go))
+	}
+	return fmt.Sprintf("Go toolchain %s is too old: %s", e.Running, strings.Join(lines, "; "))
+}
+
+// checkGoVersion returns a *GoVersionError if any module in the
+// module graph of env requires a newer Go toolchain than the one
+// that builds it, so that this fails fast rather than with compiler
+// errors after the build has started. Toolchains whose version is
+// not a release (e.g. development builds) are not checked.
+func (env environment) checkGoVersion(ctx context.Context) error {
+	if env.plan != nil {
+		// queries are not run when planning
+		return nil
+	}
+	running, err := env.goVersion(ctx)
+	if err != nil {
+		return err
+	}
+	if !semver.IsValid(goSemver(running)) {
+		env.log.Printf("[WARNING] Not checking Go version requirements of Go toolchain %s", running)
+		return nil
+	}
+
+	modules, err := env.listModules(ctx)
+	if err != nil {
+		return err
+	}
+	var requirements []GoRequirement
+	for _, mod := range modules {
+		name := mod.Path
+		if mod.Version != "" {
+			name += "@" + mod.Version
+		}
+		if mod.GoVersion != "" {
+			requirements = append(requirements, GoRequirement{Module: name, Go: mod.GoVersion})
+		}
+		if mod.Main {
+			// the toolchain directive only counts for the main module
+			if toolchain, err := env.toolchainDirective(); err != nil {
+				return err
+			} else if toolchain != "" {
+				requirements = append(requirements, GoRequirement{Module: name, Go: toolchain})
+			}
+		}
+	}
+
+	var unmet []GoRequirement
+	for _, r := range requirements {
+		if semver.Compare(goSemver(r.Go), goSemver(running)) > 0 {
+			unmet = append(unmet, r)
+		}
+	}
+	if len(unmet) > 0 {
+		return &GoVersionError{Running: running, Requirements: unmet}
+	}
+	return nil
+}
+
+// toolchainDirective returns the version in the toolchain
+// directive of the main module's go.mod, if there is one.
+func (env environment) toolchainDirective() (string, error) {
+	path := filepath.Join(env.tempFolder, "go.mod")
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	f, err := modfile.ParseLax(path, data, nil)
+	if err != nil {
+		return "", err
+	}
+	if f.Toolchain == nil || f.Toolchain.Name == "default" {
+		return "", nil
+	}
+	return strings.TrimPrefix(f.Toolchain.Name, "go"), nil
+}
+
+// goSemver converts a Go version such as "go1.22.1", "1.21",
+// or "1.21rc2" to the equivalent semantic version, e.g.
+// "v1.22.1", "v1.21.0", or "v1.21.0-rc2". The result is
+// not valid if version is not a Go release version.
+func goSemver(version string) string {
+	version = strings.TrimPrefix(version, "go")
+	release, pre := version, ""
+	if i := strings.IndexAny(version, "abcdefghijklmnopqrstuvwxyz"); i >= 0 {
+		release, pre = version[:i], version[i:]
+	}
+	for strings.Count(release, ".") < 2 {
+		release += ".0"
+	}
+	if pre != "" {
+		release += "-" + pre
+	}
+	return "v" + release
+}
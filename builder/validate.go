@@ -0,0 +1,397 @@
+package builder
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	msemver "github.com/Masterminds/semver/v3"
+	"golang.org/x/mod/modfile"
+	"golang.org/x/mod/module"
+	"golang.org/x/mod/semver"
+)
+
+// ValidationProblem is a problem with a setting of a Builder.
+type ValidationProblem struct {
+	// The JSON name of the setting, e.g. "plugins[1].version",
+	// or "output" for the output file.
+	Field string `json:"field"`
+
+	// What is wrong with it.
+	Problem string `json:"problem"`
+}
+
+func (p ValidationProblem) String() string {
+	return p.Field + ": " + p.Problem
+}
+
+// ValidationError is returned by Validate
+// with all problems of the configuration.
+type ValidationError struct {
+	Problems []ValidationProblem
+}
+
+func (e *ValidationError) Error() string {
+	lines := make([]string, 0, len(e.Problems))
+	for _, p := range e.Problems {
+		lines = append(lines, p.String())
+	}
+	return fmt.Sprintf("%d configuration problem(s): %s", len(e.Problems), strings.Join(lines, "; "))
+}
+
+// Validate checks the configuration of b without building anything,
+// and returns a *ValidationError with all the problems it finds, so
+// that they can be fixed at once rather than one failed build at a
+// time. It checks the syntax of module paths and versions, that
+// replacements and workspace modules exist and declare the right
+// modules, that options which exclude each other are not combined,
+// that the target platform is supported by the go command, and, if
+// outputFile is not empty, that the binary can be written there.
+// Problems that only resolving the dependencies reveals, such as
+// versions that do not exist, are not found. Settings that have no
+// effect, such as cgo flags without cgo, are only logged as warnings.
+func (b Builder) Validate(ctx context.Context, outputFile string) error {
+	b.setPlatformDefaults()
+	v := &validator{}
+
+	if outputFile != "" {
+		if absOutputFile, err := b.outputPath(outputFile); err != nil {
+			v.add("output", err)
+		} else {
+			v.add("output", checkOutputFile(absOutputFile))
+		}
+	}
+
+	v.add("caddy_version", checkVersionQuery(b.CaddyVersion))
+	if b.BaseModule != "" {
+		v.add("base_module", module.CheckPath(b.BaseModule))
+	}
+	if b.MainPackage != "" {
+		v.add("main_package", module.CheckImportPath(b.MainPackage))
+	}
+	v.add("main_module_path", module.CheckImportPath(b.mainModulePath()))
+	if b.GoDirective != "" && !modfile.GoVersionRE.MatchString(b.GoDirective) {
+		v.add("go_directive", fmt.Errorf("invalid language version %q; use a version such as 1.22", b.GoDirective))
+	}
+	if b.GoVersion != "" && !semver.IsValid(goSemver(goToolchain(b.GoVersion))) {
+		v.add("go_version", fmt.Errorf("invalid Go version %q, expected a version such as 1.22.1", b.GoVersion))
+	}
+	for i, p := range b.Plugins {
+		field := fmt.Sprintf("plugins[%d]", i)
+		v.add(field+".module_path", module.CheckImportPath(p.PackagePath))
+		v.add(field+".version", checkVersionQuery(p.Version))
+	}
+	for _, list := range []struct {
+		field string
+		deps  []Dependency
+	}{{"excludes", b.Excludes}, {"pins", b.Pins}} {
+		for i, d := range list.deps {
+			field := fmt.Sprintf("%s[%d]", list.field, i)
+			v.add(field+".module_path", module.CheckPath(d.PackagePath))
+			if d.Version == "" {
+				v.add(field+".version", fmt.Errorf("version is required"))
+			} else {
+				v.add(field+".version", checkVersionQuery(d.Version))
+			}
+		}
+	}
+	for i, r := range b.Replacements {
+		v.addAll(fmt.Sprintf("replacements[%d]", i), checkReplacement(r))
+	}
+	for i, pkg := range b.GeneratePackages {
+		v.add(fmt.Sprintf("generate_packages[%d]", i), module.CheckImportPath(pkg))
+	}
+	for i, dir := range b.Workspace {
+		_, _, err := checkWorkspaceModule(dir)
+		v.add(fmt.Sprintf("workspace[%d]", i), err)
+	}
+
+	v.addAll("", b.checkExclusiveOptions())
+
+	if b.OutputTemplate != "" {
+		_, err := b.outputName(b.CaddyVersion)
+		v.add("output_template", err)
+	}
+	v.add("cleanup_policy", func() error { _, err := b.cleanupPolicy(); return err }())
+	v.add("sbom_format", checkSBOMFormat(b.SBOMFormat))
+	v.add("go_experiment", checkGoExperiment(b.GoExperiment))
+	v.add("go_sum_db", checkSumDB(b.GoSumDB))
+	v.addAll("", checkProxies(b.GoProxies))
+	for _, p := range []struct{ field, patterns string }{
+		{"go_no_proxy", b.GoNoProxy},
+		{"go_private", b.GoPrivate},
+		{"go_no_sum_db", b.GoNoSumDB},
+		{"go_insecure", b.GoInsecure},
+	} {
+		v.add(p.field, checkModulePatterns(p.patterns))
+	}
+	if b.VerifyChecksums && b.GoSumDB == "off" {
+		v.add("verify_checksums", fmt.Errorf("cannot be combined with go_sum_db off"))
+	}
+	v.add("go_debug", checkGoDebug(b.GoDebug))
+	if err := checkBuildMode(b.BuildMode); err != nil {
+		v.add("build_mode", err)
+	} else {
+		v.addAll("", b.checkBuildModeOptions())
+	}
+	v.addAll("", b.checkWasmOptions())
+	v.addAll("", b.checkEmbeds())
+	if b.Compress != nil {
+		v.add("compress", b.Compress.check())
+	}
+	if b.SmokeTest != nil && b.SmokeTest.Sysroot != "" {
+		if info, err := os.Stat(b.SmokeTest.Sysroot); err != nil {
+			v.add("smoke_test.sysroot", err)
+		} else if !info.IsDir() {
+			v.add("smoke_test.sysroot", fmt.Errorf("%s is not a directory", b.SmokeTest.Sysroot))
+		}
+	}
+	if b.SizeBreakdown && b.StripSymbols {
+		v.add("size_breakdown", fmt.Errorf("cannot be combined with strip_symbols, which omits the symbol table"))
+	}
+	if b.Debug {
+		switch {
+		case b.StripSymbols:
+			v.add("debug", fmt.Errorf("cannot be combined with strip_symbols, which omits the DWARF debugging information"))
+		case b.Reproducible:
+			v.add("debug", fmt.Errorf("cannot be combined with reproducible, which builds with -trimpath"))
+		case b.Compress != nil:
+			v.add("debug", fmt.Errorf("cannot be combined with compress, as debuggers cannot read compressed binaries"))
+		}
+	} else if b.DebugDir != "" {
+		v.add("debug_dir", fmt.Errorf("requires debug"))
+	}
+	if b.MaxBinarySize < 0 {
+		v.add("max_binary_size", fmt.Errorf("must not be negative"))
+	}
+	if b.Prebuilt != nil && b.Prebuilt.URL == "" {
+		v.add("prebuilt.url", fmt.Errorf("the URL of the download service is required"))
+	}
+	if b.PGOProfile != "" {
+		if _, err := os.Stat(b.PGOProfile); err != nil {
+			v.add("pgo_profile", err)
+		}
+	}
+	if _, err := linkerVariables(b.LDFlagsX); err != nil {
+		v.add("ldflags_x", err)
+	}
+
+	// cgo flags without cgo are not a problem, as the build works,
+	// but likely a mistake; this and the platform check may run
+	// the go command, so they come last
+	var ignored []string
+	if b.Compile.CgoCFlags != "" {
+		ignored = append(ignored, "cgo_cflags")
+	}
+	if b.Compile.CgoLdFlags != "" {
+		ignored = append(ignored, "cgo_ldflags")
+	}
+	if len(ignored) > 0 && !b.usesCgo(b.validateGoVersion(ctx)) {
+		b.logger().Printf("[WARNING] %s: ignored because cgo is disabled; set cgo to use them", strings.Join(ignored, ", "))
+	}
+	if err := checkPlatform(ctx, b.Platform); err != nil {
+		var unsupported *UnsupportedPlatformError
+		if errors.As(err, &unsupported) {
+			err = fmt.Errorf("%s is not supported by the go command", strings.Trim(unsupported.Platform.key(), "/"))
+		}
+		v.add("platform", err)
+	}
+
+	if len(v.problems) > 0 {
+		return &ValidationError{Problems: v.problems}
+	}
+	return nil
+}
+
+// validateGoVersion returns the version of the toolchain that
+// FIPS builds of b use, which decides whether they require cgo,
+// or "" if b is not a FIPS build or the version is not known.
+func (b Builder) validateGoVersion(ctx context.Context) string {
+	if !b.FIPS {
+		return ""
+	}
+	if b.GoVersion != "" {
+		return goToolchain(b.GoVersion)
+	}
+	out, err := exec.CommandContext(ctx, GetGo(), "env", "GOVERSION").Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// validator collects the problems found by Validate.
+type validator struct {
+	problems []ValidationProblem
+}
+
+// add records err, if not nil, as a problem with field.
+func (v *validator) add(field string, err error) {
+	if err != nil {
+		v.problems = append(v.problems, ValidationProblem{Field: field, Problem: err.Error()})
+	}
+}
+
+// addAll records problems, with their fields below field.
+func (v *validator) addAll(field string, problems []ValidationProblem) {
+	for _, p := range problems {
+		if field != "" {
+			p.Field = field + p.Field
+		}
+		v.problems = append(v.problems, p)
+	}
+}
+
+// checkOutputFile returns an error if the binary cannot be
+// written to absOutputFile. Unlike prepareOutputFile, it does
+// not create any directories: the closest existing one must be
+// writable.
+func checkOutputFile(absOutputFile string) error {
+	if info, err := os.Stat(absOutputFile); err == nil && info.IsDir() {
+		return fmt.Errorf("%s is a directory; specify a file name", absOutputFile)
+	}
+	dir := filepath.Dir(absOutputFile)
+	for {
+		info, err := os.Stat(dir)
+		if err == nil {
+			if !info.IsDir() {
+				return fmt.Errorf("%s is not a directory", dir)
+			}
+			break
+		}
+		if !os.IsNotExist(err) || filepath.Dir(dir) == dir {
+			return err
+		}
+		dir = filepath.Dir(dir)
+	}
+	probe, err := os.CreateTemp(dir, ".goaway-write-check")
+	if err != nil {
+		return fmt.Errorf("directory %s is not writable: %v", dir, err)
+	}
+	probe.Close()
+	return os.Remove(probe.Name())
+}
+
+// checkVersionQuery returns an error if version is neither empty nor
+// syntactically a version query that `go get` or resolveVersion
+// accepts: a version, a constraint, or a keyword, branch name, or
+// commit hash.
+func checkVersionQuery(version string) error {
+	switch {
+	case version == "":
+		return nil
+	case isVersionConstraint(version):
+		if _, err := msemver.NewConstraint(version); err != nil {
+			return fmt.Errorf("invalid version constraint %q: %v", version, err)
+		}
+		return nil
+	case strings.HasPrefix(version, "-") || strings.ContainsAny(version, "@:\\\"'`\t\n"):
+		return fmt.Errorf("invalid version %q", version)
+	case strings.HasPrefix(version, "v") && semver.IsValid(version) && !isConcreteVersion(version):
+		// a prefix such as v1.2, which the go command resolves
+		return nil
+	case strings.HasPrefix(version, "v") && strings.Count(version, ".") == 2 && !semver.IsValid(version):
+		return fmt.Errorf("invalid semantic version %q", version)
+	}
+	return nil
+}
+
+// checkReplacement returns the problems with r, with fields
+// relative to the replacement. Local replacements must be
+// directories of the module they replace.
+func checkReplacement(r Replace) []ValidationProblem {
+	v := &validator{}
+	if r.Old == "" {
+		v.add(".old", fmt.Errorf("module path is required"))
+	} else if err := checkReplacementPath(r.Old, false); err != nil {
+		v.add(".old", err)
+	} else if path, version := r.Old.split(); version != "" {
+		// only the new version is resolved, so the
+		// old one must be as it is written in go.mod
+		v.add(".old", module.Check(path, version))
+	}
+	switch {
+	case r.New == "":
+		v.add(".new", fmt.Errorf("replacement is required"))
+	case r.New.IsLocal():
+		if r.Old != "" {
+			_, err := checkLocalReplacement(r)
+			v.add(".new", err)
+		}
+	default:
+		v.add(".new", checkReplacementPath(r.New, true))
+	}
+	return v.problems
+}
+
+// checkReplacementPath returns an error if r is not a module path
+// with an optional version, which is required if needVersion.
+func checkReplacementPath(r ReplacementPath, needVersion bool) error {
+	path, version := r.split()
+	if err := module.CheckPath(path); err != nil {
+		return err
+	}
+	if version == "" {
+		if needVersion {
+			return fmt.Errorf("replacement module %s needs a version", path)
+		}
+		return nil
+	}
+	return checkVersionQuery(version)
+}
+
+// checkExclusiveOptions returns the problems with options of b
+// that exclude each other or have no effect together.
+func (b Builder) checkExclusiveOptions() []ValidationProblem {
+	v := &validator{}
+	if b.SkipBuild {
+		for _, opt := range []struct {
+			field string
+			set   bool
+		}{
+			{"race_detector", b.RaceDetector},
+			{"memory_sanitizer", b.MemorySanitizer},
+			{"address_sanitizer", b.AddressSanitizer},
+			{"coverage", b.Coverage},
+			{"compress", b.Compress != nil},
+			{"smoke_test", b.SmokeTest != nil},
+			{"sbom_format", b.SBOMFormat != ""},
+			{"provenance", b.Provenance != nil},
+			{"macos_sign", b.MacOSSign != nil},
+		} {
+			if opt.set {
+				v.add(opt.field, fmt.Errorf("has no effect with skip_build, which builds no binary"))
+			}
+		}
+	}
+	v.addAll("", b.checkSanitizers())
+	if b.Static && b.Compile.Cgo && b.targetOS() == "darwin" {
+		v.add("static", fmt.Errorf("macOS does not support statically linked binaries with cgo"))
+	}
+	if b.Checkpoint != "" && len(b.Workspace) > 0 {
+		v.add("checkpoint", fmt.Errorf("workspace builds cannot be resumed"))
+	}
+	if b.Vendor && len(b.Workspace) > 0 {
+		v.add("vendor", fmt.Errorf("vendoring is not supported for workspace builds"))
+	}
+	if b.Offline && b.GoProxy != "" && b.GoProxy != "off" {
+		v.add("offline", fmt.Errorf("cannot be combined with go_proxy %s", b.GoProxy))
+	}
+	if b.Offline && len(b.GoProxies) > 0 {
+		v.add("offline", fmt.Errorf("cannot be combined with go_proxies"))
+	}
+	if b.GoProxy != "" && len(b.GoProxies) > 0 {
+		v.add("go_proxies", fmt.Errorf("cannot be combined with go_proxy"))
+	}
+	if b.Hardened && b.BuildMode == BuildModeExe {
+		v.add("hardened", fmt.Errorf("cannot be combined with build_mode exe, which is not position independent"))
+	}
+	if b.MainTemplateFS != nil && b.MainTemplate == "" {
+		v.add("main_template", fmt.Errorf("the path of the template in MainTemplateFS is required"))
+	}
+	return v.problems
+}
@@ -0,0 +1,52 @@
+package builder
+
+import (
+	"context"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func TestValidateWarnsAboutCgoFlags(t *testing.T) {
+	if _, err := exec.LookPath(GetGo()); err != nil {
+		t.Skipf("go command not found: %v", err)
+	}
+	flags := Compile{CgoCFlags: "-O3", CgoLdFlags: "-lm"}
+	for _, tt := range []struct {
+		name string
+		b    Builder
+		want string
+	}{
+		{"no flags", Builder{}, ""},
+		{"cflags", Builder{Compile: Compile{CgoCFlags: "-O3"}}, "cgo_cflags: ignored because cgo is disabled"},
+		{"both", Builder{Compile: flags}, "cgo_cflags, cgo_ldflags: ignored"},
+		{"ldflags", Builder{Compile: Compile{CgoLdFlags: "-lm"}}, "cgo_ldflags: ignored"},
+		{"cgo enabled", Builder{Compile: Compile{Cgo: true, CgoCFlags: "-O3", CgoLdFlags: "-lm"}}, ""},
+		// cgo is enabled for them when they are built
+		{"race detector", Builder{Compile: Compile{Platform: Platform{OS: "linux", Arch: "amd64"}, CgoCFlags: "-O3"}, RaceDetector: true}, ""},
+		{"c-shared build mode", Builder{Compile: flags, BuildMode: BuildModeCShared}, ""},
+		{"FIPS with BoringCrypto", Builder{Compile: flags, FIPS: true, GoVersion: "1.22.1"}, ""},
+		{"FIPS with the Go Cryptographic Module", Builder{Compile: flags, FIPS: true, GoVersion: "1.24.0"}, "cgo_cflags, cgo_ldflags: ignored"},
+		// which has no cgo even if it is set
+		{"WebAssembly", Builder{Compile: Compile{Platform: Platform{OS: "wasip1", Arch: "wasm"}, Cgo: true, CgoCFlags: "-O3"}}, "cgo_cflags: ignored"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			logger := &recordingLogger{}
+			b := tt.b
+			b.Logger = logger
+			if err := b.Validate(context.Background(), ""); err != nil {
+				t.Fatalf("ignored cgo flags are not a problem: %v", err)
+			}
+			warnings := logger.Messages("[WARNING]")
+			if tt.want == "" {
+				if len(warnings) > 0 {
+					t.Errorf("unexpected warnings: %q", warnings)
+				}
+				return
+			}
+			if len(warnings) != 1 || !strings.Contains(warnings[0], tt.want) {
+				t.Errorf("warnings %q, want one containing %q", warnings, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,128 @@
+package builder
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/mod/semver"
+)
+
+// VerifiedModule describes a requested module as
+// it was found in the build information of a binary.
+type VerifiedModule struct {
+	// The package path of the plugin, or the
+	// path of the Caddy module, as requested.
+	Package string `json:"package,omitempty"`
+
+	// The module that provides Package in the binary.
+	Module string `json:"module,omitempty"`
+
+	// The version that was requested, after resolving queries.
+	// Empty if the latest version was requested.
+	Requested string `json:"requested,omitempty"`
+
+	// The version of Module in the binary.
+	Version string `json:"version,omitempty"`
+
+	// The replacement of Module in the binary, if any, as a
+	// module path and version or a directory.
+	Replace ReplacementPath `json:"replace,omitempty"`
+
+	// Why the module failed verification, if it did.
+	Problem string `json:"problem,omitempty"`
+}
+
+// VerificationError is returned when the binary does not contain
+// a requested module at the requested version, such as when
+// `go mod tidy` dropped a plugin or another module downgraded it.
+type VerificationError struct {
+	Modules []VerifiedModule
+}
+
+func (e *VerificationError) Error() string {
+	lines := make([]string, 0, len(e.Modules))
+	for _, m := range e.Modules {
+		lines = append(lines, m.Package+": "+m.Problem)
+	}
+	return fmt.Sprintf("%d module(s) failed verification: %s", len(e.Modules), strings.Join(lines, "; "))
+}
+
+// VerifyBinary checks that the binary at path, such as one built
+// earlier or downloaded, contains the base module and every plugin
+// of b at the versions that b requests, as Build checks after
+// compiling, and returns what it found. Modules requested by a
+// version query, such as "latest", may be at any version.
+func (b Builder) VerifyBinary(path string) ([]VerifiedModule, error) {
+	info, err := ReadBuildInfo(path)
+	if err != nil {
+		return nil, err
+	}
+	result := &BuildResult{OutputFile: path}
+	result.setBuildInfo(info)
+	caddyModulePath, _ := b.baseModule()
+	env := &environment{caddyModulePath: caddyModulePath, caddyVersion: b.CaddyVersion}
+	return b.verifyBinary(env, result)
+}
+
+// verifyBinary checks that the build information of the binary
+// of result contains the Caddy module and every plugin at the
+// version that was requested or resolved in buildEnv, and returns
+// what it found. Modules at a newer version than requested, which
+// minimal version selection allows, are only logged.
+func (b Builder) verifyBinary(buildEnv *environment, result *BuildResult) ([]VerifiedModule, error) {
+	if result.GoVersion == "" {
+		// the binary has no build information to verify
+		return nil, nil
+	}
+	replacements := make(map[string]ReplacementPath)
+	for _, r := range result.Replacements {
+		replacements[r.Old.String()] = r.New
+	}
+	wanted := []VerifiedModule{{
+		Package:   buildEnv.caddyModulePath,
+		Requested: buildEnv.requestedVersion(buildEnv.caddyModulePath, buildEnv.caddyVersion),
+	}}
+	for _, p := range b.Plugins {
+		wanted = append(wanted, VerifiedModule{Package: p.PackagePath, Requested: buildEnv.requestedVersion(p.PackagePath, p.Version)})
+	}
+
+	var verified, failed []VerifiedModule
+	for _, m := range wanted {
+		// the longest module path that contains
+		// the package is the module that provides it
+		var provider *Dependency
+		for i, dep := range result.Modules {
+			if m.Package != dep.PackagePath && !strings.HasPrefix(m.Package, dep.PackagePath+"/") {
+				continue
+			}
+			if provider == nil || len(dep.PackagePath) > len(provider.PackagePath) {
+				provider = &result.Modules[i]
+			}
+		}
+		if provider == nil {
+			m.Problem = "not compiled into the binary"
+			failed = append(failed, m)
+			continue
+		}
+		m.Module, m.Version = provider.PackagePath, provider.Version
+		if r, ok := replacements[provider.PackagePath+" "+provider.Version]; ok {
+			// the replacement determines the code, not the version
+			m.Replace = r
+			verified = append(verified, m)
+			continue
+		}
+		if m.Requested != "" && m.Version != m.Requested {
+			if semver.Compare(m.Version, m.Requested) < 0 {
+				m.Problem = fmt.Sprintf("requested %s, but the binary has %s", m.Requested, m.Version)
+				failed = append(failed, m)
+				continue
+			}
+			b.logger().Printf("[WARNING] %s was requested at %s, but another module upgraded it to %s", m.Package, m.Requested, m.Version)
+		}
+		verified = append(verified, m)
+	}
+	if len(failed) > 0 {
+		return nil, &VerificationError{Modules: failed}
+	}
+	return verified, nil
+}
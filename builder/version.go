@@ -0,0 +1,223 @@
+package builder
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	msemver "github.com/Masterminds/semver/v3"
+	"golang.org/x/mod/semver"
+)
+
+// ResolvedVersion records a version query, such as "latest", a
+// constraint, a branch name, or a commit hash, and the concrete
+// version (possibly a pseudo-version) it was resolved to.
+type ResolvedVersion struct {
+	// The module or package path the query was for.
+	Path string `json:"path,omitempty"`
+
+	// The version as it was requested.
+	Query string `json:"query,omitempty"`
+
+	// The concrete version that was used.
+	Version string `json:"version,omitempty"`
+}
+
+// isConcreteVersion returns true if version is a complete
+// semantic version, as opposed to a query that the go
+// command has to resolve, such as "v1", "master", or a
+// commit hash.
+func isConcreteVersion(version string) bool {
+	return semver.IsValid(version) &&
+		semver.Canonical(version) == strings.TrimSuffix(version, "+incompatible")
+}
+
+// isVersionConstraint returns true if version is a
+// semantic version constraint such as "~2.8", ">= 2.7,
+// < 3", or "2.x" rather than a single version.
+func isVersionConstraint(version string) bool {
+	return strings.ContainsAny(version, "~^<>=*|, ") ||
+		strings.HasSuffix(version, ".x") || strings.HasSuffix(version, ".X")
+}
+
+// resolveVersion resolves version of the module at modulePath to a
+// concrete version: a constraint resolves to the newest version
+// listed by the module proxy that satisfies it, and any other query,
+// such as "latest", a branch name, or a commit hash, is resolved by
+// the go command, possibly to a pseudo-version. Concrete and empty
+// versions are returned as is.
+func (env environment) resolveVersion(ctx context.Context, modulePath, version string) (string, error) {
+	if env.plan != nil {
+		// queries are not run when planning
+		return version, nil
+	}
+	switch {
+	case version == "" || isConcreteVersion(version):
+		return version, nil
+	case isVersionConstraint(version):
+		constraint, err := msemver.NewConstraint(version)
+		if err != nil {
+			return "", fmt.Errorf("invalid version constraint %q for %s: %v", version, modulePath, err)
+		}
+		info, err := env.listModule(ctx, "-versions", modulePath)
+		if err != nil {
+			return "", err
+		}
+		var newest *msemver.Version
+		var resolved string
+		for _, v := range info.Versions {
+			sv, err := msemver.NewVersion(v)
+			if err != nil || !constraint.Check(sv) {
+				continue
+			}
+			if newest == nil || sv.GreaterThan(newest) {
+				newest, resolved = sv, v
+			}
+		}
+		if resolved == "" {
+			return "", fmt.Errorf("no version of %s satisfies %q", modulePath, version)
+		}
+		return resolved, nil
+	default:
+		// with -e, as the go.mod files of forks declare the module
+		// path of the original, which the query does not care about
+		info, err := env.listModule(ctx, "-e", modulePath+"@"+version)
+		if err == nil && info.Error != nil && !isConcreteVersion(info.Version) {
+			err = errors.New(info.Error.Err)
+		}
+		if err != nil {
+			return "", fmt.Errorf("resolving %s@%s: %v", modulePath, version, err)
+		}
+		return info.Version, nil
+	}
+}
+
+// recordResolved logs and records that version of path was
+// resolved to resolved, unless the two are the same.
+func (env *environment) recordResolved(path, version, resolved string) {
+	if resolved == version {
+		return
+	}
+	env.log.Printf("[INFO] Resolved %s@%s to %s", path, version, resolved)
+	env.resolved = append(env.resolved, ResolvedVersion{Path: path, Query: version, Version: resolved})
+}
+
+// requestedVersion returns the concrete version that version of
+// path was requested at, after resolving queries, or "" for the
+// latest version.
+func (env environment) requestedVersion(path, version string) string {
+	for _, r := range env.resolved {
+		if r.Path == path {
+			return r.Version
+		}
+	}
+	if isConcreteVersion(version) {
+		return version
+	}
+	return ""
+}
+
+// recordResolvedPlugins records the versions that the go command
+// selected for plugins whose version was a query rather than a
+// concrete version, from the modules that provide their packages.
+func (env *environment) recordResolvedPlugins(ctx context.Context, plugins []Dependency) error {
+	if env.plan != nil {
+		// queries are not run when planning
+		return nil
+	}
+	var queried []Dependency
+	for _, p := range plugins {
+		if p.Version != "" && !isConcreteVersion(p.Version) {
+			queried = append(queried, p)
+		}
+	}
+	if len(queried) == 0 {
+		return nil
+	}
+	modules, err := env.listModules(ctx)
+	if err != nil {
+		return err
+	}
+	for _, p := range queried {
+		if provider := moduleProviding(modules, p.PackagePath); provider != nil {
+			env.recordResolved(p.PackagePath, p.Version, provider.Version)
+		}
+	}
+	return nil
+}
+
+// listedModule is the subset of the output
+// of `go list -m -json` that the builder uses.
+type listedModule struct {
+	Path      string
+	Version   string
+	Versions  []string
+	GoVersion string
+	GoMod     string
+	Main      bool
+	Replace   *listedModule
+	Error     *struct{ Err string }
+}
+
+// moduleProviding returns the module of modules that provides
+// the package packagePath, which is the one with the longest
+// module path that contains it, or nil if there is none.
+func moduleProviding(modules []listedModule, packagePath string) *listedModule {
+	var provider *listedModule
+	for i, m := range modules {
+		if packagePath != m.Path && !strings.HasPrefix(packagePath, m.Path+"/") {
+			continue
+		}
+		if provider == nil || len(m.Path) > len(provider.Path) {
+			provider = &modules[i]
+		}
+	}
+	return provider
+}
+
+// listModule runs `go list -m -json` with the given
+// arguments in env and returns the module it reports.
+func (env environment) listModule(ctx context.Context, args ...string) (*listedModule, error) {
+	var out bytes.Buffer
+	_, err := env.runFetchCommand(ctx, func() *exec.Cmd {
+		out.Reset()
+		cmd := env.newCommand(ctx, GetGo(), append([]string{"list", "-m", "-json"}, args...)...)
+		cmd.Stdout = &out
+		return cmd
+	})
+	if err != nil {
+		return nil, err
+	}
+	var info listedModule
+	if err := json.Unmarshal(out.Bytes(), &info); err != nil {
+		return nil, fmt.Errorf("decoding module information: %v", err)
+	}
+	return &info, nil
+}
+
+// listModules returns all modules in the module graph of env.
+func (env environment) listModules(ctx context.Context) ([]listedModule, error) {
+	var out bytes.Buffer
+	_, err := env.runFetchCommand(ctx, func() *exec.Cmd {
+		out.Reset()
+		cmd := env.newCommand(ctx, GetGo(), "list", "-m", "-json", "all")
+		cmd.Stdout = &out
+		return cmd
+	})
+	if err != nil {
+		return nil, err
+	}
+	var modules []listedModule
+	for dec := json.NewDecoder(&out); dec.More(); {
+		var m listedModule
+		if err := dec.Decode(&m); err != nil {
+			return nil, fmt.Errorf("decoding module list: %v", err)
+		}
+		modules = append(modules, m)
+	}
+	return modules, nil
+}
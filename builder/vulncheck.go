@@ -0,0 +1,156 @@
+package builder
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// VulnPolicy configures the govulncheck gate that
+// runs against the build environment before compiling.
+type VulnPolicy struct {
+	// Fail makes any reported vulnerability fail the
+	// build; otherwise vulnerabilities are only logged.
+	Fail bool `json:"fail,omitempty"`
+
+	// IncludeUncalled also reports vulnerabilities in required
+	// modules or imported packages whose vulnerable code is
+	// never called. By default only called code counts.
+	IncludeUncalled bool `json:"include_uncalled,omitempty"`
+
+	// Command is the govulncheck executable. Default: govulncheck
+	Command string `json:"command,omitempty"`
+}
+
+// Vulnerability is a known vulnerability reported by govulncheck.
+type Vulnerability struct {
+	ID      string `json:"id,omitempty"`
+	Summary string `json:"summary,omitempty"`
+	Module  string `json:"module,omitempty"`
+	Version string `json:"version,omitempty"`
+	Called  bool   `json:"called,omitempty"`
+}
+
+func (v Vulnerability) String() string {
+	s := fmt.Sprintf("%s in %s@%s", v.ID, v.Module, v.Version)
+	if v.Summary != "" {
+		s += ": " + v.Summary
+	}
+	return s
+}
+
+// VulnerabilityError is returned when the vulnerability
+// policy fails the build.
+type VulnerabilityError struct {
+	Vulnerabilities []Vulnerability
+}
+
+func (e *VulnerabilityError) Error() string {
+	ids := make([]string, 0, len(e.Vulnerabilities))
+	for _, v := range e.Vulnerabilities {
+		ids = append(ids, v.ID)
+	}
+	return fmt.Sprintf("found %d known vulnerabilities: %s", len(ids), strings.Join(ids, ", "))
+}
+
+// checkVulnerabilities runs govulncheck in env according to
+// policy, logs every finding, and returns an error if the
+// policy requires the build to fail.
+func (env environment) checkVulnerabilities(ctx context.Context, policy VulnPolicy, tags []string) error {
+	command := policy.Command
+	if command == "" {
+		command = "govulncheck"
+	}
+	env.log.Printf("[INFO] Checking for known vulnerabilities")
+
+	var out bytes.Buffer
+	cmd := env.newCommand(ctx, command, "-json")
+	if len(tags) > 0 {
+		cmd.Args = append(cmd.Args, "-tags", strings.Join(tags, ","))
+	}
+	cmd.Args = append(cmd.Args, "./...")
+	cmd.Stdout = &out
+	if err := env.runCommand(ctx, cmd); err != nil {
+		return fmt.Errorf("running %s: %v", command, err)
+	}
+	vulns, err := parseGovulncheck(&out)
+	if err != nil {
+		return err
+	}
+
+	var reported []Vulnerability
+	for _, v := range vulns {
+		if !v.Called && !policy.IncludeUncalled {
+			continue
+		}
+		env.log.Printf("[WARNING] Vulnerability: %s", v)
+		reported = append(reported, v)
+	}
+	if policy.Fail && len(reported) > 0 {
+		return &VulnerabilityError{Vulnerabilities: reported}
+	}
+	return nil
+}
+
+// parseGovulncheck reads the JSON message stream written by
+// `govulncheck -json` and returns one entry per vulnerability.
+func parseGovulncheck(r io.Reader) ([]Vulnerability, error) {
+	type frame struct {
+		Module   string `json:"module"`
+		Version  string `json:"version"`
+		Function string `json:"function"`
+	}
+	type message struct {
+		OSV *struct {
+			ID      string `json:"id"`
+			Summary string `json:"summary"`
+		} `json:"osv"`
+		Finding *struct {
+			OSV   string  `json:"osv"`
+			Trace []frame `json:"trace"`
+		} `json:"finding"`
+	}
+
+	summaries := make(map[string]string)
+	found := make(map[string]*Vulnerability)
+	dec := json.NewDecoder(r)
+	for {
+		var msg message
+		if err := dec.Decode(&msg); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("decoding govulncheck output: %v", err)
+		}
+		if msg.OSV != nil {
+			summaries[msg.OSV.ID] = msg.OSV.Summary
+		}
+		if msg.Finding == nil || len(msg.Finding.Trace) == 0 {
+			continue
+		}
+		f := msg.Finding
+		v, ok := found[f.OSV]
+		if !ok {
+			v = &Vulnerability{
+				ID:      f.OSV,
+				Module:  f.Trace[0].Module,
+				Version: f.Trace[0].Version,
+			}
+			found[f.OSV] = v
+		}
+		if f.Trace[0].Function != "" {
+			v.Called = true
+		}
+	}
+
+	vulns := make([]Vulnerability, 0, len(found))
+	for id, v := range found {
+		v.Summary = summaries[id]
+		vulns = append(vulns, *v)
+	}
+	sort.Slice(vulns, func(i, j int) bool { return vulns[i].ID < vulns[j].ID })
+	return vulns, nil
+}
@@ -0,0 +1,125 @@
+package builder
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime/debug"
+)
+
+// wasmExecFile is the JavaScript support file of the Go
+// toolchain that runs modules built for js/wasm.
+const wasmExecFile = "wasm_exec.js"
+
+// isWasm reports whether b builds for WebAssembly,
+// i.e. for js/wasm or wasip1/wasm.
+func (b Builder) isWasm() bool {
+	return b.targetArch() == "wasm"
+}
+
+// wasmSettings adjusts the settings of b that WebAssembly
+// targets do not support, with a warning for each: they have
+// neither cgo nor the race detector.
+func (b *Builder) wasmSettings() {
+	if b.RaceDetector {
+		b.logger().Printf("[WARNING] Disabling the race detector, which %s/wasm does not support", b.targetOS())
+		b.RaceDetector = false
+	}
+	if b.Compile.Cgo {
+		b.logger().Printf("[WARNING] Disabling cgo, which %s/wasm does not support", b.targetOS())
+		b.Compile.Cgo = false
+	}
+}
+
+// checkWasmOptions returns the problems with options
+// of b that do not work with its target.
+func (b Builder) checkWasmOptions() []ValidationProblem {
+	v := &validator{}
+	if b.WasmExec && (!b.isWasm() || b.targetOS() != "js") {
+		v.add("wasm_exec", fmt.Errorf("only builds for js/wasm need %s", wasmExecFile))
+	}
+	if !b.isWasm() {
+		return v.problems
+	}
+	if b.Compress != nil {
+		v.add("compress", fmt.Errorf("UPX does not support wasm"))
+	}
+	if b.Static {
+		v.add("static", fmt.Errorf("has no effect for wasm, which links no C library"))
+	}
+	return v.problems
+}
+
+// copyWasmExec copies wasm_exec.js of the Go toolchain
+// that builds in env next to absOutputFile, and returns
+// the path of the copy.
+func (env environment) copyWasmExec(ctx context.Context, absOutputFile string) (string, error) {
+	goroot, err := env.goEnvValue(ctx, "GOROOT")
+	if err != nil {
+		return "", err
+	}
+	dst := filepath.Join(filepath.Dir(absOutputFile), wasmExecFile)
+	// lib/wasm since Go 1.24, misc/wasm before
+	for _, dir := range []string{"lib", "misc"} {
+		src := filepath.Join(goroot, dir, "wasm", wasmExecFile)
+		if env.executor != nil {
+			// the toolchain is on the executor, which only shares
+			// the build environment, so the file is copied there
+			shared := filepath.Join(env.tempFolder, executorOutputFolder, wasmExecFile)
+			if env.runCommand(ctx, env.newCommand(ctx, "cp", src, shared)) != nil {
+				continue
+			}
+			src = shared
+		} else if _, err := os.Stat(src); err != nil {
+			continue
+		}
+		if err := copyFile(src, dst, 0644); err != nil {
+			return "", err
+		}
+		return dst, nil
+	}
+	return "", fmt.Errorf("%s not found in GOROOT %s", wasmExecFile, goroot)
+}
+
+// The sentinels that frame the module information in
+// binaries, and the Go version among the data of a module.
+var (
+	modInfoStart  = []byte("0w\xaf\x0c\x92t\x08\x02A\xe1\xc1\x07\xe6\xd6\x18\xe6")
+	modInfoEnd    = []byte("\xf92C1\x86\x18 r\x00\x82B\x10A\x16\xd8\xf2")
+	wasmGoVersion = regexp.MustCompile(`\x00(go1\.[0-9]+(?:\.[0-9]+)?(?:(?:rc|beta)[0-9]+)?)\x00`)
+)
+
+// readWasmBuildInfo reads the build information from the data of
+// the WebAssembly module at path. Unlike other binaries, modules
+// have no header that locates it, so the module information is
+// found by its sentinels and the Go version is the first string
+// in the data that is one.
+func readWasmBuildInfo(path string) (*debug.BuildInfo, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.HasPrefix(data, []byte("\x00asm")) {
+		return nil, fmt.Errorf("%s is not a WebAssembly module", path)
+	}
+	start := bytes.Index(data, modInfoStart)
+	if start < 0 {
+		return nil, fmt.Errorf("%s has no module information", path)
+	}
+	mod := data[start+len(modInfoStart):]
+	end := bytes.Index(mod, modInfoEnd)
+	if end < 0 {
+		return nil, fmt.Errorf("%s has truncated module information", path)
+	}
+	bi, err := debug.ParseBuildInfo(string(mod[:end]))
+	if err != nil {
+		return nil, err
+	}
+	if m := wasmGoVersion.FindSubmatch(data); m != nil {
+		bi.GoVersion = string(m[1])
+	}
+	return bi, nil
+}
@@ -0,0 +1,185 @@
+package builder
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// watchDebounce is how long Watch waits for further changes
+// after a change before it rebuilds, so that saving several
+// files at once results in a single build.
+const watchDebounce = 250 * time.Millisecond
+
+// Watch builds b to outputFile, then watches the directories of
+// all local replacements and rebuilds whenever their sources
+// change, calling onBuild with the outcome of every build. Builds
+// after the first reuse the prepared build environment, so only
+// the changed packages are recompiled; a change to a go.mod or
+// go.sum file prepares a new environment. On failed builds,
+// onBuild gets a zero BuildResult.
+//
+// Watch blocks until ctx is done and then returns ctx.Err(), or
+// an error if there are no local replacements to watch.
+func (b Builder) Watch(ctx context.Context, outputFile string, onBuild func(BuildResult, error)) error {
+	dirs, err := b.localReplacementDirs()
+	if err != nil {
+		return err
+	}
+	if len(dirs) == 0 {
+		return fmt.Errorf("no local replacements to watch")
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("creating file watcher: %v", err)
+	}
+	defer watcher.Close()
+	for _, dir := range dirs {
+		if err := watchTree(watcher, dir); err != nil {
+			return err
+		}
+		b.logger().Printf("[INFO] Watching %s", dir)
+	}
+
+	var prepared *Environment
+	defer func() {
+		if prepared != nil {
+			_ = prepared.Close()
+		}
+	}()
+	build := func(reprepare bool) {
+		if reprepare && prepared != nil {
+			_ = prepared.Close()
+			prepared = nil
+		}
+		if prepared == nil {
+			env, err := b.PrepareEnvironment(ctx)
+			if err != nil {
+				onBuild(BuildResult{}, err)
+				return
+			}
+			prepared = env
+		}
+		result, err := prepared.Build(ctx, outputFile)
+		if err != nil {
+			onBuild(BuildResult{}, err)
+			return
+		}
+		onBuild(*result, nil)
+	}
+	build(true)
+
+	// changes are collected until none have arrived for
+	// watchDebounce, then the build is run once for all
+	var (
+		debounce  <-chan time.Time
+		changed   []string
+		reprepare bool
+	)
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return fmt.Errorf("file watcher closed")
+			}
+			b.logger().Printf("[WARNING] Watching for changes: %v", err)
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return fmt.Errorf("file watcher closed")
+			}
+			if event.Op == fsnotify.Chmod || isIgnoredPath(event.Name) {
+				continue
+			}
+			if event.Op&fsnotify.Create != 0 {
+				// new directories are not watched automatically
+				if info, err := os.Stat(event.Name); err == nil && info.IsDir() {
+					if err := watchTree(watcher, event.Name); err != nil {
+						b.logger().Printf("[WARNING] Watching %s: %v", event.Name, err)
+					}
+				}
+			}
+			switch filepath.Base(event.Name) {
+			case "go.mod", "go.sum":
+				reprepare = true
+			}
+			if !containsString(changed, event.Name) {
+				changed = append(changed, event.Name)
+			}
+			debounce = time.After(watchDebounce)
+		case <-debounce:
+			b.logger().Printf("[INFO] Rebuilding after changes to %s", strings.Join(changed, ", "))
+			build(reprepare)
+			debounce, changed, reprepare = nil, nil, false
+		}
+	}
+}
+
+// localReplacementDirs returns the absolute paths
+// of the directories of all local replacements
+// and workspace modules.
+func (b Builder) localReplacementDirs() ([]string, error) {
+	var dirs []string
+	for _, r := range b.Replacements {
+		if !r.New.IsLocal() {
+			continue
+		}
+		dir, err := checkLocalReplacement(r)
+		if err != nil {
+			return nil, err
+		}
+		dirs = append(dirs, dir)
+	}
+	for _, dir := range b.Workspace {
+		absPath, _, err := checkWorkspaceModule(dir)
+		if err != nil {
+			return nil, err
+		}
+		dirs = append(dirs, absPath)
+	}
+	return dirs, nil
+}
+
+// watchTree adds root and all directories below it to watcher,
+// except for hidden directories such as .git.
+func watchTree(watcher *fsnotify.Watcher, root string) error {
+	return filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		if path != root && isIgnoredPath(path) {
+			return filepath.SkipDir
+		}
+		if err := watcher.Add(path); err != nil {
+			return fmt.Errorf("watching %s: %v", path, err)
+		}
+		return nil
+	})
+}
+
+// isIgnoredPath returns true for hidden files and directories,
+// which include version control metadata and editor swap files.
+func isIgnoredPath(path string) bool {
+	name := filepath.Base(path)
+	return strings.HasPrefix(name, ".") || strings.HasSuffix(name, "~")
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,408 @@
+package builder
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// WindowsResources are the resources to embed into binaries for
+// windows: an icon, version information, and a manifest, which
+// Explorer shows in the properties of the binary. They are
+// compiled into a .syso file in the build environment before
+// `go build`, which links it into the binary.
+type WindowsResources struct {
+	// Icon, if set, is the path of an .ico file.
+	Icon string `json:"icon,omitempty"`
+
+	// Manifest, if set, is the path of an application manifest.
+	Manifest string `json:"manifest,omitempty"`
+
+	// FileVersion and ProductVersion are versions of up to four
+	// numbers, such as "2.7.6" or "2.7.6.0". The default of
+	// FileVersion is the version of Caddy that is built, if it
+	// is a semantic version, and the default of ProductVersion
+	// is FileVersion.
+	FileVersion    string `json:"file_version,omitempty"`
+	ProductVersion string `json:"product_version,omitempty"`
+
+	// The strings of the version information.
+	CompanyName      string `json:"company_name,omitempty"`
+	ProductName      string `json:"product_name,omitempty"`
+	FileDescription  string `json:"file_description,omitempty"`
+	LegalCopyright   string `json:"legal_copyright,omitempty"`
+	InternalName     string `json:"internal_name,omitempty"`
+	OriginalFilename string `json:"original_filename,omitempty"`
+	Comments         string `json:"comments,omitempty"`
+}
+
+// Types of Windows resources.
+const (
+	rtIcon      = 3
+	rtGroupIcon = 14
+	rtVersion   = 16
+	rtManifest  = 24
+)
+
+// winresLanguage is the language of the resources, U.S. English,
+// with the Unicode code page in the version information.
+const (
+	winresLanguage = 0x0409
+	winresCodePage = 0x04b0
+)
+
+// coffMachines are the COFF machine types and the relocation type
+// for image-relative addresses of the windows architectures.
+var coffMachines = map[string]struct{ machine, reloc uint16 }{
+	"386":   {0x14c, 7},
+	"amd64": {0x8664, 3},
+	"arm":   {0x1c4, 2},
+	"arm64": {0xaa64, 2},
+}
+
+// writeWindowsResources compiles res into a .syso file for arch in
+// dir and returns its path. caddyVersion is the default version.
+func writeWindowsResources(res WindowsResources, dir, arch, caddyVersion string) (string, error) {
+	machine, ok := coffMachines[arch]
+	if !ok {
+		return "", fmt.Errorf("windows resources are not supported on %s", arch)
+	}
+	resources, err := res.resources(caddyVersion)
+	if err != nil {
+		return "", err
+	}
+	syso := filepath.Join(dir, "goaway_resources_windows_"+arch+".syso")
+	return syso, os.WriteFile(syso, coffResources(resources, machine.machine, machine.reloc), 0644)
+}
+
+type winResource struct {
+	typ, id uint32
+	data    []byte
+}
+
+// resources returns the resources of res.
+func (res WindowsResources) resources(caddyVersion string) ([]winResource, error) {
+	var resources []winResource
+	if res.Icon != "" {
+		icons, err := iconResources(res.Icon)
+		if err != nil {
+			return nil, fmt.Errorf("icon %s: %v", res.Icon, err)
+		}
+		resources = append(resources, icons...)
+	}
+	if res.Manifest != "" {
+		manifest, err := os.ReadFile(res.Manifest)
+		if err != nil {
+			return nil, err
+		}
+		resources = append(resources, winResource{rtManifest, 1, manifest})
+	}
+
+	fileVersion := res.FileVersion
+	if fileVersion == "" {
+		fileVersion = strings.SplitN(strings.SplitN(strings.TrimPrefix(caddyVersion, "v"), "-", 2)[0], "+", 2)[0]
+		if _, err := parseWindowsVersion(fileVersion); err != nil {
+			fileVersion = ""
+		}
+	}
+	productVersion := res.ProductVersion
+	if productVersion == "" {
+		productVersion = fileVersion
+	}
+	version, err := versionInfo(res, fileVersion, productVersion)
+	if err != nil {
+		return nil, err
+	}
+	return append(resources, winResource{rtVersion, 1, version}), nil
+}
+
+// iconResources returns the icon resources of the .ico file: one
+// for each of its images, and a group of them.
+func iconResources(file string) ([]winResource, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	le := binary.LittleEndian
+	if len(data) < 6 || le.Uint16(data[0:]) != 0 || le.Uint16(data[2:]) != 1 {
+		return nil, fmt.Errorf("not an icon file")
+	}
+	count := int(le.Uint16(data[4:]))
+	if count == 0 || len(data) < 6+16*count {
+		return nil, fmt.Errorf("invalid icon directory")
+	}
+
+	var resources []winResource
+	group := new(bytes.Buffer)
+	binary.Write(group, le, [3]uint16{0, 1, uint16(count)})
+	for i := 0; i < count; i++ {
+		entry := data[6+16*i : 6+16*(i+1)]
+		size, offset := le.Uint32(entry[8:]), le.Uint32(entry[12:])
+		if uint64(offset)+uint64(size) > uint64(len(data)) {
+			return nil, fmt.Errorf("image %d is out of bounds", i)
+		}
+		id := uint32(i + 1)
+		resources = append(resources, winResource{rtIcon, id, data[offset : offset+size]})
+		// the group entry is the directory entry with the
+		// ID of the resource instead of the file offset
+		group.Write(entry[:12])
+		binary.Write(group, le, uint16(id))
+	}
+	return append(resources, winResource{rtGroupIcon, 1, group.Bytes()}), nil
+}
+
+// parseWindowsVersion parses a version of up to four numbers.
+func parseWindowsVersion(v string) ([4]uint16, error) {
+	var parts [4]uint16
+	fields := strings.Split(v, ".")
+	if v == "" || len(fields) > 4 {
+		return parts, fmt.Errorf("invalid version %q: must be up to four numbers, such as 2.7.6.0", v)
+	}
+	for i, f := range fields {
+		n, err := strconv.ParseUint(f, 10, 16)
+		if err != nil {
+			return parts, fmt.Errorf("invalid version %q: must be up to four numbers, such as 2.7.6.0", v)
+		}
+		parts[i] = uint16(n)
+	}
+	return parts, nil
+}
+
+// versionInfo returns the VS_VERSIONINFO resource of res.
+func versionInfo(res WindowsResources, fileVersion, productVersion string) ([]byte, error) {
+	var fileParts, productParts [4]uint16
+	var err error
+	if fileVersion != "" {
+		if fileParts, err = parseWindowsVersion(fileVersion); err != nil {
+			return nil, err
+		}
+	}
+	if productVersion != "" {
+		if productParts, err = parseWindowsVersion(productVersion); err != nil {
+			return nil, err
+		}
+	}
+
+	fixed := new(bytes.Buffer)
+	binary.Write(fixed, binary.LittleEndian, [13]uint32{
+		0xfeef04bd, // signature
+		0x00010000, // structure version
+		uint32(fileParts[0])<<16 | uint32(fileParts[1]),
+		uint32(fileParts[2])<<16 | uint32(fileParts[3]),
+		uint32(productParts[0])<<16 | uint32(productParts[1]),
+		uint32(productParts[2])<<16 | uint32(productParts[3]),
+		0x3f,    // flags mask
+		0,       // flags
+		0x40004, // VOS_NT_WINDOWS32
+		1,       // VFT_APP
+		0, 0, 0, // subtype and date
+	})
+
+	strs := map[string]string{
+		"CompanyName":      res.CompanyName,
+		"ProductName":      res.ProductName,
+		"FileDescription":  res.FileDescription,
+		"LegalCopyright":   res.LegalCopyright,
+		"InternalName":     res.InternalName,
+		"OriginalFilename": res.OriginalFilename,
+		"Comments":         res.Comments,
+		"FileVersion":      fileVersion,
+		"ProductVersion":   productVersion,
+	}
+	var entries [][]byte
+	for _, key := range sortedKeys(strs) {
+		if strs[key] != "" {
+			value := utf16Bytes(strs[key])
+			entries = append(entries, versionBlock(key, 1, value, len(value)/2))
+		}
+	}
+	stringTable := versionBlock(fmt.Sprintf("%04x%04x", winresLanguage, winresCodePage), 1, nil, 0, entries...)
+	stringFileInfo := versionBlock("StringFileInfo", 1, nil, 0, stringTable)
+
+	translation := make([]byte, 4)
+	binary.LittleEndian.PutUint16(translation, winresLanguage)
+	binary.LittleEndian.PutUint16(translation[2:], winresCodePage)
+	varFileInfo := versionBlock("VarFileInfo", 1, nil, 0, versionBlock("Translation", 0, translation, len(translation)))
+
+	return versionBlock("VS_VERSION_INFO", 0, fixed.Bytes(), fixed.Len(), stringFileInfo, varFileInfo), nil
+}
+
+// versionBlock returns a block of version information: a header
+// with its length, the length of the value (in words for text,
+// typ 1, and in bytes otherwise), and the type, the key, the value,
+// and the children, each aligned to 32 bits.
+func versionBlock(key string, typ uint16, value []byte, valueLength int, children ...[]byte) []byte {
+	b := make([]byte, 6)
+	b = append(b, utf16Bytes(key)...)
+	b = pad4(b)
+	b = append(b, value...)
+	for _, child := range children {
+		b = pad4(b)
+		b = append(b, child...)
+	}
+	le := binary.LittleEndian
+	le.PutUint16(b[0:], uint16(len(b)))
+	le.PutUint16(b[2:], uint16(valueLength))
+	le.PutUint16(b[4:], typ)
+	return b
+}
+
+// utf16Bytes returns s in null-terminated UTF-16LE.
+func utf16Bytes(s string) []byte {
+	codes := append(utf16.Encode([]rune(s)), 0)
+	b := make([]byte, 2*len(codes))
+	for i, c := range codes {
+		binary.LittleEndian.PutUint16(b[2*i:], c)
+	}
+	return b
+}
+
+func pad4(b []byte) []byte {
+	for len(b)%4 != 0 {
+		b = append(b, 0)
+	}
+	return b
+}
+
+// coffResources returns a COFF object file with the resources in a
+// .rsrc section, as the Go linker expects of .syso files: a resource
+// directory tree of types, IDs, and languages, followed by the data,
+// whose image-relative addresses are relocated by the linker.
+func coffResources(resources []winResource, machine, relocType uint16) []byte {
+	sort.Slice(resources, func(i, j int) bool {
+		if resources[i].typ != resources[j].typ {
+			return resources[i].typ < resources[j].typ
+		}
+		return resources[i].id < resources[j].id
+	})
+	var types []uint32
+	byType := make(map[uint32][]winResource)
+	for _, r := range resources {
+		if len(byType[r.typ]) == 0 {
+			types = append(types, r.typ)
+		}
+		byType[r.typ] = append(byType[r.typ], r)
+	}
+
+	// the tree: the directory of types, then one of IDs for each
+	// type, then one of languages for each resource, then the data
+	// entries; every directory has a header of 16 bytes and an entry
+	// of 8 bytes for each child, and every data entry has 16 bytes
+	dirSize := func(entries int) uint32 { return 16 + 8*uint32(entries) }
+	offset := dirSize(len(types))
+	typeDirs := make(map[uint32]uint32)
+	for _, typ := range types {
+		typeDirs[typ] = offset
+		offset += dirSize(len(byType[typ]))
+	}
+	langDirs := make([]uint32, len(resources))
+	for i := range resources {
+		langDirs[i] = offset
+		offset += dirSize(1)
+	}
+	dataEntries := make([]uint32, len(resources))
+	for i := range resources {
+		dataEntries[i] = offset
+		offset += 16
+	}
+	dataOffsets := make([]uint32, len(resources))
+	for i, r := range resources {
+		offset = (offset + 7) &^ 7
+		dataOffsets[i] = offset
+		offset += uint32(len(r.data))
+	}
+
+	section := make([]byte, (offset+7)&^7)
+	le := binary.LittleEndian
+	putDir := func(at uint32, entries [][2]uint32) {
+		le.PutUint16(section[at+14:], uint16(len(entries)))
+		for i, e := range entries {
+			le.PutUint32(section[at+16+8*uint32(i):], e[0])
+			le.PutUint32(section[at+20+8*uint32(i):], e[1])
+		}
+	}
+	const subdirectory = 0x80000000
+	var typeEntries [][2]uint32
+	for _, typ := range types {
+		typeEntries = append(typeEntries, [2]uint32{typ, subdirectory | typeDirs[typ]})
+	}
+	putDir(0, typeEntries)
+	i := 0
+	for _, typ := range types {
+		var idEntries [][2]uint32
+		for _, r := range byType[typ] {
+			idEntries = append(idEntries, [2]uint32{r.id, subdirectory | langDirs[i]})
+			putDir(langDirs[i], [][2]uint32{{winresLanguage, dataEntries[i]}})
+			i++
+		}
+		putDir(typeDirs[typ], idEntries)
+	}
+	var relocs []uint32
+	for i, r := range resources {
+		le.PutUint32(section[dataEntries[i]:], dataOffsets[i])
+		le.PutUint32(section[dataEntries[i]+4:], uint32(len(r.data)))
+		copy(section[dataOffsets[i]:], r.data)
+		relocs = append(relocs, dataEntries[i])
+	}
+
+	// file header, section header, section
+	// data, relocations, symbols, strings
+	const headerSize, sectionHeaderSize, relocSize = 20, 40, 10
+	sectionAt := uint32(headerSize + sectionHeaderSize)
+	relocsAt := sectionAt + uint32(len(section))
+	symbolsAt := relocsAt + relocSize*uint32(len(relocs))
+
+	var out bytes.Buffer
+	binary.Write(&out, le, struct {
+		Machine              uint16
+		NumberOfSections     uint16
+		TimeDateStamp        uint32
+		PointerToSymbolTable uint32
+		NumberOfSymbols      uint32
+		SizeOfOptionalHeader uint16
+		Characteristics      uint16
+	}{machine, 1, 0, symbolsAt, 1, 0, 0})
+	binary.Write(&out, le, struct {
+		Name                 [8]byte
+		VirtualSize          uint32
+		VirtualAddress       uint32
+		SizeOfRawData        uint32
+		PointerToRawData     uint32
+		PointerToRelocations uint32
+		PointerToLinenumbers uint32
+		NumberOfRelocations  uint16
+		NumberOfLinenumbers  uint16
+		Characteristics      uint32
+	}{
+		Name:                 [8]byte{'.', 'r', 's', 'r', 'c'},
+		SizeOfRawData:        uint32(len(section)),
+		PointerToRawData:     sectionAt,
+		PointerToRelocations: relocsAt,
+		NumberOfRelocations:  uint16(len(relocs)),
+		Characteristics:      0x40000040, // initialized data, readable
+	})
+	out.Write(section)
+	for _, at := range relocs {
+		binary.Write(&out, le, struct {
+			VirtualAddress   uint32
+			SymbolTableIndex uint32
+			Type             uint16
+		}{at, 0, relocType})
+	}
+	binary.Write(&out, le, struct {
+		Name               [8]byte
+		Value              uint32
+		SectionNumber      int16
+		Type               uint16
+		StorageClass       uint8
+		NumberOfAuxSymbols uint8
+	}{Name: [8]byte{'.', 'r', 's', 'r', 'c'}, SectionNumber: 1, StorageClass: 3})
+	binary.Write(&out, le, uint32(4)) // empty string table
+	return out.Bytes()
+}
@@ -0,0 +1,286 @@
+package builder
+
+import (
+	"bytes"
+	"context"
+	"debug/pe"
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var updateGolden = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// testIcon writes an .ico file with two small images, whose
+// bytes stand in for bitmaps, to dir and returns its path.
+func testIcon(t *testing.T, dir string) string {
+	t.Helper()
+	images := [][]byte{bytes.Repeat([]byte{0xaa}, 40), bytes.Repeat([]byte{0x55}, 72)}
+	le := binary.LittleEndian
+	var ico bytes.Buffer
+	binary.Write(&ico, le, [3]uint16{0, 1, uint16(len(images))})
+	offset := 6 + 16*len(images)
+	for i, img := range images {
+		size := uint8(16 * (i + 1))
+		ico.Write([]byte{size, size, 0, 0})
+		binary.Write(&ico, le, [2]uint16{1, 32})
+		binary.Write(&ico, le, [2]uint32{uint32(len(img)), uint32(offset)})
+		offset += len(img)
+	}
+	for _, img := range images {
+		ico.Write(img)
+	}
+	path := filepath.Join(dir, "goaway.ico")
+	if err := os.WriteFile(path, ico.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+const testManifest = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<assembly xmlns="urn:schemas-microsoft-com:asm.v1" manifestVersion="1.0"/>
+`
+
+// testWindowsResources returns WindowsResources with an icon and a
+// manifest written to dir and all strings of the version information.
+func testWindowsResources(t *testing.T, dir string) WindowsResources {
+	t.Helper()
+	manifest := filepath.Join(dir, "goaway.manifest")
+	if err := os.WriteFile(manifest, []byte(testManifest), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return WindowsResources{
+		Icon:             testIcon(t, dir),
+		Manifest:         manifest,
+		ProductVersion:   "2.7",
+		CompanyName:      "Goaway Authors",
+		ProductName:      "Goaway",
+		FileDescription:  "Goaway server",
+		LegalCopyright:   "© Goaway Authors",
+		InternalName:     "goaway",
+		OriginalFilename: "goaway.exe",
+		Comments:         "built by goaway-builder",
+	}
+}
+
+// TestWindowsResourcesGolden compares the encoded .syso files with
+// those in testdata/winres, which go test -update rewrites.
+func TestWindowsResourcesGolden(t *testing.T) {
+	dir := t.TempDir()
+	full := testWindowsResources(t, dir)
+	for _, tt := range []struct {
+		name, arch, caddyVersion string
+		res                      WindowsResources
+	}{
+		{"version_amd64", "amd64", "v2.7.6-beta.1", WindowsResources{ProductName: "Goaway"}},
+		{"no_version_amd64", "amd64", "latest", WindowsResources{}},
+		{"full_amd64", "amd64", "v2.7.6", full},
+		{"full_386", "386", "v2.7.6", full},
+		{"full_arm", "arm", "v2.7.6", full},
+		{"full_arm64", "arm64", "v2.7.6", full},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			syso, err := writeWindowsResources(tt.res, t.TempDir(), tt.arch, tt.caddyVersion)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := os.ReadFile(syso)
+			if err != nil {
+				t.Fatal(err)
+			}
+			golden := filepath.Join("testdata", "winres", tt.name+".syso")
+			if *updateGolden {
+				if err := os.MkdirAll(filepath.Dir(golden), 0755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(golden, got, 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("%s differs from %s in %d of its %d bytes, first at offset %d; if that is intended, run go test -update",
+					filepath.Base(syso), golden, diffBytes(got, want), len(got), firstDiff(got, want))
+			}
+		})
+	}
+}
+
+// diffBytes returns the number of bytes in which a and b differ.
+func diffBytes(a, b []byte) int {
+	n := len(a) - len(b)
+	if n < 0 {
+		a, b, n = b, a, -n
+	}
+	for i := range b {
+		if a[i] != b[i] {
+			n++
+		}
+	}
+	return n
+}
+
+// firstDiff returns the offset of the first byte in which a and b differ.
+func firstDiff(a, b []byte) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i] != b[i] {
+			return i
+		}
+	}
+	if len(a) < len(b) {
+		return len(a)
+	}
+	return len(b)
+}
+
+// TestWindowsResourcesLinked builds a binary for windows/amd64 with
+// resources and reads them back from its resource directory, which
+// checks that the linker accepted the .syso and relocated its data.
+func TestWindowsResourcesLinked(t *testing.T) {
+	b := testBuilder(t, testBaseModule(t))
+	b.Platform = Platform{OS: "windows", Arch: "amd64"}
+	res := testWindowsResources(t, t.TempDir())
+	// the local base module has no version to default to
+	res.FileVersion = "2.7.6"
+	b.WindowsResources = &res
+	result, err := b.Build(context.Background(), filepath.Join(t.TempDir(), "goaway"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := pe.Open(result.OutputFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	rsrc, err := readPEResources(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		typ, id uint32
+		check   func([]byte) error
+	}{
+		{rtIcon, 1, wantLength(40)},
+		{rtIcon, 2, wantLength(72)},
+		{rtGroupIcon, 1, wantLength(6 + 14*2)},
+		{rtManifest, 1, func(data []byte) error {
+			if string(data) != testManifest {
+				return fmt.Errorf("is %q", data)
+			}
+			return nil
+		}},
+		{rtVersion, 1, func(data []byte) error {
+			if len(data) < 6 || int(binary.LittleEndian.Uint16(data)) != len(data) {
+				return fmt.Errorf("has an invalid length")
+			}
+			for _, s := range []string{"VS_VERSION_INFO", "Goaway server", "© Goaway Authors", "2.7.6", "2.7"} {
+				if !bytes.Contains(data, utf16Bytes(s)) {
+					return fmt.Errorf("lacks %q", s)
+				}
+			}
+			fixed := make([]byte, 4)
+			binary.LittleEndian.PutUint32(fixed, 0xfeef04bd)
+			if !bytes.Contains(data, fixed) {
+				return fmt.Errorf("lacks VS_FIXEDFILEINFO")
+			}
+			return nil
+		}},
+	} {
+		data, ok := rsrc.find(tt.typ, tt.id, winresLanguage)
+		if !ok {
+			t.Errorf("binary lacks the resource %d/%d", tt.typ, tt.id)
+			continue
+		}
+		if err := tt.check(data); err != nil {
+			t.Errorf("resource %d/%d %v", tt.typ, tt.id, err)
+		}
+	}
+}
+
+func wantLength(n int) func([]byte) error {
+	return func(data []byte) error {
+		if len(data) != n {
+			return fmt.Errorf("has %d bytes, want %d", len(data), n)
+		}
+		return nil
+	}
+}
+
+// peResources is the resource directory of a PE image.
+type peResources struct {
+	data []byte // the section that holds the directory
+	rva  uint32 // the address of data
+	root uint32 // the offset of the directory in data
+}
+
+func readPEResources(f *pe.File) (*peResources, error) {
+	header, ok := f.OptionalHeader.(*pe.OptionalHeader64)
+	if !ok {
+		return nil, fmt.Errorf("not a 64-bit image")
+	}
+	dir := header.DataDirectory[pe.IMAGE_DIRECTORY_ENTRY_RESOURCE]
+	if dir.VirtualAddress == 0 {
+		return nil, fmt.Errorf("image has no resources")
+	}
+	for _, s := range f.Sections {
+		if dir.VirtualAddress >= s.VirtualAddress && dir.VirtualAddress < s.VirtualAddress+s.VirtualSize {
+			data, err := s.Data()
+			if err != nil {
+				return nil, err
+			}
+			return &peResources{data: data, rva: s.VirtualAddress, root: dir.VirtualAddress - s.VirtualAddress}, nil
+		}
+	}
+	return nil, fmt.Errorf("no section holds the resources at %#x", dir.VirtualAddress)
+}
+
+// find returns the data of the resource with the given type, ID, and
+// language, following the offsets of the directory tree and the
+// relocated address of the data.
+func (r *peResources) find(typ, id, lang uint32) ([]byte, bool) {
+	const subdirectory = 0x80000000
+	at := r.root
+	for i, name := range []uint32{typ, id, lang} {
+		next, ok := r.entry(at, name)
+		// types and IDs lead to directories, languages to data
+		if !ok || (next&subdirectory != 0) != (i < 2) {
+			return nil, false
+		}
+		at = r.root + next&^subdirectory
+	}
+	if int(at)+8 > len(r.data) {
+		return nil, false
+	}
+	rva, size := binary.LittleEndian.Uint32(r.data[at:]), binary.LittleEndian.Uint32(r.data[at+4:])
+	if rva < r.rva || uint64(rva-r.rva)+uint64(size) > uint64(len(r.data)) {
+		return nil, false
+	}
+	return r.data[rva-r.rva : rva-r.rva+size], true
+}
+
+// entry returns the offset in the entry with the ID name of the
+// directory at the offset at.
+func (r *peResources) entry(at, name uint32) (uint32, bool) {
+	le := binary.LittleEndian
+	if int(at)+16 > len(r.data) {
+		return 0, false
+	}
+	n := uint32(le.Uint16(r.data[at+12:])) + uint32(le.Uint16(r.data[at+14:]))
+	for i := uint32(0); i < n; i++ {
+		e := at + 16 + 8*i
+		if int(e)+8 > len(r.data) {
+			return 0, false
+		}
+		if le.Uint32(r.data[e:]) == name {
+			return le.Uint32(r.data[e+4:]), true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,68 @@
+package builder
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/mod/modfile"
+)
+
+// workspaceFile is the name of the go.work file
+// that workspace builds create in the build environment.
+const workspaceFile = "go.work"
+
+// checkWorkspaceModule returns the absolute path of the local
+// module directory dir of Workspace and the path of its module.
+func checkWorkspaceModule(dir string) (string, string, error) {
+	// the go command runs in the temporary folder, so
+	// relative directories must be made absolute
+	absPath, err := filepath.Abs(dir)
+	if err != nil {
+		return "", "", err
+	}
+	goModPath := filepath.Join(absPath, "go.mod")
+	goMod, err := os.ReadFile(goModPath)
+	if err != nil {
+		return "", "", fmt.Errorf("workspace module %s: %v", dir, err)
+	}
+	modulePath := modfile.ModulePath(goMod)
+	if modulePath == "" {
+		return "", "", fmt.Errorf("workspace module %s: no module directive in %s", dir, goModPath)
+	}
+	return absPath, modulePath, nil
+}
+
+// useWorkspace creates the go.work file of env, which uses the
+// main module and the modules in dirs, and records the paths of
+// those modules in env.
+func (env *environment) useWorkspace(ctx context.Context, dirs []string) error {
+	if env.vendor {
+		return fmt.Errorf("vendoring is not supported for workspace builds")
+	}
+	args := []string{"work", "init", "."}
+	for _, dir := range dirs {
+		absPath, modulePath, err := checkWorkspaceModule(dir)
+		if err != nil {
+			return err
+		}
+		env.log.Printf("[INFO] Using workspace module %s in %s", modulePath, absPath)
+		args = append(args, absPath)
+		env.workspace = append(env.workspace, modulePath)
+	}
+	cmd := env.newCommand(ctx, GetGo(), args...)
+	return env.runCommand(ctx, cmd)
+}
+
+// workspaceModuleFor returns the path of the workspace
+// module of env that provides packagePath, if any.
+func (env environment) workspaceModuleFor(packagePath string) (string, bool) {
+	for _, mod := range env.workspace {
+		if packagePath == mod || strings.HasPrefix(packagePath, mod+"/") {
+			return mod, true
+		}
+	}
+	return "", false
+}
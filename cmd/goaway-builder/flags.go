@@ -0,0 +1,190 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/spf13/pflag"
+
+	"github.com/crackeer/goaway/builder"
+)
+
+// builderFlags are the flags that set fields of the Builder, named
+// after their JSON keys. Only the flags that are given override the
+// configuration from --config or the GOAWAY_ environment variables.
+type builderFlags struct {
+	config string
+
+	caddyVersion string
+	baseModule   string
+	mainPackage  string
+	mainModule   string
+	goDirective  string
+	plugins      []string
+	replacements []string
+	os           string
+	arch         string
+	arm          string
+	buildFlags   string
+	buildTags    []string
+	goVersion    string
+	goProxy      string
+	timeoutGet   time.Duration
+	timeoutBuild time.Duration
+	static       bool
+	cgo          bool
+	race         bool
+	reproducible bool
+	offline      bool
+	vendor       bool
+	sbomFormat   string
+	maxSize      int64
+	warnSize     bool
+	sizes        bool
+	debug        bool
+	debugDir     string
+	skipCleanup  bool
+	workDir      string
+}
+
+// register adds the flags of f to fs.
+func (f *builderFlags) register(fs *pflag.FlagSet) {
+	fs.StringVarP(&f.config, "config", "c", "", "read the configuration from a .json, .yaml, .yml, or .toml file instead of GOAWAY_ variables")
+	fs.StringVar(&f.caddyVersion, "caddy-version", "", "version of the base module, such as v1.2.3, latest, or a constraint")
+	fs.StringVar(&f.baseModule, "base-module", "", "module to build instead of github.com/crackeer/goaway")
+	fs.StringVar(&f.mainPackage, "main-package", "", "package of the base module that provides Main")
+	fs.StringVar(&f.mainModule, "main-module-path", "", "module path of the generated main module")
+	fs.StringVar(&f.goDirective, "go-directive", "", "go directive of the generated main module, such as 1.22")
+	fs.StringArrayVar(&f.plugins, "plugins", nil, "plugin to add as module[@version]; repeatable")
+	fs.StringArrayVar(&f.replacements, "replacements", nil, "module replacement as old=new; repeatable")
+	fs.StringVar(&f.os, "os", "", "target operating system (GOOS)")
+	fs.StringVar(&f.arch, "arch", "", "target architecture (GOARCH)")
+	fs.StringVar(&f.arm, "arm", "", "target ARM version (GOARM)")
+	fs.StringVar(&f.buildFlags, "build-flags", "", "additional flags of go build")
+	fs.StringSliceVar(&f.buildTags, "build-tags", nil, "build tags, comma-separated")
+	fs.StringVar(&f.goVersion, "go-version", "", "Go toolchain to build with, such as 1.22.1")
+	fs.StringVar(&f.goProxy, "go-proxy", "", "GOPROXY of the build")
+	fs.DurationVar(&f.timeoutGet, "timeout-get", 0, "time limit of each go get")
+	fs.DurationVar(&f.timeoutBuild, "timeout-build", 0, "time limit of the whole build")
+	fs.BoolVar(&f.static, "static", false, "link statically")
+	fs.BoolVar(&f.cgo, "cgo", false, "enable cgo")
+	fs.BoolVar(&f.race, "race-detector", false, "build with the race detector")
+	fs.BoolVar(&f.reproducible, "reproducible", false, "build byte-identical binaries from the same configuration")
+	fs.BoolVar(&f.offline, "offline", false, "use the module cache only")
+	fs.BoolVar(&f.vendor, "vendor", false, "vendor the modules before building")
+	fs.StringVar(&f.sbomFormat, "sbom-format", "", "write an SBOM next to the binary: spdx or cyclonedx")
+	fs.Int64Var(&f.maxSize, "max-binary-size", 0, "fail if the binary has more bytes than this")
+	fs.BoolVar(&f.warnSize, "warn-binary-size", false, "only warn if the binary is larger than --max-binary-size")
+	fs.BoolVar(&f.sizes, "size-breakdown", false, "report the size of the binary by module and package")
+	fs.BoolVar(&f.debug, "debug", false, "build for debuggers such as dlv")
+	fs.StringVar(&f.debugDir, "debug-dir", "", "copy the sources of a debug build to this directory")
+	fs.BoolVar(&f.skipCleanup, "skip-cleanup", false, "keep the build environment")
+	fs.StringVar(&f.workDir, "work-dir", "", "directory to create the build environment in")
+}
+
+// builder returns the Builder configured by --config, or else by
+// the environment, with the flags that were given in fs applied.
+func (f *builderFlags) builder(fs *pflag.FlagSet) (builder.Builder, error) {
+	var b builder.Builder
+	var err error
+	if f.config != "" {
+		b, err = builder.LoadConfig(f.config)
+	} else {
+		b, err = builder.FromEnv()
+	}
+	if err != nil {
+		return builder.Builder{}, err
+	}
+
+	for _, s := range []struct {
+		flag  string
+		field *string
+		value string
+	}{
+		{"caddy-version", &b.CaddyVersion, f.caddyVersion},
+		{"base-module", &b.BaseModule, f.baseModule},
+		{"main-package", &b.MainPackage, f.mainPackage},
+		{"main-module-path", &b.MainModulePath, f.mainModule},
+		{"go-directive", &b.GoDirective, f.goDirective},
+		{"os", &b.OS, f.os},
+		{"arch", &b.Arch, f.arch},
+		{"arm", &b.ARM, f.arm},
+		{"build-flags", &b.BuildFlags, f.buildFlags},
+		{"go-version", &b.GoVersion, f.goVersion},
+		{"go-proxy", &b.GoProxy, f.goProxy},
+		{"sbom-format", &b.SBOMFormat, f.sbomFormat},
+		{"work-dir", &b.WorkDir, f.workDir},
+		{"debug-dir", &b.DebugDir, f.debugDir},
+	} {
+		if fs.Changed(s.flag) {
+			*s.field = s.value
+		}
+	}
+	for _, s := range []struct {
+		flag  string
+		field *bool
+		value bool
+	}{
+		{"static", &b.Static, f.static},
+		{"cgo", &b.Cgo, f.cgo},
+		{"race-detector", &b.RaceDetector, f.race},
+		{"reproducible", &b.Reproducible, f.reproducible},
+		{"offline", &b.Offline, f.offline},
+		{"vendor", &b.Vendor, f.vendor},
+		{"skip-cleanup", &b.SkipCleanup, f.skipCleanup},
+		{"warn-binary-size", &b.WarnBinarySize, f.warnSize},
+		{"size-breakdown", &b.SizeBreakdown, f.sizes},
+		{"debug", &b.Debug, f.debug},
+	} {
+		if fs.Changed(s.flag) {
+			*s.field = s.value
+		}
+	}
+	if fs.Changed("timeout-get") {
+		b.TimeoutGet = f.timeoutGet
+	}
+	if fs.Changed("timeout-build") {
+		b.TimeoutBuild = f.timeoutBuild
+	}
+	if fs.Changed("max-binary-size") {
+		b.MaxBinarySize = f.maxSize
+	}
+	if fs.Changed("build-tags") {
+		b.BuildTags = f.buildTags
+	}
+
+	// plugins and replacements add to the configured ones
+	for _, p := range f.plugins {
+		path, version, _ := strings.Cut(p, "@")
+		if path == "" {
+			return builder.Builder{}, fmt.Errorf("invalid plugin %q; use module[@version]", p)
+		}
+		b.Plugins = append(b.Plugins, builder.Dependency{PackagePath: path, Version: version})
+	}
+	for _, r := range f.replacements {
+		old, new, ok := strings.Cut(r, "=")
+		if !ok || old == "" || new == "" {
+			return builder.Builder{}, fmt.Errorf("invalid replacement %q; use old=new", r)
+		}
+		b.Replacements = append(b.Replacements, builder.NewReplace(old, new))
+	}
+	return b, nil
+}
+
+// parseTarget parses a target of build-all, such as
+// linux/amd64 or linux/arm/7.
+func parseTarget(target string) (builder.Platform, error) {
+	parts := strings.Split(target, "/")
+	if len(parts) < 2 || len(parts) > 3 || parts[0] == "" || parts[1] == "" {
+		return builder.Platform{}, fmt.Errorf("invalid target %q; use os/arch or os/arm/version", target)
+	}
+	p := builder.Platform{OS: parts[0], Arch: parts[1]}
+	if len(parts) == 3 {
+		if p.Arch != "arm" {
+			return builder.Platform{}, fmt.Errorf("invalid target %q; only arm has versions", target)
+		}
+		p.ARM = parts[2]
+	}
+	return p, nil
+}
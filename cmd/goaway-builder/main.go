@@ -0,0 +1,312 @@
+// Command goaway-builder builds custom binaries with plugins, as the
+// builder package does, without writing Go code.
+//
+//	goaway-builder build -o goaway --plugins github.com/acme/a@v1.2.0
+//	goaway-builder build-all --target linux/amd64 --target linux/arm/7 -o dist
+//	goaway-builder export ./module
+//	goaway-builder plan
+//	goaway-builder outdated
+//	goaway-builder verify ./goaway
+//	goaway-builder gc --mod-cache-max-size 10000000000 --build-env-max-age 24h
+//
+// The build is configured by the file given with --config or else by
+// GOAWAY_ environment variables (see builder.FromEnv), and the flags
+// given override either. Results are written to standard output, as
+// JSON with --json, and the progress of the build to standard error.
+// With --report, the progress is also written as newline-delimited
+// JSON events (see builder.ReportEvent) to a file, or with --report -
+// to standard output instead of the results.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/crackeer/goaway/builder"
+)
+
+var (
+	flags      builderFlags
+	jsonOutput bool
+	reportPath string
+	outputFile string
+	targets    []string
+	failOnOld  bool
+	gcPolicy   builder.CacheGCPolicy
+
+	rootCmd = &cobra.Command{
+		Use:           "goaway-builder",
+		Short:         "Build custom binaries with plugins",
+		SilenceUsage:  true,
+		SilenceErrors: true,
+	}
+	buildCmd = &cobra.Command{
+		Use:   "build",
+		Short: "Build a binary",
+		Args:  cobra.NoArgs,
+		RunE:  runBuild,
+	}
+	buildAllCmd = &cobra.Command{
+		Use:   "build-all",
+		Short: "Build binaries for several targets from one build environment",
+		Args:  cobra.NoArgs,
+		RunE:  runBuildAll,
+	}
+	exportCmd = &cobra.Command{
+		Use:   "export DIR",
+		Short: "Write the resolved module of the build to a directory",
+		Args:  cobra.ExactArgs(1),
+		RunE:  runExport,
+	}
+	planCmd = &cobra.Command{
+		Use:     "plan",
+		Aliases: []string{"dry-run"},
+		Short:   "Show what a build would do without doing it",
+		Args:    cobra.NoArgs,
+		RunE:    runPlan,
+	}
+	outdatedCmd = &cobra.Command{
+		Use:   "outdated",
+		Short: "Report newer versions of the base module and the plugins",
+		Args:  cobra.NoArgs,
+		RunE:  runOutdated,
+	}
+	verifyCmd = &cobra.Command{
+		Use:   "verify BINARY",
+		Short: "Check that a binary contains the configured modules",
+		Args:  cobra.ExactArgs(1),
+		RunE:  runVerify,
+	}
+	gcCmd = &cobra.Command{
+		Use:   "gc",
+		Short: "Trim the caches and build environments of the configuration",
+		Args:  cobra.NoArgs,
+		RunE:  runGC,
+	}
+)
+
+func main() {
+	flags.register(rootCmd.PersistentFlags())
+	rootCmd.PersistentFlags().BoolVar(&jsonOutput, "json", false, "write results as JSON")
+	rootCmd.PersistentFlags().StringVar(&reportPath, "report", "", "write the progress of builds as newline-delimited JSON to this file, or - for standard output")
+	for _, cmd := range []*cobra.Command{buildCmd, planCmd} {
+		cmd.Flags().StringVarP(&outputFile, "output", "o", "goaway", "output file, or directory ending in /")
+	}
+	buildAllCmd.Flags().StringVarP(&outputFile, "output", "o", "dist", "output directory")
+	buildAllCmd.Flags().StringArrayVar(&targets, "target", nil, "target as os/arch or os/arm/version; repeatable")
+	outdatedCmd.Flags().BoolVar(&failOnOld, "fail", false, "exit with status 1 if updates are available")
+	gcCmd.Flags().Int64Var(&gcPolicy.ModCache.MaxSize, "mod-cache-max-size", 0, "bytes to keep of the module cache")
+	gcCmd.Flags().DurationVar(&gcPolicy.ModCache.MaxAge, "mod-cache-max-age", 0, "remove modules downloaded longer ago")
+	gcCmd.Flags().Int64Var(&gcPolicy.BuildCache.MaxSize, "build-cache-max-size", 0, "bytes to keep of the build cache")
+	gcCmd.Flags().DurationVar(&gcPolicy.BuildCache.MaxAge, "build-cache-max-age", 0, "remove build cache entries unused for longer")
+	gcCmd.Flags().DurationVar(&gcPolicy.BuildEnvMaxAge, "build-env-max-age", 0, "remove build environments modified longer ago")
+	rootCmd.AddCommand(buildCmd, buildAllCmd, exportCmd, planCmd, outdatedCmd, verifyCmd, gcCmd)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	err := rootCmd.ExecuteContext(ctx)
+	stop()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
+	}
+}
+
+// configured returns the Builder of cmd, which
+// reports to the file of --report, if given.
+func configured(cmd *cobra.Command) (builder.Builder, error) {
+	b, err := flags.builder(cmd.Flags())
+	if err != nil {
+		return builder.Builder{}, err
+	}
+	switch reportPath {
+	case "":
+	case "-":
+		// keep standard output to the report
+		b.Report = os.Stdout
+		b.Stdout = os.Stderr
+	default:
+		f, err := os.Create(reportPath)
+		if err != nil {
+			return builder.Builder{}, err
+		}
+		// closed when the process exits
+		b.Report = f
+	}
+	return b, nil
+}
+
+// reportsToStdout reports whether the report is written to
+// standard output, which then has no other results.
+func reportsToStdout() bool {
+	return reportPath == "-"
+}
+
+// printJSON writes v to standard output as indented JSON.
+func printJSON(v interface{}) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+func runBuild(cmd *cobra.Command, args []string) error {
+	b, err := configured(cmd)
+	if err != nil {
+		return err
+	}
+	result, err := b.Build(cmd.Context(), outputFile)
+	if err != nil || result == nil || reportsToStdout() {
+		return err
+	}
+	if jsonOutput {
+		return printJSON(result)
+	}
+	fmt.Println(result.OutputFile)
+	if result.Sizes != nil {
+		for _, m := range result.Sizes.Modules {
+			fmt.Printf("%s\t%d\n", m.Path, m.Bytes)
+		}
+	}
+	return nil
+}
+
+func runBuildAll(cmd *cobra.Command, args []string) error {
+	b, err := configured(cmd)
+	if err != nil {
+		return err
+	}
+	if len(targets) == 0 {
+		return fmt.Errorf("at least one --target is required")
+	}
+	var platforms []builder.Platform
+	for _, t := range targets {
+		p, err := parseTarget(t)
+		if err != nil {
+			return err
+		}
+		platforms = append(platforms, p)
+	}
+	results, err := b.BuildAll(cmd.Context(), platforms, outputFile)
+	if err != nil || reportsToStdout() {
+		return err
+	}
+	if jsonOutput {
+		return printJSON(results)
+	}
+	for _, r := range results {
+		if r != nil {
+			fmt.Println(r.OutputFile)
+		}
+	}
+	return nil
+}
+
+func runExport(cmd *cobra.Command, args []string) error {
+	b, err := configured(cmd)
+	if err != nil {
+		return err
+	}
+	return b.Export(cmd.Context(), args[0])
+}
+
+func runPlan(cmd *cobra.Command, args []string) error {
+	b, err := configured(cmd)
+	if err != nil {
+		return err
+	}
+	plan, err := b.Plan(cmd.Context(), outputFile)
+	if err != nil {
+		return err
+	}
+	if jsonOutput {
+		return printJSON(plan)
+	}
+	fmt.Print(plan.String())
+	return nil
+}
+
+func runOutdated(cmd *cobra.Command, args []string) error {
+	b, err := configured(cmd)
+	if err != nil {
+		return err
+	}
+	report, err := b.Outdated(cmd.Context())
+	if err != nil {
+		return err
+	}
+	if jsonOutput {
+		err = printJSON(report)
+	} else {
+		for _, m := range append([]builder.OutdatedModule{report.Base}, report.Plugins...) {
+			switch {
+			case m.Replace != "":
+				fmt.Printf("%s\treplaced by %s\n", m.Path, m.Replace)
+			case m.UpdateAvailable():
+				fmt.Printf("%s\t%s -> %s\n", m.Path, m.Current, m.LatestCompatible)
+			default:
+				fmt.Printf("%s\t%s\tup to date\n", m.Path, m.Current)
+			}
+		}
+	}
+	if err == nil && failOnOld && len(report.Updates()) > 0 {
+		err = fmt.Errorf("%d update(s) available", len(report.Updates()))
+	}
+	return err
+}
+
+func runVerify(cmd *cobra.Command, args []string) error {
+	b, err := configured(cmd)
+	if err != nil {
+		return err
+	}
+	path, err := filepath.Abs(args[0])
+	if err != nil {
+		return err
+	}
+	verified, err := b.VerifyBinary(path)
+	if err != nil {
+		return err
+	}
+	if jsonOutput {
+		return printJSON(verified)
+	}
+	for _, m := range verified {
+		found := m.Version
+		if m.Replace != "" {
+			found = "replaced by " + string(m.Replace)
+		}
+		fmt.Println(strings.Join([]string{m.Package, found}, "\t"))
+	}
+	return nil
+}
+
+func runGC(cmd *cobra.Command, args []string) error {
+	b, err := configured(cmd)
+	if err != nil {
+		return err
+	}
+	// the caches of the configuration, with the limits
+	// of its artifact cache
+	policy := gcPolicy
+	policy.GoModCache, policy.GoCache = b.GoModCache, b.Env["GOCACHE"]
+	policy.ArtifactCache, policy.WorkDir = b.ArtifactCache, b.WorkDir
+	report, err := builder.CacheGC(cmd.Context(), policy)
+	if err != nil {
+		return err
+	}
+	if jsonOutput {
+		return printJSON(report)
+	}
+	for _, c := range []*builder.CacheGCResult{report.ModCache, report.BuildCache, report.ArtifactCache, report.BuildEnvs} {
+		if c != nil {
+			fmt.Printf("%s\tremoved %d (%d bytes), %d bytes remaining\n", c.Dir, c.Removed, c.Freed, c.Remaining)
+		}
+	}
+	return nil
+}